@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/loginpolicy"
+)
+
+// runCommand はサーバーを起動せずに実行する運用コマンドを処理し、終了コードを返す。
+// 本番も同じバイナリしか置かないため、別 cmd ではなくサブコマンドにしている。
+//
+//	server audit-verify            監査ログのハッシュチェーンを検証する
+//	server promote-admin <email>   ユーザーを有効な管理者にする（管理者がいなくなったときの復旧用）
+func runCommand(args []string) int {
+	switch args[0] {
+	case "audit-verify":
+		return cmdAuditVerify()
+	case "promote-admin":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: server promote-admin <email>")
+			return 2
+		}
+		return cmdPromoteAdmin(args[1])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\nusage:\n  server                         start the web server\n  server audit-verify            verify the audit log hash chain\n  server promote-admin <email>   make the user an active admin\n", args[0])
+		return 2
+	}
+}
+
+// cmdAuditVerify は監査ログ全体のハッシュチェーンを検証する。
+// 改ざんを検出したら 1、検証自体ができなければ 2 を返す。
+func cmdAuditVerify() int {
+	conn, err := openDB()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer func() { _ = conn.Close() }()
+
+	checked, err := audit.Verify(context.Background(), database.New(conn))
+	var verr *audit.VerifyError
+	switch {
+	case errors.As(err, &verr):
+		fmt.Fprintf(os.Stderr, "NG: %v (%d entries verified before it)\n", verr, checked)
+		return 1
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Printf("OK: %d audit log entries verified\n", checked)
+	return 0
+}
+
+// cmdPromoteAdmin は email のユーザーを有効な管理者にする。画面からは最後の管理者を
+// 降格・無効化・削除できないが、DB を直接書き換えた場合などに締め出されたときに使う。
+func cmdPromoteAdmin(email string) int {
+	conn, err := openDB()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer func() { _ = conn.Close() }()
+
+	user, err := loginpolicy.PromoteAdmin(context.Background(), conn, email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		fmt.Fprintf(os.Stderr, "NG: user not found: %s\n", email)
+		return 1
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Printf("OK: %s (id=%d) is now an active admin\n", user.Email, user.ID)
+	return 0
+}
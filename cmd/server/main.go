@@ -24,13 +24,21 @@ import (
 	_ "modernc.org/sqlite"
 
 	"github.com/naozine/project_crud_with_auth_tmpl/db"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appconfig"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/handlers"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/invitation"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/limits"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/loginpolicy"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/mailer"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
 	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/oidc"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/routes"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/trash"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/version"
 	"github.com/naozine/project_crud_with_auth_tmpl/web"
 )
@@ -49,26 +57,20 @@ func main() {
 	}
 	defer logger.Close()
 
-	// 1. Database Setup
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = "app.db"
+	// サブコマンド（例: `server audit-verify`）。サーバーは起動せず、終了コードで結果を返す。
+	if len(os.Args) > 1 {
+		code := runCommand(os.Args[1:])
+		logger.Close()
+		os.Exit(code)
 	}
-	conn, err := sql.Open("sqlite", "file:"+dbPath+"?_pragma=busy_timeout(30000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(on)")
+
+	// 1. Database Setup（マイグレーション適用込み）
+	conn, err := openDB()
 	if err != nil {
-		log.Fatal("Failed to connect to app.db:", err)
+		log.Fatal(err)
 	}
 	defer func() { _ = conn.Close() }()
 
-	// Run Migrations
-	goose.SetBaseFS(db.MigrationsFS)
-	if err := goose.SetDialect("sqlite3"); err != nil {
-		log.Fatal("Failed to set goose dialect:", err)
-	}
-	if err := goose.Up(conn, "migrations"); err != nil {
-		log.Fatal("Failed to apply migrations:", err)
-	}
-
 	// Initialize Admin User if needed
 	if err := ensureAdminUser(conn); err != nil {
 		log.Printf("Warning: Failed to initialize admin user: %v", err)
@@ -97,7 +99,7 @@ func main() {
 	mlConfig.CookieName = generateCookieName(version.ProjectName)
 
 	mlConfig.AllowLogin = func(r *http.Request, email string) error {
-		return loginpolicy.AllowLogin(r.Context(), database.New(conn), email, r.URL.Query().Get("hp"))
+		return loginpolicy.AllowLogin(r.Context(), conn, email, r.URL.Query().Get("hp"))
 	}
 
 	// SMTP
@@ -124,16 +126,69 @@ func main() {
 		log.Fatal("Failed to initialize MagicLink:", err)
 	}
 
+	// 招待メール（管理者が追加・インポートしたユーザー宛て）。SMTP 設定は magiclink と共用し、
+	// SMTP_HOST 未設定の開発環境では送信せず招待リンクをログに出す。
+	sender := mailer.New(mailer.SMTPConfig{
+		Host:     mlConfig.SMTPHost,
+		Port:     mlConfig.SMTPPort,
+		Username: mlConfig.SMTPUsername,
+		Password: mlConfig.SMTPPassword,
+		From:     mlConfig.SMTPFrom,
+		FromName: mlConfig.SMTPFromName,
+	})
+	inviter := invitation.NewInviter(sender, mlConfig.ServerAddr)
+	inviter.Async = true
+
+	// 管理画面で作ったカスタムロール。OIDC のロール対応の検証より前に読み込む。
+	if err := roles.Load(context.Background(), database.New(conn)); err != nil {
+		log.Fatal("Failed to load custom roles:", err)
+	}
+	// 初期セットアップで設定したアプリ名。
+	if err := appconfig.LoadAppName(context.Background(), database.New(conn)); err != nil {
+		log.Fatal("Failed to load app name:", err)
+	}
+
+	// 初期セットアップ（/setup）のワンタイムトークン。ユーザーがいなければ URL をログに出す。
+	setupToken, err := initialSetupToken(conn, mlConfig.ServerAddr)
+	if err != nil {
+		log.Fatal("Invalid setup configuration:", err)
+	}
+
+	// 社内 IdP での OIDC ログイン（OIDC_ISSUER 未設定なら無効）。
+	oidcConfig, err := loadOIDCConfig(mlConfig.ServerAddr)
+	if err != nil {
+		log.Fatal("Invalid OIDC configuration:", err)
+	}
+
 	// 3. Initialize Handlers
 	queries := database.New(conn)
-	authHandler := handlers.NewAuthHandler(queries)
-	profileHandler := handlers.NewProfileHandler(queries)
-	setupHandler := handlers.NewSetupHandler(queries)
+	var ssoName string
+	if oidcConfig.Enabled() {
+		ssoName = oidcConfig.ProviderName
+	}
+	authHandler := handlers.NewAuthHandler(queries, ssoName)
+	profileHandler := handlers.NewProfileHandler(conn, queries, ml)
+	setupHandler := handlers.NewSetupHandler(conn, queries, sender, setupToken)
+	invitationHandler := handlers.NewInvitationHandler(conn, queries, ml)
+
+	// inline script を nonce で許す CSP（CSP_MODE: enforce / report-only / off）。
+	cspMode, err := appMiddleware.ParseCSPMode(os.Getenv("CSP_MODE"))
+	if err != nil {
+		log.Fatal("Invalid CSP configuration:", err)
+	}
+
+	// ゴミ箱の保持日数（TRASH_RETENTION_DAYS。0 なら自動では削除しない）。
+	trashDays, err := trash.ParseRetentionDays(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil {
+		log.Fatal("Invalid trash configuration:", err)
+	}
+	trash.SetRetentionDays(trashDays)
 
 	// 4. Chi Router Setup
 	r := chi.NewRouter()
 	r.Use(chiMiddleware.Recoverer)
 	r.Use(appMiddleware.SecurityHeaders)
+	r.Use(appMiddleware.CSP(cspMode))
 	r.Use(appMiddleware.NoIndex)
 	r.Use(appMiddleware.UserContextMiddleware(ml, conn))
 	// 直近リクエストのインメモリ保持（管理画面のアクセスログビューワ用。再起動で消える）。
@@ -143,6 +198,14 @@ func main() {
 	// UserContextMiddleware より外側に置くと AccessLog 側が見る r.Context() に
 	// userEmail が反映されず、user_id が空のままログ出力されてしまう。
 	r.Use(appMiddleware.AccessLogMiddleware(logger.AccessWriter(), accessLogStore))
+	// 書き込みのリクエストが別のサイトから送らせたものでないか確かめる（Origin / Sec-Fetch-Site と
+	// セッションごとのトークン）。断った 403 もアクセスログに残すよう AccessLogMiddleware の内側に置く。
+	// CSP の違反報告はブラウザが自分で送るため、トークンを持たない。multipart のフォームは
+	// 受け付けるルートだけを並べ、トークンはそのハンドラが確かめる。
+	r.Use(appMiddleware.CSRF(ml.Config.CookieName, mlConfig.ServerAddr, appMiddleware.CSRFOptions{
+		Exempt:    []string{appMiddleware.CSPReportPath},
+		Multipart: routes.CSRFMultipartPaths,
+	}))
 
 	// Static files
 	staticSubFS, err := fs.Sub(web.StaticFS, "static")
@@ -154,6 +217,7 @@ func main() {
 
 	// 5. Routes
 	r.Get("/health", handlers.HealthCheck)
+	r.With(appMiddleware.MaxBodySize(limits.CSPReportBody)).Post(appMiddleware.CSPReportPath, handlers.CSPReport)
 
 	// robots.txt: 全クローラに全パスのクロールを禁止する。検索結果に
 	// 出したくない限定公開サービス向け。公開サイトにする場合は外す。
@@ -167,6 +231,7 @@ func main() {
 	r.Get("/auth/login", authHandler.LoginPage)
 	r.Get("/setup", setupHandler.SetupPage)
 	r.Post("/setup", setupHandler.CreateInitialAdmin)
+	r.Post("/setup/test-mail", setupHandler.SendTestMail)
 
 	// Datastar リファレンス/回帰確認ページ（dev 限定。本番では登録しない）。
 	// docs/datastar/datastar-llm-guide.md の各機能を実機で確認できる。
@@ -190,16 +255,48 @@ func main() {
 	r.Post("/datastar/recipes/api/reset", handlers.RecipeReset)
 
 	// MagicLink handlers (net/http ベース)
-	// Handler() は /auth/login, /auth/verify, /auth/logout, /webauthn/* をフルパスで登録
-	mlHandler := ml.Handler()
+	// Handler() は /auth/login, /auth/verify, /auth/logout, /webauthn/* をフルパスで登録。
+	// ログイン時に発行されたセッションの IP・User-Agent を記録するために包む（マイページのセッション一覧用）。
+	// ログアウトやパスキーの登録でセッションのユーザー情報が変わるので、キャッシュも捨てる。
+	// 未登録のアドレスのサインアップは、リンクを開いて本人と確かめた後に行う。
+	allowVerified := func(ctx context.Context, email string) error {
+		return loginpolicy.AllowVerifiedLogin(ctx, conn, email)
+	}
+	mlHandler := appMiddleware.RecordSessionDetails(ml.Config.CookieName, conn, authsession.MethodMagicLink)(
+		appMiddleware.ForgetSessionIdentity(ml.Config.CookieName)(
+			appMiddleware.AllowVerifiedLogin(ml.Config.CookieName, ml.Config.ErrorRedirectURL, conn, allowVerified)(ml.Handler())))
 	r.Handle("/auth/*", mlHandler)
 	r.Handle("/webauthn/*", mlHandler)
 
+	// 招待メールのリンク（認証不要）。承認するとそのままログインするため、
+	// magiclink のログインと同じくセッションの IP・User-Agent を記録する。
+	r.Get(invitation.AcceptPath, invitationHandler.AcceptPage)
+	r.With(appMiddleware.RecordSessionDetails(ml.Config.CookieName, conn, authsession.MethodMagicLink)).Post(invitation.AcceptPath, invitationHandler.Accept)
+
+	// OIDC ログイン（認証不要）。/auth/* より具体的なパスなので magiclink より優先される。
+	// コールバックでセッションを作るため、magiclink と同じくセッションの IP・User-Agent を記録する。
+	if oidcConfig.Enabled() {
+		oidcHandler := handlers.NewOIDCHandler(conn, ml, oidc.New(oidcConfig))
+		r.Get(oidc.LoginPath, oidcHandler.Login)
+		r.With(appMiddleware.RecordSessionDetails(ml.Config.CookieName, conn, authsession.MethodSSO)).Get(oidc.CallbackPath, oidcHandler.Callback)
+	}
+
 	// Business & Admin Routes
-	authMW := appMiddleware.RequireAuth("/auth/login")
-	routes.RegisterBusinessRoutes(r, conn, queries, authMW)
-	routes.RegisterAdminRoutes(r, queries, authMW, accessLogStore)
-	routes.RegisterSSERoutes(r, queries, ml, authMW)
+	// 認証付きのルートはすべてメンテナンス（admin 以外は 503）と読み取り専用モード（書き込みを拒否）、
+	// 「このユーザーとして表示」中の書き込みの拒否のミドルウェアを通す。状態は毎リクエスト DB を読まないようキャッシュし、管理画面での切替時に捨てる。
+	maintenanceCache := maintenance.NewCache(queries)
+	requireAuth := appMiddleware.RequireAuth("/auth/login")
+	maintenanceMW := appMiddleware.Maintenance(maintenanceCache, http.HandlerFunc(handlers.MaintenancePage))
+	readOnlyMW := appMiddleware.ReadOnly(maintenanceCache, http.HandlerFunc(handlers.ReadOnlyRejectedSSE), routes.ReadOnlyExemptPrefix, routes.ImpersonationStopPath)
+	impersonationMW := appMiddleware.BlockImpersonatedWrites(http.HandlerFunc(handlers.ImpersonationRejectedSSE), routes.ImpersonationStopPath)
+	guardMW := func(next http.Handler) http.Handler { return maintenanceMW(readOnlyMW(impersonationMW(next))) }
+	authMW := func(next http.Handler) http.Handler { return requireAuth(guardMW(next)) }
+	routes.RegisterBusinessRoutes(r, conn, queries, inviter, authMW)
+	routes.RegisterAdminRoutes(r, conn, queries, maintenanceCache, authMW, accessLogStore)
+	routes.RegisterSSERoutes(r, conn, queries, ml, inviter, maintenanceCache, authMW)
+	// JSON API は未認証をログイン画面へ送らず 401 にするため、RequireAuth の代わりに
+	// RequireAPIAuth を使う（メンテナンスと読み取り専用モードは画面と同じく効かせる）。
+	routes.RegisterAPIRoutes(r, conn, queries, ml, inviter, guardMW)
 
 	// Profile Routes
 	r.Group(func(r chi.Router) {
@@ -207,6 +304,9 @@ func main() {
 		r.Get("/profile", profileHandler.ShowProfile)
 	})
 
+	// OpenAPI ドキュメントと API エクスプローラ。上で登録した全ルートから組み立てるので最後に登録する。
+	routes.RegisterDocsRoutes(r, ml.Config.CookieName, guardMW, authMW)
+
 	// Start server
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -227,6 +327,9 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	// 保持期間を過ぎたゴミ箱の行を、起動時と 1 時間ごとに完全に削除する。
+	go trash.Run(ctx, conn)
+
 	serverErr := make(chan error, 1)
 	go func() {
 		log.Printf("Starting server on :%s", port)
@@ -252,6 +355,29 @@ func main() {
 	// 「排水完了後」に実行される（Shutdown より先に DB を閉じてはいけない）。
 }
 
+// openDB は DB_PATH の SQLite を開き、goose マイグレーションを適用する。
+func openDB() (*sql.DB, error) {
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "app.db"
+	}
+	conn, err := sql.Open("sqlite", "file:"+dbPath+"?_pragma=busy_timeout(30000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(on)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", dbPath, err)
+	}
+
+	goose.SetBaseFS(db.MigrationsFS)
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to set goose dialect: %w", err)
+	}
+	if err := goose.Up(conn, "migrations"); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to apply migrations: %w", err)
+	}
+	return conn, nil
+}
+
 func mustAtoi(s string, defaultValue int) int {
 	if s == "" {
 		return defaultValue
@@ -303,6 +429,33 @@ func ensureAdminUser(conn *sql.DB) error {
 	return nil
 }
 
+// initialSetupToken は初期セットアップ（/setup）のワンタイムトークンを返す。SETUP_TOKEN が
+// あればそれを、無ければ起動ごとに作る。ユーザーがいなければセットアップの URL をログに出す
+// （作ったトークンはここでしか分からない）。ユーザーがいれば空（セットアップは開けない）。
+func initialSetupToken(conn *sql.DB, serverAddr string) (string, error) {
+	token := os.Getenv("SETUP_TOKEN")
+	if token != "" && len(token) < handlers.MinSetupTokenLength {
+		return "", fmt.Errorf("SETUP_TOKEN must be at least %d characters", handlers.MinSetupTokenLength)
+	}
+
+	var count int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		return "", fmt.Errorf("failed to count users: %w", err)
+	}
+	if count > 0 {
+		return "", nil
+	}
+
+	setupURL := strings.TrimRight(serverAddr, "/") + "/setup?token="
+	if token == "" {
+		token = handlers.NewSetupToken()
+		log.Printf("No users found. Open %s%s to create the first admin.", setupURL, token)
+	} else {
+		log.Printf("No users found. Open %s<SETUP_TOKEN> to create the first admin.", setupURL)
+	}
+	return token, nil
+}
+
 func generateCookieName(projectName string) string {
 	name := strings.ToLower(projectName)
 	reg := regexp.MustCompile(`[^a-z0-9]+`)
@@ -328,6 +481,36 @@ func resolveServerAddr() string {
 	return version.ServerAddr
 }
 
+// loadOIDCConfig は OIDC_* の環境変数から OIDC ログインの設定を読む。
+// OIDC_ISSUER が空なら無効（ゼロ値）を返す。
+func loadOIDCConfig(serverAddr string) (oidc.Config, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return oidc.Config{}, nil
+	}
+	cfg := oidc.Config{
+		Issuer:         issuer,
+		ClientID:       os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:   os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:    strings.TrimSuffix(serverAddr, "/") + oidc.CallbackPath,
+		ProviderName:   os.Getenv("OIDC_PROVIDER_NAME"),
+		AllowedDomains: oidc.ParseDomains(os.Getenv("OIDC_ALLOWED_DOMAINS")),
+		RoleClaim:      os.Getenv("OIDC_ROLE_CLAIM"),
+	}
+	if cfg.ClientID == "" {
+		return oidc.Config{}, fmt.Errorf("OIDC_CLIENT_ID is required when OIDC_ISSUER is set")
+	}
+	if cfg.ProviderName == "" {
+		cfg.ProviderName = "SSO"
+	}
+	roleMap, err := oidc.ParseRoleMap(os.Getenv("OIDC_ROLE_MAP"))
+	if err != nil {
+		return oidc.Config{}, err
+	}
+	cfg.RoleMap = roleMap
+	return cfg, nil
+}
+
 func extractHost(serverAddr string) string {
 	u, err := url.Parse(serverAddr)
 	if err != nil || u.Host == "" {
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS session_details (
+    session_hash TEXT PRIMARY KEY,
+    user_email TEXT NOT NULL,
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_details_user_email ON session_details(user_email);
+
+-- +goose Down
+DROP TABLE IF EXISTS session_details;
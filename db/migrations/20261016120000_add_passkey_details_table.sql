@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS passkey_details (
+    credential_id TEXT PRIMARY KEY,
+    user_email TEXT NOT NULL,
+    nickname TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_passkey_details_user_email ON passkey_details(user_email);
+
+-- +goose Down
+DROP TABLE IF EXISTS passkey_details;
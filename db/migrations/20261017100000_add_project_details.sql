@@ -0,0 +1,33 @@
+-- +goose Up
+ALTER TABLE projects ADD COLUMN description TEXT NOT NULL DEFAULT ''; -- Markdown
+ALTER TABLE projects ADD COLUMN status TEXT NOT NULL DEFAULT 'active'; -- planned, active, on_hold, done
+ALTER TABLE projects ADD COLUMN owner_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE projects ADD COLUMN due_date DATE;
+ALTER TABLE projects ADD COLUMN tags TEXT NOT NULL DEFAULT ''; -- comma-separated
+
+-- +goose Down
+-- owner_id は外部キーのため DROP COLUMN できない。db/README.md の手順で projects を作り直す。
+-- foreign_keys をトランザクション内で切れないので、projects を参照する project_members も
+-- 退避して作り直す（そのまま DROP TABLE projects するとメンバーが CASCADE で消える）。
+CREATE TEMP TABLE project_members_backup AS SELECT * FROM project_members;
+DROP INDEX IF EXISTS idx_project_members_user_id;
+DROP TABLE project_members;
+CREATE TABLE projects_new (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO projects_new (id, name, created_at)
+    SELECT id, name, created_at FROM projects;
+DROP TABLE projects;
+ALTER TABLE projects_new RENAME TO projects;
+CREATE TABLE project_members (
+    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL, -- owner, editor, viewer
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (project_id, user_id)
+);
+INSERT INTO project_members SELECT * FROM project_members_backup;
+DROP TABLE project_members_backup;
+CREATE INDEX IF NOT EXISTS idx_project_members_user_id ON project_members(user_id);
@@ -0,0 +1,16 @@
+-- +goose Up
+-- 削除はゴミ箱に移すだけ（deleted_at に日時を入れる）。NULL なら削除されていない。
+ALTER TABLE projects ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_projects_deleted_at ON projects(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at);
+
+-- +goose Down
+-- 列を消すとゴミ箱の中身が通常の行に戻ってしまうため、先に完全に削除する。
+DELETE FROM projects WHERE deleted_at IS NOT NULL;
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS idx_users_deleted_at;
+DROP INDEX IF EXISTS idx_projects_deleted_at;
+ALTER TABLE users DROP COLUMN deleted_at;
+ALTER TABLE projects DROP COLUMN deleted_at;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- セッションをどの手段（passkey / magic_link / sso）で発行したか。本人確認（step-up）で、
+-- パスキーを登録しているユーザーにはパスキーでのログインを求めるために使う。
+-- 既存のセッションは空文字（手段が分からない = パスキーとしては扱わない）。
+ALTER TABLE session_details ADD COLUMN auth_method TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE session_details DROP COLUMN auth_method;
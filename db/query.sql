@@ -1,27 +1,65 @@
 -- NOTE: Do not use Japanese in sqlc source files (causes code generation bugs)
 
 -- name: GetUserByEmail :one
-SELECT * FROM users WHERE email = ? LIMIT 1;
+SELECT * FROM users WHERE email = ? AND deleted_at IS NULL LIMIT 1;
 
 -- name: GetUserByID :one
-SELECT * FROM users WHERE id = ? LIMIT 1;
+SELECT * FROM users WHERE id = ? AND deleted_at IS NULL LIMIT 1;
 
 -- name: CreateUser :one
 INSERT INTO users (email, name, role, is_active)
 VALUES (?, ?, ?, ?)
 RETURNING *;
 
+-- name: CreateFirstUser :one
+-- ユーザーが 1 人もいないときだけ作る（初期セットアップ）。いれば行を返さない（sql.ErrNoRows）。
+-- 数えてから作る間に別のリクエストが割り込まないよう、1 文で行う。
+INSERT INTO users (email, name, role, is_active)
+SELECT ?, ?, ?, ?
+WHERE NOT EXISTS (SELECT 1 FROM users)
+RETURNING *;
+
 -- name: UpdateUser :one
 UPDATE users
 SET name = ?, role = ?, is_active = ?, updated_at = CURRENT_TIMESTAMP
-WHERE id = ?
+WHERE id = ? AND deleted_at IS NULL
 RETURNING *;
 
 -- name: ListUsers :many
-SELECT * FROM users ORDER BY created_at DESC;
+SELECT * FROM users WHERE deleted_at IS NULL ORDER BY created_at DESC;
+
+-- name: ListUsersPage :many
+SELECT * FROM users
+WHERE deleted_at IS NULL
+  AND id < sqlc.arg(before_id)
+  AND (name LIKE sqlc.arg(pattern) ESCAPE '\' OR email LIKE sqlc.arg(pattern) ESCAPE '\')
+  AND role LIKE sqlc.arg(role_pattern) ESCAPE '\'
+  AND (sqlc.narg(is_active) IS NULL OR is_active = sqlc.narg(is_active))
+ORDER BY id DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: TrashUser :exec
+UPDATE users SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL;
 
--- name: DeleteUser :exec
-DELETE FROM users WHERE id = ?;
+-- name: GetTrashedUserByEmail :one
+SELECT * FROM users WHERE email = ? AND deleted_at IS NOT NULL LIMIT 1;
+
+-- name: ListTrashedUsers :many
+SELECT * FROM users WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id DESC;
+
+-- name: RestoreUser :one
+UPDATE users
+SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND deleted_at IS NOT NULL
+RETURNING *;
+
+-- name: PurgeUser :one
+DELETE FROM users WHERE id = ? AND deleted_at IS NOT NULL
+RETURNING *;
+
+-- name: PurgeUsersTrashedBefore :many
+DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < ?
+RETURNING *;
 
 -- name: CountUsers :one
 SELECT COUNT(*) FROM users;
@@ -31,4 +69,181 @@ SELECT * FROM app_settings WHERE key = ? LIMIT 1;
 
 -- name: UpsertAppSetting :exec
 INSERT INTO app_settings (key, value) VALUES (?, ?)
-ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP;
\ No newline at end of file
+ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP;
+
+-- name: DeleteAppSetting :exec
+DELETE FROM app_settings WHERE key = ?;
+
+-- name: CreateAuditLog :one
+INSERT INTO audit_log (actor_id, actor_email, action, target_type, target_id, before_json, after_json, prev_hash, hash, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: GetLatestAuditLog :one
+SELECT * FROM audit_log ORDER BY id DESC LIMIT 1;
+
+-- name: ListAuditLogs :many
+SELECT * FROM audit_log
+WHERE actor_email LIKE sqlc.arg(actor_pattern) ESCAPE '\'
+  AND target_type LIKE sqlc.arg(target_type_pattern) ESCAPE '\'
+  AND target_id LIKE sqlc.arg(target_id_pattern) ESCAPE '\'
+  AND created_at >= sqlc.arg(since)
+  AND created_at < sqlc.arg(until)
+ORDER BY id DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: ListAuditLogsAfter :many
+SELECT * FROM audit_log WHERE id > ? ORDER BY id LIMIT ?;
+
+-- name: CreateSessionDetail :exec
+INSERT INTO session_details (session_hash, user_email, ip, user_agent, created_at, last_seen_at, auth_method)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(session_hash) DO NOTHING;
+
+-- name: TouchSessionDetail :exec
+UPDATE session_details SET last_seen_at = ?, ip = ? WHERE session_hash = ?;
+
+-- name: ListSessionDetailsByEmail :many
+SELECT * FROM session_details WHERE user_email = ?;
+
+-- name: GetSessionAuthMethod :one
+SELECT auth_method FROM session_details WHERE session_hash = ?;
+
+-- name: DeleteSessionDetail :exec
+DELETE FROM session_details WHERE session_hash = ?;
+
+-- name: DeleteSessionDetailsByEmail :exec
+DELETE FROM session_details WHERE user_email = ?;
+
+-- name: CreateImpersonation :one
+INSERT INTO impersonations (session_hash, admin_user_id, target_user_id, started_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(session_hash) DO UPDATE SET
+    admin_user_id = excluded.admin_user_id,
+    target_user_id = excluded.target_user_id,
+    started_at = excluded.started_at
+RETURNING *;
+
+-- name: GetImpersonation :one
+SELECT * FROM impersonations WHERE session_hash = ?;
+
+-- name: DeleteImpersonation :execrows
+DELETE FROM impersonations WHERE session_hash = ?;
+
+-- name: UpsertPasskeyDetail :exec
+INSERT INTO passkey_details (credential_id, user_email, nickname, updated_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(credential_id) DO UPDATE SET nickname = excluded.nickname, updated_at = excluded.updated_at;
+
+-- name: ListPasskeyDetailsByEmail :many
+SELECT * FROM passkey_details WHERE user_email = ?;
+
+-- name: DeletePasskeyDetail :exec
+DELETE FROM passkey_details WHERE credential_id = ?;
+
+-- name: DeletePasskeyDetailsByEmail :exec
+DELETE FROM passkey_details WHERE user_email = ?;
+
+-- name: UpsertInvitation :one
+INSERT INTO invitations (user_id, token_hash, invited_by, created_at, expires_at)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(user_id) DO UPDATE SET
+    token_hash = excluded.token_hash,
+    invited_by = excluded.invited_by,
+    created_at = excluded.created_at,
+    expires_at = excluded.expires_at,
+    sent_at = NULL,
+    accepted_at = NULL
+RETURNING *;
+
+-- name: MarkInvitationSent :exec
+UPDATE invitations SET sent_at = ? WHERE id = ?;
+
+-- name: GetInvitationByUserID :one
+SELECT * FROM invitations WHERE user_id = ? LIMIT 1;
+
+-- name: GetInvitationByTokenHash :one
+SELECT * FROM invitations WHERE token_hash = ? LIMIT 1;
+
+-- name: AcceptInvitation :execrows
+UPDATE invitations SET accepted_at = ? WHERE id = ? AND accepted_at IS NULL;
+
+-- name: DeletePendingInvitation :execrows
+DELETE FROM invitations WHERE user_id = ? AND accepted_at IS NULL;
+
+-- name: ListPendingInvitations :many
+SELECT invitations.id, invitations.user_id, users.email, users.name, users.role,
+       invitations.invited_by, invitations.created_at, invitations.expires_at, invitations.sent_at
+FROM invitations
+JOIN users ON users.id = invitations.user_id
+WHERE invitations.accepted_at IS NULL AND users.is_active = 0 AND users.deleted_at IS NULL
+ORDER BY invitations.created_at DESC;
+
+-- name: CreateSignupRequest :exec
+INSERT INTO signup_requests (email, requested_at) VALUES (?, ?)
+ON CONFLICT(email) DO NOTHING;
+
+-- name: GetSignupRequest :one
+SELECT * FROM signup_requests WHERE id = ? LIMIT 1;
+
+-- name: GetSignupRequestByEmail :one
+SELECT * FROM signup_requests WHERE email = ? LIMIT 1;
+
+-- name: ListSignupRequests :many
+SELECT * FROM signup_requests ORDER BY requested_at ASC, id ASC;
+
+-- name: DeleteSignupRequest :execrows
+DELETE FROM signup_requests WHERE id = ?;
+
+-- name: CreateAPIToken :one
+INSERT INTO api_tokens (user_id, name, token_hash, token_prefix, scope, created_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: GetAPIToken :one
+SELECT * FROM api_tokens WHERE id = ? LIMIT 1;
+
+-- name: GetAPITokenByHash :one
+SELECT * FROM api_tokens WHERE token_hash = ? LIMIT 1;
+
+-- name: ListAPITokensByUser :many
+SELECT * FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC, id DESC;
+
+-- name: ListAPITokens :many
+SELECT api_tokens.id, api_tokens.user_id, users.email, api_tokens.name, api_tokens.token_prefix,
+       api_tokens.scope, api_tokens.created_at, api_tokens.expires_at, api_tokens.last_used_at
+FROM api_tokens
+JOIN users ON users.id = api_tokens.user_id
+WHERE users.deleted_at IS NULL
+ORDER BY api_tokens.created_at DESC, api_tokens.id DESC;
+
+-- name: TouchAPIToken :exec
+UPDATE api_tokens SET last_used_at = ? WHERE id = ?;
+
+-- name: DeleteAPIToken :execrows
+DELETE FROM api_tokens WHERE id = ?;
+
+-- name: ListCustomRoles :many
+SELECT * FROM custom_roles ORDER BY name ASC;
+
+-- name: GetCustomRole :one
+SELECT * FROM custom_roles WHERE name = ? LIMIT 1;
+
+-- name: CreateCustomRole :one
+INSERT INTO custom_roles (name, label, permissions, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: UpdateCustomRole :one
+UPDATE custom_roles SET label = ?, permissions = ?, updated_at = ?
+WHERE name = ?
+RETURNING *;
+
+-- name: DeleteCustomRole :execrows
+DELETE FROM custom_roles WHERE name = ?;
+
+-- name: CountUsersByRole :one
+SELECT COUNT(*) FROM users WHERE role = ?;
+
+-- name: CountActiveUsersGroupByRole :many
+SELECT role, COUNT(*) AS count FROM users WHERE is_active = 1 AND deleted_at IS NULL GROUP BY role;
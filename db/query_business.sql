@@ -1,22 +1,103 @@
 -- NOTE: Do not use Japanese in sqlc source files (causes code generation bugs)
 
 -- name: ListProjects :many
-SELECT * FROM projects ORDER BY created_at DESC;
+SELECT * FROM projects WHERE deleted_at IS NULL ORDER BY created_at DESC;
 
 -- name: CreateProject :one
-INSERT INTO projects (name)
-VALUES (?)
+INSERT INTO projects (name, description, status, owner_id, due_date, tags)
+VALUES (?, ?, ?, ?, ?, ?)
 RETURNING *;
 
 -- name: GetProject :one
-SELECT * FROM projects WHERE id = ? LIMIT 1;
+SELECT * FROM projects WHERE id = ? AND deleted_at IS NULL LIMIT 1;
 
 -- name: UpdateProject :one
 UPDATE projects
-SET name = ?
-WHERE id = ?
+SET name = ?, description = ?, status = ?, owner_id = ?, due_date = ?, tags = ?
+WHERE id = ? AND deleted_at IS NULL
 RETURNING *;
 
--- name: DeleteProject :exec
+-- name: ClearProjectOwner :exec
+UPDATE projects
+SET owner_id = NULL
+WHERE id = ? AND owner_id = ?;
+
+-- name: TrashProject :exec
+UPDATE projects
+SET deleted_at = ?
+WHERE id = ? AND deleted_at IS NULL;
+
+-- name: ListTrashedProjects :many
+SELECT * FROM projects WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id DESC;
+
+-- name: RestoreProject :one
+UPDATE projects
+SET deleted_at = NULL
+WHERE id = ? AND deleted_at IS NOT NULL
+RETURNING *;
+
+-- name: PurgeProject :one
+DELETE FROM projects
+WHERE id = ? AND deleted_at IS NOT NULL
+RETURNING *;
+
+-- name: PurgeProjectsTrashedBefore :many
 DELETE FROM projects
-WHERE id = ?;
+WHERE deleted_at IS NOT NULL AND deleted_at < ?
+RETURNING *;
+
+-- name: ListProjectsPage :many
+SELECT * FROM projects
+WHERE deleted_at IS NULL
+  AND id < sqlc.arg(before_id)
+  AND name LIKE sqlc.arg(name_pattern) ESCAPE '\'
+ORDER BY id DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: ListProjectsForUser :many
+SELECT p.*, m.role FROM projects p
+JOIN project_members m ON m.project_id = p.id
+WHERE m.user_id = ? AND p.deleted_at IS NULL
+ORDER BY p.created_at DESC;
+
+-- name: ListProjectsPageForUser :many
+SELECT p.* FROM projects p
+JOIN project_members m ON m.project_id = p.id
+WHERE m.user_id = sqlc.arg(user_id)
+  AND p.deleted_at IS NULL
+  AND p.id < sqlc.arg(before_id)
+  AND p.name LIKE sqlc.arg(name_pattern) ESCAPE '\'
+ORDER BY p.id DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: GetProjectMember :one
+SELECT * FROM project_members
+WHERE project_id = ? AND user_id = ?
+LIMIT 1;
+
+-- name: ListProjectMembers :many
+SELECT m.project_id, m.user_id, m.role, m.created_at, u.email, u.name
+FROM project_members m
+JOIN users u ON u.id = m.user_id
+WHERE m.project_id = ? AND u.deleted_at IS NULL
+ORDER BY m.created_at ASC, m.user_id ASC;
+
+-- name: CreateProjectMember :one
+INSERT INTO project_members (project_id, user_id, role, created_at)
+VALUES (?, ?, ?, ?)
+RETURNING *;
+
+-- name: UpdateProjectMemberRole :one
+UPDATE project_members
+SET role = ?
+WHERE project_id = ? AND user_id = ?
+RETURNING *;
+
+-- name: DeleteProjectMember :execrows
+DELETE FROM project_members
+WHERE project_id = ? AND user_id = ?;
+
+-- name: CountProjectMembersByRole :one
+SELECT COUNT(*) FROM project_members m
+JOIN users u ON u.id = m.user_id
+WHERE m.project_id = ? AND m.role = ? AND u.deleted_at IS NULL;
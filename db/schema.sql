@@ -10,13 +10,125 @@ CREATE TABLE IF NOT EXISTS users (
     role TEXT NOT NULL DEFAULT 'viewer', -- admin, viewer
     is_active BOOLEAN NOT NULL DEFAULT 1,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP -- NULL unless moved to the trash
 );
 
 CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at);
 
 CREATE TABLE IF NOT EXISTS app_settings (
     key TEXT PRIMARY KEY,
     value TEXT NOT NULL,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
\ No newline at end of file
+);
+
+-- Append-only audit trail. Each row stores the hash of the previous row
+-- (prev_hash) so that edits or deletions break the chain (see internal/audit).
+-- actor_id = 0 means the change was made by the system (CLI, startup).
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor_id INTEGER NOT NULL DEFAULT 0,
+    actor_email TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL,
+    target_id TEXT NOT NULL DEFAULT '',
+    before_json TEXT NOT NULL DEFAULT '',
+    after_json TEXT NOT NULL DEFAULT '',
+    prev_hash TEXT NOT NULL DEFAULT '',
+    hash TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor_email);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_type, target_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
+
+-- Login metadata for magiclink sessions (the sessions table itself is owned by
+-- nz-magic-link). session_hash matches sessions.session_hash; rows whose
+-- session is gone are ignored and pruned (see internal/authsession).
+CREATE TABLE IF NOT EXISTS session_details (
+    session_hash TEXT PRIMARY KEY,
+    user_email TEXT NOT NULL,
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    auth_method TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_details_user_email ON session_details(user_email);
+
+-- Admin impersonation ("view as user") bound to one magiclink session. While a
+-- row exists, requests with that session act as target_user_id and writes are
+-- refused. Rows whose session is gone are ignored and pruned.
+CREATE TABLE IF NOT EXISTS impersonations (
+    session_hash TEXT PRIMARY KEY,
+    admin_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    target_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    started_at TIMESTAMP NOT NULL
+);
+
+-- User-facing metadata for magiclink passkey credentials (passkey_credentials is
+-- owned by nz-magic-link). credential_id matches passkey_credentials.id.
+CREATE TABLE IF NOT EXISTS passkey_details (
+    credential_id TEXT PRIMARY KEY,
+    user_email TEXT NOT NULL,
+    nickname TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_passkey_details_user_email ON passkey_details(user_email);
+
+-- Pending invitations for admin-created / imported users. A user is "pending"
+-- while is_active = 0 and the invitation has not been accepted. Only the
+-- SHA-256 of the emailed token is stored; resending replaces it, which
+-- invalidates the previous link (see internal/invitation).
+CREATE TABLE IF NOT EXISTS invitations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    invited_by TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    sent_at TIMESTAMP,
+    accepted_at TIMESTAMP
+);
+
+-- Self-service sign-ups from an allowed email domain that are waiting for admin
+-- approval (see internal/signup). No users row exists until an admin approves;
+-- approving creates the user with the policy's default role and deletes the row.
+CREATE TABLE IF NOT EXISTS signup_requests (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email TEXT NOT NULL UNIQUE,
+    requested_at TIMESTAMP NOT NULL
+);
+
+-- Personal access tokens for scripts and integrations (Authorization: Bearer).
+-- Only the SHA-256 of the token is stored; token_prefix keeps the first few
+-- characters so users can tell their tokens apart. scope is 'read' (safe
+-- methods only) or 'write'. A NULL expires_at means the token never expires.
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    token_prefix TEXT NOT NULL,
+    scope TEXT NOT NULL DEFAULT 'read',
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+
+-- Roles defined by admins on /admin/roles, in addition to the built-in
+-- viewer / editor / admin (which live in internal/roles and are not stored).
+-- permissions is a comma-separated list of roles.Permission values.
+CREATE TABLE IF NOT EXISTS custom_roles (
+    name TEXT PRIMARY KEY,
+    label TEXT NOT NULL,
+    permissions TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
@@ -6,5 +6,23 @@
 CREATE TABLE IF NOT EXISTS projects (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
   name TEXT NOT NULL,
-  created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  description TEXT NOT NULL DEFAULT '', -- Markdown
+  status TEXT NOT NULL DEFAULT 'active', -- planned, active, on_hold, done
+  owner_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  due_date DATE,
+  tags TEXT NOT NULL DEFAULT '', -- comma-separated
+  deleted_at TIMESTAMP -- NULL unless moved to the trash
 );
+
+CREATE INDEX IF NOT EXISTS idx_projects_deleted_at ON projects(deleted_at);
+
+CREATE TABLE IF NOT EXISTS project_members (
+  project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  role TEXT NOT NULL, -- owner, editor, viewer
+  created_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (project_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_project_members_user_id ON project_members(user_id);
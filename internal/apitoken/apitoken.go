@@ -0,0 +1,179 @@
+// Package apitoken はスクリプトや外部連携から使う個人用 API トークンを扱う。
+//
+// トークンはユーザーがマイページで名前を付けて発行し、`Authorization: Bearer <token>` で送る。
+// DB には SHA-256 ハッシュと、一覧で見分けるための先頭数文字だけを保存し、平文は発行直後に
+// 1 回だけ表示する。スコープは read（GET などの安全なメソッドのみ）と write（すべて）の 2 種類。
+// トークンで認証したリクエストは、所有者本人のロールで動く。
+package apitoken
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/opaquetoken"
+)
+
+// スコープ。api_tokens.scope に保存される値と一致する。
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// Prefix は発行するトークンの先頭に付ける目印。ログやリポジトリに紛れ込んだときに
+// トークンだと分かるようにする。
+const Prefix = "pat_"
+
+// displayPrefixLen は一覧に表示するトークン先頭の文字数（Prefix を含む）。
+const displayPrefixLen = len(Prefix) + 6
+
+// NameMaxLen はトークン名の最大文字数。
+const NameMaxLen = 100
+
+// ExpiryDays は発行ダイアログで選べる有効期間（日数）。0 は無期限。
+var ExpiryDays = []int{30, 90, 365, 0}
+
+// Authenticate が返すエラー。どちらも 401 にする。
+var (
+	ErrInvalid = errors.New("apitoken: invalid token")
+	ErrExpired = errors.New("apitoken: expired")
+)
+
+// IsValidScope は s が有効なスコープかを返す。
+func IsValidScope(s string) bool {
+	return s == ScopeRead || s == ScopeWrite
+}
+
+// IsValidExpiryDays は発行ダイアログで選べる有効期間かを返す。
+func IsValidExpiryDays(days int) bool {
+	for _, d := range ExpiryDays {
+		if d == days {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateName はトークン名を検証し、前後の空白を除いた名前を返す。
+func ValidateName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("名前を入力してください")
+	}
+	if utf8.RuneCountInString(name) > NameMaxLen {
+		return "", fmt.Errorf("名前は %d 文字以内で入力してください", NameMaxLen)
+	}
+	return name, nil
+}
+
+// Create は userID のトークンを発行し、保存した行と平文のトークンを返す。
+// 平文はここでしか得られない（DB にはハッシュだけが残る）。expiryDays が 0 なら無期限。
+func Create(ctx context.Context, q *database.Queries, userID int64, name, scope string, expiryDays int) (database.ApiToken, string, error) {
+	raw, err := opaquetoken.New(Prefix)
+	if err != nil {
+		return database.ApiToken{}, "", err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	var expires sql.NullTime
+	if expiryDays > 0 {
+		expires = sql.NullTime{Time: now.AddDate(0, 0, expiryDays), Valid: true}
+	}
+	tok, err := q.CreateAPIToken(ctx, database.CreateAPITokenParams{
+		UserID:      userID,
+		Name:        name,
+		TokenHash:   opaquetoken.Hash(raw),
+		TokenPrefix: raw[:displayPrefixLen],
+		Scope:       scope,
+		CreatedAt:   now,
+		ExpiresAt:   expires,
+	})
+	if err != nil {
+		return database.ApiToken{}, "", fmt.Errorf("apitoken: save: %w", err)
+	}
+	return tok, raw, nil
+}
+
+// AuditState は監査ログに残すトークンの状態。token_hash は記録しない。
+func AuditState(t database.ApiToken) map[string]any {
+	m := map[string]any{
+		"user_id":      t.UserID,
+		"name":         t.Name,
+		"token_prefix": t.TokenPrefix,
+		"scope":        t.Scope,
+	}
+	if t.ExpiresAt.Valid {
+		m["expires_at"] = t.ExpiresAt.Time
+	}
+	return m
+}
+
+// Authenticate は raw のトークンとその所有者を返す。
+// 期限切れ・所有者が無効化されたトークンは使えない。
+func Authenticate(ctx context.Context, q *database.Queries, raw string) (database.ApiToken, database.User, error) {
+	if !strings.HasPrefix(raw, Prefix) {
+		return database.ApiToken{}, database.User{}, ErrInvalid
+	}
+	tok, err := q.GetAPITokenByHash(ctx, opaquetoken.Hash(raw))
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.ApiToken{}, database.User{}, ErrInvalid
+	}
+	if err != nil {
+		return database.ApiToken{}, database.User{}, fmt.Errorf("apitoken: lookup: %w", err)
+	}
+	if tok.ExpiresAt.Valid && !time.Now().Before(tok.ExpiresAt.Time) {
+		return database.ApiToken{}, database.User{}, ErrExpired
+	}
+	user, err := q.GetUserByID(ctx, tok.UserID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !user.IsActive) {
+		return database.ApiToken{}, database.User{}, ErrInvalid
+	}
+	if err != nil {
+		return database.ApiToken{}, database.User{}, fmt.Errorf("apitoken: get user: %w", err)
+	}
+	return tok, user, nil
+}
+
+// Allows は scope のトークンで method のリクエストを受け付けてよいかを返す。
+func Allows(scope, method string) bool {
+	if scope == ScopeWrite {
+		return true
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// FromRequest は Authorization ヘッダの Bearer トークンを返す。ヘッダが無ければ ok=false。
+// Bearer 以外の方式（Basic など）も ok=false として扱い、Cookie 認証に任せる。
+func FromRequest(r *http.Request) (token string, ok bool) {
+	h := r.Header.Get("Authorization")
+	scheme, token, found := strings.Cut(h, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// touches は最終使用日時の書き込みを 1 分に 1 回までに間引く（スクリプトからの連続アクセス向け）。
+var touches = opaquetoken.NewThrottle(time.Minute)
+
+// Touch はトークンの最終使用日時を更新する（touches の間隔ごとに 1 回まで）。
+func Touch(ctx context.Context, q *database.Queries, tok database.ApiToken) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	if !touches.Allow(tok.TokenHash, now) {
+		return nil
+	}
+	return q.TouchAPIToken(ctx, database.TouchAPITokenParams{
+		LastUsedAt: sql.NullTime{Time: now, Valid: true},
+		ID:         tok.ID,
+	})
+}
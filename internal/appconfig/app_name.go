@@ -0,0 +1,50 @@
+package appconfig
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+// AppNameKey は app_settings テーブルでアプリ名を保存する key 名（初期セットアップで設定する）。
+const AppNameKey = "app_name"
+
+var appName atomic.Pointer[string]
+
+// AppName は UI 各所に表示されるアプリ名。初期セットアップで設定した名前、無ければ DefaultAppName。
+func AppName() string {
+	if name := appName.Load(); name != nil {
+		return *name
+	}
+	return DefaultAppName
+}
+
+// SetAppName はメモリ上のアプリ名を差し替える（保存は SaveAppName）。空なら DefaultAppName に戻す。
+func SetAppName(name string) {
+	if name == "" {
+		appName.Store(nil)
+		return
+	}
+	appName.Store(&name)
+}
+
+// LoadAppName は保存したアプリ名を読み込む。起動時に呼ぶ。
+func LoadAppName(ctx context.Context, q *database.Queries) error {
+	s, err := q.GetAppSetting(ctx, AppNameKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	SetAppName(s.Value)
+	return nil
+}
+
+// SaveAppName はアプリ名を保存する。トランザクションのコミット後に SetAppName でメモリにも反映すること。
+func SaveAppName(ctx context.Context, q *database.Queries, name string) error {
+	return q.UpsertAppSetting(ctx, database.UpsertAppSettingParams{Key: AppNameKey, Value: name})
+}
@@ -5,8 +5,9 @@
 package appconfig
 
 const (
-	// AppName は UI 各所に表示されるアプリ名。
-	AppName = "プロジェクト管理"
+	// DefaultAppName は UI 各所に表示されるアプリ名の既定値。初期セットアップの画面で
+	// 別の名前にできる（AppName）。
+	DefaultAppName = "プロジェクト管理"
 
 	// LandingPath はログイン成功後・WebAuthn 検証成功後の既定リダイレクト先。
 	// magiclink の RedirectURL / WebAuthnRedirectURL の両方にデフォルトとして渡される。
@@ -2,16 +2,25 @@ package appcontext
 
 import (
 	"context"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
 )
 
 type contextKey string
 
 const (
-	userEmailKey  contextKey = "userEmail"
-	isLoggedInKey contextKey = "isLoggedIn"
-	hasPasskeyKey contextKey = "hasPasskey"
-	userRoleKey   contextKey = "userRole"
-	userIDKey     contextKey = "userID"
+	userEmailKey    contextKey = "userEmail"
+	isLoggedInKey   contextKey = "isLoggedIn"
+	hasPasskeyKey   contextKey = "hasPasskey"
+	userRoleKey     contextKey = "userRole"
+	userIDKey       contextKey = "userID"
+	maintenanceKey  contextKey = "maintenance"
+	readOnlyKey     contextKey = "readOnly"
+	impersonatorKey contextKey = "impersonator"
+	authAtKey       contextKey = "authenticatedAt"
+	authMethodKey   contextKey = "authMethod"
+	csrfTokenKey    contextKey = "csrfToken"
 )
 
 func WithUser(ctx context.Context, email string, loggedIn bool, hasPasskey bool, role string, id int64) context.Context {
@@ -35,7 +44,105 @@ func GetUserRole(ctx context.Context) string {
 	return role
 }
 
+// Can はログイン中のユーザーのロールが p の権限を持つかを返す（画面のボタンの出し分け用）。
+func Can(ctx context.Context, p roles.Permission) bool {
+	return roles.Has(GetUserRole(ctx), p)
+}
+
 func GetUserID(ctx context.Context) int64 {
 	id, _ := ctx.Value(userIDKey).(int64)
 	return id
 }
+
+// Maintenance は Shell のバナーとメンテナンス画面に渡すメンテナンスの情報。
+type Maintenance struct {
+	// Active はメンテナンス中か。画面まで届くのは admin か許可されたユーザーだけ。
+	Active bool
+	// Start / End は予定メンテナンスの時間帯（手動切替だけのときはゼロ値）。
+	Start, End time.Time
+	// Message は管理者が設定した告知文（空なら既定の文言を出す）。
+	Message string
+}
+
+// WithMaintenance はメンテナンス中・予定ありの情報を載せる（Shell にバナーを出すため）。
+func WithMaintenance(ctx context.Context, m Maintenance) context.Context {
+	return context.WithValue(ctx, maintenanceKey, m)
+}
+
+// GetMaintenance はリクエストに載っているメンテナンスの情報を返す。
+// 予定もなく通常運用中なら ok は false。
+func GetMaintenance(ctx context.Context) (m Maintenance, ok bool) {
+	m, ok = ctx.Value(maintenanceKey).(Maintenance)
+	return m, ok
+}
+
+// InMaintenance はこのリクエストがメンテナンス中に処理されているかを返す。
+func InMaintenance(ctx context.Context) bool {
+	m, _ := GetMaintenance(ctx)
+	return m.Active
+}
+
+// WithReadOnly は読み取り専用モード中であることを載せる（画面の書き込みボタンを隠すため）。
+func WithReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey, true)
+}
+
+// IsReadOnly はこのリクエストが読み取り専用モード中に処理されているかを返す。
+func IsReadOnly(ctx context.Context) bool {
+	on, _ := ctx.Value(readOnlyKey).(bool)
+	return on
+}
+
+// Impersonator は「このユーザーとして表示」中の、実際にログインしている管理者。
+type Impersonator struct {
+	ID    int64
+	Email string
+	Role  string
+}
+
+// WithImpersonator は管理者 admin がログイン中のユーザー（WithUser）として表示していることを載せる。
+func WithImpersonator(ctx context.Context, admin Impersonator) context.Context {
+	return context.WithValue(ctx, impersonatorKey, admin)
+}
+
+// GetImpersonator は「このユーザーとして表示」中なら、実際にログインしている管理者を返す。
+func GetImpersonator(ctx context.Context) (Impersonator, bool) {
+	admin, ok := ctx.Value(impersonatorKey).(Impersonator)
+	return admin, ok
+}
+
+// WithAuthenticatedAt はこのセッションでログイン（パスキー・マジックリンク・SSO）した時刻を載せる
+// （破壊的な操作の前に本人確認を求めるかの判断に使う）。
+func WithAuthenticatedAt(ctx context.Context, at time.Time) context.Context {
+	return context.WithValue(ctx, authAtKey, at)
+}
+
+// GetAuthenticatedAt はこのセッションでログインした時刻を返す。API トークンでの認証など、
+// セッションが無ければゼロ値。
+func GetAuthenticatedAt(ctx context.Context) time.Time {
+	at, _ := ctx.Value(authAtKey).(time.Time)
+	return at
+}
+
+// WithAuthMethod はこのセッションを発行したログインの手段（authsession.Method*）を載せる
+// （パスキーを登録しているユーザーに、本人確認としてパスキーでのログインを求めるのに使う）。
+func WithAuthMethod(ctx context.Context, method string) context.Context {
+	return context.WithValue(ctx, authMethodKey, method)
+}
+
+// GetAuthMethod はこのセッションを発行したログインの手段を返す。セッションが無ければ空文字。
+func GetAuthMethod(ctx context.Context) string {
+	method, _ := ctx.Value(authMethodKey).(string)
+	return method
+}
+
+// WithCSRFToken はこのセッションの CSRF トークンを載せる（layouts.Head がページに埋め込む）。
+func WithCSRFToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, csrfTokenKey, token)
+}
+
+// GetCSRFToken はこのセッションの CSRF トークンを返す。セッションでログインしていなければ空文字。
+func GetCSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfTokenKey).(string)
+	return token
+}
@@ -0,0 +1,234 @@
+// Package audit は管理操作の監査ログ（audit_log テーブル）の記録と改ざん検証を提供する。
+//
+// 各エントリは直前のエントリのハッシュ（prev_hash）を含めて自身のハッシュを計算する
+// ハッシュチェーンになっている。途中の行を書き換えたり削除したりすると以降の
+// チェーンが合わなくなるため、Verify（`server audit-verify`）で検出できる。
+//
+// Record は必ず変更本体と同じトランザクションの Queries（Queries.WithTx）で呼ぶこと。
+// 変更だけコミットされて監査ログが残らない、あるいはその逆の状態を作らないため。
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+// 操作種別（audit_log.action）。"<対象>.<動詞>" の形で揃える。
+const (
+	ActionUserCreate          = "user.create"
+	ActionUserUpdate          = "user.update"
+	ActionUserDelete          = "user.delete" // ゴミ箱に移す
+	ActionUserRestore         = "user.restore"
+	ActionUserPurge           = "user.purge"
+	ActionUserImport          = "user.import"
+	ActionUserSignup          = "user.signup"
+	ActionUserSSOProvision    = "user.sso_provision"
+	ActionUserSSORoleSync     = "user.sso_role_sync"
+	ActionUserPromoteAdmin    = "user.promote_admin"
+	ActionUserSetup           = "user.setup"
+	ActionImpersonateStart    = "user.impersonate_start"
+	ActionImpersonateStop     = "user.impersonate_stop"
+	ActionSignupApprove       = "signup.approve"
+	ActionSignupReject        = "signup.reject"
+	ActionSignupPolicy        = "signup.policy"
+	ActionInvitationResend    = "invitation.resend"
+	ActionInvitationRevoke    = "invitation.revoke"
+	ActionInvitationAccept    = "invitation.accept"
+	ActionProjectCreate       = "project.create"
+	ActionProjectUpdate       = "project.update"
+	ActionProjectDelete       = "project.delete" // ゴミ箱に移す
+	ActionProjectRestore      = "project.restore"
+	ActionProjectPurge        = "project.purge"
+	ActionProjectMemberAdd    = "project.member_add"
+	ActionProjectMemberUpdate = "project.member_update"
+	ActionProjectMemberRemove = "project.member_remove"
+	ActionMaintenanceToggle   = "maintenance.toggle"
+	ActionMaintenanceSchedule = "maintenance.schedule"
+	ActionMaintenanceCancel   = "maintenance.cancel"
+	ActionReadOnlyToggle      = "read_only.toggle"
+	ActionAPITokenCreate      = "api_token.create"
+	ActionAPITokenRevoke      = "api_token.revoke"
+	ActionRoleCreate          = "role.create"
+	ActionRoleUpdate          = "role.update"
+	ActionRoleDelete          = "role.delete"
+)
+
+// 対象種別（audit_log.target_type）。
+const (
+	TargetUser          = "user"
+	TargetSignupRequest = "signup_request"
+	TargetProject       = "project"
+	TargetSetting       = "app_setting"
+	TargetAPIToken      = "api_token"
+	TargetRole          = "role"
+)
+
+// TargetTypes は一覧画面の絞り込みに出す対象種別。
+var TargetTypes = []string{TargetUser, TargetSignupRequest, TargetProject, TargetSetting, TargetAPIToken, TargetRole}
+
+// Entry は記録する 1 件分の内容。Before / After は JSON にエンコードされる
+// （作成時の Before、削除時の After のように状態が無い側は nil にする）。
+type Entry struct {
+	ActorID    int64
+	ActorEmail string
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     any
+	After      any
+}
+
+// FromContext はログイン中のユーザーを操作者とした Entry の雛形を返す。
+// 「このユーザーとして表示」中は、表示しているユーザーではなく実際の管理者を操作者にする。
+func FromContext(ctx context.Context, action, targetType string, targetID int64) Entry {
+	email, _, _ := appcontext.GetUser(ctx)
+	actorID := appcontext.GetUserID(ctx)
+	if admin, ok := appcontext.GetImpersonator(ctx); ok {
+		email, actorID = admin.Email, admin.ID
+	}
+	return Entry{
+		ActorID:    actorID,
+		ActorEmail: email,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   strconv.FormatInt(targetID, 10),
+	}
+}
+
+// Record は e を監査ログに追記する。q はトランザクション内の Queries を渡すこと。
+// 変更本体の書き込みの後に呼ぶ（トランザクションが書き込みロックを持った状態で
+// 直前のハッシュを読むため、並行する記録同士でチェーンが分岐しない）。
+func Record(ctx context.Context, q *database.Queries, e Entry) error {
+	before, err := encodeState(e.Before)
+	if err != nil {
+		return fmt.Errorf("audit: encode before: %w", err)
+	}
+	after, err := encodeState(e.After)
+	if err != nil {
+		return fmt.Errorf("audit: encode after: %w", err)
+	}
+
+	prevHash := ""
+	latest, err := q.GetLatestAuditLog(ctx)
+	switch {
+	case err == nil:
+		prevHash = latest.Hash
+	case !errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("audit: read latest entry: %w", err)
+	}
+
+	params := database.CreateAuditLogParams{
+		ActorID:    e.ActorID,
+		ActorEmail: e.ActorEmail,
+		Action:     e.Action,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		BeforeJson: before,
+		AfterJson:  after,
+		PrevHash:   prevHash,
+		// 秒単位に丸める。DB を往復しても同じ文字列表現になり、ハッシュが再計算できる。
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	params.Hash = hashOf(params)
+
+	if _, err := q.CreateAuditLog(ctx, params); err != nil {
+		return fmt.Errorf("audit: insert: %w", err)
+	}
+	return nil
+}
+
+func encodeState(v any) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// hashOf はエントリ内容と直前のハッシュから SHA-256 を計算する。
+// フィールドの区切りが曖昧にならないよう、配列として JSON エンコードしたものを入力にする。
+func hashOf(p database.CreateAuditLogParams) string {
+	b, _ := json.Marshal([]any{
+		p.PrevHash,
+		p.CreatedAt.UTC().Format(time.RFC3339),
+		p.ActorID,
+		p.ActorEmail,
+		p.Action,
+		p.TargetType,
+		p.TargetID,
+		p.BeforeJson,
+		p.AfterJson,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyError はハッシュチェーンが壊れている最初のエントリを表す。
+type VerifyError struct {
+	EntryID int64
+	Reason  string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("audit log entry %d: %s", e.EntryID, e.Reason)
+}
+
+// verifyBatchSize は検証時に 1 回のクエリで読む件数。
+const verifyBatchSize = 500
+
+// Verify は監査ログ全体のハッシュチェーンを先頭から検証し、検証した件数を返す。
+// 改ざんを検出した場合は *VerifyError を返す。
+func Verify(ctx context.Context, q *database.Queries) (int, error) {
+	var (
+		checked  int
+		lastID   int64
+		prevHash string
+	)
+	for {
+		entries, err := q.ListAuditLogsAfter(ctx, database.ListAuditLogsAfterParams{
+			ID:    lastID,
+			Limit: verifyBatchSize,
+		})
+		if err != nil {
+			return checked, fmt.Errorf("audit: list entries: %w", err)
+		}
+		if len(entries) == 0 {
+			return checked, nil
+		}
+		for _, e := range entries {
+			if e.PrevHash != prevHash {
+				return checked, &VerifyError{EntryID: e.ID, Reason: "prev_hash does not match the preceding entry (rows removed or reordered)"}
+			}
+			want := hashOf(database.CreateAuditLogParams{
+				ActorID:    e.ActorID,
+				ActorEmail: e.ActorEmail,
+				Action:     e.Action,
+				TargetType: e.TargetType,
+				TargetID:   e.TargetID,
+				BeforeJson: e.BeforeJson,
+				AfterJson:  e.AfterJson,
+				PrevHash:   e.PrevHash,
+				CreatedAt:  e.CreatedAt,
+			})
+			if e.Hash != want {
+				return checked, &VerifyError{EntryID: e.ID, Reason: "hash mismatch (entry content was modified)"}
+			}
+			prevHash = e.Hash
+			lastID = e.ID
+			checked++
+		}
+	}
+}
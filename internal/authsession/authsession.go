@@ -0,0 +1,293 @@
+// Package authsession は magiclink が管理するセッション・パスキーをユーザー単位で操作する。
+//
+// magiclink の storage.Database はセッションをハッシュ単位でしか消せないため、
+// 「あるユーザーの全セッション」を扱う処理は同じ *sql.DB の sessions テーブルに
+// 直接 SQL を発行する（magiclink.NewWithDB でアプリと DB を共有している前提）。
+// sessions.user_id にはメールアドレスが入る。
+//
+// ログイン元の IP・User-Agent・最終アクセス時刻は magiclink が持たないため、
+// アプリ側の session_details テーブルに session_hash をキーにして保存する。
+package authsession
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/opaquetoken"
+)
+
+// Session はプロフィール画面に表示する 1 件分のセッション。
+// session_details が無いセッション（この機能の導入前のログインなど）は IP / Device が空、
+// LastSeenAt がゼロ値になる。
+type Session struct {
+	ID         int64 // sessions.id（取り消し操作のキー。session_hash は画面に出さない）
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastSeenAt time.Time
+	IP         string
+	Device     string // User-Agent を "Chrome / macOS" のように要約したもの
+	Current    bool   // このリクエストのセッションか
+}
+
+// HashToken は Cookie の値から sessions.session_hash を求める（magiclink と同じ SHA-256 hex）。
+func HashToken(token string) string {
+	return opaquetoken.Hash(token)
+}
+
+// CurrentHash はリクエストのセッション Cookie のハッシュを返す。Cookie が無ければ空文字。
+func CurrentHash(r *http.Request, cookieName string) string {
+	c, err := r.Cookie(cookieName)
+	if err != nil || c.Value == "" {
+		return ""
+	}
+	return HashToken(c.Value)
+}
+
+// ClientIP はリクエスト元の IP（ポートを除いた RemoteAddr）を返す。
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// セッションを発行したログインの手段（session_details.auth_method）。
+// 本人確認（middleware.RecentlyAuthenticated）は、パスキーを登録しているユーザーには
+// MethodPasskey のセッションを求める。
+const (
+	MethodPasskey   = "passkey"
+	MethodMagicLink = "magic_link"
+	MethodSSO       = "sso"
+)
+
+// RecordLogin はログイン直後のセッションに IP・User-Agent とログインの手段（Method*）を記録する。
+// あわせて、期限切れやログアウトで sessions から消えたセッションの記録と
+// 「このユーザーとして表示」の状態を掃除する。
+func RecordLogin(ctx context.Context, db *sql.DB, sessionHash, ip, userAgent, method string) error {
+	email, err := SessionEmail(ctx, db, sessionHash)
+	if err != nil {
+		return fmt.Errorf("authsession: find session: %w", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	if err := database.New(db).CreateSessionDetail(ctx, database.CreateSessionDetailParams{
+		SessionHash: sessionHash,
+		UserEmail:   email,
+		Ip:          ip,
+		UserAgent:   userAgent,
+		CreatedAt:   now,
+		LastSeenAt:  now,
+		AuthMethod:  method,
+	}); err != nil {
+		return fmt.Errorf("authsession: save details: %w", err)
+	}
+
+	if _, err := db.ExecContext(ctx,
+		`DELETE FROM session_details WHERE session_hash NOT IN (SELECT session_hash FROM sessions)`); err != nil {
+		return fmt.Errorf("authsession: prune details: %w", err)
+	}
+	if _, err := db.ExecContext(ctx,
+		`DELETE FROM impersonations WHERE session_hash NOT IN (SELECT session_hash FROM sessions)`); err != nil {
+		return fmt.Errorf("authsession: prune impersonations: %w", err)
+	}
+	return nil
+}
+
+// SessionEmail は sessionHash のセッションのメールアドレスを返す。無ければ sql.ErrNoRows を返す。
+func SessionEmail(ctx context.Context, db *sql.DB, sessionHash string) (string, error) {
+	var email string
+	err := db.QueryRowContext(ctx, `SELECT user_id FROM sessions WHERE session_hash = ?`, sessionHash).Scan(&email)
+	return email, err
+}
+
+// Discard は sessionHash のセッションを消す（発行したがログインを認めなかったとき）。
+func Discard(ctx context.Context, db *sql.DB, sessionHash string) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM sessions WHERE session_hash = ?`, sessionHash); err != nil {
+		return fmt.Errorf("authsession: discard session: %w", err)
+	}
+	ForgetSession(sessionHash)
+	return nil
+}
+
+// AuthenticatedAt は sessionHash のセッションが発行された（ログインした）時刻を返す。
+// セッションが無ければ sql.ErrNoRows を返す。
+func AuthenticatedAt(ctx context.Context, db *sql.DB, sessionHash string) (time.Time, error) {
+	var created int64
+	err := db.QueryRowContext(ctx, `SELECT created_at FROM sessions WHERE session_hash = ?`, sessionHash).Scan(&created)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(created, 0).UTC(), nil
+}
+
+// AuthMethod は sessionHash のセッションを発行したログインの手段（Method*）を返す。
+// 記録が無ければ空文字（どの手段でもないものとして扱う）。
+func AuthMethod(ctx context.Context, db *sql.DB, sessionHash string) (string, error) {
+	method, err := database.New(db).GetSessionAuthMethod(ctx, sessionHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return method, err
+}
+
+// ReplaceSession は同じブラウザでログインし直したとき、それまでのセッション oldHash を消す
+// （本人確認のためのログインで、セッション一覧に同じ端末が並ばないように）。
+// oldHash が newHash と別のユーザーのセッションなら消さない。
+func ReplaceSession(ctx context.Context, db *sql.DB, oldHash, newHash string) error {
+	if oldHash == "" || oldHash == newHash {
+		return nil
+	}
+	if _, err := db.ExecContext(ctx,
+		`DELETE FROM sessions WHERE session_hash = ? AND user_id = (SELECT user_id FROM sessions WHERE session_hash = ?)`,
+		oldHash, newHash); err != nil {
+		return fmt.Errorf("authsession: replace session: %w", err)
+	}
+	ForgetSession(oldHash)
+	return nil
+}
+
+// touches は最終アクセス時刻の書き込みを 1 分に 1 回までに間引く（毎リクエストの UPDATE を避ける）。
+var touches = opaquetoken.NewThrottle(time.Minute)
+
+// Touch はセッションの最終アクセス時刻と IP を更新する（touches の間隔ごとに 1 回まで）。
+func Touch(ctx context.Context, db *sql.DB, sessionHash, ip string) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	if !touches.Allow(sessionHash, now) {
+		return nil
+	}
+	return database.New(db).TouchSessionDetail(ctx, database.TouchSessionDetailParams{
+		LastSeenAt:  now,
+		Ip:          ip,
+		SessionHash: sessionHash,
+	})
+}
+
+// List は email の有効なセッションを新しい順に返す。currentHash に一致するものは Current になる。
+func List(ctx context.Context, db *sql.DB, email, currentHash string) ([]Session, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT id, session_hash, created_at, expires_at FROM sessions WHERE user_id = ? AND expires_at > ?`,
+		email, time.Now().Unix())
+	if err != nil {
+		return nil, fmt.Errorf("authsession: list sessions: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var sessions []Session
+	var hashes []string
+	for rows.Next() {
+		var (
+			s                  Session
+			hash               string
+			created, expiresAt int64
+		)
+		if err := rows.Scan(&s.ID, &hash, &created, &expiresAt); err != nil {
+			return nil, fmt.Errorf("authsession: scan session: %w", err)
+		}
+		s.CreatedAt = time.Unix(created, 0).UTC()
+		s.ExpiresAt = time.Unix(expiresAt, 0).UTC()
+		s.Current = hash != "" && hash == currentHash
+		sessions = append(sessions, s)
+		hashes = append(hashes, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("authsession: list sessions: %w", err)
+	}
+
+	details, err := database.New(db).ListSessionDetailsByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("authsession: list details: %w", err)
+	}
+	byHash := make(map[string]database.SessionDetail, len(details))
+	for _, d := range details {
+		byHash[d.SessionHash] = d
+	}
+	for i := range sessions {
+		if d, ok := byHash[hashes[i]]; ok {
+			sessions[i].IP = d.Ip
+			sessions[i].Device = DescribeUserAgent(d.UserAgent)
+			sessions[i].LastSeenAt = d.LastSeenAt
+		}
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+// Revoke は email のセッションのうち id のものを削除する。
+// 他のユーザーのセッション・存在しない id の場合は false を返す。
+func Revoke(ctx context.Context, db *sql.DB, email string, id int64) (bool, error) {
+	var hash string
+	err := db.QueryRowContext(ctx, `SELECT session_hash FROM sessions WHERE id = ? AND user_id = ?`, id, email).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("authsession: find session: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `DELETE FROM sessions WHERE id = ?`, id); err != nil {
+		return false, fmt.Errorf("authsession: revoke session: %w", err)
+	}
+	ForgetSession(hash)
+	if err := database.New(db).DeleteSessionDetail(ctx, hash); err != nil {
+		return true, fmt.Errorf("authsession: delete details: %w", err)
+	}
+	return true, nil
+}
+
+// RevokeOthers は email のセッションのうち keepHash 以外をすべて削除し、削除した件数を返す
+// （「他の端末からログアウト」）。
+func RevokeOthers(ctx context.Context, db *sql.DB, email, keepHash string) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ? AND session_hash <> ?`, email, keepHash)
+	if err != nil {
+		return 0, fmt.Errorf("authsession: revoke sessions: %w", err)
+	}
+	ForgetUser(email)
+	if _, err := db.ExecContext(ctx,
+		`DELETE FROM session_details WHERE user_email = ? AND session_hash <> ?`, email, keepHash); err != nil {
+		return 0, fmt.Errorf("authsession: delete details: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// RevokeAll は email の全セッションを削除し、削除した件数を返す。
+// 削除されたセッションの Cookie は次のリクエストから未ログイン扱いになる。
+func RevokeAll(ctx context.Context, db *sql.DB, email string) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, email)
+	if err != nil {
+		return 0, fmt.Errorf("authsession: revoke sessions: %w", err)
+	}
+	ForgetUser(email)
+	if err := database.New(db).DeleteSessionDetailsByEmail(ctx, email); err != nil {
+		return 0, fmt.Errorf("authsession: delete details: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// Purge は email に紐づくパスキーと全セッションを削除する（ユーザーを完全に削除したときの後始末）。
+// ゴミ箱に移すだけならパスキーは残し、RevokeAll でセッションだけを消す（戻したユーザーが
+// 登録し直さなくて済むように）。途中で失敗しても残りの削除は続け、最初のエラーを返す。
+func Purge(ctx context.Context, db *sql.DB, email string) error {
+	var firstErr error
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM passkey_credentials WHERE user_id = ?`, email); err != nil {
+		firstErr = fmt.Errorf("authsession: delete passkeys: %w", err)
+	}
+	if err := database.New(db).DeletePasskeyDetailsByEmail(ctx, email); err != nil && firstErr == nil {
+		firstErr = fmt.Errorf("authsession: delete passkey details: %w", err)
+	}
+
+	if _, err := RevokeAll(ctx, db, email); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
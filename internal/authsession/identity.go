@@ -0,0 +1,94 @@
+package authsession
+
+import (
+	"sync"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+)
+
+// IdentityTTL は UserContextMiddleware がセッションから解決したユーザーをキャッシュする最長時間。
+// ユーザー・パスキー・セッションの変更は Forget* で即時に捨てるので、これは別プロセス
+// （CLI・複数台構成）での変更やセッションの期限切れが反映されるまでの上限になる。
+const IdentityTTL = 30 * time.Second
+
+// identityCacheMax はキャッシュする session_hash の上限。超えたら期限切れを掃除し、
+// それでも超えていれば全部捨てる（キャッシュが無くても DB を読むだけで動く）。
+const identityCacheMax = 10000
+
+// Identity はセッションから解決したログイン中のユーザー（有効なユーザーのものだけをキャッシュする）。
+type Identity struct {
+	Email      string
+	Role       string
+	UserID     int64
+	HasPasskey bool
+	// AuthenticatedAt はこのセッションでログインした時刻（authsession.AuthenticatedAt）。
+	AuthenticatedAt time.Time
+	// AuthMethod はこのセッションを発行したログインの手段（authsession.AuthMethod）。
+	AuthMethod string
+	// Impersonator は「このユーザーとして表示」中の実際の管理者（表示中でなければ nil）。
+	Impersonator *appcontext.Impersonator
+}
+
+type identityEntry struct {
+	id      Identity
+	expires time.Time
+}
+
+var identities = struct {
+	mu sync.Mutex
+	m  map[string]identityEntry
+}{m: map[string]identityEntry{}}
+
+// CachedIdentity は sessionHash のセッションのキャッシュ済みのユーザーを返す。
+func CachedIdentity(sessionHash string) (Identity, bool) {
+	identities.mu.Lock()
+	defer identities.mu.Unlock()
+	e, ok := identities.m[sessionHash]
+	if !ok || time.Now().After(e.expires) {
+		return Identity{}, false
+	}
+	return e.id, true
+}
+
+// CacheIdentity は sessionHash のセッションのユーザーを IdentityTTL の間キャッシュする。
+func CacheIdentity(sessionHash string, id Identity) {
+	if sessionHash == "" {
+		return
+	}
+	now := time.Now()
+	identities.mu.Lock()
+	defer identities.mu.Unlock()
+	if len(identities.m) >= identityCacheMax {
+		for h, e := range identities.m {
+			if now.After(e.expires) {
+				delete(identities.m, h)
+			}
+		}
+		if len(identities.m) >= identityCacheMax {
+			clear(identities.m)
+		}
+	}
+	identities.m[sessionHash] = identityEntry{id: id, expires: now.Add(IdentityTTL)}
+}
+
+// ForgetSession は sessionHash のセッションのキャッシュを捨てる（ログアウト・
+// 「このユーザーとして表示」の開始と終了など、そのセッションだけが変わるとき）。
+func ForgetSession(sessionHash string) {
+	identities.mu.Lock()
+	defer identities.mu.Unlock()
+	delete(identities.m, sessionHash)
+}
+
+// ForgetUser は email のユーザーに関わるキャッシュ（本人のセッションと、本人として表示している
+// 管理者・本人が管理者として表示しているセッション）をすべて捨てる。ユーザーのロール・
+// 有効 / 無効・パスキー・セッションを変えたらコミット後に呼ぶ。
+func ForgetUser(email string) {
+	identities.mu.Lock()
+	defer identities.mu.Unlock()
+	for h, e := range identities.m {
+		if e.id.Email == email || (e.id.Impersonator != nil && e.id.Impersonator.Email == email) {
+			delete(identities.m, h)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package authsession
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/naozine/nz-magic-link/magiclink"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+// NicknameMaxLen はパスキーの名前の最大文字数（rune 数）。
+const NicknameMaxLen = 50
+
+// Passkey はプロフィール画面に表示する 1 件分のパスキー。
+// 名前を付けていないパスキーは Nickname が空（表示側で Authenticator を代わりに使う）。
+type Passkey struct {
+	ID            string // passkey_credentials.id（base64url の credential ID）
+	Nickname      string
+	Authenticator string // "iCloud キーチェーン" などの認証器の種類
+	Synced        bool   // 複数端末で同期されるパスキーか（backup eligible）
+	CreatedAt     time.Time
+	LastUsedAt    time.Time // 未使用ならゼロ値
+}
+
+// ListPasskeys は email のパスキーを登録の新しい順に返す。
+// 認証情報は magiclink の storage から、名前は passkey_details から読んで突き合わせる。
+func ListPasskeys(ctx context.Context, ml *magiclink.MagicLink, db *sql.DB, email string) ([]Passkey, error) {
+	creds, err := ml.DB.GetPasskeyCredentialsByUserID(email)
+	if err != nil {
+		return nil, fmt.Errorf("authsession: list passkeys: %w", err)
+	}
+	details, err := database.New(db).ListPasskeyDetailsByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("authsession: list passkey details: %w", err)
+	}
+	names := make(map[string]string, len(details))
+	for _, d := range details {
+		names[d.CredentialID] = d.Nickname
+	}
+
+	passkeys := make([]Passkey, 0, len(creds))
+	for _, c := range creds {
+		p := Passkey{
+			ID:            c.ID,
+			Nickname:      names[c.ID],
+			Authenticator: describeAuthenticator(c.AAGUID, c.Transports, c.BackupEligible),
+			Synced:        c.BackupEligible,
+			CreatedAt:     c.CreatedAt,
+		}
+		// magiclink はログインに使われるたびに updated_at を更新する（sign count の更新時）。
+		if c.UpdatedAt.After(c.CreatedAt) {
+			p.LastUsedAt = c.UpdatedAt
+		}
+		passkeys = append(passkeys, p)
+	}
+	sort.SliceStable(passkeys, func(i, j int) bool {
+		return passkeys[i].CreatedAt.After(passkeys[j].CreatedAt)
+	})
+	return passkeys, nil
+}
+
+// ownPasskey は id のパスキーが email のものかを確認する。
+func ownPasskey(ml *magiclink.MagicLink, email, id string) (bool, error) {
+	cred, err := ml.DB.GetPasskeyCredentialByID(id)
+	if err != nil {
+		return false, fmt.Errorf("authsession: get passkey: %w", err)
+	}
+	return cred != nil && cred.UserID == email, nil
+}
+
+// RenamePasskey は email のパスキー id に名前を付ける（空文字で名前を外す）。
+// 他のユーザーのパスキー・存在しない id の場合は false を返す。
+func RenamePasskey(ctx context.Context, ml *magiclink.MagicLink, db *sql.DB, email, id, nickname string) (bool, error) {
+	ok, err := ownPasskey(ml, email, id)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := database.New(db).UpsertPasskeyDetail(ctx, database.UpsertPasskeyDetailParams{
+		CredentialID: id,
+		UserEmail:    email,
+		Nickname:     nickname,
+		UpdatedAt:    time.Now().UTC(),
+	}); err != nil {
+		return false, fmt.Errorf("authsession: save passkey nickname: %w", err)
+	}
+	return true, nil
+}
+
+// DeletePasskey は email のパスキー id を 1 件だけ削除する。
+// 他のユーザーのパスキー・存在しない id の場合は false を返す。
+func DeletePasskey(ctx context.Context, ml *magiclink.MagicLink, db *sql.DB, email, id string) (bool, error) {
+	ok, err := ownPasskey(ml, email, id)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := ml.DB.DeletePasskeyCredential(id); err != nil {
+		return false, fmt.Errorf("authsession: delete passkey: %w", err)
+	}
+	ForgetUser(email)
+	if err := database.New(db).DeletePasskeyDetail(ctx, id); err != nil {
+		return true, fmt.Errorf("authsession: delete passkey details: %w", err)
+	}
+	return true, nil
+}
+
+// knownAuthenticators は主要なパスキープロバイダの AAGUID と表示名。
+// 一覧に無い AAGUID は transports / backup eligible から大まかな種類を出す。
+var knownAuthenticators = map[string]string{
+	"fbfc3007-154e-4ecc-8c0b-6e020557d7bd": "iCloud キーチェーン",
+	"dd4ec289-e01d-41c9-bb89-70fa845d4bf2": "iCloud キーチェーン",
+	"ea9b8d66-4d01-1d21-3ce4-b6b48cb575d4": "Google パスワードマネージャー",
+	"adce0002-35bc-c60a-648b-0b25f1f05503": "Chrome (Mac)",
+	"08987058-cadc-4b81-b6e1-30de50dcbe96": "Windows Hello",
+	"9ddd1817-af5a-4672-a2b9-3e3dd95000a9": "Windows Hello",
+	"6028b017-b1d4-4c02-b4b3-afcdafc96bb2": "Windows Hello",
+	"bada5566-a7aa-401f-bd96-45619a55120d": "1Password",
+	"d548826e-79b4-db40-a3d8-11116f7e8349": "Bitwarden",
+	"53414d53-554e-4700-0000-000000000000": "Samsung Pass",
+}
+
+// describeAuthenticator は認証器の種類を表示用に要約する。aaguid は magiclink が保存する base64url 形式。
+func describeAuthenticator(aaguid string, transports []string, backupEligible bool) string {
+	if raw, err := base64.RawURLEncoding.DecodeString(aaguid); err == nil && len(raw) == 16 {
+		id := fmt.Sprintf("%x-%x-%x-%x-%x", raw[0:4], raw[4:6], raw[6:8], raw[8:10], raw[10:16])
+		if name, ok := knownAuthenticators[id]; ok {
+			return name
+		}
+	}
+	switch {
+	case slices.Contains(transports, "usb"), slices.Contains(transports, "nfc"), slices.Contains(transports, "ble"):
+		return "セキュリティキー"
+	case backupEligible:
+		return "同期パスキー"
+	default:
+		return "この端末のパスキー"
+	}
+}
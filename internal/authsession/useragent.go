@@ -0,0 +1,50 @@
+package authsession
+
+import "strings"
+
+// DescribeUserAgent は User-Agent を「ブラウザ / OS」の短い表記にする（例: "Chrome / macOS"）。
+// セッション一覧で端末を見分けるための目安で、厳密な判定はしない。判別できない部分は「不明」。
+func DescribeUserAgent(ua string) string {
+	if ua == "" {
+		return ""
+	}
+	return uaBrowser(ua) + " / " + uaOS(ua)
+}
+
+// uaBrowser は判定順に意味がある（Edge / Opera の UA は "Chrome" を、Chrome の UA は "Safari" を含む）。
+func uaBrowser(ua string) string {
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		return "Edge"
+	case strings.Contains(ua, "OPR/"):
+		return "Opera"
+	case strings.Contains(ua, "Firefox/"), strings.Contains(ua, "FxiOS/"):
+		return "Firefox"
+	case strings.Contains(ua, "Chrome/"), strings.Contains(ua, "CriOS/"):
+		return "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		return "Safari"
+	default:
+		return "不明なブラウザ"
+	}
+}
+
+// uaOS も判定順に意味がある（iPhone / Android の UA は "Mac OS X" / "Linux" を含む）。
+func uaOS(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		return "iOS"
+	case strings.Contains(ua, "Android"):
+		return "Android"
+	case strings.Contains(ua, "Windows"):
+		return "Windows"
+	case strings.Contains(ua, "Mac OS X"), strings.Contains(ua, "Macintosh"):
+		return "macOS"
+	case strings.Contains(ua, "CrOS"):
+		return "ChromeOS"
+	case strings.Contains(ua, "Linux"):
+		return "Linux"
+	default:
+		return "不明な OS"
+	}
+}
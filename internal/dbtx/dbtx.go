@@ -0,0 +1,25 @@
+// Package dbtx はトランザクションの開始・コミット・ロールバックをまとめる。
+// 変更本体と監査ログ（audit.Record）を同じトランザクションで書く処理は、すべてこれを使う。
+package dbtx
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+// Run は fn をトランザクション内で実行し、fn が nil を返したときだけコミットする。
+// fn には q をトランザクションに結び付けた Queries を渡す。
+func Run(ctx context.Context, db *sql.DB, q *database.Queries, fn func(qtx *database.Queries) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(q.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -23,5 +23,17 @@ func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 		httpError(w, r, http.StatusInternalServerError, "ユーザー一覧の取得に失敗しました")
 		return
 	}
-	renderShell(w, r, "ユーザー管理", components.AdminUserList(users))
+	invitations, err := h.Queries.ListPendingInvitations(r.Context())
+	if err != nil {
+		logger.Error("招待一覧の取得に失敗", "error", err)
+		httpError(w, r, http.StatusInternalServerError, "ユーザー一覧の取得に失敗しました")
+		return
+	}
+	requests, err := h.Queries.ListSignupRequests(r.Context())
+	if err != nil {
+		logger.Error("サインアップ申請一覧の取得に失敗", "error", err)
+		httpError(w, r, http.StatusInternalServerError, "ユーザー一覧の取得に失敗しました")
+		return
+	}
+	renderShell(w, r, "ユーザー管理", components.AdminUserList(users, invitations, requests))
 }
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/starfederation/datastar-go/datastar"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+)
+
+// AuditHandler は監査ログの閲覧画面（管理者用）。
+type AuditHandler struct {
+	Queries *database.Queries
+}
+
+func NewAuditHandler(queries *database.Queries) *AuditHandler {
+	return &AuditHandler{Queries: queries}
+}
+
+// auditViewLimit は一覧に表示する最大件数（新しい順）。
+const auditViewLimit = 500
+
+// auditFilter は絞り込みフォームの signals。日付は "2006-01-02"（UTC の日付）。
+type auditFilter struct {
+	Actor    string `json:"auditActor"`
+	Target   string `json:"auditTarget"`
+	TargetID string `json:"auditTargetId"`
+	From     string `json:"auditFrom"`
+	To       string `json:"auditTo"`
+}
+
+// params は絞り込み条件を ListAuditLogs の引数に変換する。未指定の条件は全件一致にする。
+// 日付が解釈できない場合は ok=false を返す。
+func (f auditFilter) params() (database.ListAuditLogsParams, bool) {
+	p := database.ListAuditLogsParams{
+		ActorPattern:      "%",
+		TargetTypePattern: "%",
+		TargetIDPattern:   "%",
+		Since:             time.Time{},
+		Until:             time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC),
+		MaxRows:           auditViewLimit,
+	}
+	p.ActorPattern = likePattern(f.Actor)
+	if v := strings.TrimSpace(f.Target); v != "" {
+		p.TargetTypePattern = escapeLike(v)
+	}
+	if v := strings.TrimSpace(f.TargetID); v != "" {
+		p.TargetIDPattern = escapeLike(v)
+	}
+	if f.From != "" {
+		d, err := time.Parse(time.DateOnly, f.From)
+		if err != nil {
+			return p, false
+		}
+		p.Since = d
+	}
+	if f.To != "" {
+		d, err := time.Parse(time.DateOnly, f.To)
+		if err != nil {
+			return p, false
+		}
+		// 終了日はその日の終わりまでを含める。
+		p.Until = d.AddDate(0, 0, 1)
+	}
+	return p, true
+}
+
+// Page は監査ログを新しい順に表示する（絞り込みなし）。
+func (h *AuditHandler) Page(w http.ResponseWriter, r *http.Request) {
+	p, _ := auditFilter{}.params()
+	entries, err := h.Queries.ListAuditLogs(r.Context(), p)
+	if err != nil {
+		logger.Error("監査ログの取得に失敗", "error", err)
+		httpError(w, r, http.StatusInternalServerError, "監査ログの取得に失敗しました")
+		return
+	}
+	renderShell(w, r, "監査ログ", components.AdminAuditPage(entries))
+}
+
+// TableSSE は絞り込み条件で一覧コンテナ #audit-list を inner 置換する（@get）。
+func (h *AuditHandler) TableSSE(w http.ResponseWriter, r *http.Request) {
+	var f auditFilter
+	if !readSignalsOr413(w, r, &f) {
+		return
+	}
+	p, ok := f.params()
+	if !ok {
+		http.Error(w, "日付の形式が不正です", http.StatusBadRequest)
+		return
+	}
+	entries, err := h.Queries.ListAuditLogs(r.Context(), p)
+	if err != nil {
+		logger.Error("監査ログの取得に失敗", "error", err)
+		http.Error(w, "監査ログの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	sse := newSSE(w, r)
+	if err := sse.PatchElementTempl(
+		components.AdminAuditTable(entries),
+		datastar.WithSelectorID("audit-list"),
+		datastar.WithModeInner(),
+		datastar.WithViewTransitions(),
+	); err != nil {
+		logger.Error("SSE PatchElementTempl failed", "error", err)
+	}
+}
@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/a-h/templ"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
 	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
@@ -12,42 +18,216 @@ import (
 )
 
 type MaintenanceHandler struct {
+	DB      *sql.DB
 	Queries *database.Queries
+	Cache   *maintenance.Cache
 }
 
-func NewMaintenanceHandler(q *database.Queries) *MaintenanceHandler {
-	return &MaintenanceHandler{Queries: q}
+func NewMaintenanceHandler(db *sql.DB, q *database.Queries, cache *maintenance.Cache) *MaintenanceHandler {
+	return &MaintenanceHandler{DB: db, Queries: q, Cache: cache}
 }
 
-// Page はメンテナンスモードの状態と切替ボタンを表示する。admin 限定。
+// maintenanceState は監査ログに残すメンテモードの状態。
+type maintenanceState struct {
+	Enabled bool                `json:"enabled"`
+	Window  *maintenance.Window `json:"window,omitempty"`
+}
+
+// datetimeLocalLayout は <input type="datetime-local"> の値の形式。サーバーのタイムゾーンで解釈する。
+const datetimeLocalLayout = "2006-01-02T15:04"
+
+// Page はメンテナンスモードの状態・切替ボタン、予定メンテナンスの設定、読み取り専用モードの
+// 切替を表示する。admin 限定。
 func (h *MaintenanceHandler) Page(w http.ResponseWriter, r *http.Request) {
-	enabled := maintenance.IsEnabled(r.Context(), h.Queries)
-	renderShell(w, r, "メンテナンスモード", components.AdminMaintenance(enabled))
+	st := maintenance.Load(r.Context(), h.Queries)
+	renderShell(w, r, "メンテナンスモード", components.AdminMaintenance(st.Active(time.Now()), st.ReadOnly, st.Window))
 }
 
 // ToggleSSE は現在のメンテモードを反転させ、状態パネルだけを patch する。
 // Datastar 経由 (POST /api/sse/admin/maintenance/toggle)。reload しない。
+//
+// 手動切替は予定より優先する。予定の時間帯中に解除した場合は、その予定も取り消す
+// （残しておくと、解除したのにメンテナンス中のままになる）。
 func (h *MaintenanceHandler) ToggleSSE(w http.ResponseWriter, r *http.Request) {
-	cur := maintenance.IsEnabled(r.Context(), h.Queries)
-	if err := maintenance.SetEnabled(r.Context(), h.Queries, !cur); err != nil {
+	ctx := r.Context()
+	now := time.Now()
+	st := maintenance.Load(ctx, h.Queries)
+	cur := st.Active(now)
+	endWindow := cur && st.InWindow(now)
+
+	before := maintenanceState{Enabled: cur, Window: st.Window}
+	after := maintenanceState{Enabled: !cur, Window: st.Window}
+	if endWindow {
+		after.Window = nil
+	}
+	err := dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		if err := maintenance.SetEnabled(ctx, qtx, !cur); err != nil {
+			return err
+		}
+		if endWindow {
+			if err := maintenance.ClearWindow(ctx, qtx); err != nil {
+				return err
+			}
+		}
+		entry := audit.FromContext(ctx, audit.ActionMaintenanceToggle, audit.TargetSetting, 0)
+		entry.TargetID = maintenance.Key
+		entry.Before, entry.After = before, after
+		return audit.Record(ctx, qtx, entry)
+	})
+	if err != nil {
 		logger.Error("メンテナンスモード切替に失敗", "error", err, "next", !cur)
 		http.Error(w, "切替に失敗しました", http.StatusInternalServerError)
 		return
 	}
+	// ミドルウェアのキャッシュに即時反映する（コミット後に捨てる）。
+	h.Cache.Invalidate()
 
 	sse := newSSE(w, r)
-	// 状態パネルだけを差し替える（reload しない）。
-	if err := sse.PatchElementTempl(
-		components.AdminMaintenancePanel(!cur),
-		datastar.WithSelectorID("maintenance-panel"),
-		datastar.WithModeOuter(),
-		datastar.WithViewTransitions(),
-	); err != nil {
-		logger.Error("SSE PatchElementTempl failed", "error", err)
-	}
+	h.patchPanels(sse, r)
 	state := "OFF"
 	if !cur {
 		state = "ON"
 	}
 	sendToast(sse, fmt.Sprintf("メンテナンスモードを%sにしました", state))
 }
+
+// ToggleReadOnlySSE は読み取り専用モードを反転させる。
+// Datastar 経由 (POST /api/sse/admin/maintenance/read-only/toggle)。読み取り専用モード中も
+// 受け付ける（routes.ReadOnlyExemptPrefix）。
+func (h *MaintenanceHandler) ToggleReadOnlySSE(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cur := maintenance.Load(ctx, h.Queries).ReadOnly
+	err := dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		if err := maintenance.SetReadOnly(ctx, qtx, !cur); err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionReadOnlyToggle, audit.TargetSetting, 0)
+		entry.TargetID = maintenance.ReadOnlyKey
+		entry.Before, entry.After = maintenanceState{Enabled: cur}, maintenanceState{Enabled: !cur}
+		return audit.Record(ctx, qtx, entry)
+	})
+	if err != nil {
+		logger.Error("読み取り専用モード切替に失敗", "error", err, "next", !cur)
+		http.Error(w, "切替に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	h.Cache.Invalidate()
+
+	sse := newSSE(w, r)
+	h.patchPanels(sse, r)
+	state := "OFF"
+	if !cur {
+		state = "ON"
+	}
+	sendToast(sse, fmt.Sprintf("読み取り専用モードを%sにしました", state))
+}
+
+// maintenanceWindowSignals は予定メンテナンスのフォームの signals。
+type maintenanceWindowSignals struct {
+	Start   string `json:"mwStart"`
+	End     string `json:"mwEnd"`
+	Message string `json:"mwMessage"`
+	Allow   string `json:"mwAllow"`
+}
+
+// SaveWindowSSE は予定メンテナンスを登録する（既存の予定は置き換える）。
+// Datastar 経由 (PUT /api/sse/admin/maintenance/window)。入力が不正なら 400 でメッセージを返す。
+func (h *MaintenanceHandler) SaveWindowSSE(w http.ResponseWriter, r *http.Request) {
+	var signals maintenanceWindowSignals
+	if !readSignalsOr413(w, r, &signals) {
+		return
+	}
+
+	// 空・不正な日時はゼロ値のまま NormalizeWindow に渡し、そこでメッセージを返す。
+	start, _ := time.ParseInLocation(datetimeLocalLayout, signals.Start, time.Local)
+	end, _ := time.ParseInLocation(datetimeLocalLayout, signals.End, time.Local)
+	next, err := maintenance.NormalizeWindow(maintenance.Window{
+		Start:       start,
+		End:         end,
+		Message:     signals.Message,
+		AllowEmails: maintenance.ParseEmails(signals.Allow),
+	}, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	err = dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		cur := maintenance.Load(ctx, qtx)
+		if err := maintenance.SaveWindow(ctx, qtx, next); err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionMaintenanceSchedule, audit.TargetSetting, 0)
+		entry.TargetID = maintenance.WindowKey
+		entry.Before, entry.After = cur.Window, next
+		return audit.Record(ctx, qtx, entry)
+	})
+	if err != nil {
+		logger.Error("メンテナンス予定の保存に失敗", "error", err)
+		http.Error(w, "保存に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	h.Cache.Invalidate()
+
+	sse := newSSE(w, r)
+	h.patchPanels(sse, r)
+	sendToast(sse, "メンテナンスの予定を登録しました")
+}
+
+// CancelWindowSSE は予定メンテナンスを取り消す。時間帯中なら、その時点でメンテナンスが終わる
+// （手動で ON にしている場合は手動の状態のまま）。
+// Datastar 経由 (DELETE /api/sse/admin/maintenance/window)。
+func (h *MaintenanceHandler) CancelWindowSSE(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	err := dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		cur := maintenance.Load(ctx, qtx)
+		if cur.Window == nil {
+			return sql.ErrNoRows
+		}
+		if err := maintenance.ClearWindow(ctx, qtx); err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionMaintenanceCancel, audit.TargetSetting, 0)
+		entry.TargetID = maintenance.WindowKey
+		entry.Before = cur.Window
+		return audit.Record(ctx, qtx, entry)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "予定されたメンテナンスはありません", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("メンテナンス予定の取り消しに失敗", "error", err)
+		http.Error(w, "取り消しに失敗しました", http.StatusInternalServerError)
+		return
+	}
+	h.Cache.Invalidate()
+
+	sse := newSSE(w, r)
+	h.patchPanels(sse, r)
+	sendToast(sse, "メンテナンスの予定を取り消しました")
+}
+
+// patchPanels はメンテナンス画面の各カードを最新の状態で差し替える（reload しない）。
+// 予定の登録・取り消しでも ON / OFF が変わりうるので、どの操作でも全部を送る。
+func (h *MaintenanceHandler) patchPanels(sse *datastar.ServerSentEventGenerator, r *http.Request) {
+	st := maintenance.Load(r.Context(), h.Queries)
+	for _, c := range []struct {
+		id   string
+		comp templ.Component
+	}{
+		{"maintenance-panel", components.AdminMaintenancePanel(st.Active(time.Now()))},
+		{"maintenance-window", components.AdminMaintenanceWindow(st.Window)},
+		{"read-only-panel", components.AdminReadOnlyPanel(st.ReadOnly)},
+	} {
+		if err := sse.PatchElementTempl(
+			c.comp,
+			datastar.WithSelectorID(c.id),
+			datastar.WithModeOuter(),
+			datastar.WithViewTransitions(),
+		); err != nil {
+			logger.Error("SSE PatchElementTempl failed", "error", err)
+		}
+	}
+}
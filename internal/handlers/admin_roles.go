@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/starfederation/datastar-go/datastar"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/signup"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+)
+
+var errRoleNotFound = errors.New("role not found")
+
+// RoleHandler はカスタムロールの管理画面（/admin/roles）。組み込みロールは一覧に出すだけで
+// 変更・削除はできない。変更を保存したら roles.Load でプロセス内のロールを読み直す。
+type RoleHandler struct {
+	DB      *sql.DB
+	Queries *database.Queries
+}
+
+func NewRoleHandler(db *sql.DB, q *database.Queries) *RoleHandler {
+	return &RoleHandler{DB: db, Queries: q}
+}
+
+// Page はロールの一覧を表示する。
+func (h *RoleHandler) Page(w http.ResponseWriter, r *http.Request) {
+	renderShell(w, r, "ロール", components.AdminRoles(roles.List()))
+}
+
+// newRoleSignals / editRoleSignals はロールの追加・編集ダイアログの signals。Perms のキーは
+// components.RolePermissionSignal（権限名の "." を "_" にしたもの）。
+type newRoleSignals struct {
+	Name  string          `json:"newRoleName"`
+	Label string          `json:"newRoleLabel"`
+	Perms map[string]bool `json:"newRolePerms"`
+}
+
+type editRoleSignals struct {
+	Label string          `json:"editRoleLabel"`
+	Perms map[string]bool `json:"editRolePerms"`
+}
+
+// checkedPermissions はチェックの付いた権限を返す。
+func checkedPermissions(perms map[string]bool) []roles.Permission {
+	var ps []roles.Permission
+	for _, p := range roles.Permissions {
+		if perms[components.RolePermissionSignal(p)] {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+// CreateSSE はカスタムロールを作る。入力が不正なら 400 でメッセージを返す。
+func (h *RoleHandler) CreateSSE(w http.ResponseWriter, r *http.Request) {
+	var signals newRoleSignals
+	if !readSignalsOr413(w, r, &signals) {
+		return
+	}
+	role, err := roles.Normalize(roles.Role{Name: signals.Name, Label: signals.Label, Permissions: checkedPermissions(signals.Perms)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	now := time.Now().UTC()
+	err = dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		if _, err := qtx.GetCustomRole(ctx, role.Name); err == nil {
+			return inputError(fmt.Sprintf("ロール %s は既にあります", role.Name))
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		row, err := qtx.CreateCustomRole(ctx, database.CreateCustomRoleParams{
+			Name:        role.Name,
+			Label:       role.Label,
+			Permissions: roles.EncodePermissions(role.Permissions),
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+		if err != nil {
+			return err
+		}
+		entry := roleAuditEntry(ctx, audit.ActionRoleCreate, role.Name)
+		entry.After = roles.FromRow(row)
+		return audit.Record(ctx, qtx, entry)
+	})
+	if msg, ok := inputErrorMessage(err); ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Error("ロールの作成に失敗", "error", err, "name", role.Name)
+		http.Error(w, "ロールの作成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	sse := h.patchList(w, r)
+	runScript(sse, "document.getElementById('role-add-dialog')?.close()")
+	sendToast(sse, "ロールを作成しました")
+}
+
+// EditDialogSSE はカスタムロールの編集ダイアログを開く。組み込みロールは 404。
+func (h *RoleHandler) EditDialogSSE(w http.ResponseWriter, r *http.Request) {
+	row, err := h.Queries.GetCustomRole(r.Context(), chi.URLParam(r, "name"))
+	if err != nil {
+		http.Error(w, "ロールが見つかりません", http.StatusNotFound)
+		return
+	}
+
+	sse := newSSE(w, r)
+	if err := sse.PatchElementTempl(
+		components.AdminRoleEditDialog(roles.FromRow(row)),
+		datastar.WithSelectorID("dialog-container"),
+		datastar.WithModeInner(),
+	); err != nil {
+		logger.Error("SSE PatchElementTempl failed", "error", err)
+		return
+	}
+	if err := runScript(sse, "document.getElementById('role-edit-dialog')?.showModal();document.activeElement?.blur()"); err != nil {
+		logger.Error("SSE runScript failed", "error", err)
+	}
+}
+
+// UpdateSSE はカスタムロールの表示名と権限を変更する。そのロールのユーザーには次の
+// リクエストから新しい権限が効く。
+func (h *RoleHandler) UpdateSSE(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	var signals editRoleSignals
+	if !readSignalsOr413(w, r, &signals) {
+		return
+	}
+	if roles.IsBuiltIn(name) {
+		http.Error(w, "組み込みロールは変更できません", http.StatusBadRequest)
+		return
+	}
+	role, err := roles.Normalize(roles.Role{Name: name, Label: signals.Label, Permissions: checkedPermissions(signals.Perms)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	err = dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		before, err := qtx.GetCustomRole(ctx, role.Name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errRoleNotFound
+		}
+		if err != nil {
+			return err
+		}
+		after, err := qtx.UpdateCustomRole(ctx, database.UpdateCustomRoleParams{
+			Label:       role.Label,
+			Permissions: roles.EncodePermissions(role.Permissions),
+			UpdatedAt:   time.Now().UTC(),
+			Name:        role.Name,
+		})
+		if err != nil {
+			return err
+		}
+		// ユーザー管理の権限を外すなら、変更後の定義で数えて有効な管理者が残ることを確かめる。
+		if roles.IsAdmin(role.Name) && !slices.Contains(role.Permissions, roles.UserManage) {
+			err := ensureAdminRemains(ctx, qtx, func(name string) bool {
+				return name != role.Name && roles.IsAdmin(name)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		entry := roleAuditEntry(ctx, audit.ActionRoleUpdate, role.Name)
+		entry.Before, entry.After = roles.FromRow(before), roles.FromRow(after)
+		return audit.Record(ctx, qtx, entry)
+	})
+	if errors.Is(err, errRoleNotFound) {
+		http.Error(w, "ロールが見つかりません", http.StatusNotFound)
+		return
+	}
+	if lastAdminToast(w, r, err) {
+		return
+	}
+	if err != nil {
+		logger.Error("ロールの更新に失敗", "error", err, "name", name)
+		http.Error(w, "ロールの更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	sse := h.patchList(w, r)
+	runScript(sse, "document.getElementById('role-edit-dialog')?.close()")
+	sendToast(sse, "ロールを更新しました")
+}
+
+// DeleteSSE はカスタムロールを削除する。組み込みロールと、ユーザー（招待中・ゴミ箱の中を含む）や
+// セルフサインアップの既定ロールに使われているロールは削除できない。
+func (h *RoleHandler) DeleteSSE(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if roles.IsBuiltIn(name) {
+		http.Error(w, "組み込みロールは削除できません", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	err := dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		before, err := qtx.GetCustomRole(ctx, name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errRoleNotFound
+		}
+		if err != nil {
+			return err
+		}
+		n, err := qtx.CountUsersByRole(ctx, name)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return inputError(fmt.Sprintf("このロールのユーザーが %d 人（ゴミ箱の中を含む）いるため削除できません。先にユーザーのロールを変更してください", n))
+		}
+		if signup.Load(ctx, qtx).DefaultRole == name {
+			return inputError("セルフサインアップの自動登録時のロールに指定されているため削除できません")
+		}
+		if _, err := qtx.DeleteCustomRole(ctx, name); err != nil {
+			return err
+		}
+		entry := roleAuditEntry(ctx, audit.ActionRoleDelete, name)
+		entry.Before = roles.FromRow(before)
+		return audit.Record(ctx, qtx, entry)
+	})
+	if msg, ok := inputErrorMessage(err); ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errRoleNotFound) {
+		http.Error(w, "ロールが見つかりません", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("ロールの削除に失敗", "error", err, "name", name)
+		http.Error(w, "ロールの削除に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	sse := h.patchList(w, r)
+	sendToast(sse, "ロールを削除しました")
+}
+
+// roleAuditEntry はロールを対象にした監査ログの雛形（対象 ID はロール名）。
+func roleAuditEntry(ctx context.Context, action, name string) audit.Entry {
+	entry := audit.FromContext(ctx, action, audit.TargetRole, 0)
+	entry.TargetID = name
+	return entry
+}
+
+// patchList はプロセス内のロールを DB から読み直し、一覧を差し替える。
+func (h *RoleHandler) patchList(w http.ResponseWriter, r *http.Request) *datastar.ServerSentEventGenerator {
+	if err := roles.Load(r.Context(), h.Queries); err != nil {
+		logger.Error("カスタムロールの読み込みに失敗", "error", err)
+	}
+	sse := newSSE(w, r)
+	if err := sse.PatchElementTempl(
+		components.AdminRolesList(roles.List()),
+		datastar.WithSelectorID("roles-list"),
+		datastar.WithModeOuter(),
+		datastar.WithViewTransitions(),
+	); err != nil {
+		logger.Error("SSE PatchElementTempl failed", "error", err)
+	}
+	return sse
+}
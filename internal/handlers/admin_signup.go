@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/signup"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+)
+
+type SignupHandler struct {
+	DB      *sql.DB
+	Queries *database.Queries
+}
+
+func NewSignupHandler(db *sql.DB, q *database.Queries) *SignupHandler {
+	return &SignupHandler{DB: db, Queries: q}
+}
+
+// Page はセルフサインアップの設定画面を表示する。admin 限定。
+func (h *SignupHandler) Page(w http.ResponseWriter, r *http.Request) {
+	policy := signup.Load(r.Context(), h.Queries)
+	renderShell(w, r, "セルフサインアップ", components.AdminSignup(policy))
+}
+
+// signupPolicySignals はセルフサインアップ設定の signals。
+type signupPolicySignals struct {
+	Enabled  bool   `json:"signupEnabled"`
+	Domains  string `json:"signupDomains"`
+	Role     string `json:"signupRole"`
+	Approval bool   `json:"signupApproval"`
+}
+
+// UpdatePolicySSE はサインアップ設定を保存する。
+// Datastar 経由 (PUT /api/sse/admin/signup)。入力が不正なら 400 でメッセージを返す。
+func (h *SignupHandler) UpdatePolicySSE(w http.ResponseWriter, r *http.Request) {
+	var signals signupPolicySignals
+	if !readSignalsOr413(w, r, &signals) {
+		return
+	}
+
+	next, err := signup.Normalize(signup.Policy{
+		Enabled:         signals.Enabled,
+		Domains:         signup.ParseDomains(signals.Domains),
+		DefaultRole:     signals.Role,
+		RequireApproval: signals.Approval,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	err = dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		cur := signup.Load(ctx, qtx)
+		if err := signup.Save(ctx, qtx, next); err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionSignupPolicy, audit.TargetSetting, 0)
+		entry.TargetID = signup.Key
+		entry.Before, entry.After = cur, next
+		return audit.Record(ctx, qtx, entry)
+	})
+	if err != nil {
+		logger.Error("サインアップ設定の保存に失敗", "error", err)
+		http.Error(w, "保存に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	sse := newSSE(w, r)
+	sendToast(sse, "サインアップ設定を保存しました")
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/trash"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+// TrashHandler は管理画面のゴミ箱（削除したプロジェクト・ユーザーを戻す・完全に削除する）を扱う。
+// プロジェクトの欄と操作は全プロジェクトの権限（roles.ProjectAll）を持つときだけ。
+type TrashHandler struct {
+	DB      *sql.DB
+	Queries *database.Queries
+}
+
+func NewTrashHandler(db *sql.DB, q *database.Queries) *TrashHandler {
+	return &TrashHandler{DB: db, Queries: q}
+}
+
+// Page はゴミ箱の画面を表示する。
+func (h *TrashHandler) Page(w http.ResponseWriter, r *http.Request) {
+	projects, users, showProjects, err := h.load(r.Context())
+	if err != nil {
+		logger.Error("ゴミ箱の取得に失敗", "error", err)
+		httpError(w, r, http.StatusInternalServerError, "ゴミ箱の取得に失敗しました")
+		return
+	}
+	renderShell(w, r, "ゴミ箱", components.AdminTrash(projects, users, showProjects, trash.RetentionDays()))
+}
+
+// RestoreProjectSSE はプロジェクトをゴミ箱から戻す。
+func (h *TrashHandler) RestoreProjectSSE(w http.ResponseWriter, r *http.Request) {
+	h.handle(w, r, restoreTrashedProject, "プロジェクトを戻しました", "プロジェクトを戻せませんでした")
+}
+
+// PurgeProjectSSE はゴミ箱のプロジェクトを完全に削除する。
+func (h *TrashHandler) PurgeProjectSSE(w http.ResponseWriter, r *http.Request) {
+	h.handle(w, r, purgeTrashedProject, "プロジェクトを完全に削除しました", "プロジェクトを完全に削除できませんでした")
+}
+
+// RestoreUserSSE はユーザーをゴミ箱から戻す。
+func (h *TrashHandler) RestoreUserSSE(w http.ResponseWriter, r *http.Request) {
+	h.handle(w, r, restoreTrashedUser, "ユーザーを戻しました", "ユーザーを戻せませんでした")
+}
+
+// PurgeUserSSE はゴミ箱のユーザーを完全に削除する。
+func (h *TrashHandler) PurgeUserSSE(w http.ResponseWriter, r *http.Request) {
+	h.handle(w, r, purgeTrashedUser, "ユーザーを完全に削除しました", "ユーザーを完全に削除できませんでした")
+}
+
+// handle は {id} の行に op を適用し、ゴミ箱の一覧を差し替えてトーストを出す。
+// ゴミ箱に無い行（戻した・削除した後を含む）は 404。
+func (h *TrashHandler) handle(w http.ResponseWriter, r *http.Request, op func(context.Context, *sql.DB, *database.Queries, int64) error, done, failed string) {
+	id, ok := parseIDOr400(w, r, "id")
+	if !ok {
+		return
+	}
+	err := op(r.Context(), h.DB, h.Queries, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "ゴミ箱に見つかりません", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error(failed, "error", err, "id", id)
+		http.Error(w, failed, http.StatusInternalServerError)
+		return
+	}
+
+	projects, users, showProjects, err := h.load(r.Context())
+	if err != nil {
+		logger.Error("ゴミ箱の取得に失敗", "error", err)
+	}
+	sse := newSSE(w, r)
+	if err := sse.PatchElementTempl(
+		components.AdminTrashList(projects, users, showProjects),
+		datastar.WithSelectorID("trash-list"),
+		datastar.WithModeOuter(),
+		datastar.WithViewTransitions(),
+	); err != nil {
+		logger.Error("SSE PatchElementTempl failed", "error", err)
+	}
+	sendToast(sse, done)
+}
+
+// load はゴミ箱の中身を返す。プロジェクトは全プロジェクトの権限があるときだけ読む。
+func (h *TrashHandler) load(ctx context.Context) (projects []database.Project, users []database.User, showProjects bool, err error) {
+	showProjects = appcontext.Can(ctx, roles.ProjectAll)
+	if showProjects {
+		if projects, err = h.Queries.ListTrashedProjects(ctx); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	if users, err = h.Queries.ListTrashedUsers(ctx); err != nil {
+		return nil, nil, false, err
+	}
+	return projects, users, showProjects, nil
+}
+
+// restoreTrashedProject はプロジェクトをゴミ箱から戻し、監査ログを残す。メンバーは
+// ゴミ箱に移したときのまま。ゴミ箱に無ければ sql.ErrNoRows を返す。
+func restoreTrashedProject(ctx context.Context, db *sql.DB, q *database.Queries, id int64) error {
+	return dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		after, err := qtx.RestoreProject(ctx, id)
+		if err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionProjectRestore, audit.TargetProject, id)
+		entry.After = after
+		return audit.Record(ctx, qtx, entry)
+	})
+}
+
+// purgeTrashedProject はゴミ箱のプロジェクトを完全に削除し、監査ログを残す。メンバーは
+// 外部キーで一緒に消える。ゴミ箱に無ければ sql.ErrNoRows を返す。
+func purgeTrashedProject(ctx context.Context, db *sql.DB, q *database.Queries, id int64) error {
+	return dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		before, err := qtx.PurgeProject(ctx, id)
+		if err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionProjectPurge, audit.TargetProject, id)
+		entry.Before = before
+		return audit.Record(ctx, qtx, entry)
+	})
+}
+
+// restoreTrashedUser はユーザーをゴミ箱から戻し、監査ログを残す。パスキーは残っているが、
+// セッションはゴミ箱に移したときに消しているので、本人はログインし直す。ゴミ箱に無ければ sql.ErrNoRows を返す。
+func restoreTrashedUser(ctx context.Context, db *sql.DB, q *database.Queries, id int64) error {
+	return dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		after, err := qtx.RestoreUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionUserRestore, audit.TargetUser, id)
+		entry.After = after
+		return audit.Record(ctx, qtx, entry)
+	})
+}
+
+// purgeTrashedUser はゴミ箱のユーザーを完全に削除し、監査ログを残す。メンバーシップ・
+// API トークン・招待は外部キーで一緒に消え、責任者だったプロジェクトは責任者なしになる。
+// パスキーはコミット後に消す（authsession.Purge）。ゴミ箱に無ければ sql.ErrNoRows を返す。
+func purgeTrashedUser(ctx context.Context, db *sql.DB, q *database.Queries, id int64) error {
+	var email string
+	err := dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		before, err := qtx.PurgeUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		email = before.Email
+		entry := audit.FromContext(ctx, audit.ActionUserPurge, audit.TargetUser, id)
+		entry.Before = before
+		return audit.Record(ctx, qtx, entry)
+	})
+	if err != nil {
+		return err
+	}
+	if err := authsession.Purge(ctx, db, email); err != nil {
+		logger.Error("完全に削除したユーザーのパスキーの削除に失敗", "error", err, "email", email)
+	}
+	return nil
+}
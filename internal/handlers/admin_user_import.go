@@ -8,9 +8,14 @@ import (
 	"net/mail"
 	"strings"
 
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/invitation"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/limits"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/models"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
 	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
@@ -20,10 +25,11 @@ import (
 type UserImportHandler struct {
 	DB      *sql.DB
 	Queries *database.Queries
+	Inviter *invitation.Inviter
 }
 
-func NewUserImportHandler(db *sql.DB, queries *database.Queries) *UserImportHandler {
-	return &UserImportHandler{DB: db, Queries: queries}
+func NewUserImportHandler(db *sql.DB, queries *database.Queries, inviter *invitation.Inviter) *UserImportHandler {
+	return &UserImportHandler{DB: db, Queries: queries, Inviter: inviter}
 }
 
 func (h *UserImportHandler) ImportPage(w http.ResponseWriter, r *http.Request) {
@@ -70,6 +76,11 @@ func (h *UserImportHandler) ExecuteImport(w http.ResponseWriter, r *http.Request
 		httpError(w, r, http.StatusBadRequest, "リクエストの解析に失敗しました")
 		return
 	}
+	// multipart の CSRF トークンは CSRF ミドルウェアでは読まないので、ここで確かめる。
+	if !appMiddleware.ValidCSRFForm(r) {
+		httpError(w, r, http.StatusForbidden, appMiddleware.CSRFTokenMessage)
+		return
+	}
 
 	file, header, err := r.FormFile("file")
 	if err != nil {
@@ -110,6 +121,8 @@ func (h *UserImportHandler) ExecuteImport(w http.ResponseWriter, r *http.Request
 	ctx := r.Context()
 	result := &models.ImportResult{}
 	seenEmails := make(map[string]int)
+	invitedBy, _, _ := appcontext.GetUser(ctx)
+	var pendings []invitation.Pending
 
 	// 全行を1トランザクションで実行する: 途中でプロセスが止まっても（クラッシュ、
 	// graceful shutdown の排水タイムアウト超え）部分的に取り込まれた状態を残さない。
@@ -148,7 +161,11 @@ func (h *UserImportHandler) ExecuteImport(w http.ResponseWriter, r *http.Request
 			continue
 		}
 		if !roles.IsValid(role) {
-			result.Errors = append(result.Errors, models.ImportRowError{Row: rowNum, Message: "ロールは viewer, editor, admin のいずれかを指定してください"})
+			result.Errors = append(result.Errors, models.ImportRowError{Row: rowNum, Message: fmt.Sprintf("ロールは %s のいずれかを指定してください", strings.Join(roles.Names(), ", "))})
+			continue
+		}
+		if !canGrantRole(ctx, role) {
+			result.Errors = append(result.Errors, models.ImportRowError{Row: rowNum, Message: string(errRoleNotGrantable)})
 			continue
 		}
 
@@ -171,18 +188,44 @@ func (h *UserImportHandler) ExecuteImport(w http.ResponseWriter, r *http.Request
 			result.Errors = append(result.Errors, models.ImportRowError{Row: rowNum, Message: "データベースエラーが発生しました"})
 			continue
 		}
+		_, err = qtx.GetTrashedUserByEmail(ctx, email)
+		if err == nil {
+			result.Errors = append(result.Errors, models.ImportRowError{Row: rowNum, Message: "このメールアドレスのユーザーはゴミ箱にあります"})
+			continue
+		}
+		if err != sql.ErrNoRows {
+			logger.Error("インポート中の DB エラー", "error", err, "email", email, "row", rowNum)
+			result.Errors = append(result.Errors, models.ImportRowError{Row: rowNum, Message: "データベースエラーが発生しました"})
+			continue
+		}
 
-		_, err = qtx.CreateUser(ctx, database.CreateUserParams{
+		user, err := qtx.CreateUser(ctx, database.CreateUserParams{
 			Email:    email,
 			Name:     name,
 			Role:     role,
-			IsActive: true,
+			IsActive: false, // 招待メールのリンクを開くと有効になる
 		})
 		if err != nil {
 			logger.Error("ユーザー作成に失敗", "error", err, "email", email, "row", rowNum)
 			result.Errors = append(result.Errors, models.ImportRowError{Row: rowNum, Message: "ユーザーの作成に失敗しました"})
 			continue
 		}
+		// 監査ログが書けない行はコミット対象に残せないため、インポート全体を中止する
+		// （行をスキップすると「監査ログの無いユーザー」がコミットされてしまう）。
+		entry := audit.FromContext(ctx, audit.ActionUserImport, audit.TargetUser, user.ID)
+		entry.After = user
+		if err := audit.Record(ctx, qtx, entry); err != nil {
+			logger.Error("インポートの監査ログ記録に失敗", "error", err, "email", email, "row", rowNum)
+			httpError(w, r, http.StatusInternalServerError, "インポートの保存に失敗しました")
+			return
+		}
+		pending, err := invitation.Issue(ctx, qtx, user, invitedBy)
+		if err != nil {
+			logger.Error("インポートの招待作成に失敗", "error", err, "email", email, "row", rowNum)
+			httpError(w, r, http.StatusInternalServerError, "インポートの保存に失敗しました")
+			return
+		}
+		pendings = append(pendings, pending)
 
 		result.SuccessCount++
 	}
@@ -192,6 +235,12 @@ func (h *UserImportHandler) ExecuteImport(w http.ResponseWriter, r *http.Request
 		httpError(w, r, http.StatusInternalServerError, "インポートの保存に失敗しました")
 		return
 	}
+	// 作ったのは未有効のユーザーだけだが、削除したユーザーと同じメールアドレスで
+	// 作り直した場合に古いキャッシュが残らないよう捨てておく。
+	for _, p := range pendings {
+		authsession.ForgetUser(p.User.Email)
+	}
+	h.Inviter.SendAll(ctx, h.Queries, pendings)
 
 	if len(result.Errors) > 50 {
 		total := len(result.Errors)
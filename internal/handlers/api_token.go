@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/apitoken"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+// errAPITokenNotFound は失効対象のトークンが無い（または他人のトークン）ことを表す。
+var errAPITokenNotFound = errors.New("api token not found")
+
+// APITokenHandler は個人用 API トークンの発行・失効を扱う。
+// 本人の操作はマイページ、全ユーザー分の一覧と失効は管理画面から行う。
+type APITokenHandler struct {
+	DB      *sql.DB
+	Queries *database.Queries
+}
+
+func NewAPITokenHandler(db *sql.DB, q *database.Queries) *APITokenHandler {
+	return &APITokenHandler{DB: db, Queries: q}
+}
+
+// apiTokenSignals は API トークン発行フォームの signals。
+type apiTokenSignals struct {
+	Name   string `json:"tokenName"`
+	Scope  string `json:"tokenScope"`
+	Expiry string `json:"tokenExpiry"`
+}
+
+// CreateSSE はログイン中のユーザーのトークンを発行し、平文を 1 回だけ表示する。
+// API トークンで認証したリクエストからは発行させない（漏れたトークンから増やされないように）。
+func (h *APITokenHandler) CreateSSE(w http.ResponseWriter, r *http.Request) {
+	if _, ok := apitoken.FromRequest(r); ok {
+		http.Error(w, "API トークンでは API トークンを発行できません", http.StatusForbidden)
+		return
+	}
+
+	var signals apiTokenSignals
+	if !readSignalsOr413(w, r, &signals) {
+		return
+	}
+	name, err := apitoken.ValidateName(signals.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !apitoken.IsValidScope(signals.Scope) {
+		http.Error(w, "権限の指定が不正です", http.StatusBadRequest)
+		return
+	}
+	days, err := strconv.Atoi(signals.Expiry)
+	if err != nil || !apitoken.IsValidExpiryDays(days) {
+		http.Error(w, "有効期間の指定が不正です", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	userID := appcontext.GetUserID(ctx)
+	var raw string
+	err = dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		tok, plain, err := apitoken.Create(ctx, qtx, userID, name, signals.Scope, days)
+		if err != nil {
+			return err
+		}
+		raw = plain
+		entry := audit.FromContext(ctx, audit.ActionAPITokenCreate, audit.TargetAPIToken, tok.ID)
+		entry.After = apitoken.AuditState(tok)
+		return audit.Record(ctx, qtx, entry)
+	})
+	if err != nil {
+		logger.Error("API トークンの発行に失敗", "error", err, "user_id", userID)
+		http.Error(w, "API トークンの発行に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	sse := h.patchProfileTokens(w, r, raw)
+	_ = sse.MarshalAndPatchSignals(map[string]any{"tokenName": ""})
+	_ = runScript(sse, "document.getElementById('api-token-dialog')?.close()")
+	sendToast(sse, "API トークンを発行しました")
+}
+
+// RevokeSSE は自分のトークンを失効させる。他のユーザーのトークンは 404 として扱う。
+func (h *APITokenHandler) RevokeSSE(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDOr400(w, r, "id")
+	if !ok {
+		return
+	}
+	userID := appcontext.GetUserID(r.Context())
+	if !h.revoke(w, r, id, userID) {
+		return
+	}
+	sse := h.patchProfileTokens(w, r, "")
+	sendToast(sse, "API トークンを失効させました")
+}
+
+// AdminPage は全ユーザーのトークン一覧を表示する。admin 限定。
+func (h *APITokenHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
+	tokens, err := h.Queries.ListAPITokens(r.Context())
+	if err != nil {
+		logger.Error("API トークン一覧の取得に失敗", "error", err)
+		httpError(w, r, http.StatusInternalServerError, "API トークン一覧の取得に失敗しました")
+		return
+	}
+	renderShell(w, r, "API トークン", components.AdminAPITokens(tokens))
+}
+
+// AdminRevokeSSE は任意のユーザーのトークンを失効させる。admin 限定。
+func (h *APITokenHandler) AdminRevokeSSE(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDOr400(w, r, "id")
+	if !ok {
+		return
+	}
+	if !h.revoke(w, r, id, 0) {
+		return
+	}
+
+	tokens, err := h.Queries.ListAPITokens(r.Context())
+	if err != nil {
+		logger.Error("API トークン一覧の取得に失敗", "error", err)
+	}
+	sse := newSSE(w, r)
+	if err := sse.PatchElementTempl(
+		components.AdminAPITokensList(tokens),
+		datastar.WithSelectorID("api-tokens-list"),
+		datastar.WithModeOuter(),
+		datastar.WithViewTransitions(),
+	); err != nil {
+		logger.Error("SSE PatchElementTempl failed", "error", err)
+	}
+	sendToast(sse, "API トークンを失効させました")
+}
+
+// revoke はトークンを削除して監査ログを残す。ownerID が 0 以外なら、そのユーザーの
+// トークンだけを対象にする。失敗時はエラーを書いて false を返す。
+func (h *APITokenHandler) revoke(w http.ResponseWriter, r *http.Request, id, ownerID int64) bool {
+	ctx := r.Context()
+	err := dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		tok, err := qtx.GetAPIToken(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && ownerID != 0 && tok.UserID != ownerID) {
+			return errAPITokenNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := qtx.DeleteAPIToken(ctx, id); err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionAPITokenRevoke, audit.TargetAPIToken, id)
+		entry.Before = apitoken.AuditState(tok)
+		return audit.Record(ctx, qtx, entry)
+	})
+	switch {
+	case errors.Is(err, errAPITokenNotFound):
+		http.Error(w, "API トークンが見つかりません", http.StatusNotFound)
+		return false
+	case err != nil:
+		logger.Error("API トークンの失効に失敗", "error", err, "id", id)
+		http.Error(w, "API トークンの失効に失敗しました", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// patchProfileTokens はマイページのトークンカードを差し替える。newToken は発行直後の平文
+// （カード内に 1 回だけ表示する。それ以外は空文字）。
+func (h *APITokenHandler) patchProfileTokens(w http.ResponseWriter, r *http.Request, newToken string) *datastar.ServerSentEventGenerator {
+	userID := appcontext.GetUserID(r.Context())
+	tokens, err := h.Queries.ListAPITokensByUser(r.Context(), userID)
+	if err != nil {
+		logger.Error("API トークン一覧の取得に失敗", "error", err, "user_id", userID)
+	}
+
+	sse := newSSE(w, r)
+	if err := sse.PatchElementTempl(
+		components.ProfileAPITokensCard(tokens, newToken),
+		datastar.WithSelectorID("profile-api-tokens"),
+		datastar.WithModeOuter(),
+		datastar.WithViewTransitions(),
+	); err != nil {
+		logger.Error("SSE PatchElementTempl failed", "error", err)
+	}
+	return sse
+}
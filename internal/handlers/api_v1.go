@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+)
+
+// JSON API（/api/v1）の共通部分。
+//
+// 成功時は {"data": ...}（一覧は {"data": [...], "next_cursor": "..."}）、
+// 失敗時はミドルウェアと同じ {"error": コード, "message": 説明} を返す。
+// 一覧は新しい順（id の降順）で、next_cursor を次の ?cursor= に渡すと続きを取れる。
+
+const (
+	// apiPageDefault は ?limit= を省略したときの 1 ページの件数。
+	apiPageDefault = 50
+	// apiPageMax は ?limit= で指定できる最大件数。
+	apiPageMax = 200
+)
+
+// apiItem は 1 件を返すときの本体。
+type apiItem[T any] struct {
+	Data T `json:"data"`
+}
+
+// apiList は一覧を返すときの本体。最後のページでは NextCursor を省く。
+type apiList[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// apiPage は ?cursor= と ?limit= を解釈した結果。
+type apiPage struct {
+	// beforeID より小さい id の行を返す（最初のページは math.MaxInt64）。
+	beforeID int64
+	limit    int64
+}
+
+// parseAPIPageOr400 は ?cursor= と ?limit= を解釈する。不正なら 400 を返して false を返す。
+func parseAPIPageOr400(w http.ResponseWriter, r *http.Request) (apiPage, bool) {
+	p := apiPage{beforeID: math.MaxInt64, limit: apiPageDefault}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > apiPageMax {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request", "limit は 1〜"+strconv.Itoa(apiPageMax)+" で指定してください")
+			return p, false
+		}
+		p.limit = n
+	}
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		id, err := decodeCursor(v)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request", "cursor が不正です")
+			return p, false
+		}
+		p.beforeID = id
+	}
+	return p, true
+}
+
+// maxRows はページの判定用に 1 件多く読む件数を返す（多く読めたら次のページがある）。
+func (p apiPage) maxRows() int64 { return p.limit + 1 }
+
+// pageOf は maxRows 件まで読んだ rows を 1 ページ分に切り詰め、conv で API の形に変換する。
+func pageOf[T, U any](rows []T, p apiPage, id func(T) int64, conv func(T) U) apiList[U] {
+	list := apiList[U]{Data: make([]U, 0, min(len(rows), int(p.limit)))}
+	if int64(len(rows)) > p.limit {
+		rows = rows[:p.limit]
+		list.NextCursor = encodeCursor(id(rows[len(rows)-1]))
+	}
+	for _, row := range rows {
+		list.Data = append(list.Data, conv(row))
+	}
+	return list
+}
+
+// カーソルはそのページ最後の行の id。中身に依存されないよう base64url で包む。
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(s string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid cursor")
+	}
+	return id, nil
+}
+
+// likePattern は部分一致の検索語を LIKE のパターンにする（空なら全件一致）。
+// 検索語の % と _ は文字として扱う（クエリ側に ESCAPE '\' が必要）。
+func likePattern(q string) string {
+	if q = strings.TrimSpace(q); q == "" {
+		return "%"
+	}
+	return "%" + escapeLike(q) + "%"
+}
+
+// likeEscaper は LIKE ... ESCAPE '\' のワイルドカードとエスケープ文字を打ち消す。
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike は s を LIKE ... ESCAPE '\' で完全一致させるパターンにする。
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// parseAPIIDOr404 は URL の {id} を解釈する。数値でなければ該当なしとして 404 を返す。
+func parseAPIIDOr404(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "not_found", "見つかりません")
+		return 0, false
+	}
+	return id, true
+}
+
+// readJSONOr4xx はリクエスト body の JSON を dst に読む。Content-Type が JSON でなければ 415、
+// body 上限超過は 413、形式の誤りや未知のフィールドは 400 を返して false を返す。
+// JSON に限ることで、他サイトのフォームから Cookie 付きで書き込まれるのも防ぐ。
+func readJSONOr4xx(w http.ResponseWriter, r *http.Request, dst any) bool {
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "Content-Type は application/json にしてください")
+		return false
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "request_too_large", "リクエストが大きすぎます")
+			return false
+		}
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "JSON の形式が不正です")
+		return false
+	}
+	return true
+}
+
+// writeJSON は v を JSON で返す。
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("JSON レスポンスの書き込みに失敗", "error", err)
+	}
+}
+
+// writeAPIError は {"error": code, "message": message} を返す。
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]string{"error": code, "message": message})
+}
+
+// writeAPIInputError は err が入力エラーなら 400 を返して true を返す。
+func writeAPIInputError(w http.ResponseWriter, err error) bool {
+	if msg, ok := inputErrorMessage(err); ok {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", msg)
+		return true
+	}
+	return false
+}
+
+// APINotFound / APIMethodNotAllowed は /api/v1 配下の未定義ルートに JSON で応答する。
+func APINotFound(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, "not_found", "見つかりません")
+}
+
+func APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "このメソッドは使えません")
+}
+
+// apiTime は NULL を許す日時を JSON の null か RFC 3339 にする。
+func apiTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/models"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// ProjectAPIHandler は JSON API のプロジェクト CRUD（/api/v1/projects）。
+// 検証・監査ログは画面の ProjectSSEHandler と共通（createProject などを参照）。
+type ProjectAPIHandler struct {
+	DB      *sql.DB
+	Queries *database.Queries
+}
+
+func NewProjectAPIHandler(db *sql.DB, queries *database.Queries) *ProjectAPIHandler {
+	return &ProjectAPIHandler{DB: db, Queries: queries}
+}
+
+// apiProject は API で返すプロジェクト。DueDate は "2006-01-02"、OwnerID は責任者のユーザー ID
+// （いなければ null）。
+type apiProject struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Status      string     `json:"status"`
+	OwnerID     *int64     `json:"owner_id"`
+	DueDate     *string    `json:"due_date"`
+	Tags        []string   `json:"tags"`
+	CreatedAt   *time.Time `json:"created_at"`
+}
+
+func toAPIProject(p database.Project) apiProject {
+	out := apiProject{
+		ID:          p.ID,
+		Name:        p.Name,
+		Description: p.Description,
+		Status:      p.Status,
+		Tags:        models.ParseProjectTags(p.Tags),
+		CreatedAt:   apiTime(p.CreatedAt),
+	}
+	if out.Tags == nil {
+		out.Tags = []string{}
+	}
+	if p.OwnerID.Valid {
+		out.OwnerID = &p.OwnerID.Int64
+	}
+	if p.DueDate.Valid {
+		d := p.DueDate.Time.Format(time.DateOnly)
+		out.DueDate = &d
+	}
+	return out
+}
+
+// projectInput は作成・更新の body（画面の signals も projectSignals.input でこれにする）。
+// 更新では省略した項目を変えない。作成で省略すると、状態は active、責任者は作成者になる。
+// owner_id の 0 と due_date の "" は「なし」にする。
+type projectInput struct {
+	Name        *string   `json:"name"`
+	Description *string   `json:"description"`
+	Status      *string   `json:"status"`
+	OwnerID     *int64    `json:"owner_id"`
+	DueDate     *string   `json:"due_date"`
+	Tags        *[]string `json:"tags"`
+}
+
+// applyTo は指定された項目だけを f に上書きする。
+func (in projectInput) applyTo(f *projectFields) {
+	if in.Name != nil {
+		f.Name = *in.Name
+	}
+	if in.Description != nil {
+		f.Description = *in.Description
+	}
+	if in.Status != nil {
+		f.Status = *in.Status
+	}
+	if in.OwnerID != nil {
+		f.OwnerID = *in.OwnerID
+	}
+	if in.DueDate != nil {
+		f.DueDate = *in.DueDate
+	}
+	if in.Tags != nil {
+		f.Tags = *in.Tags
+	}
+}
+
+// List はメンバーになっているプロジェクト（ProjectAll の権限があれば全件）を新しい順に返す。
+// ?q= で名前の部分一致に絞り込める。
+func (h *ProjectAPIHandler) List(w http.ResponseWriter, r *http.Request) {
+	page, ok := parseAPIPageOr400(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	var (
+		rows []database.Project
+		err  error
+	)
+	if appcontext.Can(ctx, roles.ProjectAll) {
+		rows, err = h.Queries.ListProjectsPage(ctx, database.ListProjectsPageParams{
+			BeforeID:    page.beforeID,
+			NamePattern: likePattern(r.URL.Query().Get("q")),
+			MaxRows:     page.maxRows(),
+		})
+	} else {
+		rows, err = h.Queries.ListProjectsPageForUser(ctx, database.ListProjectsPageForUserParams{
+			UserID:      appcontext.GetUserID(ctx),
+			BeforeID:    page.beforeID,
+			NamePattern: likePattern(r.URL.Query().Get("q")),
+			MaxRows:     page.maxRows(),
+		})
+	}
+	if err != nil {
+		logger.Error("プロジェクト一覧の取得に失敗", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "プロジェクト一覧の取得に失敗しました")
+		return
+	}
+	writeJSON(w, http.StatusOK, pageOf(rows, page, func(p database.Project) int64 { return p.ID }, toAPIProject))
+}
+
+func (h *ProjectAPIHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseAPIIDOr404(w, r)
+	if !ok {
+		return
+	}
+	project, _, err := projectAccess(r.Context(), h.Queries, id)
+	if writeAPIProjectAccessError(w, err) {
+		return
+	}
+	if err != nil {
+		logger.Error("プロジェクトの取得に失敗", "error", err, "id", id)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "プロジェクトの取得に失敗しました")
+		return
+	}
+	writeJSON(w, http.StatusOK, apiItem[apiProject]{Data: toAPIProject(project)})
+}
+
+// writeAPIProjectAccessError は projectAccess 系のエラーなら 404 / 403 を書いて true を返す。
+func writeAPIProjectAccessError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeAPIError(w, http.StatusNotFound, "not_found", "プロジェクトが見つかりません")
+		return true
+	case errors.Is(err, errProjectForbidden):
+		writeAPIError(w, http.StatusForbidden, "forbidden", "このプロジェクトでの権限が足りません")
+		return true
+	}
+	return false
+}
+
+// Create はプロジェクトを作成し（作成者がオーナーになる）、201 と Location を返す。
+func (h *ProjectAPIHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var in projectInput
+	if !readJSONOr4xx(w, r, &in) {
+		return
+	}
+	project, err := createProject(r.Context(), h.DB, h.Queries, in)
+	if writeAPIInputError(w, err) {
+		return
+	}
+	if err != nil {
+		logger.Error("プロジェクト作成に失敗", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "プロジェクトの作成に失敗しました")
+		return
+	}
+	w.Header().Set("Location", "/api/v1/projects/"+strconv.FormatInt(project.ID, 10))
+	writeJSON(w, http.StatusCreated, apiItem[apiProject]{Data: toAPIProject(project)})
+}
+
+// Update は body で指定した項目だけを変更する（PATCH）。
+func (h *ProjectAPIHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseAPIIDOr404(w, r)
+	if !ok {
+		return
+	}
+	var in projectInput
+	if !readJSONOr4xx(w, r, &in) {
+		return
+	}
+
+	ctx := r.Context()
+	var (
+		project database.Project
+		err     error
+	)
+	if in != (projectInput{}) {
+		project, _, err = updateProject(ctx, h.DB, h.Queries, id, in)
+	} else {
+		// 変更する項目が無ければ、書き込まずに現在の値を返す（権限の判定は更新と同じ）。
+		project, _, err = requireProjectRole(ctx, h.Queries, id, roles.ProjectCanWrite)
+	}
+	if writeAPIInputError(w, err) {
+		return
+	}
+	if writeAPIProjectAccessError(w, err) {
+		return
+	}
+	if err != nil {
+		logger.Error("プロジェクト更新に失敗", "error", err, "id", id)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "プロジェクトの更新に失敗しました")
+		return
+	}
+	writeJSON(w, http.StatusOK, apiItem[apiProject]{Data: toAPIProject(project)})
+}
+
+// Delete はプロジェクトを削除して 204 を返す。既に無いプロジェクトも 204（冪等）。
+// 削除できるのはオーナーだけ。
+func (h *ProjectAPIHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseAPIIDOr404(w, r)
+	if !ok {
+		return
+	}
+	err := deleteProject(r.Context(), h.DB, h.Queries, id)
+	if writeAPIProjectAccessError(w, err) {
+		return
+	}
+	if err != nil {
+		logger.Error("プロジェクト削除に失敗", "error", err, "id", id)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "プロジェクトの削除に失敗しました")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
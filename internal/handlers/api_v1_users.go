@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/naozine/nz-magic-link/magiclink"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/invitation"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// UserAPIHandler は JSON API のユーザー CRUD（/api/v1/users）。admin 限定。
+// 検証・招待・監査ログ・削除時の認証情報の後始末は管理画面の AdminSSEHandler と共通。
+type UserAPIHandler struct {
+	DB      *sql.DB
+	Queries *database.Queries
+	ML      *magiclink.MagicLink
+	Inviter *invitation.Inviter
+}
+
+func NewUserAPIHandler(db *sql.DB, queries *database.Queries, ml *magiclink.MagicLink, inviter *invitation.Inviter) *UserAPIHandler {
+	return &UserAPIHandler{DB: db, Queries: queries, ML: ml, Inviter: inviter}
+}
+
+// apiUser は API で返すユーザー。
+type apiUser struct {
+	ID        int64      `json:"id"`
+	Email     string     `json:"email"`
+	Name      string     `json:"name"`
+	Role      string     `json:"role"`
+	IsActive  bool       `json:"is_active"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+func toAPIUser(u database.User) apiUser {
+	return apiUser{
+		ID:        u.ID,
+		Email:     u.Email,
+		Name:      u.Name,
+		Role:      u.Role,
+		IsActive:  u.IsActive,
+		CreatedAt: apiTime(u.CreatedAt),
+		UpdatedAt: apiTime(u.UpdatedAt),
+	}
+}
+
+// List はユーザーを新しい順に返す。?q= で名前・メールアドレスの部分一致、
+// ?role= でロール、?status=active|inactive で有効・無効（招待中を含む）に絞り込める。
+func (h *UserAPIHandler) List(w http.ResponseWriter, r *http.Request) {
+	page, ok := parseAPIPageOr400(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	p := database.ListUsersPageParams{
+		BeforeID:    page.beforeID,
+		Pattern:     likePattern(query.Get("q")),
+		RolePattern: "%",
+		MaxRows:     page.maxRows(),
+	}
+	if role := query.Get("role"); role != "" {
+		if !roles.IsValid(role) {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request", "role の指定が不正です")
+			return
+		}
+		p.RolePattern = escapeLike(role)
+	}
+	switch query.Get("status") {
+	case "":
+	case "active":
+		p.IsActive = sql.NullBool{Bool: true, Valid: true}
+	case "inactive":
+		p.IsActive = sql.NullBool{Bool: false, Valid: true}
+	default:
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "status は active か inactive で指定してください")
+		return
+	}
+
+	rows, err := h.Queries.ListUsersPage(r.Context(), p)
+	if err != nil {
+		logger.Error("ユーザー一覧の取得に失敗", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "ユーザー一覧の取得に失敗しました")
+		return
+	}
+	writeJSON(w, http.StatusOK, pageOf(rows, page, func(u database.User) int64 { return u.ID }, toAPIUser))
+}
+
+func (h *UserAPIHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseAPIIDOr404(w, r)
+	if !ok {
+		return
+	}
+	user, err := h.Queries.GetUserByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "ユーザーが見つかりません")
+		return
+	}
+	if err != nil {
+		logger.Error("ユーザーの取得に失敗", "error", err, "id", id)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "ユーザーの取得に失敗しました")
+		return
+	}
+	writeJSON(w, http.StatusOK, apiItem[apiUser]{Data: toAPIUser(user)})
+}
+
+// userCreateInput はユーザー作成の body。
+type userCreateInput struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// userCreated はユーザー作成の応答。
+type userCreated struct {
+	Data           apiUser `json:"data"`
+	InvitationSent bool    `json:"invitation_sent"`
+}
+
+// Create は管理画面の「ユーザー追加」と同じく、招待中のユーザーを作って招待メールを送る。
+// メールを送れなくてもユーザーは残る（invitation_sent が false になる。管理画面から再送できる）。
+func (h *UserAPIHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var in userCreateInput
+	if !readJSONOr4xx(w, r, &in) {
+		return
+	}
+
+	ctx := r.Context()
+	pending, err := createInvitedUser(ctx, h.DB, h.Queries, in.Name, in.Email, in.Role)
+	if errors.Is(err, errEmailTaken) || errors.Is(err, errEmailInTrash) {
+		writeAPIError(w, http.StatusConflict, "conflict", err.Error())
+		return
+	}
+	if writeAPIInputError(w, err) {
+		return
+	}
+	if err != nil {
+		logger.Error("ユーザー作成に失敗", "error", err, "email", in.Email)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "ユーザーの作成に失敗しました")
+		return
+	}
+
+	sent := true
+	if err := h.Inviter.Send(ctx, h.Queries, pending); err != nil {
+		logger.Error("招待メールの送信に失敗", "error", err, "email", pending.User.Email)
+		sent = false
+	}
+
+	w.Header().Set("Location", "/api/v1/users/"+strconv.FormatInt(pending.User.ID, 10))
+	writeJSON(w, http.StatusCreated, userCreated{Data: toAPIUser(pending.User), InvitationSent: sent})
+}
+
+// userUpdateInput はユーザー更新の body。省略した項目は変えない。
+type userUpdateInput struct {
+	Name     *string `json:"name"`
+	Role     *string `json:"role"`
+	IsActive *bool   `json:"is_active"`
+}
+
+// Update は body で指定した項目（name / role / is_active）だけを変更する（PATCH）。
+// 管理者相当のロールへ変えるときは、ログインが古ければ 403（本人確認）。API トークンでは常に 403。
+func (h *UserAPIHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseAPIIDOr404(w, r)
+	if !ok {
+		return
+	}
+	var in userUpdateInput
+	if !readJSONOr4xx(w, r, &in) {
+		return
+	}
+	if in.Role != nil && !appMiddleware.RecentlyAuthenticated(r.Context()) &&
+		promotesToAdmin(r.Context(), h.Queries, id, *in.Role) {
+		appMiddleware.RejectStepUp(w, r, http.HandlerFunc(StepUpRequiredSSE))
+		return
+	}
+
+	user, err := updateUser(r.Context(), h.DB, h.Queries, id, func(p *database.UpdateUserParams) {
+		if in.Name != nil {
+			p.Name = *in.Name
+		}
+		if in.Role != nil {
+			p.Role = *in.Role
+		}
+		if in.IsActive != nil {
+			p.IsActive = *in.IsActive
+		}
+	})
+	if writeAPIInputError(w, err) {
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "ユーザーが見つかりません")
+		return
+	}
+	if err != nil {
+		logger.Error("ユーザー更新に失敗", "error", err, "id", id)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "ユーザーの更新に失敗しました")
+		return
+	}
+	writeJSON(w, http.StatusOK, apiItem[apiUser]{Data: toAPIUser(user)})
+}
+
+// Delete はユーザーを削除して 204 を返す。自分自身は削除できない（400）。
+func (h *UserAPIHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseAPIIDOr404(w, r)
+	if !ok {
+		return
+	}
+	err := deleteUser(r.Context(), h.DB, h.Queries, id)
+	if writeAPIInputError(w, err) {
+		return
+	}
+	if err != nil {
+		logger.Error("ユーザー削除に失敗", "error", err, "id", id)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "ユーザーの削除に失敗しました")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
@@ -11,23 +12,33 @@ import (
 
 type AuthHandler struct {
 	Queries *database.Queries
+	// SSOName は OIDC ログインの IdP の表示名（空なら OIDC ログインのボタンを出さない）。
+	SSOName string
 }
 
-func NewAuthHandler(queries *database.Queries) *AuthHandler {
-	return &AuthHandler{Queries: queries}
+func NewAuthHandler(queries *database.Queries, ssoName string) *AuthHandler {
+	return &AuthHandler{Queries: queries, SSOName: ssoName}
 }
 
 func (h *AuthHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
 	_, isLoggedIn, _ := appcontext.GetUser(r.Context())
 
-	// メンテナンス中は一般ログインのフォームを描画せず、案内のみ表示する。
-	// 既にログイン中のユーザーはセッションが維持されるのでそのままホームへ通す。
-	if maintenance.IsEnabled(r.Context(), h.Queries) {
+	// メンテナンス中は一般ログインのフォームを描画せず、案内のみ表示する（予定に許可アドレスが
+	// あるときだけフォームも出す）。既にログイン中のユーザーはホームへ送る（admin と許可アドレス
+	// 以外はそこで middleware.Maintenance に止められる）。
+	now := time.Now()
+	if st := maintenance.Load(r.Context(), h.Queries); st.Active(now) {
 		if isLoggedIn {
 			http.Redirect(w, r, "/projects", http.StatusSeeOther)
 			return
 		}
-		renderGuest(w, r, "メンテナンス中", components.LoginMaintenance())
+		// 終了予定は予定の時間帯だけで決まる場合に出す（手動 ON は解除されるまで続く）。
+		var end time.Time
+		if st.InWindow(now) && !st.Manual {
+			end = st.Window.End
+		}
+		showForm := st.InWindow(now) && len(st.Window.AllowEmails) > 0
+		renderGuest(w, r, "メンテナンス中", components.LoginMaintenance(st.Message(now), end, showForm, h.SSOName))
 		return
 	}
 
@@ -45,5 +56,5 @@ func (h *AuthHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
 		errorMessage = "無効なログインリンクです。"
 	}
 
-	renderGuest(w, r, "ログイン", components.LoginForm(errorMessage))
+	renderGuest(w, r, "ログイン", components.LoginForm(errorMessage, h.SSOName))
 }
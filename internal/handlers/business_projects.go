@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -18,14 +20,15 @@ func NewProjectHandler(queries *database.Queries) *ProjectHandler {
 	return &ProjectHandler{Queries: queries}
 }
 
+// ListProjects はログイン中のユーザーがメンバーのプロジェクトを一覧する（ProjectAll の権限があれば全件）。
 func (h *ProjectHandler) ListProjects(w http.ResponseWriter, r *http.Request) {
-	projects, err := h.Queries.ListProjects(r.Context())
+	projects, memberRoles, err := listVisibleProjects(r.Context(), h.Queries)
 	if err != nil {
 		logger.Error("プロジェクト一覧の取得に失敗", "error", err)
 		httpError(w, r, http.StatusInternalServerError, "プロジェクト一覧の取得に失敗しました")
 		return
 	}
-	renderShell(w, r, "プロジェクト一覧", components.ProjectList(projects))
+	renderShell(w, r, "プロジェクト一覧", components.ProjectList(projects, memberRoles))
 }
 
 func (h *ProjectHandler) ShowProject(w http.ResponseWriter, r *http.Request) {
@@ -37,11 +40,24 @@ func (h *ProjectHandler) ShowProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	project, err := h.Queries.GetProject(r.Context(), id)
-	if err != nil {
+	// メンバーでないプロジェクトも「見つからない」にする（存在を漏らさない）。
+	ctx := r.Context()
+	project, memberRole, err := projectAccess(ctx, h.Queries, id)
+	if errors.Is(err, sql.ErrNoRows) {
 		httpError(w, r, http.StatusNotFound, "プロジェクトが見つかりません")
 		return
 	}
+	if err != nil {
+		logger.Error("プロジェクトの取得に失敗", "error", err, "id", id)
+		httpError(w, r, http.StatusInternalServerError, "プロジェクトの取得に失敗しました")
+		return
+	}
+	members, err := h.Queries.ListProjectMembers(ctx, id)
+	if err != nil {
+		logger.Error("プロジェクトメンバーの取得に失敗", "error", err, "id", id)
+		httpError(w, r, http.StatusInternalServerError, "プロジェクトの取得に失敗しました")
+		return
+	}
 
-	renderShell(w, r, project.Name, components.ProjectDetail(project))
+	renderShell(w, r, project.Name, components.ProjectDetail(project, memberRole, members))
 }
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+)
+
+// cspReport はブラウザが CSP の report-uri に送る違反報告（Content-Type: application/csp-report）。
+type cspReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		EffectiveDirective string `json:"effective-directive"`
+		ViolatedDirective  string `json:"violated-directive"`
+		BlockedURI         string `json:"blocked-uri"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		Disposition        string `json:"disposition"`
+	} `json:"csp-report"`
+}
+
+// CSPReport は CSP（middleware.CSP）の違反報告をログに残す（認証不要）。
+// report-only で導入したときは、このログに違反が出なくなってから enforce に切り替える。
+// 報告はブラウザが勝手に送るもので応答は読まれないため、壊れた報告でも 204 を返す。
+func CSPReport(w http.ResponseWriter, r *http.Request) {
+	var report cspReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	v := report.Report
+	directive := v.EffectiveDirective
+	if directive == "" {
+		directive = v.ViolatedDirective
+	}
+	logger.Warn("CSP violation",
+		"document", v.DocumentURI,
+		"directive", directive,
+		"blocked", v.BlockedURI,
+		"source", v.SourceFile,
+		"line", v.LineNumber,
+		"disposition", v.Disposition,
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
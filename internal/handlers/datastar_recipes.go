@@ -148,10 +148,13 @@ func RecipeCounterInc(w http.ResponseWriter, r *http.Request) {
 
 // --- レシピ 3: インメモリ TODO 追加（ReadSignals → PatchElementTempl でリスト再描画）---
 
+// recipeTodoSignals は TODO 追加の signals。
+type recipeTodoSignals struct {
+	Text string `json:"text"`
+}
+
 func RecipeTodoAdd(w http.ResponseWriter, r *http.Request) {
-	var sig struct {
-		Text string `json:"text"`
-	}
+	var sig recipeTodoSignals
 	if err := datastar.ReadSignals(r, &sig); err != nil {
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
@@ -188,10 +191,13 @@ func RecipeTodoRemove(w http.ResponseWriter, r *http.Request) {
 
 // --- レシピ 5: ライブ検索（data-on:input__debounce → @get → PatchElementTempl）---
 
+// recipeSearchSignals はライブ検索の signals。
+type recipeSearchSignals struct {
+	Query string `json:"query"`
+}
+
 func RecipeSearch(w http.ResponseWriter, r *http.Request) {
-	var sig struct {
-		Query string `json:"query"`
-	}
+	var sig recipeSearchSignals
 	_ = datastar.ReadSignals(r, &sig)
 	q := strings.ToLower(strings.TrimSpace(sig.Query))
 	hits := make([]string, 0, len(recipeFruits))
@@ -230,7 +236,7 @@ func RecipeTick(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
-// --- レシピ 8: ダイアログ（PatchElementTempl で挿入 → ExecuteScript で showModal）---
+// --- レシピ 8: ダイアログ（PatchElementTempl で挿入 → runScript で showModal）---
 
 func RecipeDialog(w http.ResponseWriter, r *http.Request) {
 	sse := datastar.NewSSE(w, r)
@@ -239,16 +245,19 @@ func RecipeDialog(w http.ResponseWriter, r *http.Request) {
 		datastar.WithSelectorID("recipe-dialog-container"),
 		datastar.WithModeInner(),
 	)
-	_ = sse.ExecuteScript("document.getElementById('recipe-dialog')?.showModal()")
+	_ = runScript(sse, "document.getElementById('recipe-dialog')?.showModal()")
 }
 
 // --- レシピ 9: 仮想スクロール（JS 不要・サーバ往復型）---
 // 可視範囲＋overscan の行だけを translateY 付きで差し替える。DOM 上の行は常に一定。
 
+// recipeVRowsSignals は仮想スクロールの signals（表示先頭の行番号）。
+type recipeVRowsSignals struct {
+	Vstart int `json:"vstart"`
+}
+
 func RecipeVRows(w http.ResponseWriter, r *http.Request) {
-	var sig struct {
-		Vstart int `json:"vstart"`
-	}
+	var sig recipeVRowsSignals
 	_ = datastar.ReadSignals(r, &sig)
 	start := sig.Vstart - components.RecipeVOverscan
 	if start < 0 {
@@ -287,7 +296,12 @@ func RecipeItemEdit(w http.ResponseWriter, r *http.Request) {
 		datastar.WithSelectorID("recipe-item-dialog-container"),
 		datastar.WithModeInner(),
 	)
-	_ = sse.ExecuteScript("document.getElementById('recipe-item-dialog')?.showModal()")
+	_ = runScript(sse, "document.getElementById('recipe-item-dialog')?.showModal()")
+}
+
+// recipeItemSignals はインライン編集の signals。
+type recipeItemSignals struct {
+	Editname string `json:"editname"`
 }
 
 func RecipeItemUpdate(w http.ResponseWriter, r *http.Request) {
@@ -296,9 +310,7 @@ func RecipeItemUpdate(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid id", http.StatusBadRequest)
 		return
 	}
-	var sig struct {
-		Editname string `json:"editname"`
-	}
+	var sig recipeItemSignals
 	_ = datastar.ReadSignals(r, &sig)
 	item, ok := recipeState.updateItem(id, strings.TrimSpace(sig.Editname))
 	if !ok {
@@ -312,7 +324,7 @@ func RecipeItemUpdate(w http.ResponseWriter, r *http.Request) {
 		datastar.WithSelectorID(fmt.Sprintf("item-%d", id)),
 		datastar.WithModeOuter(),
 	)
-	_ = sse.ExecuteScript("document.getElementById('recipe-item-dialog')?.close()")
+	_ = runScript(sse, "document.getElementById('recipe-item-dialog')?.close()")
 }
 
 // --- リセット（インメモリ状態を初期化してリロード）---
@@ -320,5 +332,5 @@ func RecipeItemUpdate(w http.ResponseWriter, r *http.Request) {
 func RecipeReset(w http.ResponseWriter, r *http.Request) {
 	recipeState.reset()
 	sse := datastar.NewSSE(w, r)
-	_ = sse.ExecuteScript("window.location.reload()")
+	_ = runScript(sse, "window.location.reload()")
 }
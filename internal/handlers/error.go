@@ -3,8 +3,11 @@ package handlers
 import (
 	"net/http"
 
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
 	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/layouts"
 )
 
 // httpError はHTMLエラーページを返す。
@@ -31,3 +34,26 @@ func httpError(w http.ResponseWriter, r *http.Request, code int, message string)
 	w.WriteHeader(code)
 	renderGuest(w, r, "エラー", components.ErrorPage(code, message))
 }
+
+// MaintenancePage はメンテナンス中に admin 以外のユーザーへ返す 503 の画面。
+// middleware.Maintenance に渡す（Retry-After はミドルウェアが付ける）。
+func MaintenancePage(w http.ResponseWriter, r *http.Request) {
+	m, _ := appcontext.GetMaintenance(r.Context())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	layouts.Guest("メンテナンス中", components.MaintenanceNotice(m.Message, m.End)).Render(r.Context(), w)
+}
+
+// ReadOnlyRejectedSSE は読み取り専用モード中に Datastar から来た書き込みを、トーストで断る。
+// middleware.ReadOnly に渡す（503 にすると Datastar は汎用のエラートーストしか出せない）。
+func ReadOnlyRejectedSSE(w http.ResponseWriter, r *http.Request) {
+	sse := newSSE(w, r)
+	sendToast(sse, appMiddleware.ReadOnlyMessage)
+}
+
+// ImpersonationRejectedSSE は「このユーザーとして表示」中に Datastar から来た書き込みを、
+// トーストで断る（middleware.BlockImpersonatedWrites に渡す）。
+func ImpersonationRejectedSSE(w http.ResponseWriter, r *http.Request) {
+	sse := newSSE(w, r)
+	sendToast(sse, appMiddleware.ImpersonationMessage)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/naozine/nz-magic-link/magiclink"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appconfig"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/invitation"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/loginpolicy"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+)
+
+// InvitationHandler は招待メールのリンク（認証不要）を処理する。
+type InvitationHandler struct {
+	DB      *sql.DB
+	Queries *database.Queries
+	ML      *magiclink.MagicLink
+}
+
+func NewInvitationHandler(db *sql.DB, queries *database.Queries, ml *magiclink.MagicLink) *InvitationHandler {
+	return &InvitationHandler{DB: db, Queries: queries, ML: ml}
+}
+
+// AcceptPage は招待の承認画面。リンクが使えるかだけを確認し、承認はボタンの POST で行う
+// （メール内のリンクを先読みするセキュリティ製品に 1 回限りのトークンを消費されないため）。
+func (h *InvitationHandler) AcceptPage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	_, user, err := invitation.Lookup(r.Context(), h.Queries, token)
+	if err != nil {
+		h.renderInviteError(w, r, err)
+		return
+	}
+	renderGuest(w, r, "招待の承認", components.InviteAccept(user.Name, token))
+}
+
+// Accept は招待を承認してユーザーを有効化し、そのままログインさせる。
+// ログインできないとき（メンテナンス中など。loginpolicy.AllowInvitationLogin）は承認せず、
+// 理由を表示する（リンクは後で使える）。ログイン元の IP・User-Agent はルートに付けた
+// RecordSessionDetails が記録する。
+func (h *InvitationHandler) Accept(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	token := r.PostFormValue("token")
+
+	_, invitee, err := invitation.Lookup(ctx, h.Queries, token)
+	if err != nil {
+		h.renderInviteError(w, r, err)
+		return
+	}
+	if err := loginpolicy.AllowInvitationLogin(ctx, h.DB, invitee.Email); err != nil {
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		renderGuest(w, r, "招待", components.InviteError(err.Error()))
+		return
+	}
+
+	var user database.User
+	err = dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		var err error
+		user, err = invitation.Accept(ctx, qtx, token)
+		return err
+	})
+	if err != nil {
+		h.renderInviteError(w, r, err)
+		return
+	}
+	logger.Info("招待が承認されました", "email", user.Email)
+
+	// セッションはコミット後に作る（magiclink は別接続で sessions に書く）。
+	// 作れなくてもアカウントは有効になっているので、通常のログインに誘導する。
+	if err := h.ML.SessionManager.Create(w, r, user.Email); err != nil {
+		logger.Error("招待承認後のセッション作成に失敗", "error", err, "email", user.Email)
+		http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, appconfig.LandingPath, http.StatusSeeOther)
+}
+
+// renderInviteError は使えない招待リンクの理由を表示する。
+func (h *InvitationHandler) renderInviteError(w http.ResponseWriter, r *http.Request, err error) {
+	var code int
+	var message string
+	switch {
+	case errors.Is(err, invitation.ErrExpired):
+		code, message = http.StatusGone, "招待リンクの有効期限が切れています。管理者に招待の再送を依頼してください。"
+	case errors.Is(err, invitation.ErrAccepted):
+		code, message = http.StatusConflict, "この招待は既に承認されています。ログイン画面からログインしてください。"
+	case errors.Is(err, invitation.ErrInvalid):
+		code, message = http.StatusNotFound, "招待リンクが無効です。再送された場合は、最新の招待メールのリンクを開いてください。"
+	default:
+		logger.Error("招待の処理に失敗", "error", err)
+		httpError(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	renderGuest(w, r, "招待", components.InviteError(message))
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/naozine/nz-magic-link/magiclink"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appconfig"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/loginpolicy"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/oidc"
+)
+
+// oidcStateMaxAge は IdP でのログインを終えて戻ってくるまでの猶予（秒）。
+const oidcStateMaxAge = 10 * 60
+
+// OIDCHandler は社内 IdP（OpenID Connect）でのログインを処理する（認証不要）。
+// ログインに成功するとマジックリンクと同じセッションを作る。
+type OIDCHandler struct {
+	DB       *sql.DB
+	ML       *magiclink.MagicLink
+	Provider *oidc.Provider
+}
+
+func NewOIDCHandler(db *sql.DB, ml *magiclink.MagicLink, provider *oidc.Provider) *OIDCHandler {
+	return &OIDCHandler{DB: db, ML: ml, Provider: provider}
+}
+
+// stateCookie は認可リクエストの state・nonce・code_verifier を預ける Cookie の名前。
+func (h *OIDCHandler) stateCookie() string {
+	return h.ML.Config.CookieName + "_oidc"
+}
+
+// Login は state などを Cookie に預けて IdP の認可画面へリダイレクトする。
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	ar, err := oidc.NewAuthRequest()
+	if err != nil {
+		logger.Error("OIDC の認可リクエスト作成に失敗", "error", err)
+		h.fail(w, r, "システムエラーが発生しました。")
+		return
+	}
+	authURL, err := h.Provider.AuthCodeURL(r.Context(), ar)
+	if err != nil {
+		logger.Error("OIDC のディスカバリに失敗", "error", err)
+		h.fail(w, r, "シングルサインオンを利用できません。しばらく経ってから再度お試しください。")
+		return
+	}
+	raw, err := json.Marshal(ar)
+	if err != nil {
+		logger.Error("OIDC の認可リクエスト作成に失敗", "error", err)
+		h.fail(w, r, "システムエラーが発生しました。")
+		return
+	}
+	h.setStateCookie(w, base64.RawURLEncoding.EncodeToString(raw), oidcStateMaxAge)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback は IdP からの戻り先。state を照合してコードを交換し、ID トークンのメールアドレスで
+// ログイン可否（loginpolicy）を判定してからセッションを作る。
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	// state は 1 回限り。結果にかかわらず Cookie は消す。
+	ar, ok := h.readStateCookie(r)
+	h.setStateCookie(w, "", -1)
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		logger.Warn("OIDC の認可が拒否された", "error", e, "description", query.Get("error_description"))
+		h.fail(w, r, "シングルサインオンでのログインが完了しませんでした。")
+		return
+	}
+	state := query.Get("state")
+	if !ok || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(ar.State)) != 1 {
+		logger.Warn("OIDC の state が一致しない")
+		h.fail(w, r, "ログインの有効期限が切れました。もう一度お試しください。")
+		return
+	}
+
+	claims, err := h.Provider.Exchange(r.Context(), query.Get("code"), ar)
+	if err != nil {
+		logger.Error("OIDC のトークン検証に失敗", "error", err)
+		h.fail(w, r, "シングルサインオンでのログインに失敗しました。")
+		return
+	}
+	email := strings.TrimSpace(claims.Email)
+	if email == "" || !claims.Verified() {
+		logger.Warn("OIDC のメールアドレスが未確認", "sub", claims.Subject, "email", email)
+		h.fail(w, r, "IdP でメールアドレスが確認されていないため、ログインできません。")
+		return
+	}
+	if !h.Provider.AllowsEmail(email) {
+		logger.Warn("OIDC のメールアドレスのドメインが許可されていない", "email", email)
+		h.fail(w, r, "このメールアドレスではシングルサインオンを利用できません。")
+		return
+	}
+
+	if err := loginpolicy.AllowExternalLogin(r.Context(), h.DB, loginpolicy.ExternalIdentity{
+		Email:     email,
+		Name:      claims.Name,
+		Role:      h.Provider.RoleFor(claims),
+		Provision: h.Provider.Provisions(),
+	}); err != nil {
+		h.fail(w, r, err.Error())
+		return
+	}
+	if err := h.ML.SessionManager.Create(w, r, email); err != nil {
+		logger.Error("OIDC ログイン後のセッション作成に失敗", "error", err, "email", email)
+		h.fail(w, r, "システムエラーが発生しました。")
+		return
+	}
+	logger.Info("OIDC でログイン", "email", email)
+	http.Redirect(w, r, appconfig.LandingPath, http.StatusSeeOther)
+}
+
+// fail はログイン画面に戻してメッセージを表示する（magiclink の ErrorRedirectURL と同じ形）。
+func (h *OIDCHandler) fail(w http.ResponseWriter, r *http.Request, message string) {
+	q := url.Values{"error": {"oidc"}, "error_description": {message}}
+	http.Redirect(w, r, "/auth/login?"+q.Encode(), http.StatusSeeOther)
+}
+
+// setStateCookie は state の Cookie を書く（maxAge が負なら削除）。IdP からはトップレベルの
+// GET で戻ってくるので SameSite=Lax で届く。
+func (h *OIDCHandler) setStateCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.stateCookie(),
+		Value:    value,
+		Path:     "/auth/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   h.ML.Config.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *OIDCHandler) readStateCookie(r *http.Request) (oidc.AuthRequest, bool) {
+	var ar oidc.AuthRequest
+	c, err := r.Cookie(h.stateCookie())
+	if err != nil {
+		return ar, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil || json.Unmarshal(raw, &ar) != nil || ar.State == "" {
+		return ar, false
+	}
+	return ar, true
+}
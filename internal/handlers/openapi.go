@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appconfig"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/invitation"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/oidc"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/openapi"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/version"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+)
+
+// OpenAPIHandler は OpenAPI ドキュメント（/api/openapi.json）と、それを表示する
+// 管理者向けの API エクスプローラ（/admin/api-docs）。
+//
+// ドキュメントはルーターの登録内容から初回アクセス時に 1 度だけ組み立てる。
+type OpenAPIHandler struct {
+	Routes     chi.Routes
+	CookieName string
+
+	once sync.Once
+	spec []byte
+	err  error
+}
+
+func NewOpenAPIHandler(routes chi.Routes, cookieName string) *OpenAPIHandler {
+	return &OpenAPIHandler{Routes: routes, CookieName: cookieName}
+}
+
+// BuildOpenAPI は routes の OpenAPI ドキュメントを組み立てる。missing は apiOperations に
+// 説明の無いルート（integration テストで空であることを確かめている）。
+func BuildOpenAPI(routes chi.Routes, cookieName string) (*openapi.Document, []string, error) {
+	return openapi.Build(routes, apiOperations, openapi.Config{
+		Title:         appconfig.AppName(),
+		Version:       version.Version,
+		CookieName:    cookieName,
+		RequiredRoles: appMiddleware.RequiredRoles,
+	})
+}
+
+// Spec は OpenAPI ドキュメントを JSON で返す。
+func (h *OpenAPIHandler) Spec(w http.ResponseWriter, r *http.Request) {
+	h.once.Do(func() {
+		doc, missing, err := BuildOpenAPI(h.Routes, h.CookieName)
+		if err != nil {
+			h.err = err
+			return
+		}
+		if len(missing) > 0 {
+			logger.Warn("OpenAPI の説明が無いルートがあります", "routes", strings.Join(missing, ", "))
+		}
+		h.spec, h.err = json.Marshal(doc)
+	})
+	if h.err != nil {
+		logger.Error("OpenAPI ドキュメントの生成に失敗", "error", h.err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "API ドキュメントの生成に失敗しました")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(h.spec)
+}
+
+// ExplorerPage は API エクスプローラを表示する。admin 限定。
+func (h *OpenAPIHandler) ExplorerPage(w http.ResponseWriter, r *http.Request) {
+	renderShell(w, r, "API ドキュメント", components.AdminAPIDocs())
+}
+
+// OpenAPI ドキュメントのタグ。
+const (
+	tagPublic   = "公開"
+	tagAuth     = "認証"
+	tagProjects = "プロジェクト"
+	tagAdmin    = "管理"
+	tagProfile  = "マイページ"
+	tagAPI      = "JSON API"
+	tagRecipes  = "Datastar レシピ"
+)
+
+// apiPageQuery は JSON API の一覧で共通のクエリパラメータ。
+var apiPageQuery = []openapi.Param{
+	{Name: "limit", Description: "1 ページの件数（1〜200、既定 50）"},
+	{Name: "cursor", Description: "前のページの next_cursor"},
+}
+
+// apiOperations はルートごとの説明。キーは chi のパターン（openapi.Key）。
+// ルートを追加したらここにも足す（足し忘れは TestOpenAPI_EveryRouteDocumented が検出する）。
+// 必要なロールはルーターの RequireRole から読むので、ここには書かない。
+var apiOperations = map[string]openapi.Operation{
+	// --- 公開 ---
+	openapi.Key(openapi.AnyMethod, "/static/*"):   {Summary: "静的ファイル（CSS・JS・画像）", Public: true},
+	openapi.Key(openapi.AnyMethod, "/auth/*"):     {Summary: "マジックリンクのログイン・検証・ログアウト（nz-magic-link）", Public: true},
+	openapi.Key(openapi.AnyMethod, "/webauthn/*"): {Summary: "パスキーの登録・ログイン（nz-magic-link）", Public: true},
+	openapi.Key(http.MethodGet, "/health"): {
+		Summary: "ヘルスチェック", Tag: tagPublic, Public: true,
+		Media: openapi.MediaJSON, Result: HealthResponse{},
+	},
+	openapi.Key(http.MethodGet, "/robots.txt"): {Summary: "クロール拒否の robots.txt", Tag: tagPublic, Public: true, Media: openapi.MediaText},
+	openapi.Key(http.MethodGet, "/"):           {Summary: "ログイン画面へリダイレクト", Tag: tagPublic, Public: true, Status: http.StatusSeeOther},
+	openapi.Key(http.MethodPost, appMiddleware.CSPReportPath): {
+		Summary: "CSP の違反報告（ブラウザが送る）。ログに残す", Tag: tagPublic, Public: true,
+		Body: cspReport{}, Status: http.StatusNoContent,
+	},
+	openapi.Key(http.MethodGet, "/auth/login"): {
+		Summary: "ログイン画面", Tag: tagAuth, Public: true, Media: openapi.MediaHTML,
+		Query: []openapi.Param{{Name: "error", Description: "ログイン失敗の理由"}, {Name: "error_description", Description: "表示するエラーメッセージ"}},
+	},
+	openapi.Key(http.MethodGet, "/setup"): {
+		Summary: "初期セットアップ画面（ユーザーが 1 人もいないときだけ）", Tag: tagAuth, Public: true, Media: openapi.MediaHTML,
+		Query: []openapi.Param{{Name: "token", Description: "起動時にログへ出すワンタイムトークン（SETUP_TOKEN）"}},
+	},
+	openapi.Key(http.MethodPost, "/setup"): {Summary: "最初の管理者を作成（token は起動時にログへ出すワンタイムトークン）", Tag: tagAuth, Public: true, Body: setupRequest{}, Media: openapi.MediaJSON},
+	openapi.Key(http.MethodPost, "/setup/test-mail"): {
+		Summary: "初期セットアップ中に SMTP の設定でテストメールを送る", Tag: tagAuth, Public: true,
+		Body: setupTestMailRequest{}, Media: openapi.MediaJSON,
+	},
+	openapi.Key(http.MethodGet, invitation.AcceptPath): {
+		Summary: "招待の承認画面", Tag: tagAuth, Public: true, Media: openapi.MediaHTML,
+		Query: []openapi.Param{{Name: "token", Description: "招待メールのトークン"}},
+	},
+	openapi.Key(http.MethodPost, invitation.AcceptPath): {
+		Summary: "招待を承認してログイン", Tag: tagAuth, Public: true, Status: http.StatusSeeOther,
+		Form: []openapi.Param{{Name: "token", Description: "招待メールのトークン"}},
+	},
+	openapi.Key(http.MethodGet, oidc.LoginPath): {Summary: "OIDC の IdP へリダイレクト（OIDC_ISSUER 設定時のみ）", Tag: tagAuth, Public: true, Status: http.StatusFound},
+	openapi.Key(http.MethodGet, oidc.CallbackPath): {
+		Summary: "OIDC の IdP からの戻り先。ログインしてランディングページへ", Tag: tagAuth, Public: true, Status: http.StatusSeeOther,
+		Query: []openapi.Param{{Name: "code", Description: "認可コード"}, {Name: "state", Description: "ログイン開始時の state"}, {Name: "error", Description: "IdP が返したエラー"}},
+	},
+
+	// --- Datastar のリファレンス・レシピ（認証不要のデモ）---
+	openapi.Key(http.MethodGet, "/datastar-test"):                        {Summary: "Datastar リファレンスページ（dev 限定）", Tag: tagRecipes, Public: true, Media: openapi.MediaHTML},
+	openapi.Key(http.MethodGet, "/datastar/recipes"):                     {Summary: "Datastar レシピ集", Tag: tagRecipes, Public: true, Media: openapi.MediaHTML},
+	openapi.Key(http.MethodPost, "/datastar/recipes/api/counter"):        {Summary: "カウンターを増やす", Tag: tagRecipes, Public: true, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPost, "/datastar/recipes/api/todos"):          {Summary: "TODO を追加", Tag: tagRecipes, Public: true, Signals: recipeTodoSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/datastar/recipes/api/todos/{id}"):   {Summary: "TODO を削除", Tag: tagRecipes, Public: true, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodGet, "/datastar/recipes/api/search"):          {Summary: "ライブ検索", Tag: tagRecipes, Public: true, Signals: recipeSearchSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodGet, "/datastar/recipes/api/slow"):            {Summary: "遅い処理（インジケーターの確認用）", Tag: tagRecipes, Public: true, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodGet, "/datastar/recipes/api/tick"):            {Summary: "サーバー時刻を数回送る", Tag: tagRecipes, Public: true, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodGet, "/datastar/recipes/api/dialog"):          {Summary: "ダイアログを開く", Tag: tagRecipes, Public: true, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodGet, "/datastar/recipes/api/vrows"):           {Summary: "仮想スクロールの表示範囲の行", Tag: tagRecipes, Public: true, Signals: recipeVRowsSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodGet, "/datastar/recipes/api/items/{id}/edit"): {Summary: "インライン編集を開く", Tag: tagRecipes, Public: true, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPut, "/datastar/recipes/api/items/{id}"):      {Summary: "インライン編集を保存", Tag: tagRecipes, Public: true, Signals: recipeItemSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPost, "/datastar/recipes/api/reset"):          {Summary: "デモの状態を初期化", Tag: tagRecipes, Public: true, Media: openapi.MediaSSE},
+
+	// --- プロジェクト ---
+	openapi.Key(http.MethodGet, "/projects/"):                                 {Summary: "プロジェクト一覧", Tag: tagProjects, Media: openapi.MediaHTML},
+	openapi.Key(http.MethodGet, "/projects/{id}"):                             {Summary: "プロジェクト詳細", Tag: tagProjects, Media: openapi.MediaHTML},
+	openapi.Key(http.MethodPost, "/api/sse/projects/new"):                     {Summary: "プロジェクトを作成", Tag: tagProjects, Signals: projectSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodGet, "/api/sse/projects/{id}/edit"):                {Summary: "編集ダイアログを開く", Tag: tagProjects, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPut, "/api/sse/projects/{id}"):                     {Summary: "プロジェクトを更新", Tag: tagProjects, Signals: projectSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/projects/{id}"):                  {Summary: "プロジェクトをゴミ箱に移す", Tag: tagProjects, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPost, "/api/sse/projects/{id}/members"):            {Summary: "メンバーを追加", Tag: tagProjects, Signals: addProjectMemberSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPut, "/api/sse/projects/{id}/members/{userID}"):    {Summary: "メンバーのロールを変更", Tag: tagProjects, Signals: projectMemberRolesSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/projects/{id}/members/{userID}"): {Summary: "メンバーを外す", Tag: tagProjects, Media: openapi.MediaSSE},
+
+	// --- 管理 ---
+	openapi.Key(http.MethodGet, "/admin/users"):                 {Summary: "ユーザー管理", Tag: tagAdmin, Media: openapi.MediaHTML},
+	openapi.Key(http.MethodGet, "/admin/users/import"):          {Summary: "ユーザー一括登録の画面", Tag: tagAdmin, Media: openapi.MediaHTML},
+	openapi.Key(http.MethodGet, "/admin/users/import/template"): {Summary: "一括登録のテンプレート（Excel）", Tag: tagAdmin, Media: openapi.MediaXLSX},
+	openapi.Key(http.MethodPost, "/admin/users/import"): {
+		Summary: "Excel からユーザーを一括登録", Tag: tagAdmin, Media: openapi.MediaHTML,
+		Form: []openapi.Param{{Name: "file", Description: ".xlsx ファイル（5MB まで）", File: true}}, Multipart: true,
+	},
+	openapi.Key(http.MethodGet, "/admin/access-logs"):       {Summary: "アクセスログ", Tag: tagAdmin, Media: openapi.MediaHTML},
+	openapi.Key(http.MethodGet, "/admin/access-logs/table"): {Summary: "アクセスログの一覧を再読み込み", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodGet, "/admin/audit"):             {Summary: "監査ログ", Tag: tagAdmin, Media: openapi.MediaHTML},
+	openapi.Key(http.MethodGet, "/admin/audit/table"):       {Summary: "監査ログを絞り込み", Tag: tagAdmin, Signals: auditFilter{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodGet, "/admin/maintenance"):       {Summary: "メンテナンス設定", Tag: tagAdmin, Media: openapi.MediaHTML},
+	openapi.Key(http.MethodGet, "/admin/signup"):            {Summary: "セルフサインアップ設定", Tag: tagAdmin, Media: openapi.MediaHTML},
+	openapi.Key(http.MethodGet, "/admin/api-tokens"):        {Summary: "全ユーザーの API トークン", Tag: tagAdmin, Media: openapi.MediaHTML},
+	openapi.Key(http.MethodGet, "/admin/roles"):             {Summary: "ロール", Tag: tagAdmin, Media: openapi.MediaHTML},
+	openapi.Key(http.MethodGet, "/admin/trash"):             {Summary: "ゴミ箱", Tag: tagAdmin, Media: openapi.MediaHTML},
+	openapi.Key(http.MethodGet, "/admin/api-docs"):          {Summary: "API エクスプローラ", Tag: tagAdmin, Media: openapi.MediaHTML},
+
+	openapi.Key(http.MethodPost, "/api/sse/admin/users/create"):                 {Summary: "ユーザーを追加して招待", Tag: tagAdmin, Signals: newUserSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodGet, "/api/sse/admin/users/{id}/edit"):               {Summary: "ユーザー編集ダイアログを開く", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPut, "/api/sse/admin/users/{id}"):                    {Summary: "ユーザーを更新", Tag: tagAdmin, Signals: editUserSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/admin/users/{id}"):                 {Summary: "ユーザーをゴミ箱に移す", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/admin/users/{id}/sessions"):        {Summary: "ユーザーの全セッションを失効", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPost, "/api/sse/admin/users/{id}/invitation"):        {Summary: "招待メールを再送", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/admin/users/{id}/invitation"):      {Summary: "招待を取り消し", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPost, "/api/sse/admin/users/{id}/impersonate"):       {Summary: "このユーザーとして表示を開始", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPost, "/api/sse/admin/signup-requests/{id}/approve"): {Summary: "サインアップ申請を承認", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/admin/signup-requests/{id}"):       {Summary: "サインアップ申請を却下", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPut, "/api/sse/admin/signup"):                        {Summary: "セルフサインアップ設定を保存", Tag: tagAdmin, Signals: signupPolicySignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPost, "/api/sse/admin/maintenance/toggle"):           {Summary: "メンテナンスモードを切り替え", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPut, "/api/sse/admin/maintenance/window"):            {Summary: "予定メンテナンスを登録", Tag: tagAdmin, Signals: maintenanceWindowSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/admin/maintenance/window"):         {Summary: "予定メンテナンスを取り消し", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPost, "/api/sse/admin/maintenance/read-only/toggle"): {Summary: "読み取り専用モードを切り替え", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/admin/api-tokens/{id}"):            {Summary: "API トークンを失効（管理者）", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPost, "/api/sse/admin/roles"):                        {Summary: "カスタムロールを作成", Tag: tagAdmin, Signals: newRoleSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodGet, "/api/sse/admin/roles/{name}/edit"):             {Summary: "ロール編集ダイアログを開く", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPut, "/api/sse/admin/roles/{name}"):                  {Summary: "カスタムロールを更新", Tag: tagAdmin, Signals: editRoleSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/admin/roles/{name}"):               {Summary: "カスタムロールを削除", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPost, "/api/sse/admin/trash/projects/{id}/restore"):  {Summary: "プロジェクトをゴミ箱から戻す", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/admin/trash/projects/{id}"):        {Summary: "ゴミ箱のプロジェクトを完全に削除", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPost, "/api/sse/admin/trash/users/{id}/restore"):     {Summary: "ユーザーをゴミ箱から戻す", Tag: tagAdmin, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/admin/trash/users/{id}"):           {Summary: "ゴミ箱のユーザーを完全に削除", Tag: tagAdmin, Media: openapi.MediaSSE},
+
+	// --- マイページ ---
+	openapi.Key(http.MethodGet, "/profile"):                            {Summary: "マイページ", Tag: tagProfile, Media: openapi.MediaHTML},
+	openapi.Key(http.MethodPut, "/api/sse/profile"):                    {Summary: "プロフィールを更新", Tag: tagProfile, Signals: profileSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodGet, "/api/sse/profile/passkeys/{id}/edit"): {Summary: "パスキー名の編集ダイアログを開く", Tag: tagProfile, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPut, "/api/sse/profile/passkeys/{id}"):      {Summary: "パスキー名を変更", Tag: tagProfile, Signals: passkeySignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/profile/passkeys/{id}"):   {Summary: "パスキーを削除", Tag: tagProfile, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/profile/sessions"):        {Summary: "他の端末のセッションをすべて失効", Tag: tagProfile, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/profile/sessions/{id}"):   {Summary: "セッションを失効", Tag: tagProfile, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPost, "/api/sse/profile/api-tokens"):        {Summary: "API トークンを発行", Tag: tagProfile, Signals: apiTokenSignals{}, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodDelete, "/api/sse/profile/api-tokens/{id}"): {Summary: "API トークンを失効", Tag: tagProfile, Media: openapi.MediaSSE},
+	openapi.Key(http.MethodPost, "/api/sse/impersonation/stop"):        {Summary: "ユーザーとして表示を終了", Tag: tagProfile, Media: openapi.MediaSSE},
+
+	// --- JSON API ---
+	openapi.Key(http.MethodGet, "/api/openapi.json"): {Summary: "この OpenAPI ドキュメント", Tag: tagAPI, Media: openapi.MediaJSON},
+	openapi.Key(http.MethodGet, "/api/v1/projects"): {
+		Summary: "プロジェクト一覧（新しい順）", Tag: tagAPI, Media: openapi.MediaJSON, Result: apiList[apiProject]{},
+		Query: append([]openapi.Param{{Name: "q", Description: "名前の部分一致"}}, apiPageQuery...),
+	},
+	openapi.Key(http.MethodGet, "/api/v1/projects/{id}"):    {Summary: "プロジェクトを取得", Tag: tagAPI, Media: openapi.MediaJSON, Result: apiItem[apiProject]{}},
+	openapi.Key(http.MethodPost, "/api/v1/projects"):        {Summary: "プロジェクトを作成", Tag: tagAPI, Body: projectInput{}, Status: http.StatusCreated, Media: openapi.MediaJSON, Result: apiItem[apiProject]{}},
+	openapi.Key(http.MethodPatch, "/api/v1/projects/{id}"):  {Summary: "プロジェクトを更新", Tag: tagAPI, Body: projectInput{}, Media: openapi.MediaJSON, Result: apiItem[apiProject]{}},
+	openapi.Key(http.MethodDelete, "/api/v1/projects/{id}"): {Summary: "プロジェクトをゴミ箱に移す", Tag: tagAPI, Status: http.StatusNoContent},
+	openapi.Key(http.MethodGet, "/api/v1/users"): {
+		Summary: "ユーザー一覧（新しい順）", Tag: tagAPI, Media: openapi.MediaJSON, Result: apiList[apiUser]{},
+		Query: append([]openapi.Param{
+			{Name: "q", Description: "名前・メールアドレスの部分一致"},
+			{Name: "role", Description: "ロール"},
+			{Name: "status", Description: "active か inactive"},
+		}, apiPageQuery...),
+	},
+	openapi.Key(http.MethodGet, "/api/v1/users/{id}"):    {Summary: "ユーザーを取得", Tag: tagAPI, Media: openapi.MediaJSON, Result: apiItem[apiUser]{}},
+	openapi.Key(http.MethodPost, "/api/v1/users"):        {Summary: "ユーザーを追加して招待", Tag: tagAPI, Body: userCreateInput{}, Status: http.StatusCreated, Media: openapi.MediaJSON, Result: userCreated{}},
+	openapi.Key(http.MethodPatch, "/api/v1/users/{id}"):  {Summary: "ユーザーを更新", Tag: tagAPI, Body: userUpdateInput{}, Media: openapi.MediaJSON, Result: apiItem[apiUser]{}},
+	openapi.Key(http.MethodDelete, "/api/v1/users/{id}"): {Summary: "ユーザーをゴミ箱に移す", Tag: tagAPI, Status: http.StatusNoContent},
+}
@@ -1,23 +1,29 @@
 package handlers
 
 import (
+	"database/sql"
 	"net/http"
 
+	"github.com/naozine/nz-magic-link/magiclink"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
 	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
 )
 
 type ProfileHandler struct {
+	DB      *sql.DB
 	Queries *database.Queries
+	ML      *magiclink.MagicLink
 }
 
-func NewProfileHandler(queries *database.Queries) *ProfileHandler {
-	return &ProfileHandler{Queries: queries}
+func NewProfileHandler(db *sql.DB, queries *database.Queries, ml *magiclink.MagicLink) *ProfileHandler {
+	return &ProfileHandler{DB: db, Queries: queries, ML: ml}
 }
 
 func (h *ProfileHandler) ShowProfile(w http.ResponseWriter, r *http.Request) {
-	email, _, hasPasskey := appcontext.GetUser(r.Context())
+	email, _, _ := appcontext.GetUser(r.Context())
 
 	user, err := h.Queries.GetUserByEmail(r.Context(), email)
 	if err != nil {
@@ -25,5 +31,21 @@ func (h *ProfileHandler) ShowProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	renderShell(w, r, "マイページ", components.Profile(user, hasPasskey))
+	passkeys, err := authsession.ListPasskeys(r.Context(), h.ML, h.DB, email)
+	if err != nil {
+		logger.Error("パスキーの取得に失敗", "error", err, "email", email)
+	}
+
+	sessions, err := authsession.List(r.Context(), h.DB, email, authsession.CurrentHash(r, h.ML.Config.CookieName))
+	if err != nil {
+		// 一覧が出せなくてもプロフィール編集はできるようにする。
+		logger.Error("セッション一覧の取得に失敗", "error", err, "email", email)
+	}
+
+	tokens, err := h.Queries.ListAPITokensByUser(r.Context(), user.ID)
+	if err != nil {
+		logger.Error("API トークン一覧の取得に失敗", "error", err, "email", email)
+	}
+
+	renderShell(w, r, "マイページ", components.Profile(user, passkeys, sessions, tokens))
 }
@@ -1,21 +1,61 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appconfig"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/mailer"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
 	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
 )
 
+// MinSetupTokenLength は SETUP_TOKEN に許す最短の長さ。
+const MinSetupTokenLength = 16
+
+// maxAppNameLength はアプリ名の最大文字数。
+const maxAppNameLength = 50
+
+// errSetupDone は既にユーザーがいてセットアップできないこと。
+var errSetupDone = errors.New("setup already done")
+
+// SetupHandler は最初の管理者を作る初期セットアップ（/setup）。ユーザーが 1 人もいない間だけ
+// 使え、さらに起動時にログへ出すワンタイムトークン（Token）を知っている人だけが開ける。
 type SetupHandler struct {
+	DB      *sql.DB
 	Queries *database.Queries
+	// Mailer はセットアップ画面からのテストメールの送信に使う（招待メールと同じ SMTP 設定）。
+	Mailer mailer.Sender
+	// Token は /setup?token=... のトークン。空ならセットアップは開けない（ユーザーがいる）。
+	Token string
+}
+
+func NewSetupHandler(db *sql.DB, queries *database.Queries, sender mailer.Sender, token string) *SetupHandler {
+	return &SetupHandler{DB: db, Queries: queries, Mailer: sender, Token: token}
 }
 
-func NewSetupHandler(queries *database.Queries) *SetupHandler {
-	return &SetupHandler{Queries: queries}
+// NewSetupToken は初期セットアップのワンタイムトークンを作る（SETUP_TOKEN が無いときに起動ごとに作る）。
+func NewSetupToken() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b) // crypto/rand.Read はエラーを返さない（Go 1.24 以降）
+	return hex.EncodeToString(b)
+}
+
+func (h *SetupHandler) validToken(token string) bool {
+	return h.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.Token)) == 1
 }
 
 func (h *SetupHandler) hasUsers(r *http.Request) (bool, error) {
@@ -39,62 +79,167 @@ func (h *SetupHandler) SetupPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	renderGuest(w, r, "初期セットアップ", components.SetupForm(""))
+	token := r.URL.Query().Get("token")
+	if !h.validToken(token) {
+		httpError(w, r, http.StatusForbidden, "サーバーのログに出力されたセットアップ用の URL を開いてください")
+		return
+	}
+
+	renderGuest(w, r, "初期セットアップ", components.SetupForm(token, appconfig.AppName()))
+}
+
+// setupRequest は初期セットアップで送る JSON。
+type setupRequest struct {
+	Token   string `json:"token"`
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	AppName string `json:"app_name"`
 }
 
 func (h *SetupHandler) CreateInitialAdmin(w http.ResponseWriter, r *http.Request) {
-	hasUsers, err := h.hasUsers(r)
+	var req setupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	if !h.validToken(req.Token) {
+		jsonError(w, http.StatusForbidden, "セットアップ用のトークンが正しくありません")
+		return
+	}
+
+	email, err := setupEmail(req.Email)
 	if err != nil {
-		logger.Error("Failed to count users", "error", err)
-		jsonError(w, http.StatusInternalServerError, "Database error")
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Admin"
+	}
+	appName := strings.TrimSpace(req.AppName)
+	if appName == "" {
+		appName = appconfig.AppName()
+	}
+	if utf8.RuneCountInString(appName) > maxAppNameLength {
+		jsonError(w, http.StatusBadRequest, "アプリ名は "+strconv.Itoa(maxAppNameLength)+" 文字以内で入力してください")
 		return
 	}
 
-	if hasUsers {
+	// 数えてから作る間に別のリクエストが割り込まないよう、ユーザーがいないことの確認と作成は
+	// CreateFirstUser の 1 文で行う。
+	err = dbtx.Run(r.Context(), h.DB, h.Queries, func(qtx *database.Queries) error {
+		user, err := qtx.CreateFirstUser(r.Context(), database.CreateFirstUserParams{
+			Email:    email,
+			Name:     name,
+			Role:     roles.Admin,
+			IsActive: true,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			return errSetupDone
+		}
+		if err != nil {
+			return err
+		}
+		if err := appconfig.SaveAppName(r.Context(), qtx, appName); err != nil {
+			return err
+		}
+		return audit.Record(r.Context(), qtx, audit.Entry{
+			ActorEmail: user.Email,
+			Action:     audit.ActionUserSetup,
+			TargetType: audit.TargetUser,
+			TargetID:   strconv.FormatInt(user.ID, 10),
+			After:      user,
+		})
+	})
+	if errors.Is(err, errSetupDone) {
 		jsonError(w, http.StatusForbidden, "セットアップは既に完了しています")
 		return
 	}
-
-	var req struct {
-		Email string `json:"email"`
-		Name  string `json:"name"`
+	if err != nil {
+		logger.Error("Failed to create admin user", "error", err, "email", email)
+		jsonError(w, http.StatusInternalServerError, "ユーザーの作成に失敗しました")
+		return
 	}
+	appconfig.SetAppName(appName)
+
+	logger.Info("Initial admin user created", "email", email)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"email":   email,
+		"message": "管理者ユーザーを作成しました。パスキーを登録してください。",
+	})
+}
+
+// setupTestMailRequest はセットアップ画面のテストメールで送る JSON。
+type setupTestMailRequest struct {
+	Token string `json:"token"`
+	Email string `json:"email"`
+}
+
+// SendTestMail はセットアップを終える前に、SMTP の設定でメールが届くかを確かめる。
+// ログインのメールが届かないと、パスキーを無くしたときに管理者がログインできなくなる。
+func (h *SetupHandler) SendTestMail(w http.ResponseWriter, r *http.Request) {
+	var req setupTestMailRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, http.StatusBadRequest, "Invalid request")
 		return
 	}
-
-	if req.Email == "" {
-		jsonError(w, http.StatusBadRequest, "メールアドレスは必須です")
+	if !h.validToken(req.Token) {
+		jsonError(w, http.StatusForbidden, "セットアップ用のトークンが正しくありません")
 		return
 	}
-
-	if req.Name == "" {
-		req.Name = "Admin"
+	hasUsers, err := h.hasUsers(r)
+	if err != nil {
+		logger.Error("Failed to count users", "error", err)
+		jsonError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if hasUsers {
+		jsonError(w, http.StatusForbidden, "セットアップは既に完了しています")
+		return
+	}
+	email, err := setupEmail(req.Email)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	_, err = h.Queries.CreateUser(r.Context(), database.CreateUserParams{
-		Email:    req.Email,
-		Name:     req.Name,
-		Role:     roles.Admin,
-		IsActive: true,
+	err = h.Mailer.Send(r.Context(), mailer.Message{
+		To:      email,
+		Subject: appconfig.AppName() + " のテストメール",
+		Body:    "初期セットアップの画面から送ったテストメールです。\nこのメールが届いていれば、ログイン用のメールも届きます。\n",
 	})
 	if err != nil {
-		logger.Error("Failed to create admin user", "error", err, "email", req.Email)
-		jsonError(w, http.StatusInternalServerError, "ユーザーの作成に失敗しました")
+		logger.Warn("Failed to send setup test mail", "error", err, "email", email)
+		jsonError(w, http.StatusBadGateway, "テストメールを送信できませんでした（"+err.Error()+"）。SMTP_* の設定を確認してください")
 		return
 	}
-
-	logger.Info("Initial admin user created", "email", req.Email)
-
+	message := "テストメールを送信しました。届いたか確認してください。"
+	if _, ok := h.Mailer.(mailer.LogSender); ok {
+		message = "SMTP_HOST が未設定のため、送信せずサーバーのログに出力しました。"
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
-		"email":   req.Email,
-		"message": "管理者ユーザーを作成しました。パスキーを登録してください。",
+		"message": message,
 	})
 }
 
+// setupEmail は前後の空白を除いたメールアドレスを返す。空や形式の誤り（"名前 <addr>" の形も
+// 含む）はエラー。
+func setupEmail(s string) (string, error) {
+	email := strings.TrimSpace(s)
+	if email == "" {
+		return "", inputError("メールアドレスは必須です")
+	}
+	if a, err := mail.ParseAddress(email); err != nil || a.Address != email {
+		return "", inputError("メールアドレスの形式が正しくありません")
+	}
+	return email, nil
+}
+
 // jsonError はJSONエラーレスポンスを返す。
 func jsonError(w http.ResponseWriter, code int, message string) {
 	w.Header().Set("Content-Type", "application/json")
@@ -2,86 +2,112 @@ package handlers
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/naozine/nz-magic-link/magiclink"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/invitation"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/signup"
 	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
 	"github.com/starfederation/datastar-go/datastar"
 )
 
 type AdminSSEHandler struct {
+	DB      *sql.DB
 	Queries *database.Queries
+	ML      *magiclink.MagicLink
+	Inviter *invitation.Inviter
 }
 
-func NewAdminSSEHandler(queries *database.Queries) *AdminSSEHandler {
-	return &AdminSSEHandler{Queries: queries}
+func NewAdminSSEHandler(db *sql.DB, queries *database.Queries, ml *magiclink.MagicLink, inviter *invitation.Inviter) *AdminSSEHandler {
+	return &AdminSSEHandler{DB: db, Queries: queries, ML: ml, Inviter: inviter}
+}
+
+// newUserSignals はユーザー追加ダイアログの signals。
+type newUserSignals struct {
+	NewName  string `json:"newName"`
+	NewEmail string `json:"newEmail"`
+	NewRole  string `json:"newRole"`
 }
 
 func (h *AdminSSEHandler) CreateUserDialogSSE(w http.ResponseWriter, r *http.Request) {
-	var signals struct {
-		NewName  string `json:"newName"`
-		NewEmail string `json:"newEmail"`
-		NewRole  string `json:"newRole"`
-	}
+	var signals newUserSignals
 	if !readSignalsOr413(w, r, &signals) {
 		return
 	}
 
-	if _, err := h.createUser(r.Context(), signals.NewName, signals.NewEmail, signals.NewRole); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	pending, err := createInvitedUser(r.Context(), h.DB, h.Queries, signals.NewName, signals.NewEmail, signals.NewRole)
+	if msg, ok := inputErrorMessage(err); ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Error("ユーザー作成に失敗", "error", err, "email", signals.NewEmail)
+		http.Error(w, "ユーザーの作成に失敗しました", http.StatusInternalServerError)
 		return
 	}
 
+	// 招待メールはコミット後に送る。送れなくてもユーザーは招待中として残り、一覧から再送できる。
+	toast := "ユーザーを招待しました"
+	if err := h.Inviter.Send(r.Context(), h.Queries, pending); err != nil {
+		logger.Error("招待メールの送信に失敗", "error", err, "email", pending.User.Email)
+		toast = "ユーザーを追加しましたが、招待メールを送信できませんでした。招待中の一覧から再送してください"
+	}
+
 	sse := newSSE(w, r)
 	// 一覧コンテナを再描画する（テーブル/カードの2系統を同期、reload しない）。
 	if err := h.patchUserList(r.Context(), sse); err != nil {
 		logger.Error("SSE PatchElementTempl failed", "error", err)
 		return
 	}
-	sse.ExecuteScript("document.getElementById('user-add-dialog')?.close()")
-	sendToast(sse, "ユーザーを追加しました")
+	runScript(sse, "document.getElementById('user-add-dialog')?.close()")
+	sendToast(sse, toast)
 }
 
 // patchUserList は一覧コンテナ #users-list を最新の全ユーザーで inner 置換する。
 // レスポンシブ（デスクトップ=テーブル / モバイル=カード）の2系統を同期させるため、
-// 行単位ではなくコンテナごとまとめて差し替える。
+// 行単位ではなくコンテナごとまとめて差し替える。ユーザーの追加・有効化・削除で
+// 招待中の一覧も変わるため、#pending-invitations も合わせて差し替える。
 func (h *AdminSSEHandler) patchUserList(ctx context.Context, sse *datastar.ServerSentEventGenerator) error {
 	users, err := h.Queries.ListUsers(ctx)
 	if err != nil {
 		return err
 	}
-	return sse.PatchElementTempl(
+	if err := sse.PatchElementTempl(
 		components.AdminUsersListBody(users),
 		datastar.WithSelectorID("users-list"),
 		datastar.WithModeInner(),
 		datastar.WithViewTransitions(),
-	)
-}
-
-func (h *AdminSSEHandler) createUser(ctx context.Context, name, email, role string) (database.User, error) {
-	if name == "" || email == "" || role == "" {
-		return database.User{}, http.ErrAbortHandler
-	}
-
-	if !roles.IsValid(role) {
-		return database.User{}, http.ErrAbortHandler
+	); err != nil {
+		return err
 	}
+	return h.patchInvitations(ctx, sse)
+}
 
-	user, err := h.Queries.CreateUser(ctx, database.CreateUserParams{
-		Email:    email,
-		Name:     name,
-		Role:     role,
-		IsActive: true,
-	})
+// patchInvitations は招待中の一覧 #pending-invitations を差し替える。
+func (h *AdminSSEHandler) patchInvitations(ctx context.Context, sse *datastar.ServerSentEventGenerator) error {
+	invitations, err := h.Queries.ListPendingInvitations(ctx)
 	if err != nil {
-		logger.Error("ユーザー作成に失敗", "error", err, "email", email)
-		return database.User{}, err
+		return err
 	}
-
-	return user, nil
+	return sse.PatchElementTempl(
+		components.AdminPendingInvitations(invitations),
+		datastar.WithSelectorID("pending-invitations"),
+		datastar.WithModeOuter(),
+	)
 }
 
 func (h *AdminSSEHandler) EditUserDialogSSE(w http.ResponseWriter, r *http.Request) {
@@ -95,45 +121,65 @@ func (h *AdminSSEHandler) EditUserDialogSSE(w http.ResponseWriter, r *http.Reque
 		http.Error(w, "ユーザーが見つかりません", http.StatusNotFound)
 		return
 	}
+	sessions, err := authsession.List(r.Context(), h.DB, user.Email, "")
+	if err != nil {
+		logger.Error("セッション一覧の取得に失敗", "error", err, "email", user.Email)
+	}
 
 	sse := newSSE(w, r)
 	if err := sse.PatchElementTempl(
-		components.AdminUserEditDialog(user),
+		components.AdminUserEditDialog(user, len(sessions)),
 		datastar.WithSelectorID("dialog-container"),
 		datastar.WithModeInner(),
 	); err != nil {
 		logger.Error("SSE PatchElementTempl failed", "error", err)
 		return
 	}
-	if err := sse.ExecuteScript("document.getElementById('user-edit-dialog')?.showModal();document.activeElement?.blur()"); err != nil {
-		logger.Error("SSE ExecuteScript failed", "error", err)
+	if err := runScript(sse, "document.getElementById('user-edit-dialog')?.showModal();document.activeElement?.blur()"); err != nil {
+		logger.Error("SSE runScript failed", "error", err)
 		return
 	}
 }
 
+// editUserSignals はユーザー編集ダイアログの signals。
+type editUserSignals struct {
+	EditName   string `json:"editName"`
+	EditRole   string `json:"editRole"`
+	EditStatus string `json:"editStatus"`
+}
+
 func (h *AdminSSEHandler) UpdateUserSSE(w http.ResponseWriter, r *http.Request) {
 	id, ok := parseIDOr400(w, r, "id")
 	if !ok {
 		return
 	}
 
-	var signals struct {
-		EditName   string `json:"editName"`
-		EditRole   string `json:"editRole"`
-		EditStatus string `json:"editStatus"`
-	}
+	var signals editUserSignals
 	if !readSignalsOr413(w, r, &signals) {
 		return
 	}
+	if !appMiddleware.RecentlyAuthenticated(r.Context()) && promotesToAdmin(r.Context(), h.Queries, id, signals.EditRole) {
+		appMiddleware.RejectStepUp(w, r, http.HandlerFunc(StepUpRequiredSSE))
+		return
+	}
 
-	isActive := signals.EditStatus == "active"
-
-	if _, err := h.Queries.UpdateUser(r.Context(), database.UpdateUserParams{
-		Name:     signals.EditName,
-		Role:     signals.EditRole,
-		IsActive: isActive,
-		ID:       id,
-	}); err != nil {
+	_, err := updateUser(r.Context(), h.DB, h.Queries, id, func(p *database.UpdateUserParams) {
+		p.Name = signals.EditName
+		p.Role = signals.EditRole
+		p.IsActive = signals.EditStatus == "active"
+	})
+	if lastAdminToast(w, r, err) {
+		return
+	}
+	if msg, ok := inputErrorMessage(err); ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "ユーザーが見つかりません", http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		logger.Error("ユーザー更新に失敗", "error", err, "id", id)
 		http.Error(w, "ユーザーの更新に失敗しました", http.StatusInternalServerError)
 		return
@@ -145,23 +191,45 @@ func (h *AdminSSEHandler) UpdateUserSSE(w http.ResponseWriter, r *http.Request)
 		logger.Error("SSE PatchElementTempl failed", "error", err)
 		return
 	}
-	sse.ExecuteScript("document.getElementById('user-edit-dialog')?.close()")
+	runScript(sse, "document.getElementById('user-edit-dialog')?.close()")
 	sendToast(sse, "ユーザーを更新しました")
 }
 
+// lastAdminToast は err が errLastAdmin なら理由をトーストで返し、true を返す（呼び出し元は return すること）。
+// 400 だと画面には汎用のエラーしか出ないため、SSE のハンドラはこれで理由を伝える。
+func lastAdminToast(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !errors.Is(err, errLastAdmin) {
+		return false
+	}
+	sendToast(newSSE(w, r), string(errLastAdmin))
+	return true
+}
+
+// promotesToAdmin は id のユーザーのロールを、管理者相当（ユーザー管理の権限を持つ）のロールへ
+// 変える変更かを返す（本人確認を求めるかの判断に使う。ユーザーが無ければ false）。
+func promotesToAdmin(ctx context.Context, q *database.Queries, id int64, role string) bool {
+	before, err := q.GetUserByID(ctx, id)
+	if err != nil {
+		return false
+	}
+	return before.Role != role && roles.IsAdmin(role)
+}
+
 func (h *AdminSSEHandler) DeleteUserSSE(w http.ResponseWriter, r *http.Request) {
 	id, ok := parseIDOr400(w, r, "id")
 	if !ok {
 		return
 	}
 
-	currentUserID := appcontext.GetUserID(r.Context())
-	if id == currentUserID {
-		http.Error(w, "自分自身を削除することはできません", http.StatusBadRequest)
+	err := deleteUser(r.Context(), h.DB, h.Queries, id)
+	if lastAdminToast(w, r, err) {
 		return
 	}
-
-	if err := h.Queries.DeleteUser(r.Context(), id); err != nil {
+	if msg, ok := inputErrorMessage(err); ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
 		logger.Error("ユーザー削除に失敗", "error", err, "id", id)
 		http.Error(w, "ユーザーの削除に失敗しました", http.StatusInternalServerError)
 		return
@@ -172,5 +240,427 @@ func (h *AdminSSEHandler) DeleteUserSSE(w http.ResponseWriter, r *http.Request)
 	if err := h.patchUserList(r.Context(), sse); err != nil {
 		logger.Error("SSE PatchElementTempl failed", "error", err)
 	}
-	sendToast(sse, "ユーザーを削除しました")
+	sendToast(sse, "ユーザーをゴミ箱に移しました")
+}
+
+// RevokeUserSessionsSSE は指定ユーザーの全セッションを終了させる（全端末からログアウト）。
+// 自分自身はプロフィール画面の「他の端末をすべてログアウト」を使う。
+func (h *AdminSSEHandler) RevokeUserSessionsSSE(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDOr400(w, r, "id")
+	if !ok {
+		return
+	}
+	if id == appcontext.GetUserID(r.Context()) {
+		http.Error(w, "自分自身のセッションはマイページから終了してください", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Queries.GetUserByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "ユーザーが見つかりません", http.StatusNotFound)
+		return
+	}
+	n, err := authsession.RevokeAll(r.Context(), h.DB, user.Email)
+	if err != nil {
+		logger.Error("セッションの終了に失敗", "error", err, "email", user.Email)
+		http.Error(w, "セッションの終了に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("管理者がユーザーの全セッションを終了", "email", user.Email, "sessions", n)
+
+	sse := newSSE(w, r)
+	if err := sse.PatchElementTempl(
+		components.AdminUserSessions(user.ID, 0),
+		datastar.WithSelectorID("user-edit-sessions"),
+		datastar.WithModeOuter(),
+	); err != nil {
+		logger.Error("SSE PatchElementTempl failed", "error", err)
+	}
+	sendToast(sse, fmt.Sprintf("%d 件のセッションを終了しました", n))
 }
+
+// ResendInvitationSSE は招待中のユーザーに招待メールを送り直す。
+// トークンを作り直して期限を延ばすため、以前のメールのリンクは使えなくなる。
+func (h *AdminSSEHandler) ResendInvitationSSE(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDOr400(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	invitedBy, _, _ := appcontext.GetUser(ctx)
+	var pending invitation.Pending
+	err := dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		user, err := qtx.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if user.IsActive {
+			return errUserAlreadyActive
+		}
+		pending, err = invitation.Issue(ctx, qtx, user, invitedBy)
+		if err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionInvitationResend, audit.TargetUser, id)
+		entry.After = map[string]any{"email": user.Email, "expires_at": pending.Invitation.ExpiresAt}
+		return audit.Record(ctx, qtx, entry)
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "ユーザーが見つかりません", http.StatusNotFound)
+		return
+	case errors.Is(err, errUserAlreadyActive):
+		http.Error(w, "このユーザーは既に有効です", http.StatusBadRequest)
+		return
+	case err != nil:
+		logger.Error("招待の再発行に失敗", "error", err, "id", id)
+		http.Error(w, "招待の再発行に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.Inviter.Send(ctx, h.Queries, pending); err != nil {
+		logger.Error("招待メールの送信に失敗", "error", err, "email", pending.User.Email)
+		http.Error(w, "招待メールの送信に失敗しました", http.StatusBadGateway)
+		return
+	}
+
+	sse := newSSE(w, r)
+	if err := h.patchInvitations(ctx, sse); err != nil {
+		logger.Error("SSE PatchElementTempl failed", "error", err)
+	}
+	sendToast(sse, "招待メールを再送しました")
+}
+
+// RevokeInvitationSSE は招待を取り消す。ユーザーは無効のまま残る（不要なら削除する）。
+func (h *AdminSSEHandler) RevokeInvitationSSE(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDOr400(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	err := dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		user, err := qtx.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		revoked, err := invitation.Revoke(ctx, qtx, id)
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return sql.ErrNoRows
+		}
+		entry := audit.FromContext(ctx, audit.ActionInvitationRevoke, audit.TargetUser, id)
+		entry.Before = map[string]any{"email": user.Email}
+		return audit.Record(ctx, qtx, entry)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "招待が見つかりません", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("招待の取り消しに失敗", "error", err, "id", id)
+		http.Error(w, "招待の取り消しに失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	sse := newSSE(w, r)
+	if err := h.patchInvitations(ctx, sse); err != nil {
+		logger.Error("SSE PatchElementTempl failed", "error", err)
+	}
+	sendToast(sse, "招待を取り消しました")
+}
+
+// ApproveSignupSSE はセルフサインアップの申請を承認し、サインアップ設定のロールで
+// 有効なユーザーを作る。申請者は次回からそのままログインできる。
+func (h *AdminSSEHandler) ApproveSignupSSE(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDOr400(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	err := dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		policy := signup.Load(ctx, qtx)
+		user, err := signup.Approve(ctx, qtx, id, policy.DefaultRole)
+		if err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionSignupApprove, audit.TargetUser, user.ID)
+		entry.After = user
+		return audit.Record(ctx, qtx, entry)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "申請が見つかりません", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("サインアップ申請の承認に失敗", "error", err, "id", id)
+		http.Error(w, "申請の承認に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	sse := newSSE(w, r)
+	if err := h.patchSignupRequests(ctx, sse); err != nil {
+		logger.Error("SSE PatchElementTempl failed", "error", err)
+		return
+	}
+	if err := h.patchUserList(ctx, sse); err != nil {
+		logger.Error("SSE PatchElementTempl failed", "error", err)
+		return
+	}
+	sendToast(sse, "申請を承認しました")
+}
+
+// RejectSignupSSE はセルフサインアップの申請を却下する（申請を削除する）。
+// 却下されたアドレスは、ポリシーが許す限り再度申請できる。
+func (h *AdminSSEHandler) RejectSignupSSE(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDOr400(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	err := dbtx.Run(ctx, h.DB, h.Queries, func(qtx *database.Queries) error {
+		req, err := signup.Reject(ctx, qtx, id)
+		if err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionSignupReject, audit.TargetSignupRequest, id)
+		entry.Before = req
+		return audit.Record(ctx, qtx, entry)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "申請が見つかりません", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("サインアップ申請の却下に失敗", "error", err, "id", id)
+		http.Error(w, "申請の却下に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	sse := newSSE(w, r)
+	if err := h.patchSignupRequests(ctx, sse); err != nil {
+		logger.Error("SSE PatchElementTempl failed", "error", err)
+	}
+	sendToast(sse, "申請を却下しました")
+}
+
+// patchSignupRequests はサインアップの承認待ち一覧 #signup-requests を差し替える。
+func (h *AdminSSEHandler) patchSignupRequests(ctx context.Context, sse *datastar.ServerSentEventGenerator) error {
+	requests, err := h.Queries.ListSignupRequests(ctx)
+	if err != nil {
+		return err
+	}
+	return sse.PatchElementTempl(
+		components.AdminSignupRequests(requests),
+		datastar.WithSelectorID("signup-requests"),
+		datastar.WithModeOuter(),
+	)
+}
+
+// validateUserFields はユーザー名とロールを検証し、前後の空白を除いた名前を返す。
+// 管理画面（SSE）と JSON API（/api/v1）で同じ検証を使う。
+func validateUserFields(name, role string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", inputError("名前は必須です")
+	}
+	if !roles.IsValid(role) {
+		return "", inputError("ロールの指定が不正です")
+	}
+	return name, nil
+}
+
+// errRoleNotGrantable は操作者の持たない権限を含むロールの割り当て・変更を断る（canGrantRole を参照）。
+const errRoleNotGrantable = inputError("自分の持たない権限を含むロールは割り当て・変更できません")
+
+// errOwnRole は自分自身のロールの変更を断る。
+const errOwnRole = inputError("自分自身のロールは変更できません")
+
+// canGrantRole は ctx の操作者が role を扱えるか（role の権限がすべて操作者のロールにもあるか）を返す。
+// user.manage だけを持つカスタムロールが、自分や他人を admin にして権限を広げないようにする。
+func canGrantRole(ctx context.Context, role string) bool {
+	own := roles.PermissionsOf(appcontext.GetUserRole(ctx))
+	for _, p := range roles.PermissionsOf(role) {
+		if !slices.Contains(own, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// createInvitedUser は招待中（is_active=0）のユーザーと招待を作る。メールの送信は呼び出し元が
+// コミット後に行う（Inviter.Send）。
+func createInvitedUser(ctx context.Context, db *sql.DB, q *database.Queries, name, email, role string) (invitation.Pending, error) {
+	name, err := validateUserFields(name, role)
+	if err != nil {
+		return invitation.Pending{}, err
+	}
+	if !canGrantRole(ctx, role) {
+		return invitation.Pending{}, errRoleNotGrantable
+	}
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return invitation.Pending{}, inputError("メールアドレスは必須です")
+	}
+
+	invitedBy, _, _ := appcontext.GetUser(ctx)
+	var pending invitation.Pending
+	err = dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		if _, err := qtx.GetUserByEmail(ctx, email); err == nil {
+			return errEmailTaken
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if _, err := qtx.GetTrashedUserByEmail(ctx, email); err == nil {
+			return errEmailInTrash
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		user, err := qtx.CreateUser(ctx, database.CreateUserParams{
+			Email:    email,
+			Name:     name,
+			Role:     role,
+			IsActive: false,
+		})
+		if err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionUserCreate, audit.TargetUser, user.ID)
+		entry.After = user
+		if err := audit.Record(ctx, qtx, entry); err != nil {
+			return err
+		}
+		pending, err = invitation.Issue(ctx, qtx, user, invitedBy)
+		return err
+	})
+	return pending, err
+}
+
+// updateUser は現在の値に change を適用して保存し、変更前後を監査ログに残す。
+// 該当するユーザーが無ければ sql.ErrNoRows、有効な管理者がいなくなるなら errLastAdmin を返す。
+// ロール・有効 / 無効を変えるときは、変更前後のロールとも操作者が扱えること（canGrantRole）。
+// 自分自身のロールは変えられない。保存したらユーザーのキャッシュを捨てる。
+func updateUser(ctx context.Context, db *sql.DB, q *database.Queries, id int64, change func(*database.UpdateUserParams)) (database.User, error) {
+	var user database.User
+	err := dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		before, err := qtx.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		p := database.UpdateUserParams{Name: before.Name, Role: before.Role, IsActive: before.IsActive, ID: id}
+		change(&p)
+		if p.Name, err = validateUserFields(p.Name, p.Role); err != nil {
+			return err
+		}
+		if p.Role != before.Role && id == appcontext.GetUserID(ctx) {
+			return errOwnRole
+		}
+		if (p.Role != before.Role || p.IsActive != before.IsActive) &&
+			(!canGrantRole(ctx, before.Role) || !canGrantRole(ctx, p.Role)) {
+			return errRoleNotGrantable
+		}
+		user, err = qtx.UpdateUser(ctx, p)
+		if err != nil {
+			return err
+		}
+		if isActiveAdmin(before) && !isActiveAdmin(user) {
+			if err := ensureAdminRemains(ctx, qtx, nil); err != nil {
+				return err
+			}
+		}
+		entry := audit.FromContext(ctx, audit.ActionUserUpdate, audit.TargetUser, id)
+		entry.Before, entry.After = before, user
+		return audit.Record(ctx, qtx, entry)
+	})
+	if err == nil {
+		// ロール・有効 / 無効の変更を、ログイン中のセッションにも次のリクエストから効かせる。
+		authsession.ForgetUser(user.Email)
+	}
+	return user, err
+}
+
+// deleteUser はユーザーをゴミ箱に移して監査ログを残し、コミット後にセッションを消す。
+// パスキーはゴミ箱から戻したときのために残し、完全に削除するとき（purgeTrashedUser・
+// trash.PurgeExpired）に消す。ゴミ箱から戻したユーザーはログインし直す。メールアドレスはゴミ箱の中でも使用中のまま
+// （完全に削除するまで同じアドレスで登録できない）。
+// 自分自身と最後の有効な管理者は削除できない。既に削除済みなら何もしない（DELETE は冪等に扱う）。
+func deleteUser(ctx context.Context, db *sql.DB, q *database.Queries, id int64) error {
+	if id == appcontext.GetUserID(ctx) {
+		return inputError("自分自身を削除することはできません")
+	}
+
+	var deletedEmail string
+	err := dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		before, err := qtx.GetUserByID(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			// 変更が無いので監査ログも書かない。
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := qtx.TrashUser(ctx, database.TrashUserParams{
+			DeletedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+			ID:        id,
+		}); err != nil {
+			return err
+		}
+		if isActiveAdmin(before) {
+			if err := ensureAdminRemains(ctx, qtx, nil); err != nil {
+				return err
+			}
+		}
+		entry := audit.FromContext(ctx, audit.ActionUserDelete, audit.TargetUser, id)
+		entry.Before = before
+		if err := audit.Record(ctx, qtx, entry); err != nil {
+			return err
+		}
+		deletedEmail = before.Email
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// セッションはコミット後に消す（sessions は magiclink も別接続で書くため、
+	// トランザクション中に消すと書き込みロック待ちになる）。失敗しても
+	// UserContextMiddleware が削除済みユーザーのセッションを拒否するので、ログだけ残す。
+	if deletedEmail != "" {
+		if _, err := authsession.RevokeAll(ctx, db, deletedEmail); err != nil {
+			logger.Error("削除ユーザーのセッションの削除に失敗", "error", err, "email", deletedEmail)
+		}
+	}
+	return nil
+}
+
+// errUserAlreadyActive は招待の再送対象が既に有効なユーザーだったことを表す。
+var errUserAlreadyActive = errors.New("user is already active")
+
+// errLastAdmin は有効な管理者が 1 人もいなくなる変更を断る（roles.ErrNoAdminLeft を参照）。
+const errLastAdmin = inputError("有効な管理者が 1 人もいなくなるため変更できません")
+
+// isActiveAdmin は u が有効な管理者相当のユーザーかを返す。
+func isActiveAdmin(u database.User) bool {
+	return u.IsActive && roles.IsAdmin(u.Role)
+}
+
+// ensureAdminRemains は roles.EnsureAdminRemains を呼び、管理者がいなくなるなら errLastAdmin を返す。
+// 変更を書いた後、同じトランザクションの中で呼ぶこと。
+func ensureAdminRemains(ctx context.Context, qtx *database.Queries, isAdmin func(role string) bool) error {
+	err := roles.EnsureAdminRemains(ctx, qtx, isAdmin)
+	if errors.Is(err, roles.ErrNoAdminLeft) {
+		return errLastAdmin
+	}
+	return err
+}
+
+// errEmailTaken は追加しようとしたメールアドレスが登録済みであることを表す（JSON API では 409）。
+const errEmailTaken = inputError("このメールアドレスは既に登録されています")
+
+// errEmailInTrash はメールアドレスがゴミ箱の中のユーザーのものであることを表す（JSON API では 409）。
+const errEmailInTrash = inputError("このメールアドレスのユーザーはゴミ箱にあります。ゴミ箱から戻すか、完全に削除してから追加してください")
@@ -3,6 +3,7 @@ package handlers
 import (
 	"errors"
 	"fmt"
+	"html"
 	"net/http"
 	"strconv"
 	"time"
@@ -46,10 +47,9 @@ func readSignalsOr413(w http.ResponseWriter, r *http.Request, signals any) bool
 	return true
 }
 
-// sendToast は #toast-container に通知を append し、数秒後に自動で取り除く。
+// sendToast は #toast-container に通知を append する（数秒後に components.Toast 自身が取り除く）。
 // patch 化により reload しなくなった操作の成功フィードバックに使う。
-// 表示は View Transition（既定クロスフェード）でフェードイン、削除も
-// startViewTransition でフェードアウトする（非対応ブラウザは即時）。
+// 表示は View Transition（既定クロスフェード）でフェードインする。
 func sendToast(sse *datastar.ServerSentEventGenerator, message string) {
 	id := fmt.Sprintf("toast-%d", time.Now().UnixNano())
 	_ = sse.PatchElementTempl(
@@ -58,5 +58,35 @@ func sendToast(sse *datastar.ServerSentEventGenerator, message string) {
 		datastar.WithModeAppend(),
 		datastar.WithViewTransitions(),
 	)
-	_ = sse.ExecuteScript(fmt.Sprintf("setTimeout(function(){var e=document.getElementById('%s');if(!e)return;document.startViewTransition?document.startViewTransition(function(){e.remove()}):e.remove()},3000)", id))
+}
+
+// runScript はブラウザで js を 1 回実行する（sse.ExecuteScript の代わり）。ExecuteScript は
+// <script> を patch するが、CSP（middleware.CSP）の nonce を持たないため実行されない。
+// 代わりに Datastar の data-init を持つ要素を body に足し、実行したら取り除く。
+func runScript(sse *datastar.ServerSentEventGenerator, js string) error {
+	return sse.PatchElements(
+		`<div hidden data-init="`+html.EscapeString(js)+`; el.remove()"></div>`,
+		datastar.WithSelector("body"),
+		datastar.WithModeAppend(),
+	)
+}
+
+// redirectSSE は url へ遷移させる（sse.Redirect の代わり。ExecuteScript を使うため、runScript を参照）。
+func redirectSSE(sse *datastar.ServerSentEventGenerator, url string) error {
+	return runScript(sse, fmt.Sprintf("window.location.href = %q", url))
+}
+
+// inputError は利用者の入力の誤りを表すエラー。メッセージはそのまま利用者に返す（400）。
+// 検証を SSE と JSON API で共有し、それぞれの形式でエラーを返すために使う。
+type inputError string
+
+func (e inputError) Error() string { return string(e) }
+
+// inputErrorMessage は err が inputError なら、そのメッセージと true を返す。
+func inputErrorMessage(err error) (string, bool) {
+	var ie inputError
+	if errors.As(err, &ie) {
+		return string(ie), true
+	}
+	return "", false
 }
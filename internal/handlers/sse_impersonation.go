@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// ImpersonationSSEHandler は「このユーザーとして表示」の開始と終了。状態はセッション Cookie の
+// ハッシュごとに impersonations に保存し、UserContextMiddleware がリクエストごとに読む。
+type ImpersonationSSEHandler struct {
+	DB         *sql.DB
+	Queries    *database.Queries
+	CookieName string
+}
+
+func NewImpersonationSSEHandler(db *sql.DB, queries *database.Queries, cookieName string) *ImpersonationSSEHandler {
+	return &ImpersonationSSEHandler{DB: db, Queries: queries, CookieName: cookieName}
+}
+
+// StartSSE はログイン中の管理者のセッションを id のユーザーとして表示する状態にし、
+// プロジェクト一覧へ移る。
+func (h *ImpersonationSSEHandler) StartSSE(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDOr400(w, r, "id")
+	if !ok {
+		return
+	}
+	hash := authsession.CurrentHash(r, h.CookieName)
+	if hash == "" {
+		http.Error(w, "ログイン中のセッションがありません", http.StatusBadRequest)
+		return
+	}
+
+	target, err := startImpersonation(r.Context(), h.DB, h.Queries, hash, id)
+	if msg, ok := inputErrorMessage(err); ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "ユーザーが見つかりません", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("ユーザーとして表示の開始に失敗", "error", err, "user_id", id)
+		http.Error(w, "ユーザーとして表示できませんでした", http.StatusInternalServerError)
+		return
+	}
+	email, _, _ := appcontext.GetUser(r.Context())
+	logger.Info("ユーザーとして表示を開始", "email", email, "target", target.Email)
+
+	sse := newSSE(w, r)
+	_ = redirectSSE(sse, "/projects")
+}
+
+// StopSSE は「このユーザーとして表示」を終え、管理者の画面に戻る。表示中でなければ何もしない。
+// 表示中のユーザーの権限で動くため、ルートは権限で絞らない。
+func (h *ImpersonationSSEHandler) StopSSE(w http.ResponseWriter, r *http.Request) {
+	admin, ok := appcontext.GetImpersonator(r.Context())
+	if ok {
+		hash := authsession.CurrentHash(r, h.CookieName)
+		if err := stopImpersonation(r.Context(), h.DB, h.Queries, hash); err != nil {
+			logger.Error("ユーザーとして表示の終了に失敗", "error", err, "email", admin.Email)
+			http.Error(w, "表示を終了できませんでした", http.StatusInternalServerError)
+			return
+		}
+		email, _, _ := appcontext.GetUser(r.Context())
+		logger.Info("ユーザーとして表示を終了", "email", admin.Email, "target", email)
+	}
+
+	sse := newSSE(w, r)
+	if ok && roles.Has(admin.Role, roles.UserManage) {
+		_ = redirectSSE(sse, "/admin/users")
+		return
+	}
+	_ = redirectSSE(sse, "/projects")
+}
+
+// startImpersonation はセッション sessionHash で targetID のユーザーとして表示を始め、監査ログを残す。
+// 表示できない相手（appMiddleware.CanImpersonate）は inputError、存在しなければ sql.ErrNoRows を返す。
+func startImpersonation(ctx context.Context, db *sql.DB, q *database.Queries, sessionHash string, targetID int64) (database.User, error) {
+	var target database.User
+	err := dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		admin, err := qtx.GetUserByID(ctx, appcontext.GetUserID(ctx))
+		if err != nil {
+			return err
+		}
+		target, err = qtx.GetUserByID(ctx, targetID)
+		if err != nil {
+			return err
+		}
+		if !appMiddleware.CanImpersonate(admin, target) {
+			return inputError("このユーザーとしては表示できません（自分自身・無効なユーザー・管理者は対象外です）")
+		}
+		if _, err := qtx.CreateImpersonation(ctx, database.CreateImpersonationParams{
+			SessionHash:  sessionHash,
+			AdminUserID:  admin.ID,
+			TargetUserID: target.ID,
+			StartedAt:    time.Now().UTC(),
+		}); err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionImpersonateStart, audit.TargetUser, target.ID)
+		entry.After = map[string]any{"email": target.Email, "role": target.Role}
+		return audit.Record(ctx, qtx, entry)
+	})
+	if err == nil {
+		authsession.ForgetSession(sessionHash)
+	}
+	return target, err
+}
+
+// stopImpersonation はセッション sessionHash の「このユーザーとして表示」を終え、監査ログを残す。
+// ctx は表示中のリクエストのもの（操作者は audit.FromContext が実際の管理者にする）。
+func stopImpersonation(ctx context.Context, db *sql.DB, q *database.Queries, sessionHash string) error {
+	defer authsession.ForgetSession(sessionHash)
+	return dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		n, err := qtx.DeleteImpersonation(ctx, sessionHash)
+		if err != nil || n == 0 {
+			return err
+		}
+		email, _, _ := appcontext.GetUser(ctx)
+		entry := audit.FromContext(ctx, audit.ActionImpersonateStop, audit.TargetUser, appcontext.GetUserID(ctx))
+		entry.Before = map[string]any{"email": email, "role": appcontext.GetUserRole(ctx)}
+		return audit.Record(ctx, qtx, entry)
+	})
+}
@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"database/sql"
+	"fmt"
 	"net/http"
+	"strings"
+	"unicode/utf8"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/naozine/nz-magic-link/magiclink"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
 	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
@@ -12,12 +18,18 @@ import (
 )
 
 type ProfileSSEHandler struct {
+	DB      *sql.DB
 	Queries *database.Queries
 	ML      *magiclink.MagicLink
 }
 
-func NewProfileSSEHandler(queries *database.Queries, ml *magiclink.MagicLink) *ProfileSSEHandler {
-	return &ProfileSSEHandler{Queries: queries, ML: ml}
+func NewProfileSSEHandler(db *sql.DB, queries *database.Queries, ml *magiclink.MagicLink) *ProfileSSEHandler {
+	return &ProfileSSEHandler{DB: db, Queries: queries, ML: ml}
+}
+
+// profileSignals はマイページのプロフィール編集の signals。
+type profileSignals struct {
+	ProfileName string `json:"profileName"`
 }
 
 func (h *ProfileSSEHandler) UpdateProfileSSE(w http.ResponseWriter, r *http.Request) {
@@ -29,9 +41,7 @@ func (h *ProfileSSEHandler) UpdateProfileSSE(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	var signals struct {
-		ProfileName string `json:"profileName"`
-	}
+	var signals profileSignals
 	if !readSignalsOr413(w, r, &signals) {
 		return
 	}
@@ -59,31 +69,201 @@ func (h *ProfileSSEHandler) UpdateProfileSSE(w http.ResponseWriter, r *http.Requ
 	sendToast(sse, "プロフィールを保存しました")
 }
 
-func (h *ProfileSSEHandler) DeletePasskeysSSE(w http.ResponseWriter, r *http.Request) {
+// EditPasskeyDialogSSE はパスキーの名前変更ダイアログを開く。
+func (h *ProfileSSEHandler) EditPasskeyDialogSSE(w http.ResponseWriter, r *http.Request) {
+	email, _, _ := appcontext.GetUser(r.Context())
+	id := chi.URLParam(r, "id")
+
+	passkey, ok := h.findPasskey(w, r, email, id)
+	if !ok {
+		return
+	}
+
+	sse := newSSE(w, r)
+	if err := sse.PatchElementTempl(
+		components.ProfilePasskeyDialog(passkey),
+		datastar.WithSelectorID("dialog-container"),
+		datastar.WithModeInner(),
+	); err != nil {
+		logger.Error("SSE PatchElementTempl failed", "error", err)
+		return
+	}
+	if err := runScript(sse, "document.getElementById('passkey-edit-dialog')?.showModal();document.activeElement?.blur()"); err != nil {
+		logger.Error("SSE runScript failed", "error", err)
+	}
+}
+
+// passkeySignals はパスキー名の編集ダイアログの signals。
+type passkeySignals struct {
+	PasskeyName string `json:"passkeyName"`
+}
+
+// RenamePasskeySSE はパスキーの名前を変更する。
+func (h *ProfileSSEHandler) RenamePasskeySSE(w http.ResponseWriter, r *http.Request) {
 	email, _, _ := appcontext.GetUser(r.Context())
+	id := chi.URLParam(r, "id")
 
-	creds, err := h.ML.DB.GetPasskeyCredentialsByUserID(email)
+	var signals passkeySignals
+	if !readSignalsOr413(w, r, &signals) {
+		return
+	}
+	name := strings.TrimSpace(signals.PasskeyName)
+	if name == "" {
+		http.Error(w, "名前は必須です", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(name) > authsession.NicknameMaxLen {
+		http.Error(w, fmt.Sprintf("名前は %d 文字以内で入力してください", authsession.NicknameMaxLen), http.StatusBadRequest)
+		return
+	}
+
+	renamed, err := authsession.RenamePasskey(r.Context(), h.ML, h.DB, email, id, name)
+	if err != nil {
+		logger.Error("パスキーの名前変更に失敗", "error", err, "email", email, "credentialID", id)
+		http.Error(w, "パスキーの名前変更に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if !renamed {
+		http.Error(w, "パスキーが見つかりません", http.StatusNotFound)
+		return
+	}
+
+	sse := h.patchPasskeys(w, r, email)
+	if err := runScript(sse, "document.getElementById('passkey-edit-dialog')?.close()"); err != nil {
+		logger.Error("SSE runScript failed", "error", err)
+	}
+	sendToast(sse, "パスキーの名前を変更しました")
+}
+
+// DeletePasskeySSE はパスキーを 1 件だけ削除する（他の端末のパスキーは残る）。
+func (h *ProfileSSEHandler) DeletePasskeySSE(w http.ResponseWriter, r *http.Request) {
+	email, _, _ := appcontext.GetUser(r.Context())
+	id := chi.URLParam(r, "id")
+
+	deleted, err := authsession.DeletePasskey(r.Context(), h.ML, h.DB, email, id)
+	if err != nil {
+		logger.Error("パスキーの削除に失敗", "error", err, "email", email, "credentialID", id)
+		http.Error(w, "パスキーの削除に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		// 他のユーザーのパスキーも「見つからない」として扱う（存在を知らせない）。
+		http.Error(w, "パスキーが見つかりません", http.StatusNotFound)
+		return
+	}
+
+	sse := h.patchPasskeys(w, r, email)
+	sendToast(sse, "パスキーを削除しました")
+}
+
+// findPasskey は自分のパスキー一覧から id のものを探す。見つからなければ 404 を返して false。
+func (h *ProfileSSEHandler) findPasskey(w http.ResponseWriter, r *http.Request, email, id string) (authsession.Passkey, bool) {
+	passkeys, err := authsession.ListPasskeys(r.Context(), h.ML, h.DB, email)
 	if err != nil {
 		logger.Error("パスキーの取得に失敗", "error", err, "email", email)
 		http.Error(w, "パスキーの取得に失敗しました", http.StatusInternalServerError)
+		return authsession.Passkey{}, false
+	}
+	for _, p := range passkeys {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	http.Error(w, "パスキーが見つかりません", http.StatusNotFound)
+	return authsession.Passkey{}, false
+}
+
+// patchPasskeys はセキュリティカードを最新のパスキー一覧に差し替える（reload しない）。
+// トースト等を続けて送れるよう SSE を返す。
+func (h *ProfileSSEHandler) patchPasskeys(w http.ResponseWriter, r *http.Request, email string) *datastar.ServerSentEventGenerator {
+	passkeys, err := authsession.ListPasskeys(r.Context(), h.ML, h.DB, email)
+	if err != nil {
+		logger.Error("パスキーの取得に失敗", "error", err, "email", email)
+	}
+
+	sse := newSSE(w, r)
+	if err := sse.PatchElementTempl(
+		components.ProfileSecurityCard(email, passkeys),
+		datastar.WithSelectorID("profile-security"),
+		datastar.WithModeOuter(),
+		datastar.WithViewTransitions(),
+	); err != nil {
+		logger.Error("SSE PatchElementTempl failed", "error", err)
+	}
+	return sse
+}
+
+// RevokeSessionSSE は自分のセッションのうち 1 件を終了させる（その端末はログアウトされる）。
+// 現在の端末のセッションはここでは終了できない（ヘッダのログアウトを使う）。
+func (h *ProfileSSEHandler) RevokeSessionSSE(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDOr400(w, r, "id")
+	if !ok {
 		return
 	}
+	email, _, _ := appcontext.GetUser(r.Context())
+	current := authsession.CurrentHash(r, h.ML.Config.CookieName)
 
-	for _, cred := range creds {
-		if err := h.ML.DB.DeletePasskeyCredential(cred.ID); err != nil {
-			logger.Error("パスキーの削除に失敗", "error", err, "credentialID", cred.ID)
+	sessions, err := authsession.List(r.Context(), h.DB, email, current)
+	if err != nil {
+		logger.Error("セッション一覧の取得に失敗", "error", err, "email", email)
+		http.Error(w, "セッションの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	for _, s := range sessions {
+		if s.ID == id && s.Current {
+			http.Error(w, "この端末のセッションはログアウトから終了してください", http.StatusBadRequest)
+			return
 		}
 	}
 
+	revoked, err := authsession.Revoke(r.Context(), h.DB, email, id)
+	if err != nil {
+		logger.Error("セッションの終了に失敗", "error", err, "email", email, "id", id)
+		http.Error(w, "セッションの終了に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		// 他のユーザーのセッション ID も「見つからない」として扱う（存在を知らせない）。
+		http.Error(w, "セッションが見つかりません", http.StatusNotFound)
+		return
+	}
+
+	h.patchSessions(w, r, email, current, "端末をログアウトさせました")
+}
+
+// RevokeOtherSessionsSSE は現在の端末以外の自分のセッションをすべて終了させる。
+func (h *ProfileSSEHandler) RevokeOtherSessionsSSE(w http.ResponseWriter, r *http.Request) {
+	email, _, _ := appcontext.GetUser(r.Context())
+	current := authsession.CurrentHash(r, h.ML.Config.CookieName)
+	if current == "" {
+		http.Error(w, "セッションを特定できません", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := authsession.RevokeOthers(r.Context(), h.DB, email, current); err != nil {
+		logger.Error("セッションの終了に失敗", "error", err, "email", email)
+		http.Error(w, "セッションの終了に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	h.patchSessions(w, r, email, current, "他の端末をすべてログアウトさせました")
+}
+
+// patchSessions はセッション一覧カードを最新の状態に差し替えてトーストを出す。
+func (h *ProfileSSEHandler) patchSessions(w http.ResponseWriter, r *http.Request, email, current, toast string) {
+	sessions, err := authsession.List(r.Context(), h.DB, email, current)
+	if err != nil {
+		logger.Error("セッション一覧の取得に失敗", "error", err, "email", email)
+	}
+
 	sse := newSSE(w, r)
-	// セキュリティカードだけを未登録状態に差し替える（reload しない）。
 	if err := sse.PatchElementTempl(
-		components.ProfileSecurityCard(email, false),
-		datastar.WithSelectorID("profile-security"),
+		components.ProfileSessionsCard(sessions),
+		datastar.WithSelectorID("profile-sessions"),
 		datastar.WithModeOuter(),
 		datastar.WithViewTransitions(),
 	); err != nil {
 		logger.Error("SSE PatchElementTempl failed", "error", err)
 	}
-	sendToast(sse, "パスキーを削除しました")
+	sendToast(sse, toast)
 }
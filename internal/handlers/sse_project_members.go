@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/starfederation/datastar-go/datastar"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+)
+
+var errProjectMemberNotFound = errors.New("project member not found")
+
+// errLastProjectOwner はプロジェクトのオーナーが 1 人もいなくなる変更を断る。
+const errLastProjectOwner = inputError("オーナーが 1 人もいなくなるため変更できません")
+
+// ProjectMemberSSEHandler はプロジェクト詳細画面のメンバー管理。操作できるのはプロジェクトの
+// オーナー（と ProjectAll の権限を持つユーザー）だけ。
+type ProjectMemberSSEHandler struct {
+	DB      *sql.DB
+	Queries *database.Queries
+}
+
+func NewProjectMemberSSEHandler(db *sql.DB, queries *database.Queries) *ProjectMemberSSEHandler {
+	return &ProjectMemberSSEHandler{DB: db, Queries: queries}
+}
+
+// addProjectMemberSignals はメンバー追加フォームの signals。
+type addProjectMemberSignals struct {
+	Email string `json:"memberEmail"`
+	Role  string `json:"memberRole"`
+}
+
+// projectMemberRolesSignals はメンバー一覧のロール選択の signals。キーは
+// components.ProjectMemberSignal（"u" + ユーザー ID）。
+type projectMemberRolesSignals struct {
+	Roles map[string]string `json:"memberRoles"`
+}
+
+// AddSSE はメールアドレスで指定した登録済みユーザーをメンバーに加える。
+func (h *ProjectMemberSSEHandler) AddSSE(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDOr400(w, r, "id")
+	if !ok {
+		return
+	}
+	var signals addProjectMemberSignals
+	if !readSignalsOr413(w, r, &signals) {
+		return
+	}
+
+	_, err := addProjectMember(r.Context(), h.DB, h.Queries, id, signals.Email, signals.Role)
+	if h.writeError(w, err, id) {
+		return
+	}
+	sse := newSSE(w, r)
+	if err := h.patchMembers(sse, r, id); err != nil {
+		logger.Error("SSE patchMembers failed", "error", err)
+		return
+	}
+	_ = sse.MarshalAndPatchSignals(map[string]any{"memberEmail": ""})
+	sendToast(sse, "メンバーを追加しました")
+}
+
+// UpdateSSE はメンバーのプロジェクトロールを変更する。
+func (h *ProjectMemberSSEHandler) UpdateSSE(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDOr400(w, r, "id")
+	if !ok {
+		return
+	}
+	userID, ok := parseIDOr400(w, r, "userID")
+	if !ok {
+		return
+	}
+	var signals projectMemberRolesSignals
+	if !readSignalsOr413(w, r, &signals) {
+		return
+	}
+
+	role := signals.Roles[components.ProjectMemberSignal(userID)]
+	_, err := updateProjectMemberRole(r.Context(), h.DB, h.Queries, id, userID, role)
+	if h.writeError(w, err, id) {
+		return
+	}
+	sse := newSSE(w, r)
+	if err := h.patchMembers(sse, r, id); err != nil {
+		logger.Error("SSE patchMembers failed", "error", err)
+		return
+	}
+	sendToast(sse, "メンバーのロールを変更しました")
+}
+
+// RemoveSSE はメンバーを外す。
+func (h *ProjectMemberSSEHandler) RemoveSSE(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDOr400(w, r, "id")
+	if !ok {
+		return
+	}
+	userID, ok := parseIDOr400(w, r, "userID")
+	if !ok {
+		return
+	}
+
+	err := removeProjectMember(r.Context(), h.DB, h.Queries, id, userID)
+	if h.writeError(w, err, id) {
+		return
+	}
+	sse := newSSE(w, r)
+	// 自分を外してプロジェクトが見えなくなったら、プロジェクト一覧へ移る。
+	if _, _, err := projectAccess(r.Context(), h.Queries, id); errors.Is(err, sql.ErrNoRows) {
+		_ = redirectSSE(sse, "/projects")
+		return
+	}
+	if err := h.patchMembers(sse, r, id); err != nil {
+		logger.Error("SSE patchMembers failed", "error", err)
+		return
+	}
+	sendToast(sse, "メンバーを外しました")
+}
+
+// writeError は err があれば 400 / 403 / 404 / 500 にして書き、true を返す（呼び出し元は return すること）。
+func (h *ProjectMemberSSEHandler) writeError(w http.ResponseWriter, err error, projectID int64) bool {
+	if err == nil {
+		return false
+	}
+	if msg, ok := inputErrorMessage(err); ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return true
+	}
+	if projectAccessErrorSSE(w, err) {
+		return true
+	}
+	if errors.Is(err, errProjectMemberNotFound) {
+		http.Error(w, "メンバーが見つかりません", http.StatusNotFound)
+		return true
+	}
+	logger.Error("プロジェクトメンバーの変更に失敗", "error", err, "project_id", projectID)
+	http.Error(w, "メンバーの変更に失敗しました", http.StatusInternalServerError)
+	return true
+}
+
+// patchMembers はメンバー一覧（#project-members）を最新の状態で置き換える。
+func (h *ProjectMemberSSEHandler) patchMembers(sse *datastar.ServerSentEventGenerator, r *http.Request, projectID int64) error {
+	ctx := r.Context()
+	project, memberRole, err := projectAccess(ctx, h.Queries, projectID)
+	if err != nil {
+		return err
+	}
+	members, err := h.Queries.ListProjectMembers(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	return sse.PatchElementTempl(components.ProjectMembers(project, memberRole, members))
+}
+
+// validateProjectRole はプロジェクトロールを検証する。
+func validateProjectRole(role string) error {
+	if !roles.IsValidProjectRole(role) {
+		return inputError("プロジェクトロールの指定が不正です")
+	}
+	return nil
+}
+
+// addProjectMember は email のユーザーを role でメンバーに加え、監査ログを残す。
+// 見えないプロジェクトなら sql.ErrNoRows、オーナーでなければ errProjectForbidden を返す。
+func addProjectMember(ctx context.Context, db *sql.DB, q *database.Queries, projectID int64, email, role string) (database.ProjectMember, error) {
+	if err := validateProjectRole(role); err != nil {
+		return database.ProjectMember{}, err
+	}
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return database.ProjectMember{}, inputError("メールアドレスは必須です")
+	}
+
+	var member database.ProjectMember
+	err := dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		if _, _, err := requireProjectRole(ctx, qtx, projectID, roles.ProjectCanManage); err != nil {
+			return err
+		}
+		user, err := qtx.GetUserByEmail(ctx, email)
+		if errors.Is(err, sql.ErrNoRows) {
+			return inputError("このメールアドレスのユーザーは登録されていません")
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := qtx.GetProjectMember(ctx, database.GetProjectMemberParams{ProjectID: projectID, UserID: user.ID}); err == nil {
+			return inputError("このユーザーは既にメンバーです")
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		member, err = qtx.CreateProjectMember(ctx, database.CreateProjectMemberParams{
+			ProjectID: projectID,
+			UserID:    user.ID,
+			Role:      role,
+			CreatedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionProjectMemberAdd, audit.TargetProject, projectID)
+		entry.After = map[string]any{"email": user.Email, "role": member.Role}
+		return audit.Record(ctx, qtx, entry)
+	})
+	return member, err
+}
+
+// updateProjectMemberRole はメンバーのプロジェクトロールを変更し、監査ログを残す。
+// 最後のオーナーをオーナー以外にする変更は断る。
+func updateProjectMemberRole(ctx context.Context, db *sql.DB, q *database.Queries, projectID, userID int64, role string) (database.ProjectMember, error) {
+	if err := validateProjectRole(role); err != nil {
+		return database.ProjectMember{}, err
+	}
+
+	var member database.ProjectMember
+	err := dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		before, err := getManagedProjectMember(ctx, qtx, projectID, userID)
+		if err != nil {
+			return err
+		}
+		if before.Role == role {
+			member = before
+			return nil
+		}
+		if err := checkNotLastProjectOwner(ctx, qtx, before); err != nil {
+			return err
+		}
+		member, err = qtx.UpdateProjectMemberRole(ctx, database.UpdateProjectMemberRoleParams{
+			Role:      role,
+			ProjectID: projectID,
+			UserID:    userID,
+		})
+		if err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionProjectMemberUpdate, audit.TargetProject, projectID)
+		entry.Before = map[string]any{"user_id": userID, "role": before.Role}
+		entry.After = map[string]any{"user_id": userID, "role": member.Role}
+		return audit.Record(ctx, qtx, entry)
+	})
+	return member, err
+}
+
+// removeProjectMember はメンバーを外し（責任者なら責任者をなしにし）、監査ログを残す。最後のオーナーは外せない。
+func removeProjectMember(ctx context.Context, db *sql.DB, q *database.Queries, projectID, userID int64) error {
+	return dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		before, err := getManagedProjectMember(ctx, qtx, projectID, userID)
+		if err != nil {
+			return err
+		}
+		if err := checkNotLastProjectOwner(ctx, qtx, before); err != nil {
+			return err
+		}
+		if _, err := qtx.DeleteProjectMember(ctx, database.DeleteProjectMemberParams{ProjectID: projectID, UserID: userID}); err != nil {
+			return err
+		}
+		// 責任者はメンバーから選ぶので、外したメンバーが責任者なら責任者をなしにする。
+		if err := qtx.ClearProjectOwner(ctx, database.ClearProjectOwnerParams{
+			ID:      projectID,
+			OwnerID: sql.NullInt64{Int64: userID, Valid: true},
+		}); err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionProjectMemberRemove, audit.TargetProject, projectID)
+		entry.Before = map[string]any{"user_id": userID, "role": before.Role}
+		return audit.Record(ctx, qtx, entry)
+	})
+}
+
+// getManagedProjectMember はオーナーとしての操作権限を確かめてから、変更対象のメンバーを返す。
+func getManagedProjectMember(ctx context.Context, qtx *database.Queries, projectID, userID int64) (database.ProjectMember, error) {
+	if _, _, err := requireProjectRole(ctx, qtx, projectID, roles.ProjectCanManage); err != nil {
+		return database.ProjectMember{}, err
+	}
+	member, err := qtx.GetProjectMember(ctx, database.GetProjectMemberParams{ProjectID: projectID, UserID: userID})
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.ProjectMember{}, errProjectMemberNotFound
+	}
+	return member, err
+}
+
+// checkNotLastProjectOwner は member が最後のオーナーなら errLastProjectOwner を返す。
+func checkNotLastProjectOwner(ctx context.Context, qtx *database.Queries, member database.ProjectMember) error {
+	if member.Role != roles.ProjectOwner {
+		return nil
+	}
+	owners, err := qtx.CountProjectMembersByRole(ctx, database.CountProjectMembersByRoleParams{
+		ProjectID: member.ProjectID,
+		Role:      roles.ProjectOwner,
+	})
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return errLastProjectOwner
+	}
+	return nil
+}
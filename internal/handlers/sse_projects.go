@@ -1,51 +1,106 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/models"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
 	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
 	"github.com/starfederation/datastar-go/datastar"
 )
 
 type ProjectSSEHandler struct {
+	DB      *sql.DB
 	Queries *database.Queries
 }
 
-func NewProjectSSEHandler(queries *database.Queries) *ProjectSSEHandler {
-	return &ProjectSSEHandler{Queries: queries}
+func NewProjectSSEHandler(db *sql.DB, queries *database.Queries) *ProjectSSEHandler {
+	return &ProjectSSEHandler{DB: db, Queries: queries}
 }
 
-// patchGrid は一覧グリッドの中身を最新の全プロジェクトで inner 置換する。
+// patchGrid は一覧グリッドの中身を、ログイン中のユーザーに見える最新のプロジェクトで inner 置換する。
 // 作成・削除で「0件 ↔ あり」の表示が正しく切り替わるよう、グリッド全体を再描画する。
 func (h *ProjectSSEHandler) patchGrid(sse *datastar.ServerSentEventGenerator, r *http.Request) error {
-	projects, err := h.Queries.ListProjects(r.Context())
+	projects, memberRoles, err := listVisibleProjects(r.Context(), h.Queries)
 	if err != nil {
 		return err
 	}
 	return sse.PatchElementTempl(
-		components.ProjectCards(projects, true),
+		components.ProjectCards(projects, memberRoles),
 		datastar.WithSelectorID("projects-grid"),
 		datastar.WithModeInner(),
 		datastar.WithViewTransitions(),
 	)
 }
 
-func (h *ProjectSSEHandler) CreateProjectSSE(w http.ResponseWriter, r *http.Request) {
-	var signals struct {
-		Name string `json:"name"`
+// projectSignals はプロジェクトの作成・編集ダイアログの signals。送られなかった項目は
+// projectInput と同じく省略扱い（作成なら既定値、更新なら今の値）。OwnerID は編集ダイアログだけが
+// 送る（作成では作成者が責任者になる）。選択肢の値なので文字列で、空は責任者なし。
+type projectSignals struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	Status      *string `json:"status"`
+	OwnerID     *string `json:"ownerId"`
+	DueDate     *string `json:"dueDate"`
+	Tags        *string `json:"tags"`
+}
+
+// input は signals を projectInput にする。タグはカンマ区切りで入力する。
+func (s projectSignals) input() (projectInput, error) {
+	in := projectInput{
+		Name:        &s.Name,
+		Description: s.Description,
+		Status:      s.Status,
+		DueDate:     s.DueDate,
 	}
+	if s.Tags != nil {
+		tags := strings.Split(*s.Tags, ",")
+		in.Tags = &tags
+	}
+	if s.OwnerID != nil {
+		var ownerID int64
+		if *s.OwnerID != "" {
+			id, err := strconv.ParseInt(*s.OwnerID, 10, 64)
+			if err != nil {
+				return projectInput{}, inputError("責任者の指定が不正です")
+			}
+			ownerID = id
+		}
+		in.OwnerID = &ownerID
+	}
+	return in, nil
+}
+
+func (h *ProjectSSEHandler) CreateProjectSSE(w http.ResponseWriter, r *http.Request) {
+	var signals projectSignals
 	if !readSignalsOr413(w, r, &signals) {
 		return
 	}
-	if signals.Name == "" {
-		http.Error(w, "プロジェクト名は必須です", http.StatusBadRequest)
+	// 画面の signals はすべて送られるので、前に開いた編集ダイアログの ownerId も届く。作成では使わない。
+	signals.OwnerID = nil
+	in, err := signals.input()
+	if err == nil {
+		_, err = createProject(r.Context(), h.DB, h.Queries, in)
+	}
+	if msg, ok := inputErrorMessage(err); ok {
+		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
-
-	if _, err := h.Queries.CreateProject(r.Context(), signals.Name); err != nil {
+	if err != nil {
 		logger.Error("プロジェクト作成に失敗", "error", err)
 		http.Error(w, "プロジェクトの作成に失敗しました", http.StatusInternalServerError)
 		return
@@ -56,7 +111,7 @@ func (h *ProjectSSEHandler) CreateProjectSSE(w http.ResponseWriter, r *http.Requ
 		logger.Error("SSE patchGrid failed", "error", err)
 		return
 	}
-	sse.ExecuteScript("document.getElementById('project-add-dialog')?.close()")
+	runScript(sse, "document.getElementById('project-add-dialog')?.close()")
 	sendToast(sse, "プロジェクトを作成しました")
 }
 
@@ -66,21 +121,31 @@ func (h *ProjectSSEHandler) EditProjectDialogSSE(w http.ResponseWriter, r *http.
 	if !ok {
 		return
 	}
-	project, err := h.Queries.GetProject(r.Context(), id)
+	project, _, err := requireProjectRole(r.Context(), h.Queries, id, roles.ProjectCanWrite)
+	if projectAccessErrorSSE(w, err) {
+		return
+	}
 	if err != nil {
-		http.Error(w, "プロジェクトが見つかりません", http.StatusNotFound)
+		logger.Error("プロジェクトの取得に失敗", "error", err, "id", id)
+		http.Error(w, "プロジェクトの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	members, err := h.Queries.ListProjectMembers(r.Context(), id)
+	if err != nil {
+		logger.Error("プロジェクトメンバーの取得に失敗", "error", err, "id", id)
+		http.Error(w, "プロジェクトの取得に失敗しました", http.StatusInternalServerError)
 		return
 	}
 	sse := newSSE(w, r)
 	if err := sse.PatchElementTempl(
-		components.ProjectEditDialog(project),
+		components.ProjectEditDialog(project, members),
 		datastar.WithSelectorID("project-dialog-container"),
 		datastar.WithModeInner(),
 	); err != nil {
 		logger.Error("SSE PatchElementTempl failed", "error", err)
 		return
 	}
-	sse.ExecuteScript("document.getElementById('project-edit-dialog')?.showModal()")
+	runScript(sse, "document.getElementById('project-edit-dialog')?.showModal()")
 }
 
 func (h *ProjectSSEHandler) UpdateProjectSSE(w http.ResponseWriter, r *http.Request) {
@@ -89,32 +154,36 @@ func (h *ProjectSSEHandler) UpdateProjectSSE(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	var signals struct {
-		Name string `json:"name"`
-	}
+	var signals projectSignals
 	if !readSignalsOr413(w, r, &signals) {
 		return
 	}
 
-	if _, err := h.Queries.UpdateProject(r.Context(), database.UpdateProjectParams{
-		Name: signals.Name,
-		ID:   id,
-	}); err != nil {
-		logger.Error("プロジェクト更新に失敗", "error", err, "id", id)
-		http.Error(w, "プロジェクトの更新に失敗しました", http.StatusInternalServerError)
+	var (
+		project    database.Project
+		memberRole string
+	)
+	in, err := signals.input()
+	if err == nil {
+		project, memberRole, err = updateProject(r.Context(), h.DB, h.Queries, id, in)
+	}
+	if msg, ok := inputErrorMessage(err); ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if projectAccessErrorSSE(w, err) {
 		return
 	}
-
-	project, err := h.Queries.GetProject(r.Context(), id)
 	if err != nil {
-		http.Error(w, "プロジェクトが見つかりません", http.StatusNotFound)
+		logger.Error("プロジェクト更新に失敗", "error", err, "id", id)
+		http.Error(w, "プロジェクトの更新に失敗しました", http.StatusInternalServerError)
 		return
 	}
 
 	sse := newSSE(w, r)
 	// 該当カードだけを outer 置換（reload しない）。
 	if err := sse.PatchElementTempl(
-		components.ProjectCard(project, true),
+		components.ProjectCard(project, memberRole),
 		datastar.WithSelectorID(fmt.Sprintf("project-%d", id)),
 		datastar.WithModeOuter(),
 		datastar.WithViewTransitions(),
@@ -122,7 +191,7 @@ func (h *ProjectSSEHandler) UpdateProjectSSE(w http.ResponseWriter, r *http.Requ
 		logger.Error("SSE PatchElementTempl failed", "error", err)
 		return
 	}
-	sse.ExecuteScript("document.getElementById('project-edit-dialog')?.close()")
+	runScript(sse, "document.getElementById('project-edit-dialog')?.close()")
 	sendToast(sse, "プロジェクトを更新しました")
 }
 
@@ -132,7 +201,11 @@ func (h *ProjectSSEHandler) DeleteProjectSSE(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	if err := h.Queries.DeleteProject(r.Context(), id); err != nil {
+	err := deleteProject(r.Context(), h.DB, h.Queries, id)
+	if projectAccessErrorSSE(w, err) {
+		return
+	}
+	if err != nil {
 		logger.Error("プロジェクト削除に失敗", "error", err, "id", id)
 		http.Error(w, "プロジェクトの削除に失敗しました", http.StatusInternalServerError)
 		return
@@ -143,5 +216,307 @@ func (h *ProjectSSEHandler) DeleteProjectSSE(w http.ResponseWriter, r *http.Requ
 	if err := h.patchGrid(sse, r); err != nil {
 		logger.Error("SSE patchGrid failed", "error", err)
 	}
-	sendToast(sse, "プロジェクトを削除しました")
+	sendToast(sse, "プロジェクトをゴミ箱に移しました")
+}
+
+// プロジェクトの項目の上限。
+const (
+	maxProjectDescriptionLength = 10000
+	maxProjectTags              = 10
+	maxProjectTagLength         = 30
+)
+
+// projectFields はプロジェクトの項目（検証前）。作成・更新とも、これに projectInput を
+// 重ねてから validateProjectFields で検証する。
+type projectFields struct {
+	Name        string
+	Description string
+	Status      string
+	OwnerID     int64 // 0 は責任者なし
+	DueDate     string
+	Tags        []string
+}
+
+// projectFieldsOf は保存されているプロジェクトの項目を返す（更新で省略された項目に使う）。
+func projectFieldsOf(p database.Project) projectFields {
+	f := projectFields{
+		Name:        p.Name,
+		Description: p.Description,
+		Status:      p.Status,
+		OwnerID:     p.OwnerID.Int64,
+		Tags:        models.ParseProjectTags(p.Tags),
+	}
+	if p.DueDate.Valid {
+		f.DueDate = p.DueDate.Time.Format(time.DateOnly)
+	}
+	return f
+}
+
+// validateProjectName はプロジェクト名を検証し、前後の空白を除いた名前を返す。
+func validateProjectName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", inputError("プロジェクト名は必須です")
+	}
+	return name, nil
+}
+
+// validateProjectFields は f を検証し、保存する値（前後の空白と空・重複したタグを除いたもの）を返す。
+// SSE と JSON API（/api/v1）で同じ検証を使う。責任者がメンバーかは checkProjectOwner で確かめる。
+func validateProjectFields(f projectFields) (database.CreateProjectParams, error) {
+	name, err := validateProjectName(f.Name)
+	if err != nil {
+		return database.CreateProjectParams{}, err
+	}
+	description := strings.TrimSpace(f.Description)
+	if utf8.RuneCountInString(description) > maxProjectDescriptionLength {
+		return database.CreateProjectParams{}, inputError(fmt.Sprintf("説明は %d 文字以内で入力してください", maxProjectDescriptionLength))
+	}
+	if !models.IsValidProjectStatus(f.Status) {
+		return database.CreateProjectParams{}, inputError("状態の指定が不正です")
+	}
+	var dueDate sql.NullTime
+	if d := strings.TrimSpace(f.DueDate); d != "" {
+		t, err := time.Parse(time.DateOnly, d)
+		if err != nil {
+			return database.CreateProjectParams{}, inputError("期限は YYYY-MM-DD の形式で入力してください")
+		}
+		dueDate = sql.NullTime{Time: t, Valid: true}
+	}
+	var tags []string
+	for _, tag := range f.Tags {
+		tag = strings.TrimSpace(tag)
+		switch {
+		case tag == "" || slices.Contains(tags, tag):
+			continue
+		case strings.Contains(tag, ","):
+			return database.CreateProjectParams{}, inputError("タグにカンマは使えません")
+		case utf8.RuneCountInString(tag) > maxProjectTagLength:
+			return database.CreateProjectParams{}, inputError(fmt.Sprintf("タグは 1 つ %d 文字以内で入力してください", maxProjectTagLength))
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxProjectTags {
+		return database.CreateProjectParams{}, inputError(fmt.Sprintf("タグは %d 個までです", maxProjectTags))
+	}
+	return database.CreateProjectParams{
+		Name:        name,
+		Description: description,
+		Status:      f.Status,
+		OwnerID:     sql.NullInt64{Int64: f.OwnerID, Valid: f.OwnerID != 0},
+		DueDate:     dueDate,
+		Tags:        models.EncodeProjectTags(tags),
+	}, nil
+}
+
+// checkProjectOwner は責任者（ownerID）がプロジェクトのメンバーかを確かめる。責任者なしは通す。
+func checkProjectOwner(ctx context.Context, qtx *database.Queries, projectID int64, ownerID sql.NullInt64) error {
+	if !ownerID.Valid {
+		return nil
+	}
+	_, err := qtx.GetProjectMember(ctx, database.GetProjectMemberParams{ProjectID: projectID, UserID: ownerID.Int64})
+	if errors.Is(err, sql.ErrNoRows) {
+		return inputError("責任者はプロジェクトのメンバーから選んでください")
+	}
+	return err
+}
+
+// errProjectForbidden はプロジェクトのメンバーだが、プロジェクトロールでは許されない操作。
+var errProjectForbidden = errors.New("project role does not allow this operation")
+
+// projectAccess は id のプロジェクトと、ログイン中のユーザーのプロジェクトロールを返す。
+// ProjectAll の権限があればメンバーでなくてもオーナー扱いにする。存在しないプロジェクトと
+// メンバーでないプロジェクトは区別せず sql.ErrNoRows を返す（どちらも 404 にして存在を漏らさない）。
+func projectAccess(ctx context.Context, q *database.Queries, id int64) (database.Project, string, error) {
+	project, err := q.GetProject(ctx, id)
+	if err != nil {
+		return database.Project{}, "", err
+	}
+	if appcontext.Can(ctx, roles.ProjectAll) {
+		return project, roles.ProjectOwner, nil
+	}
+	member, err := q.GetProjectMember(ctx, database.GetProjectMemberParams{
+		ProjectID: id,
+		UserID:    appcontext.GetUserID(ctx),
+	})
+	if err != nil {
+		return database.Project{}, "", err
+	}
+	return project, member.Role, nil
+}
+
+// requireProjectRole は projectAccess に加えて、プロジェクトロールが allow を満たさなければ
+// errProjectForbidden を返す（allow は roles.ProjectCanWrite など）。
+func requireProjectRole(ctx context.Context, q *database.Queries, id int64, allow func(string) bool) (database.Project, string, error) {
+	project, memberRole, err := projectAccess(ctx, q, id)
+	if err != nil {
+		return database.Project{}, "", err
+	}
+	if !allow(memberRole) {
+		return database.Project{}, "", errProjectForbidden
+	}
+	return project, memberRole, nil
+}
+
+// projectAccessErrorSSE は projectAccess 系のエラーなら 404 / 403 を返して true を返す
+// （呼び出し元は return すること）。
+func projectAccessErrorSSE(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "プロジェクトが見つかりません", http.StatusNotFound)
+		return true
+	case errors.Is(err, errProjectForbidden):
+		http.Error(w, "このプロジェクトでの権限が足りません", http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+// listVisibleProjects はログイン中のユーザーに見えるプロジェクト（新しい順）と、
+// プロジェクト ID ごとのプロジェクトロールを返す。ProjectAll の権限があれば全プロジェクト。
+func listVisibleProjects(ctx context.Context, q *database.Queries) ([]database.Project, map[int64]string, error) {
+	memberRoles := map[int64]string{}
+	if appcontext.Can(ctx, roles.ProjectAll) {
+		projects, err := q.ListProjects(ctx)
+		for _, p := range projects {
+			memberRoles[p.ID] = roles.ProjectOwner
+		}
+		return projects, memberRoles, err
+	}
+	rows, err := q.ListProjectsForUser(ctx, appcontext.GetUserID(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	projects := make([]database.Project, len(rows))
+	for i, row := range rows {
+		projects[i] = database.Project{
+			ID:          row.ID,
+			Name:        row.Name,
+			CreatedAt:   row.CreatedAt,
+			Description: row.Description,
+			Status:      row.Status,
+			OwnerID:     row.OwnerID,
+			DueDate:     row.DueDate,
+			Tags:        row.Tags,
+		}
+		memberRoles[row.ID] = row.Role
+	}
+	return projects, memberRoles, nil
+}
+
+// createProject はプロジェクトを作成し、同じトランザクションで作成者をオーナーとして
+// メンバーに加えて監査ログを残す。in で省略した項目は、状態が進行中、責任者が作成者になる。
+func createProject(ctx context.Context, db *sql.DB, q *database.Queries, in projectInput) (database.Project, error) {
+	f := projectFields{Status: models.ProjectStatusActive, OwnerID: appcontext.GetUserID(ctx)}
+	in.applyTo(&f)
+	params, err := validateProjectFields(f)
+	if err != nil {
+		return database.Project{}, err
+	}
+	var project database.Project
+	err = dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		project, err = qtx.CreateProject(ctx, params)
+		if err != nil {
+			return err
+		}
+		if _, err := qtx.CreateProjectMember(ctx, database.CreateProjectMemberParams{
+			ProjectID: project.ID,
+			UserID:    appcontext.GetUserID(ctx),
+			Role:      roles.ProjectOwner,
+			CreatedAt: time.Now().UTC(),
+		}); err != nil {
+			return err
+		}
+		// 作ったばかりのプロジェクトのメンバーは作成者だけなので、責任者は作成者かなしに限られる。
+		if err := checkProjectOwner(ctx, qtx, project.ID, project.OwnerID); err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionProjectCreate, audit.TargetProject, project.ID)
+		entry.After = project
+		return audit.Record(ctx, qtx, entry)
+	})
+	return project, err
+}
+
+// updateProject は in で指定した項目を変更し（省略した項目は今の値のまま）、変更前後を
+// 監査ログに残す。変更後のプロジェクトとログイン中のユーザーのプロジェクトロールを返す。
+// 見えないプロジェクトなら sql.ErrNoRows、編集者・オーナーでなければ errProjectForbidden を返す。
+func updateProject(ctx context.Context, db *sql.DB, q *database.Queries, id int64, in projectInput) (database.Project, string, error) {
+	var (
+		project    database.Project
+		memberRole string
+	)
+	err := dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		before, role, err := requireProjectRole(ctx, qtx, id, roles.ProjectCanWrite)
+		if err != nil {
+			return err
+		}
+		memberRole = role
+		f := projectFieldsOf(before)
+		in.applyTo(&f)
+		params, err := validateProjectFields(f)
+		if err != nil {
+			return err
+		}
+		if err := checkProjectOwner(ctx, qtx, id, params.OwnerID); err != nil {
+			return err
+		}
+		project, err = qtx.UpdateProject(ctx, database.UpdateProjectParams{
+			Name:        params.Name,
+			Description: params.Description,
+			Status:      params.Status,
+			OwnerID:     params.OwnerID,
+			DueDate:     params.DueDate,
+			Tags:        params.Tags,
+			ID:          id,
+		})
+		if err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionProjectUpdate, audit.TargetProject, id)
+		entry.Before, entry.After = before, project
+		return audit.Record(ctx, qtx, entry)
+	})
+	return project, memberRole, err
+}
+
+// deleteProject はプロジェクトをゴミ箱に移し、監査ログを残す。メンバーは残すので、管理画面の
+// ゴミ箱から戻せば元どおりになる（完全な削除は purgeTrashedProject と trash.PurgeExpired）。
+// 削除できた人がもう一度削除するのは成功扱い（記録すべき変更も無い）。それ以外の、存在しない・
+// メンバーでないプロジェクトは区別せず sql.ErrNoRows（ID の有無を漏らさない）、オーナーで
+// なければ errProjectForbidden を返す。
+func deleteProject(ctx context.Context, db *sql.DB, q *database.Queries, id int64) error {
+	return dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		before, _, err := requireProjectRole(ctx, qtx, id, roles.ProjectCanManage)
+		if errors.Is(err, sql.ErrNoRows) && alreadyDeleted(ctx, qtx, id) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := qtx.TrashProject(ctx, database.TrashProjectParams{
+			DeletedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+			ID:        id,
+		}); err != nil {
+			return err
+		}
+		entry := audit.FromContext(ctx, audit.ActionProjectDelete, audit.TargetProject, id)
+		entry.Before = before
+		return audit.Record(ctx, qtx, entry)
+	})
+}
+
+// alreadyDeleted は、取得できない id のプロジェクトを、ログイン中のユーザーが既に削除したものと
+// 扱ってよいかを返す。ProjectAll の権限があれば全プロジェクトが見えるので、無い ID を成功にしても
+// 何も漏れない。それ以外はゴミ箱の中のプロジェクトのオーナーだけ（メンバーシップはゴミ箱でも
+// 残るので、メンバーの行があって取得できないならゴミ箱の中）。
+func alreadyDeleted(ctx context.Context, q *database.Queries, id int64) bool {
+	if appcontext.Can(ctx, roles.ProjectAll) {
+		return true
+	}
+	member, err := q.GetProjectMember(ctx, database.GetProjectMemberParams{
+		ProjectID: id,
+		UserID:    appcontext.GetUserID(ctx),
+	})
+	return err == nil && roles.ProjectCanManage(member.Role)
 }
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/starfederation/datastar-go/datastar"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+)
+
+// StepUpRequiredSSE は本人確認（最近のログイン）が必要な操作を Datastar から受けたとき、
+// 本人確認のダイアログを開く（middleware.RequireRecentAuth / RejectStepUp に渡す）。
+// 確認できたらダイアログが同じ操作を同じ signals でやり直す。
+func StepUpRequiredSSE(w http.ResponseWriter, r *http.Request) {
+	email, _, hasPasskey := appcontext.GetUser(r.Context())
+	sse := newSSE(w, r)
+	_ = sse.PatchElementTempl(
+		components.StepUpDialog(email, hasPasskey, r.Method, r.URL.Path),
+		datastar.WithSelectorID("step-up-container"),
+		datastar.WithModeInner(),
+	)
+	_ = runScript(sse, "document.getElementById('step-up-dialog')?.showModal()")
+}
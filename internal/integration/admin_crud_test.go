@@ -33,8 +33,12 @@ func TestAdminCRUD_CreateUser(t *testing.T) {
 	if user.Role != "editor" {
 		t.Errorf("Role = %q, want %q", user.Role, "editor")
 	}
-	if !user.IsActive {
-		t.Error("IsActive = false, want true")
+	// 管理者が追加したユーザーは招待の承認まで無効（招待中）
+	if user.IsActive {
+		t.Error("IsActive = true, want false（招待中）")
+	}
+	if _, err := q.GetInvitationByUserID(t.Context(), user.ID); err != nil {
+		t.Errorf("招待が作成されていない: %v", err)
 	}
 }
 
@@ -43,13 +47,13 @@ func TestAdminCRUD_CreateUser_ValidationError(t *testing.T) {
 	e := SetupTestServer(t, conn)
 	seed := SeedTestData(t, conn)
 
-	// 必須フィールドが空 → ハンドラが http.ErrAbortHandler を返し、500 となる
+	// 必須フィールドが空 → 入力エラーとして 400 となる
 	rec := DoSSERequest(e, http.MethodPost, "/api/sse/admin/users/create",
 		&seed.AdminUser,
 		`{"newName":"","newEmail":"","newRole":""}`)
 
-	if rec.Code != http.StatusInternalServerError {
-		t.Errorf("ステータスコード = %d, want %d", rec.Code, http.StatusInternalServerError)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("ステータスコード = %d, want %d", rec.Code, http.StatusBadRequest)
 	}
 }
 
@@ -157,8 +157,12 @@ func TestUserImport_Success(t *testing.T) {
 		if user.Role != row.Role {
 			t.Errorf("Role = %q, want %q", user.Role, row.Role)
 		}
-		if !user.IsActive {
-			t.Errorf("IsActive = false, want true")
+		// インポートしたユーザーは招待の承認まで無効（招待中）
+		if user.IsActive {
+			t.Errorf("IsActive = true, want false（招待中）")
+		}
+		if _, err := q.GetInvitationByUserID(t.Context(), user.ID); err != nil {
+			t.Errorf("ユーザー %s の招待が作成されていない: %v", row.Email, err)
 		}
 	}
 
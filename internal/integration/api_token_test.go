@@ -0,0 +1,227 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/apitoken"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+var apiTokenPattern = regexp.MustCompile(`pat_[A-Za-z0-9_-]{43}`)
+
+// doBearerRequest は Authorization: Bearer 付きでリクエストを実行する（Cookie 無し）。
+func doBearerRequest(h http.Handler, method, path, token, jsonBody string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(jsonBody))
+	if jsonBody != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// createAPIToken はマイページの発行ダイアログと同じ SSE で user のトークンを発行し、平文を返す。
+func createAPIToken(t *testing.T, h http.Handler, user *database.User, scope string) string {
+	t.Helper()
+	body := sprintf(`{"tokenName":"夜間バッチ","tokenScope":%q,"tokenExpiry":"30"}`, scope)
+	rec := DoSSERequest(h, http.MethodPost, "/api/sse/profile/api-tokens", user, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("トークン発行: got %d: %s", rec.Code, rec.Body.String())
+	}
+	token := apiTokenPattern.FindString(rec.Body.String())
+	if token == "" {
+		t.Fatalf("発行直後の平文が表示されていない: %s", rec.Body.String())
+	}
+	return token
+}
+
+// マイページで発行したトークンで Bearer 認証でき、所有者のロールで動く。
+// 平文は保存されず、最終使用日時が記録される。read スコープでは書き込めない。
+func TestAPIToken_BearerAuth(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	api := SetupSessionTestServer(t, conn, newTestMagicLink(t, conn))
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	readToken := createAPIToken(t, e, &seed.EditorUser, apitoken.ScopeRead)
+	tokens, err := q.ListAPITokensByUser(t.Context(), seed.EditorUser.ID)
+	if err != nil || len(tokens) != 1 {
+		t.Fatalf("保存されたトークン: %v, %v", tokens, err)
+	}
+	if tok := tokens[0]; tok.TokenHash == readToken || !strings.HasPrefix(readToken, tok.TokenPrefix) || !tok.ExpiresAt.Valid {
+		t.Errorf("保存内容が不正: %+v", tok)
+	}
+
+	rec := doBearerRequest(api, http.MethodGet, "/projects", readToken, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("read トークンで GET: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if tok, _ := q.GetAPIToken(t.Context(), tokens[0].ID); !tok.LastUsedAt.Valid {
+		t.Errorf("最終使用日時が記録されていない")
+	}
+
+	path := sprintf("/api/sse/projects/%d", seed.Project.ID)
+	if rec := doBearerRequest(api, http.MethodPut, path, readToken, `{"name":"read で更新"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("read トークンで PUT: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	writeToken := createAPIToken(t, e, &seed.EditorUser, apitoken.ScopeWrite)
+	if rec := doBearerRequest(api, http.MethodPut, path, writeToken, `{"name":"write で更新"}`); rec.Code != http.StatusOK {
+		t.Errorf("write トークンで PUT: got %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if p, _ := q.GetProject(t.Context(), seed.Project.ID); p.Name != "write で更新" {
+		t.Errorf("write トークンの更新が反映されていない: %q", p.Name)
+	}
+
+	// ロールは所有者のものがそのまま効く
+	viewerToken := createAPIToken(t, e, &seed.ViewerUser, apitoken.ScopeWrite)
+	if rec := doBearerRequest(api, http.MethodPut, path, viewerToken, `{"name":"viewer で更新"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer の write トークンで PUT: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	// トークンからトークンは作れない
+	if rec := doBearerRequest(api, http.MethodPost, "/api/sse/profile/api-tokens", writeToken, `{"tokenName":"x","tokenScope":"write","tokenExpiry":"0"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("トークンでの発行: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+// 無効・期限切れ・所有者が無効化されたトークンは Cookie 認証に落ちず 401 になる。
+func TestAPIToken_Rejected(t *testing.T) {
+	conn := SetupTestDB(t)
+	api := SetupSessionTestServer(t, conn, newTestMagicLink(t, conn))
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	expired, expiredRaw, err := apitoken.Create(t.Context(), q, seed.EditorUser.ID, "期限切れ", apitoken.ScopeRead, 30)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := conn.ExecContext(t.Context(), `UPDATE api_tokens SET expires_at = ? WHERE id = ?`, time.Now().Add(-time.Minute).UTC(), expired.ID); err != nil {
+		t.Fatalf("期限を過去にする: %v", err)
+	}
+	_, inactiveRaw, err := apitoken.Create(t.Context(), q, seed.DeletableUser.ID, "無効化", apitoken.ScopeRead, 0)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := q.UpdateUser(t.Context(), database.UpdateUserParams{
+		Name: seed.DeletableUser.Name, Role: seed.DeletableUser.Role, IsActive: false, ID: seed.DeletableUser.ID,
+	}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	for name, token := range map[string]string{
+		"存在しない":   "pat_AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA",
+		"形式違い":    "not-a-token",
+		"期限切れ":    expiredRaw,
+		"所有者が無効化": inactiveRaw,
+	} {
+		rec := doBearerRequest(api, http.MethodGet, "/projects", token, "")
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got %d, want %d", name, rec.Code, http.StatusUnauthorized)
+			continue
+		}
+		if !strings.Contains(rec.Header().Get("Content-Type"), "application/json") || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: JSON と WWW-Authenticate が無い: %v", name, rec.Header())
+		}
+	}
+}
+
+// 本人はマイページから自分のトークンだけを失効でき、admin は全員分の一覧から失効できる。
+// 発行・失効は監査ログに残る。
+func TestAPIToken_Revoke(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	api := SetupSessionTestServer(t, conn, newTestMagicLink(t, conn))
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	first := createAPIToken(t, e, &seed.EditorUser, apitoken.ScopeRead)
+	second := createAPIToken(t, e, &seed.EditorUser, apitoken.ScopeRead)
+	tokens, _ := q.ListAPITokensByUser(t.Context(), seed.EditorUser.ID)
+	if len(tokens) != 2 {
+		t.Fatalf("トークン数 = %d, want 2", len(tokens))
+	}
+	firstID, secondID := tokens[1].ID, tokens[0].ID // 新しい順
+
+	own := sprintf("/api/sse/profile/api-tokens/%d", firstID)
+	if rec := DoSSERequest(e, http.MethodDelete, own, &seed.ViewerUser, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("他人のトークンの失効: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	rec := DoSSERequest(e, http.MethodDelete, own, &seed.EditorUser, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "profile-api-tokens") {
+		t.Fatalf("本人の失効: got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := doBearerRequest(api, http.MethodGet, "/projects", first, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("失効後のトークン: got %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	// 管理画面: admin だけが見られ、任意のトークンを失効できる
+	if rec := DoRequest(e, http.MethodGet, "/admin/api-tokens", &seed.EditorUser); rec.Code != http.StatusForbidden {
+		t.Errorf("editor の一覧: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec = DoRequest(e, http.MethodGet, "/admin/api-tokens", &seed.AdminUser)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), seed.EditorUser.Email) {
+		t.Fatalf("admin の一覧: got %d（所有者が表示されていない）", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), second) {
+		t.Errorf("一覧に平文のトークンが出ている")
+	}
+	adminPath := sprintf("/api/sse/admin/api-tokens/%d", secondID)
+	if rec := DoSSERequest(e, http.MethodDelete, adminPath, &seed.EditorUser, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("editor の管理画面からの失効: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := DoSSERequest(e, http.MethodDelete, adminPath, &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("admin の失効: got %d", rec.Code)
+	}
+	if rec := DoSSERequest(e, http.MethodDelete, adminPath, &seed.AdminUser, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("失効済みの再失効: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := doBearerRequest(api, http.MethodGet, "/projects", second, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("admin が失効させたトークン: got %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	var actions []string
+	for _, l := range listAllAuditLogs(t, q) {
+		actions = append(actions, l.Action)
+		if strings.Contains(l.AfterJson+l.BeforeJson, "token_hash") {
+			t.Errorf("監査ログにトークンのハッシュが残っている: %+v", l)
+		}
+	}
+	if n := len(slices.DeleteFunc(slices.Clone(actions), func(a string) bool { return a != audit.ActionAPITokenRevoke })); n != 2 {
+		t.Errorf("失効の監査ログ = %d 件, want 2: %v", n, actions)
+	}
+	if !slices.Contains(actions, audit.ActionAPITokenCreate) {
+		t.Errorf("発行の監査ログが無い: %v", actions)
+	}
+}
+
+// 入力が不正な発行は 400。
+func TestAPIToken_CreateValidation(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	for name, body := range map[string]string{
+		"名前なし":    `{"tokenName":"  ","tokenScope":"read","tokenExpiry":"30"}`,
+		"名前が長い":   sprintf(`{"tokenName":%q,"tokenScope":"read","tokenExpiry":"30"}`, strings.Repeat("あ", apitoken.NameMaxLen+1)),
+		"不正な権限":   `{"tokenName":"x","tokenScope":"admin-all","tokenExpiry":"30"}`,
+		"不正な期間":   `{"tokenName":"x","tokenScope":"read","tokenExpiry":"7"}`,
+		"数値でない期間": `{"tokenName":"x","tokenScope":"read","tokenExpiry":"forever"}`,
+	} {
+		if rec := DoSSERequest(e, http.MethodPost, "/api/sse/profile/api-tokens", &seed.EditorUser, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", name, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if tokens, _ := queryFromConn(conn).ListAPITokensByUser(t.Context(), seed.EditorUser.ID); len(tokens) != 0 {
+		t.Errorf("不正な入力でトークンが作られた: %d 件", len(tokens))
+	}
+}
@@ -0,0 +1,302 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/apitoken"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+// apiBody は JSON API のレスポンス（成功・失敗の両方の形）。
+type apiBody struct {
+	Data           json.RawMessage `json:"data"`
+	NextCursor     string          `json:"next_cursor"`
+	InvitationSent bool            `json:"invitation_sent"`
+	Error          string          `json:"error"`
+	Message        string          `json:"message"`
+}
+
+type apiRecord struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	IsActive bool   `json:"is_active"`
+}
+
+func decodeAPI(t *testing.T, rec *httptest.ResponseRecorder) apiBody {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("Content-Type = %q: %s", ct, rec.Body.String())
+	}
+	var b apiBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &b); err != nil {
+		t.Fatalf("JSON の解釈に失敗: %v: %s", err, rec.Body.String())
+	}
+	return b
+}
+
+func decodeAPIRecord(t *testing.T, rec *httptest.ResponseRecorder) apiRecord {
+	t.Helper()
+	var r apiRecord
+	if err := json.Unmarshal(decodeAPI(t, rec).Data, &r); err != nil {
+		t.Fatalf("data の解釈に失敗: %v: %s", err, rec.Body.String())
+	}
+	return r
+}
+
+// プロジェクトの作成・取得・部分更新・削除。画面と同じく監査ログが残る。
+func TestAPIv1_ProjectCRUD(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	rec := DoAPIRequest(e, http.MethodPost, "/api/v1/projects", &seed.EditorUser, `{"name":"  API で作成  "}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("作成: got %d: %s", rec.Code, rec.Body.String())
+	}
+	created := decodeAPIRecord(t, rec)
+	if created.Name != "API で作成" {
+		t.Errorf("名前の前後の空白が除かれていない: %q", created.Name)
+	}
+	if loc := rec.Header().Get("Location"); loc != sprintf("/api/v1/projects/%d", created.ID) {
+		t.Errorf("Location = %q", loc)
+	}
+
+	// 作成者はオーナーになる
+	path := sprintf("/api/v1/projects/%d", created.ID)
+	rec = DoAPIRequest(e, http.MethodGet, path, &seed.EditorUser, "")
+	if rec.Code != http.StatusOK || decodeAPIRecord(t, rec).Name != "API で作成" {
+		t.Fatalf("取得: got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = DoAPIRequest(e, http.MethodPatch, path, &seed.EditorUser, `{"name":"API で更新"}`)
+	if rec.Code != http.StatusOK || decodeAPIRecord(t, rec).Name != "API で更新" {
+		t.Fatalf("更新: got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := DoAPIRequest(e, http.MethodDelete, path, &seed.EditorUser, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("削除: got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := DoAPIRequest(e, http.MethodDelete, path, &seed.EditorUser, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("削除済みの削除は冪等: got %d", rec.Code)
+	}
+	rec = DoAPIRequest(e, http.MethodGet, path, &seed.EditorUser, "")
+	if rec.Code != http.StatusNotFound || decodeAPI(t, rec).Error != "not_found" {
+		t.Errorf("削除後の取得: got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var actions []string
+	for _, l := range listAllAuditLogs(t, q) {
+		if l.ActorEmail == seed.EditorUser.Email {
+			actions = append(actions, l.Action)
+		}
+	}
+	// listAllAuditLogs は新しい順
+	want := []string{audit.ActionProjectDelete, audit.ActionProjectUpdate, audit.ActionProjectCreate}
+	if strings.Join(actions, ",") != strings.Join(want, ",") {
+		t.Errorf("監査ログ = %v, want %v", actions, want)
+	}
+}
+
+// 一覧は新しい順で、next_cursor をたどると重複・欠落なく全件を取れる。?q= で絞り込める。
+// （一覧に出るのはメンバーのプロジェクトだけなので、作成者の editor で取る）
+func TestAPIv1_ProjectListPagination(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	for i := range 4 {
+		if rec := DoAPIRequest(e, http.MethodPost, "/api/v1/projects", &seed.EditorUser, sprintf(`{"name":"案件 %d"}`, i)); rec.Code != http.StatusCreated {
+			t.Fatalf("作成: got %d", rec.Code)
+		}
+	}
+
+	var names []string
+	path := "/api/v1/projects?limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("ページが終わらない")
+		}
+		rec := DoAPIRequest(e, http.MethodGet, path, &seed.EditorUser, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("一覧: got %d: %s", rec.Code, rec.Body.String())
+		}
+		b := decodeAPI(t, rec)
+		var rows []apiRecord
+		if err := json.Unmarshal(b.Data, &rows); err != nil {
+			t.Fatalf("data の解釈に失敗: %v", err)
+		}
+		for _, r := range rows {
+			names = append(names, r.Name)
+		}
+		if b.NextCursor == "" {
+			break
+		}
+		path = "/api/v1/projects?limit=2&cursor=" + b.NextCursor
+	}
+	want := "案件 3,案件 2,案件 1,案件 0,テストプロジェクト"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("一覧 = %s, want %s", got, want)
+	}
+
+	rec := DoAPIRequest(e, http.MethodGet, "/api/v1/projects?q=件+2", &seed.EditorUser, "")
+	if b := decodeAPI(t, rec); !strings.Contains(string(b.Data), "案件 2") || strings.Contains(string(b.Data), "案件 1") {
+		t.Errorf("絞り込み: %s", rec.Body.String())
+	}
+	// % と _ はワイルドカードではなく文字として探す
+	if rec := DoAPIRequest(e, http.MethodPost, "/api/v1/projects", &seed.EditorUser, `{"name":"進捗 100%"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("作成: got %d", rec.Code)
+	}
+	for q, want := range map[string]string{"%25": "進捗 100%", "_": ""} {
+		rec := DoAPIRequest(e, http.MethodGet, "/api/v1/projects?q="+q, &seed.EditorUser, "")
+		var rows []apiRecord
+		if err := json.Unmarshal(decodeAPI(t, rec).Data, &rows); err != nil {
+			t.Fatalf("data の解釈に失敗: %v", err)
+		}
+		var got []string
+		for _, r := range rows {
+			got = append(got, r.Name)
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("q=%s の絞り込み = %v, want %q", q, got, want)
+		}
+	}
+
+	for _, bad := range []string{"?limit=0", "?limit=201", "?cursor=!!", "?cursor=YWJj"} {
+		rec := DoAPIRequest(e, http.MethodGet, "/api/v1/projects"+bad, &seed.EditorUser, "")
+		if rec.Code != http.StatusBadRequest || decodeAPI(t, rec).Error != "invalid_request" {
+			t.Errorf("%s: got %d: %s", bad, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+// 権限は RequireRole と同じ。エラーはすべて {"error","message"} の JSON で返る。
+func TestAPIv1_PermissionsAndErrors(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	projectPath := sprintf("/api/v1/projects/%d", seed.Project.ID)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		user   *database.User
+		body   string
+		status int
+		code   string
+	}{
+		{"未認証", http.MethodGet, "/api/v1/projects", nil, "", http.StatusUnauthorized, "unauthorized"},
+		{"viewer の作成", http.MethodPost, "/api/v1/projects", &seed.ViewerUser, `{"name":"x"}`, http.StatusForbidden, "forbidden"},
+		{"viewer の削除", http.MethodDelete, projectPath, &seed.ViewerUser, "", http.StatusForbidden, "forbidden"},
+		{"editor のユーザー一覧", http.MethodGet, "/api/v1/users", &seed.EditorUser, "", http.StatusForbidden, "forbidden"},
+		{"名前が空", http.MethodPost, "/api/v1/projects", &seed.EditorUser, `{"name":"  "}`, http.StatusBadRequest, "invalid_request"},
+		{"未知のフィールド", http.MethodPatch, projectPath, &seed.EditorUser, `{"title":"x"}`, http.StatusBadRequest, "invalid_request"},
+		{"JSON でない", http.MethodPost, "/api/v1/projects", &seed.EditorUser, "", http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{"存在しないプロジェクト", http.MethodPatch, "/api/v1/projects/99999", &seed.EditorUser, `{"name":"x"}`, http.StatusNotFound, "not_found"},
+		{"数値でない ID", http.MethodGet, "/api/v1/projects/abc", &seed.ViewerUser, "", http.StatusNotFound, "not_found"},
+		{"未定義のルート", http.MethodGet, "/api/v1/unknown", &seed.ViewerUser, "", http.StatusNotFound, "not_found"},
+		{"未定義のメソッド", http.MethodPut, "/api/v1/projects", &seed.AdminUser, "", http.StatusMethodNotAllowed, "method_not_allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := DoAPIRequest(e, tt.method, tt.path, tt.user, tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if b := decodeAPI(t, rec); b.Error != tt.code || b.Message == "" {
+				t.Errorf("error = %q, message = %q, want %q", b.Error, b.Message, tt.code)
+			}
+		})
+	}
+}
+
+// ユーザーの追加は管理画面と同じく招待になり、重複は 409。絞り込み・部分更新・削除ができ、
+// 自分自身は削除できない。
+func TestAPIv1_Users(t *testing.T) {
+	conn := SetupTestDB(t)
+	outbox := &testOutbox{}
+	e := SetupTestServerWithOutbox(t, conn, outbox)
+	seed := SeedTestData(t, conn)
+
+	rec := DoAPIRequest(e, http.MethodPost, "/api/v1/users", &seed.AdminUser, `{"name":"API 招待","email":"api@test.com","role":"editor"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("追加: got %d: %s", rec.Code, rec.Body.String())
+	}
+	if b := decodeAPI(t, rec); !b.InvitationSent {
+		t.Errorf("invitation_sent が false: %s", rec.Body.String())
+	}
+	created := decodeAPIRecord(t, rec)
+	if created.IsActive || created.Role != "editor" {
+		t.Errorf("招待中の editor になっていない: %+v", created)
+	}
+	inviteToken(t, outbox, "api@test.com")
+
+	rec = DoAPIRequest(e, http.MethodPost, "/api/v1/users", &seed.AdminUser, `{"name":"重複","email":"api@test.com","role":"viewer"}`)
+	if rec.Code != http.StatusConflict || decodeAPI(t, rec).Error != "conflict" {
+		t.Errorf("重複: got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = DoAPIRequest(e, http.MethodPost, "/api/v1/users", &seed.AdminUser, `{"name":"不正","email":"bad@test.com","role":"owner"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("不正なロール: got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = DoAPIRequest(e, http.MethodGet, "/api/v1/users?status=inactive", &seed.AdminUser, "")
+	var rows []apiRecord
+	if err := json.Unmarshal(decodeAPI(t, rec).Data, &rows); err != nil || len(rows) != 1 || rows[0].Email != "api@test.com" {
+		t.Errorf("status=inactive: %v, %s", err, rec.Body.String())
+	}
+	rec = DoAPIRequest(e, http.MethodGet, "/api/v1/users?role=viewer&q=deletable", &seed.AdminUser, "")
+	if err := json.Unmarshal(decodeAPI(t, rec).Data, &rows); err != nil || len(rows) != 1 || rows[0].ID != seed.DeletableUser.ID {
+		t.Errorf("role・q の絞り込み: %v, %s", err, rec.Body.String())
+	}
+
+	// 指定しなかった項目（名前・状態）は変わらない
+	rec = DoAPIRequest(e, http.MethodPatch, sprintf("/api/v1/users/%d", seed.ViewerUser.ID), &seed.AdminUser, `{"role":"editor"}`)
+	if got := decodeAPIRecord(t, rec); rec.Code != http.StatusOK || got.Role != "editor" || got.Name != "Viewer" || !got.IsActive {
+		t.Errorf("部分更新: got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = DoAPIRequest(e, http.MethodDelete, sprintf("/api/v1/users/%d", seed.AdminUser.ID), &seed.AdminUser, "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("自分自身の削除: got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := DoAPIRequest(e, http.MethodDelete, sprintf("/api/v1/users/%d", seed.DeletableUser.ID), &seed.AdminUser, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("削除: got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := queryFromConn(conn).GetUserByID(t.Context(), seed.DeletableUser.ID); err == nil {
+		t.Errorf("ユーザーが削除されていない")
+	}
+}
+
+// API トークンでも呼べ、read スコープでは書き込めない。読み取り専用モードは 503 の JSON。
+func TestAPIv1_BearerTokenAndReadOnly(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	api := SetupSessionTestServer(t, conn, newTestMagicLink(t, conn))
+	seed := SeedTestData(t, conn)
+
+	readToken := createAPIToken(t, e, &seed.EditorUser, apitoken.ScopeRead)
+	if rec := doBearerRequest(api, http.MethodGet, "/api/v1/projects", readToken, ""); rec.Code != http.StatusOK {
+		t.Errorf("read トークンで一覧: got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := doBearerRequest(api, http.MethodPost, "/api/v1/projects", readToken, `{"name":"x"}`)
+	if rec.Code != http.StatusForbidden || decodeAPI(t, rec).Error != "insufficient_scope" {
+		t.Errorf("read トークンで作成: got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := DoSSERequest(e, http.MethodPost, "/api/sse/admin/maintenance/read-only/toggle", &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("読み取り専用モード ON: got %d", rec.Code)
+	}
+	rec = DoAPIRequest(e, http.MethodPost, "/api/v1/projects", &seed.AdminUser, `{"name":"x"}`)
+	if rec.Code != http.StatusServiceUnavailable || decodeAPI(t, rec).Error != "read_only" {
+		t.Errorf("読み取り専用中の作成: got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,271 @@
+package integration
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// listAllAuditLogs は絞り込みなしで監査ログを新しい順に返す。
+func listAllAuditLogs(t *testing.T, q *database.Queries) []database.AuditLog {
+	t.Helper()
+	entries, err := q.ListAuditLogsAfter(t.Context(), database.ListAuditLogsAfterParams{ID: 0, Limit: 1000})
+	if err != nil {
+		t.Fatalf("監査ログの取得に失敗: %v", err)
+	}
+	// ListAuditLogsAfter は古い順なので反転する
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+func TestAudit_UpdateUserRecordsBeforeAfter(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	rec := DoSSERequest(e, http.MethodPut, sprintf("/api/sse/admin/users/%d", seed.ViewerUser.ID),
+		&seed.AdminUser,
+		`{"editName":"Viewer","editRole":"editor","editStatus":"active"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ステータスコード = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	entries := listAllAuditLogs(t, q)
+	if len(entries) != 1 {
+		t.Fatalf("監査ログ件数 = %d, want 1", len(entries))
+	}
+	got := entries[0]
+	if got.Action != audit.ActionUserUpdate || got.TargetType != audit.TargetUser || got.TargetID != sprintf("%d", seed.ViewerUser.ID) {
+		t.Errorf("action/target = %s %s#%s", got.Action, got.TargetType, got.TargetID)
+	}
+	if got.ActorID != seed.AdminUser.ID || got.ActorEmail != seed.AdminUser.Email {
+		t.Errorf("actor = %d %s, want %d %s", got.ActorID, got.ActorEmail, seed.AdminUser.ID, seed.AdminUser.Email)
+	}
+
+	var before, after database.User
+	if err := json.Unmarshal([]byte(got.BeforeJson), &before); err != nil {
+		t.Fatalf("before_json が読めない: %v", err)
+	}
+	if err := json.Unmarshal([]byte(got.AfterJson), &after); err != nil {
+		t.Fatalf("after_json が読めない: %v", err)
+	}
+	if before.Role != "viewer" || after.Role != "editor" {
+		t.Errorf("role before/after = %q/%q, want viewer/editor", before.Role, after.Role)
+	}
+}
+
+// 変更ごとに 1 件ずつ、操作種別どおりに記録される。
+func TestAudit_RecordsEachMutation(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	steps := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/api/sse/admin/users/create", `{"newName":"A","newEmail":"a@test.com","newRole":"viewer"}`},
+		{http.MethodDelete, sprintf("/api/sse/admin/users/%d", seed.DeletableUser.ID), ""},
+		{http.MethodPost, "/api/sse/projects/new", `{"name":"監査対象"}`},
+		{http.MethodPut, sprintf("/api/sse/projects/%d", seed.Project.ID), `{"name":"改名"}`},
+		{http.MethodDelete, sprintf("/api/sse/projects/%d", seed.Project.ID), ""},
+		{http.MethodPost, "/api/sse/admin/maintenance/toggle", ""},
+		{http.MethodPost, "/api/sse/admin/maintenance/toggle", ""},
+	}
+	for _, s := range steps {
+		rec := DoSSERequest(e, s.method, s.path, &seed.AdminUser, s.body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: ステータスコード = %d, body: %s", s.method, s.path, rec.Code, rec.Body.String())
+		}
+	}
+
+	entries := listAllAuditLogs(t, q)
+	want := []string{
+		audit.ActionMaintenanceToggle,
+		audit.ActionMaintenanceToggle,
+		audit.ActionProjectDelete,
+		audit.ActionProjectUpdate,
+		audit.ActionProjectCreate,
+		audit.ActionUserDelete,
+		audit.ActionUserCreate,
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("監査ログ件数 = %d, want %d", len(entries), len(want))
+	}
+	for i, a := range want {
+		if entries[i].Action != a {
+			t.Errorf("entries[%d].Action = %q, want %q", i, entries[i].Action, a)
+		}
+	}
+	if entries[0].BeforeJson != `{"enabled":true}` || entries[0].AfterJson != `{"enabled":false}` {
+		t.Errorf("メンテ切替の before/after = %s / %s", entries[0].BeforeJson, entries[0].AfterJson)
+	}
+	if entries[2].AfterJson != "" || entries[2].BeforeJson == "" {
+		t.Errorf("削除は before のみ持つはず: before=%q after=%q", entries[2].BeforeJson, entries[2].AfterJson)
+	}
+}
+
+func TestAudit_ImportRecordsEachCreatedUser(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	data := createExcelBytes(t, []excelRow{
+		{"インポート1", "import1@test.com", "viewer"},
+		{"インポート2", "import2@test.com", "editor"},
+		{"重複", "admin@test.com", "viewer"},
+	})
+	rec := doFileUpload(e, "/admin/users/import", &seed.AdminUser, "file", "users.xlsx", data)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ステータスコード = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	entries := listAllAuditLogs(t, q)
+	if len(entries) != 2 {
+		t.Fatalf("監査ログ件数 = %d, want 2（エラー行は記録しない）", len(entries))
+	}
+	for _, en := range entries {
+		if en.Action != audit.ActionUserImport {
+			t.Errorf("Action = %q, want %q", en.Action, audit.ActionUserImport)
+		}
+	}
+}
+
+// 失敗した変更・何も変えなかった操作は監査ログに残らない。
+func TestAudit_FailedMutationLeavesNoEntry(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	rec := DoSSERequest(e, http.MethodPost, "/api/sse/admin/users/create", &seed.AdminUser,
+		`{"newName":"Dup","newEmail":"viewer@test.com","newRole":"viewer"}`)
+	if rec.Code == http.StatusOK {
+		t.Fatalf("重複メールでの作成が成功してしまった")
+	}
+	// 存在しない行の削除は冪等に成功するが、変更が無いので記録もしない
+	rec = DoSSERequest(e, http.MethodDelete, "/api/sse/projects/99999", &seed.AdminUser, "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("存在しないプロジェクトの削除: got %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	if entries := listAllAuditLogs(t, q); len(entries) != 0 {
+		t.Errorf("監査ログ件数 = %d, want 0", len(entries))
+	}
+}
+
+func TestAudit_VerifyDetectsTampering(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	for _, name := range []string{"P1", "P2", "P3"} {
+		rec := DoSSERequest(e, http.MethodPost, "/api/sse/projects/new", &seed.EditorUser, sprintf(`{"name":%q}`, name))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("プロジェクト作成: got %d", rec.Code)
+		}
+	}
+
+	checked, err := audit.Verify(t.Context(), q)
+	if err != nil {
+		t.Fatalf("改ざん前の検証でエラー: %v", err)
+	}
+	if checked != 3 {
+		t.Errorf("検証件数 = %d, want 3", checked)
+	}
+
+	entries := listAllAuditLogs(t, q)
+	middle := entries[1]
+
+	// 内容の書き換えはその行のハッシュ不一致として検出される
+	if _, err := conn.Exec(`UPDATE audit_log SET actor_email = 'someone@else.com' WHERE id = ?`, middle.ID); err != nil {
+		t.Fatalf("改ざん用 UPDATE に失敗: %v", err)
+	}
+	_, err = audit.Verify(t.Context(), q)
+	var verr *audit.VerifyError
+	if !errors.As(err, &verr) || verr.EntryID != middle.ID {
+		t.Fatalf("書き換えを検出できない: err=%v", err)
+	}
+
+	// 行の削除は次の行の prev_hash 不一致として検出される
+	if _, err := conn.Exec(`DELETE FROM audit_log WHERE id = ?`, middle.ID); err != nil {
+		t.Fatalf("改ざん用 DELETE に失敗: %v", err)
+	}
+	_, err = audit.Verify(t.Context(), q)
+	if !errors.As(err, &verr) || verr.EntryID != entries[0].ID {
+		t.Fatalf("削除を検出できない: err=%v", err)
+	}
+}
+
+func TestAudit_PageAccessAndFilter(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	DoSSERequest(e, http.MethodPost, "/api/sse/projects/new", &seed.EditorUser, `{"name":"by editor"}`)
+	DoSSERequest(e, http.MethodPut, sprintf("/api/sse/admin/users/%d", seed.ViewerUser.ID), &seed.AdminUser,
+		`{"editName":"Viewer","editRole":"viewer","editStatus":"inactive"}`)
+
+	routes := []routeTestCase{
+		{
+			Name:   "GET /admin/audit（監査ログ）",
+			Method: http.MethodGet, Path: "/admin/audit",
+			Permission: roles.LogsRead,
+		},
+	}
+	runPermissionMatrix(t, e, seed, routes)
+
+	// 操作者で絞り込むと editor の操作だけが返る
+	signals := url.QueryEscape(`{"auditActor":"editor@","auditTarget":"","auditTargetId":"","auditFrom":"","auditTo":""}`)
+	rec := DoRequest(e, http.MethodGet, "/admin/audit/table?datastar="+signals, &seed.AdminUser)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("絞り込み: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "プロジェクト作成") || strings.Contains(body, "ユーザー更新") {
+		t.Errorf("操作者の絞り込みが効いていない: %s", body)
+	}
+
+	// % と _ はワイルドカードではなく文字として探す
+	for _, actor := range []string{"%", "_", `\\`} {
+		signals = url.QueryEscape(sprintf(`{"auditActor":"%s","auditTarget":"","auditTargetId":"","auditFrom":"","auditTo":""}`, actor))
+		rec = DoRequest(e, http.MethodGet, "/admin/audit/table?datastar="+signals, &seed.AdminUser)
+		if !strings.Contains(rec.Body.String(), "条件に一致する監査ログはありません") {
+			t.Errorf("操作者 %q の絞り込みで一致した: %s", actor, rec.Body.String())
+		}
+	}
+
+	// 対象で絞り込むとユーザーの変更だけが返る
+	signals = url.QueryEscape(`{"auditActor":"","auditTarget":"user","auditTargetId":"","auditFrom":"","auditTo":""}`)
+	rec = DoRequest(e, http.MethodGet, "/admin/audit/table?datastar="+signals, &seed.AdminUser)
+	body = rec.Body.String()
+	if !strings.Contains(body, "ユーザー更新") || strings.Contains(body, "プロジェクト作成") {
+		t.Errorf("対象の絞り込みが効いていない: %s", body)
+	}
+
+	// 過去の日付範囲では何も返らない
+	signals = url.QueryEscape(`{"auditActor":"","auditTarget":"","auditTargetId":"","auditFrom":"2000-01-01","auditTo":"2000-12-31"}`)
+	rec = DoRequest(e, http.MethodGet, "/admin/audit/table?datastar="+signals, &seed.AdminUser)
+	if !strings.Contains(rec.Body.String(), "条件に一致する監査ログはありません") {
+		t.Errorf("日付の絞り込みが効いていない: %s", rec.Body.String())
+	}
+
+	// 不正な日付は 400
+	signals = url.QueryEscape(`{"auditFrom":"2000/01/01"}`)
+	rec = DoRequest(e, http.MethodGet, "/admin/audit/table?datastar="+signals, &seed.AdminUser)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("不正な日付: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,96 @@
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
+)
+
+var (
+	cspNonce       = regexp.MustCompile(`'nonce-([^']+)'`)
+	inlineScript   = regexp.MustCompile(`<script(?:\s[^>]*)?>`)
+	inlineHandlers = regexp.MustCompile(`\son[a-z]+="`)
+)
+
+// 画面の inline script はすべてヘッダの nonce を持ち、onclick などの inline のイベント
+// ハンドラ（CSP で動かない）は使わない。
+func TestCSP_PagesUseNonceForInlineScripts(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	for path, user := range map[string]*database.User{
+		"/projects":           &seed.AdminUser,
+		"/admin/users":        &seed.AdminUser,
+		"/admin/roles":        &seed.AdminUser,
+		"/admin/users/import": &seed.AdminUser,
+		"/auth/login":         nil,
+	} {
+		rec := DoRequest(e, http.MethodGet, path, user)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", path, rec.Code)
+		}
+		m := cspNonce.FindStringSubmatch(rec.Header().Get("Content-Security-Policy"))
+		if m == nil {
+			t.Fatalf("%s: CSP に nonce が無い: %q", path, rec.Header().Get("Content-Security-Policy"))
+		}
+		body := rec.Body.String()
+		for _, tag := range inlineScript.FindAllString(body, -1) {
+			if !strings.Contains(tag, " src=") && !strings.Contains(tag, fmt.Sprintf(`nonce="%s"`, m[1])) {
+				t.Errorf("%s: nonce の無い inline script: %s", path, tag)
+			}
+		}
+		if h := inlineHandlers.FindString(body); h != "" {
+			t.Errorf("%s: inline のイベントハンドラが残っている: %q", path, h)
+		}
+	}
+}
+
+// SSE の応答は <script> を patch しない（nonce が無く CSP で止まる）。ダイアログの表示や
+// トーストの消去は data-init で行う。
+func TestCSP_SSEResponsesDoNotPatchScripts(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"ダイアログ": DoSSERequest(e, http.MethodGet, fmt.Sprintf("/api/sse/projects/%d/edit", seed.Project.ID), &seed.AdminUser, ""),
+		"トースト":  DoSSERequest(e, http.MethodPost, "/api/sse/projects/new", &seed.AdminUser, `{"name":"CSP テスト"}`),
+	} {
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || strings.Contains(body, "<script") || !strings.Contains(body, "data-init=") {
+			t.Errorf("%s: status = %d, body = %s", name, rec.Code, body)
+		}
+	}
+}
+
+// 違反報告はログインしたブラウザから CSRF トークン無しで届いても受け付ける。
+func TestCSP_ReportEndpoint(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	report := `{"csp-report":{"document-uri":"http://localhost:8080/projects","effective-directive":"script-src-elem","blocked-uri":"inline","line-number":12}}`
+	req := httptest.NewRequest(http.MethodPost, appMiddleware.CSPReportPath, strings.NewReader(report))
+	req.Header.Set("Content-Type", "application/csp-report")
+	req.Header.Set("X-Test-User-ID", fmt.Sprint(seed.AdminUser.ID))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	// 上限を超える報告は読まない。
+	req = httptest.NewRequest(http.MethodPost, appMiddleware.CSPReportPath, strings.NewReader(`{"csp-report":{"blocked-uri":"`+strings.Repeat("a", 32<<10)+`"}}`))
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("大きすぎる報告: status = %d", rec.Code)
+	}
+}
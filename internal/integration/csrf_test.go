@@ -0,0 +1,112 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
+)
+
+// forgedRequest は別のサイトのページからブラウザに送らせたリクエストを作る
+// （セッション Cookie は SameSite を抜けて付いたものとする。トークンは知らない）。
+func forgedRequest(method, path, contentType, body string, cookie *http.Cookie) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Origin", "https://evil.example")
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	req.AddCookie(cookie)
+	return req
+}
+
+// 別のサイトから送らせた書き込みは、SSE・フォーム・JSON API のどれも 403 で断り、何も変えない。
+func TestCSRF_ForgedCrossOriginRequestsAreRejected(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+	q := queryFromConn(conn)
+	admin := LoginSession(t, ml, seed.AdminUser)
+
+	before, err := q.ListProjects(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		req  *http.Request
+	}{
+		{"SSE", forgedRequest(http.MethodPost, "/api/sse/projects/new", "application/json", `{"name":"forged"}`, admin)},
+		{"フォーム", forgedRequest(http.MethodPost, "/api/sse/projects/new", "application/x-www-form-urlencoded", "name=forged", admin)},
+		{"JSON API", forgedRequest(http.MethodPost, "/api/v1/projects", "application/json", `{"name":"forged"}`, admin)},
+		{"ログアウト", forgedRequest(http.MethodPost, "/auth/logout", "application/x-www-form-urlencoded", "", admin)},
+	} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, tc.req)
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), appMiddleware.CSRFOriginMessage) {
+			t.Errorf("%s: status = %d, body = %s", tc.name, rec.Code, rec.Body.String())
+		}
+	}
+	if after, _ := q.ListProjects(t.Context()); len(after) != len(before) {
+		t.Errorf("断られたのにプロジェクトが作られた: %d -> %d", len(before), len(after))
+	}
+	if n := countSessions(t, conn, seed.AdminUser.Email); n != 1 {
+		t.Errorf("断られたのにログアウトされた: sessions = %d", n)
+	}
+}
+
+// 同じオリジンからでも、セッションのトークンが無い・違う書き込みは断る。画面には
+// トークンが埋め込まれ、それを付ければ通る。
+func TestCSRF_SessionTokenIsRequired(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+	editor := LoginSession(t, ml, seed.EditorUser)
+	token := appMiddleware.CSRFToken(editor.Value)
+
+	page := DoCookieRequest(e, http.MethodGet, "/projects", editor)
+	if !strings.Contains(page.Body.String(), `<meta name="csrf-token" content="`+token+`"`) {
+		t.Fatalf("画面に CSRF トークンが埋め込まれていない")
+	}
+
+	send := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/sse/projects/new", strings.NewReader(`{"name":"CSRF テスト"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Datastar-Request", "true")
+		req.Header.Set("Sec-Fetch-Site", "same-origin")
+		if token != "" {
+			req.Header.Set(appMiddleware.CSRFHeader, token)
+		}
+		req.AddCookie(editor)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	for name, bad := range map[string]string{"トークン無し": "", "別のセッションのトークン": appMiddleware.CSRFToken("other")} {
+		rec := send(bad)
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), appMiddleware.CSRFTokenMessage) {
+			t.Errorf("%s: status = %d, body = %s", name, rec.Code, rec.Body.String())
+		}
+		// 古いトークンを持つ画面が送り直せるよう、今のトークンを返す。
+		if got := rec.Header().Get(appMiddleware.CSRFHeader); got != token {
+			t.Errorf("%s: %s = %q, want 今のトークン", name, appMiddleware.CSRFHeader, got)
+		}
+	}
+	if rec := send(token); rec.Code != http.StatusOK {
+		t.Fatalf("正しいトークン: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	// ネイティブのフォーム（ログアウト）は csrf_token フィールドで送る。
+	req := httptest.NewRequest(http.MethodPost, "/auth/logout", strings.NewReader(appMiddleware.CSRFFormField+"="+token))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "http://localhost:8080")
+	req.AddCookie(editor)
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	if n := countSessions(t, conn, seed.EditorUser.Email); n != 0 {
+		t.Errorf("フォームのトークンでログアウトできない: sessions = %d", n)
+	}
+}
@@ -0,0 +1,281 @@
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// createCustomRole は admin として /admin/roles からカスタムロールを作る。
+// カスタムロールはプロセス内に読み込まれるので、テストの終わりに空へ戻す。
+func createCustomRole(t *testing.T, h http.Handler, seed SeedData, name string, perms ...roles.Permission) {
+	t.Helper()
+	t.Cleanup(func() { roles.SetCustom(nil) })
+	var checked []string
+	for _, p := range perms {
+		checked = append(checked, fmt.Sprintf("%q:true", strings.ReplaceAll(string(p), ".", "_")))
+	}
+	body := fmt.Sprintf(`{"newRoleName":%q,"newRoleLabel":"カスタム %s","newRolePerms":{%s}}`, name, name, strings.Join(checked, ","))
+	if rec := DoSSERequest(h, http.MethodPost, "/api/sse/admin/roles", &seed.AdminUser, body); rec.Code != http.StatusOK {
+		t.Fatalf("ロール作成: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCustomRole_CreateAssignAndAuthorize(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	createCustomRole(t, e, seed, "auditor", roles.LogsRead)
+	if !roles.IsValid("auditor") || !roles.Has("auditor", roles.LogsRead) || roles.Has("auditor", roles.UserManage) {
+		t.Fatalf("auditor の権限が読み込まれていない: %v", roles.PermissionsOf("auditor"))
+	}
+	if !slices.Contains(roles.Names(), "auditor") {
+		t.Errorf("Names() = %v, auditor が含まれない", roles.Names())
+	}
+
+	row, err := q.GetCustomRole(t.Context(), "auditor")
+	if err != nil {
+		t.Fatalf("custom_roles に保存されていない: %v", err)
+	}
+	if row.Permissions != string(roles.LogsRead) {
+		t.Errorf("permissions = %q, want %q", row.Permissions, roles.LogsRead)
+	}
+	entries := listAllAuditLogs(t, q)
+	if len(entries) == 0 || entries[0].Action != audit.ActionRoleCreate || entries[0].TargetType != audit.TargetRole || entries[0].TargetID != "auditor" {
+		t.Errorf("ロール作成の監査ログが記録されていない: %+v", entries)
+	}
+
+	// UpdateUserSSE がカスタムロールを受け付ける。
+	body := `{"editName":"Viewer","editRole":"auditor","editStatus":"active"}`
+	path := fmt.Sprintf("/api/sse/admin/users/%d", seed.ViewerUser.ID)
+	if rec := DoSSERequest(e, http.MethodPut, path, &seed.AdminUser, body); rec.Code != http.StatusOK {
+		t.Fatalf("カスタムロールへの変更: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	auditor, err := q.GetUserByID(t.Context(), seed.ViewerUser.ID)
+	if err != nil || auditor.Role != "auditor" {
+		t.Fatalf("ロールが変わっていない: %+v, %v", auditor, err)
+	}
+
+	// 付与した権限のルートだけ通る。
+	if rec := DoRequest(e, http.MethodGet, "/admin/audit", &auditor); rec.Code != http.StatusOK {
+		t.Errorf("GET /admin/audit = %d, want 200", rec.Code)
+	}
+	if rec := DoRequest(e, http.MethodGet, "/admin/users", &auditor); rec.Code != http.StatusForbidden {
+		t.Errorf("GET /admin/users = %d, want 403", rec.Code)
+	}
+
+	// 権限の変更は次のリクエストから効く。
+	body = fmt.Sprintf(`{"editRoleLabel":"監査担当","editRolePerms":{%q:true}}`, strings.ReplaceAll(string(roles.UserManage), ".", "_"))
+	if rec := DoSSERequest(e, http.MethodPut, "/api/sse/admin/roles/auditor", &seed.AdminUser, body); rec.Code != http.StatusOK {
+		t.Fatalf("ロール更新: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := DoRequest(e, http.MethodGet, "/admin/audit", &auditor); rec.Code != http.StatusForbidden {
+		t.Errorf("更新後 GET /admin/audit = %d, want 403", rec.Code)
+	}
+	if rec := DoRequest(e, http.MethodGet, "/admin/users", &auditor); rec.Code != http.StatusOK {
+		t.Errorf("更新後 GET /admin/users = %d, want 200", rec.Code)
+	}
+	if got := roles.Label("auditor"); got != "監査担当" {
+		t.Errorf("Label = %q, want 監査担当", got)
+	}
+
+	// 一覧画面にも出る。
+	rec := DoRequest(e, http.MethodGet, "/admin/roles", &seed.AdminUser)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "監査担当") {
+		t.Errorf("GET /admin/roles = %d, 監査担当が表示されない", rec.Code)
+	}
+}
+
+func TestCustomRole_Validation(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	createCustomRole(t, e, seed, "project-manager", roles.ProjectWrite)
+
+	cases := []struct {
+		name string
+		body string
+	}{
+		{"組み込みロールの名前", `{"newRoleName":"admin","newRoleLabel":"管理者2"}`},
+		{"重複", `{"newRoleName":"project-manager","newRoleLabel":"重複"}`},
+		{"使えない文字", `{"newRoleName":"監査","newRoleLabel":"監査"}`},
+		{"数字で始まる", `{"newRoleName":"1st","newRoleLabel":"一番"}`},
+		{"表示名が空", `{"newRoleName":"auditor","newRoleLabel":" "}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := DoSSERequest(e, http.MethodPost, "/api/sse/admin/roles", &seed.AdminUser, tc.body); rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400, body = %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// 存在しないロールへの変更は引き続き拒否される。
+	body := `{"editName":"Viewer","editRole":"auditor","editStatus":"active"}`
+	path := fmt.Sprintf("/api/sse/admin/users/%d", seed.ViewerUser.ID)
+	if rec := DoSSERequest(e, http.MethodPut, path, &seed.AdminUser, body); rec.Code != http.StatusBadRequest {
+		t.Errorf("未知のロールへの変更: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestCustomRole_BuiltInRolesAreFixed(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	for _, name := range roles.All {
+		if rec := DoSSERequest(e, http.MethodDelete, "/api/sse/admin/roles/"+name, &seed.AdminUser, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("DELETE %s: status = %d, want 400", name, rec.Code)
+		}
+		if rec := DoSSERequest(e, http.MethodPut, "/api/sse/admin/roles/"+name, &seed.AdminUser, `{"editRoleLabel":"x"}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: status = %d, want 400", name, rec.Code)
+		}
+		if !roles.IsValid(name) {
+			t.Errorf("組み込みロール %s が無効になった", name)
+		}
+	}
+}
+
+func TestCustomRole_DeleteRefusedWhileInUse(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	createCustomRole(t, e, seed, "auditor", roles.LogsRead)
+	path := fmt.Sprintf("/api/sse/admin/users/%d", seed.ViewerUser.ID)
+	if rec := DoSSERequest(e, http.MethodPut, path, &seed.AdminUser, `{"editName":"Viewer","editRole":"auditor","editStatus":"active"}`); rec.Code != http.StatusOK {
+		t.Fatalf("カスタムロールへの変更: status = %d", rec.Code)
+	}
+
+	rec := DoSSERequest(e, http.MethodDelete, "/api/sse/admin/roles/auditor", &seed.AdminUser, "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "1 人") {
+		t.Fatalf("使用中のロールの削除: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := DoSSERequest(e, http.MethodPut, path, &seed.AdminUser, `{"editName":"Viewer","editRole":"viewer","editStatus":"active"}`); rec.Code != http.StatusOK {
+		t.Fatalf("ロールを戻す: status = %d", rec.Code)
+	}
+	if rec := DoSSERequest(e, http.MethodDelete, "/api/sse/admin/roles/auditor", &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("ロール削除: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if roles.IsValid("auditor") {
+		t.Error("削除したロールがまだ有効")
+	}
+	if _, err := q.GetCustomRole(t.Context(), "auditor"); err == nil {
+		t.Error("custom_roles から削除されていない")
+	}
+	if entries := listAllAuditLogs(t, q); entries[0].Action != audit.ActionRoleDelete {
+		t.Errorf("最新の監査ログ = %s, want %s", entries[0].Action, audit.ActionRoleDelete)
+	}
+}
+
+func TestCustomRole_UserImportAcceptsCustomRole(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	createCustomRole(t, e, seed, "auditor", roles.LogsRead)
+	data := createExcelBytes(t, []excelRow{{Name: "監査担当", Email: "auditor@test.com", Role: "auditor"}})
+	rec := doFileUpload(e, "/admin/users/import", &seed.AdminUser, "file", "users.xlsx", data)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	user, err := queryFromConn(conn).GetUserByEmail(t.Context(), "auditor@test.com")
+	if err != nil || user.Role != "auditor" {
+		t.Fatalf("カスタムロールでインポートされていない: %+v, %v", user, err)
+	}
+}
+
+func TestCustomRole_LoadFromDatabase(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	createCustomRole(t, e, seed, "auditor", roles.LogsRead, roles.APIDocsRead)
+	roles.SetCustom(nil)
+	if roles.IsValid("auditor") {
+		t.Fatal("SetCustom(nil) 後も auditor が有効")
+	}
+
+	// 起動時と同じく DB から読み直せる。
+	if err := roles.Load(t.Context(), queryFromConn(conn)); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want := []roles.Permission{roles.LogsRead, roles.APIDocsRead}
+	if got := roles.PermissionsOf("auditor"); !slices.Equal(got, want) {
+		t.Errorf("PermissionsOf(auditor) = %v, want %v", got, want)
+	}
+}
+
+// user.manage だけのロールは、自分の持たない権限を含むロールを割り当て・変更できず、
+// 自分のロールも変えられない（管理者になって権限を広げられない）。
+func TestCustomRole_UserManageCannotEscalate(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	createCustomRole(t, e, seed, "hr", roles.UserManage)
+	if _, err := conn.Exec(`UPDATE users SET role = ? WHERE id = ?`, "hr", seed.ViewerUser.ID); err != nil {
+		t.Fatal(err)
+	}
+	hr, err := q.GetUserByID(t.Context(), seed.ViewerUser.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refused := []struct {
+		name   string
+		target database.User
+		role   string
+	}{
+		{"自分を admin に", hr, roles.Admin},
+		{"他人を admin に", seed.DeletableUser, roles.Admin},
+		{"admin を降格", seed.AdminUser, roles.Viewer},
+		{"editor を変更", seed.EditorUser, roles.Viewer},
+	}
+	for _, c := range refused {
+		path := fmt.Sprintf("/api/sse/admin/users/%d", c.target.ID)
+		body := fmt.Sprintf(`{"editName":%q,"editRole":%q,"editStatus":"active"}`, c.target.Name, c.role)
+		if rec := DoSSERequest(e, http.MethodPut, path, &hr, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", c.name, rec.Code)
+		}
+		apiPath := fmt.Sprintf("/api/v1/users/%d", c.target.ID)
+		if rec := DoAPIRequest(e, http.MethodPatch, apiPath, &hr, fmt.Sprintf(`{"role":%q}`, c.role)); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s (API): status = %d, want 400", c.name, rec.Code)
+		}
+		if u, _ := q.GetUserByID(t.Context(), c.target.ID); u.Role != c.target.Role {
+			t.Errorf("%s: 断られたのにロールが変わった: %s", c.name, u.Role)
+		}
+	}
+
+	// 自分の権限の範囲のロールなら割り当てられる。
+	path := fmt.Sprintf("/api/sse/admin/users/%d", seed.DeletableUser.ID)
+	if rec := DoSSERequest(e, http.MethodPut, path, &hr, `{"editName":"Deletable","editRole":"hr","editStatus":"active"}`); rec.Code != http.StatusOK {
+		t.Errorf("hr の割り当て: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	// 招待とインポートでも admin は割り当てられない。
+	if rec := DoAPIRequest(e, http.MethodPost, "/api/v1/users", &hr, `{"name":"New","email":"new-admin@test.com","role":"admin"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("admin で招待: status = %d, want 400", rec.Code)
+	}
+	data := createExcelBytes(t, []excelRow{{Name: "管理者", Email: "imported-admin@test.com", Role: roles.Admin}})
+	if rec := doFileUpload(e, "/admin/users/import", &hr, "file", "users.xlsx", data); rec.Code != http.StatusOK {
+		t.Fatalf("インポート: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	for _, email := range []string{"new-admin@test.com", "imported-admin@test.com"} {
+		if _, err := q.GetUserByEmail(t.Context(), email); err == nil {
+			t.Errorf("%s: admin のユーザーが作られた", email)
+		}
+	}
+}
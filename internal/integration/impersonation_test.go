@@ -0,0 +1,161 @@
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/models"
+)
+
+// impersonatePath は「このユーザーとして表示」を始めるパス。
+func impersonatePath(user database.User) string {
+	return fmt.Sprintf("/api/sse/admin/users/%d/impersonate", user.ID)
+}
+
+// 管理者が viewer として表示すると viewer の見える範囲だけが見え、書き込みは断られる。
+// 開始と終了は管理者を操作者として監査ログに残る。
+func TestImpersonation_ViewAsUser(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+	q := queryFromConn(conn)
+
+	// viewer がメンバーでないプロジェクト（admin には project.all で見える）。
+	hidden, err := q.CreateProject(t.Context(), database.CreateProjectParams{Name: "管理者だけに見えるプロジェクト", Status: models.ProjectStatusActive})
+	if err != nil {
+		t.Fatalf("プロジェクト作成に失敗: %v", err)
+	}
+	admin := LoginSession(t, ml, seed.AdminUser)
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", admin); !strings.Contains(rec.Body.String(), hidden.Name) {
+		t.Fatalf("表示前の admin に %s が見えない", hidden.Name)
+	}
+
+	rec := DoCookieRequest(e, http.MethodPost, impersonatePath(seed.ViewerUser), admin)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/projects") {
+		t.Fatalf("開始: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	rec = DoCookieRequest(e, http.MethodGet, "/projects", admin)
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("表示中の GET /projects = %d", rec.Code)
+	}
+	if strings.Contains(body, hidden.Name) || !strings.Contains(body, seed.Project.Name) {
+		t.Errorf("viewer の見える範囲になっていない: %s", body)
+	}
+	if !strings.Contains(body, seed.ViewerUser.Email+" として表示中です。") || !strings.Contains(body, "/api/sse/impersonation/stop") {
+		t.Errorf("表示中のバナーが出ていない")
+	}
+	if rec := DoCookieRequest(e, http.MethodGet, fmt.Sprintf("/api/sse/admin/users/%d/edit", seed.EditorUser.ID), admin); rec.Code != http.StatusForbidden {
+		t.Errorf("表示中のユーザー編集 = %d, want 403（viewer の権限）", rec.Code)
+	}
+
+	// 書き込みは画面・JSON API とも断る。
+	if rec := DoCookieRequest(e, http.MethodPut, "/api/sse/profile", admin); rec.Code != http.StatusForbidden {
+		t.Errorf("表示中の PUT /api/sse/profile = %d, want 403", rec.Code)
+	}
+	if rec := DoCookieRequest(e, http.MethodDelete, fmt.Sprintf("/api/v1/projects/%d", seed.Project.ID), admin); rec.Code != http.StatusForbidden ||
+		!strings.Contains(rec.Body.String(), `"impersonating"`) {
+		t.Errorf("表示中の DELETE /api/v1/projects = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if _, err := q.GetProject(t.Context(), seed.Project.ID); err != nil {
+		t.Errorf("表示中の削除が通っている: %v", err)
+	}
+
+	rec = DoCookieRequest(e, http.MethodPost, "/api/sse/impersonation/stop", admin)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/admin/users") {
+		t.Fatalf("終了: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", admin); !strings.Contains(rec.Body.String(), hidden.Name) ||
+		strings.Contains(rec.Body.String(), "として表示中です") {
+		t.Errorf("終了後も viewer として表示されている")
+	}
+
+	entries := listAllAuditLogs(t, q)
+	if len(entries) < 2 {
+		t.Fatalf("監査ログが足りない: %+v", entries)
+	}
+	for i, action := range []string{audit.ActionImpersonateStop, audit.ActionImpersonateStart} {
+		got := entries[i]
+		if got.Action != action || got.ActorEmail != seed.AdminUser.Email ||
+			got.TargetType != audit.TargetUser || got.TargetID != fmt.Sprint(seed.ViewerUser.ID) {
+			t.Errorf("監査ログ[%d] = %+v, want %s by %s", i, got, action, seed.AdminUser.Email)
+		}
+	}
+}
+
+// 表示できるのは権限を持つユーザーだけで、自分自身・管理者・無効なユーザーとしては表示できない。
+func TestImpersonation_Restrictions(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+	q := queryFromConn(conn)
+
+	editor := LoginSession(t, ml, seed.EditorUser)
+	if rec := DoCookieRequest(e, http.MethodPost, impersonatePath(seed.ViewerUser), editor); rec.Code != http.StatusForbidden {
+		t.Errorf("editor の開始: status = %d, want 403", rec.Code)
+	}
+
+	other, err := q.CreateUser(t.Context(), database.CreateUserParams{
+		Email: "admin2@test.com", Name: "Admin2", Role: "admin", IsActive: true,
+	})
+	if err != nil {
+		t.Fatalf("ユーザー作成に失敗: %v", err)
+	}
+	inactive, err := q.CreateUser(t.Context(), database.CreateUserParams{
+		Email: "inactive@test.com", Name: "Inactive", Role: "viewer", IsActive: false,
+	})
+	if err != nil {
+		t.Fatalf("ユーザー作成に失敗: %v", err)
+	}
+
+	admin := LoginSession(t, ml, seed.AdminUser)
+	for name, target := range map[string]database.User{"自分自身": seed.AdminUser, "他の管理者": other, "無効なユーザー": inactive} {
+		if rec := DoCookieRequest(e, http.MethodPost, impersonatePath(target), admin); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", name, rec.Code)
+		}
+	}
+	if rec := DoCookieRequest(e, http.MethodPost, "/api/sse/admin/users/99999/impersonate", admin); rec.Code != http.StatusNotFound {
+		t.Errorf("存在しないユーザー: status = %d, want 404", rec.Code)
+	}
+	if entries := listAllAuditLogs(t, q); len(entries) != 0 {
+		t.Errorf("断った開始が監査ログに残っている: %+v", entries)
+	}
+}
+
+// 表示中に相手が管理画面から無効化されたら、次のリクエストで管理者に戻る。
+func TestImpersonation_EndsWhenTargetDeactivated(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+	adminServer := SetupTestServer(t, conn)
+
+	admin := LoginSession(t, ml, seed.AdminUser)
+	if rec := DoCookieRequest(e, http.MethodPost, impersonatePath(seed.EditorUser), admin); rec.Code != http.StatusOK {
+		t.Fatalf("開始: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	// 表示中のユーザーをキャッシュに載せてから無効化する。
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", admin); !strings.Contains(rec.Body.String(), "として表示中です") {
+		t.Fatalf("表示が始まっていない: status = %d", rec.Code)
+	}
+	body := `{"editName":"Editor","editRole":"editor","editStatus":"inactive"}`
+	if rec := DoSSERequest(adminServer, http.MethodPut, fmt.Sprintf("/api/sse/admin/users/%d", seed.EditorUser.ID), &seed.AdminUser, body); rec.Code != http.StatusOK {
+		t.Fatalf("無効化: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	rec := DoCookieRequest(e, http.MethodGet, "/projects", admin)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "として表示中です") {
+		t.Errorf("無効化後も表示が続いている: status = %d", rec.Code)
+	}
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM impersonations`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("impersonations = %d, %v, want 0", n, err)
+	}
+}
@@ -0,0 +1,323 @@
+package integration
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/naozine/nz-magic-link/magiclink"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/loginpolicy"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
+)
+
+var inviteTokenRe = regexp.MustCompile(`/invite/accept\?token=([A-Za-z0-9_-]+)`)
+
+// inviteToken は outbox の最後の招待メールから to 宛てのトークンを取り出す。
+func inviteToken(t *testing.T, outbox *testOutbox, to string) string {
+	t.Helper()
+	msgs := outbox.Messages()
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].To != to {
+			continue
+		}
+		m := inviteTokenRe.FindStringSubmatch(msgs[i].Body)
+		if m == nil {
+			t.Fatalf("招待メールにリンクが無い: %s", msgs[i].Body)
+		}
+		return m[1]
+	}
+	t.Fatalf("%s 宛ての招待メールが送られていない", to)
+	return ""
+}
+
+// postAccept は招待の承認フォームを未ログインで送信する。
+func postAccept(h http.Handler, token string) *httptest.ResponseRecorder {
+	return DoRequest(h, http.MethodPost, "/invite/accept", nil, url.Values{"token": {token}}.Encode())
+}
+
+// sessionCookie はレスポンスで発行されたセッション Cookie を返す。
+func sessionCookie(t *testing.T, rec *httptest.ResponseRecorder, ml *magiclink.MagicLink) *http.Cookie {
+	t.Helper()
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == ml.Config.CookieName && c.Value != "" {
+			return c
+		}
+	}
+	t.Fatalf("セッション Cookie が発行されていない")
+	return nil
+}
+
+// 管理者が追加したユーザーは招待中になり、招待メールのリンクから承認すると有効化されてログインする。
+// リンクは 1 回しか使えない。
+func TestInvitation_CreateAndAccept(t *testing.T) {
+	conn := SetupTestDB(t)
+	outbox := &testOutbox{}
+	admin := SetupTestServerWithOutbox(t, conn, outbox)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+	q := queryFromConn(conn)
+
+	rec := DoSSERequest(admin, http.MethodPost, "/api/sse/admin/users/create", &seed.AdminUser,
+		`{"newName":"招待太郎","newEmail":"invitee@test.com","newRole":"viewer"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ユーザー追加: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "pending-invitations") || !strings.Contains(rec.Body.String(), "invitee@test.com") {
+		t.Errorf("招待中の一覧が patch されていない: %s", rec.Body.String())
+	}
+	token := inviteToken(t, outbox, "invitee@test.com")
+
+	if err := loginpolicy.AllowLogin(t.Context(), conn, "invitee@test.com", ""); err == nil || !strings.Contains(err.Error(), "招待の承認待ち") {
+		t.Errorf("承認前のログイン: got %v, want 招待の承認待ち", err)
+	}
+
+	// GET は確認画面だけを出し、トークンを消費しない
+	page := DoRequest(e, http.MethodGet, "/invite/accept?token="+token, nil)
+	if page.Code != http.StatusOK || !strings.Contains(page.Body.String(), "招待太郎") {
+		t.Fatalf("承認画面: got %d, body: %s", page.Code, page.Body.String())
+	}
+
+	rec = postAccept(e, token)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/projects" {
+		t.Fatalf("承認: got %d → %q, body: %s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	cookie := sessionCookie(t, rec, ml)
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", cookie); rec.Code != http.StatusOK {
+		t.Errorf("承認後のセッション: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	// ログイン元はセッション一覧に出る（メールのリンクでのログインとして記録する）。
+	var ip, method string
+	if err := conn.QueryRow(`SELECT ip, auth_method FROM session_details WHERE session_hash = ?`,
+		authsession.HashToken(cookie.Value)).Scan(&ip, &method); err != nil {
+		t.Errorf("承認で発行したセッションの記録が無い: %v", err)
+	} else if ip == "" || method != authsession.MethodMagicLink {
+		t.Errorf("セッションの記録 = ip %q, auth_method %q", ip, method)
+	}
+
+	user, err := q.GetUserByEmail(t.Context(), "invitee@test.com")
+	if err != nil {
+		t.Fatalf("ユーザーの取得に失敗: %v", err)
+	}
+	if !user.IsActive {
+		t.Errorf("承認後も IsActive = false")
+	}
+	if err := loginpolicy.AllowLogin(t.Context(), conn, user.Email, ""); err != nil {
+		t.Errorf("承認後のログイン: %v", err)
+	}
+
+	if rec := postAccept(e, token); rec.Code != http.StatusConflict {
+		t.Errorf("2 回目の承認: got %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if rec := DoRequest(e, http.MethodGet, "/invite/accept?token=bogus", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("不正なトークン: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+// 再送すると以前のリンクは使えなくなり、期限切れのリンクは承認できない。
+func TestInvitation_ResendAndExpiry(t *testing.T) {
+	conn := SetupTestDB(t)
+	outbox := &testOutbox{}
+	admin := SetupTestServerWithOutbox(t, conn, outbox)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+	q := queryFromConn(conn)
+
+	DoSSERequest(admin, http.MethodPost, "/api/sse/admin/users/create", &seed.AdminUser,
+		`{"newName":"Late","newEmail":"late@test.com","newRole":"viewer"}`)
+	oldToken := inviteToken(t, outbox, "late@test.com")
+	user, err := q.GetUserByEmail(t.Context(), "late@test.com")
+	if err != nil {
+		t.Fatalf("ユーザーの取得に失敗: %v", err)
+	}
+
+	if _, err := conn.Exec(`UPDATE invitations SET expires_at = ? WHERE user_id = ?`, "2000-01-01 00:00:00", user.ID); err != nil {
+		t.Fatalf("期限の書き換えに失敗: %v", err)
+	}
+	if rec := postAccept(e, oldToken); rec.Code != http.StatusGone {
+		t.Errorf("期限切れの承認: got %d, want %d", rec.Code, http.StatusGone)
+	}
+
+	path := sprintf("/api/sse/admin/users/%d/invitation", user.ID)
+	if rec := DoSSERequest(admin, http.MethodPost, path, &seed.EditorUser, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("editor からの再送: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec := DoSSERequest(admin, http.MethodPost, path, &seed.AdminUser, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("再送: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	newToken := inviteToken(t, outbox, "late@test.com")
+	if newToken == oldToken {
+		t.Fatalf("再送でトークンが作り直されていない")
+	}
+	if rec := postAccept(e, oldToken); rec.Code != http.StatusNotFound {
+		t.Errorf("再送前のリンク: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := postAccept(e, newToken); rec.Code != http.StatusSeeOther {
+		t.Errorf("再送後のリンク: got %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+
+	// 有効になったユーザーには再送できない
+	if rec := DoSSERequest(admin, http.MethodPost, path, &seed.AdminUser, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("有効なユーザーへの再送: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+// 取り消した招待のリンクは使えず、ユーザーは無効のまま一覧から消える。
+func TestInvitation_Revoke(t *testing.T) {
+	conn := SetupTestDB(t)
+	outbox := &testOutbox{}
+	admin := SetupTestServerWithOutbox(t, conn, outbox)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+	q := queryFromConn(conn)
+
+	DoSSERequest(admin, http.MethodPost, "/api/sse/admin/users/create", &seed.AdminUser,
+		`{"newName":"Revoked","newEmail":"revoked@test.com","newRole":"viewer"}`)
+	token := inviteToken(t, outbox, "revoked@test.com")
+	user, err := q.GetUserByEmail(t.Context(), "revoked@test.com")
+	if err != nil {
+		t.Fatalf("ユーザーの取得に失敗: %v", err)
+	}
+
+	path := sprintf("/api/sse/admin/users/%d/invitation", user.ID)
+	if rec := DoSSERequest(admin, http.MethodDelete, path, &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("取り消し: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if rec := DoSSERequest(admin, http.MethodDelete, path, &seed.AdminUser, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("2 回目の取り消し: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := postAccept(e, token); rec.Code != http.StatusNotFound {
+		t.Errorf("取り消し後のリンク: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	pending, err := q.ListPendingInvitations(t.Context())
+	if err != nil {
+		t.Fatalf("招待一覧の取得に失敗: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("招待中 = %d 件, want 0", len(pending))
+	}
+	if err := loginpolicy.AllowLogin(t.Context(), conn, user.Email, ""); err == nil || !strings.Contains(err.Error(), "ご利用いただけません") {
+		t.Errorf("取り消し後のログイン: got %v, want 無効アカウントとして拒否", err)
+	}
+}
+
+// メンテナンス中は承認もログインもせず、メンテナンスが終われば同じリンクで承認できる。
+func TestInvitation_AcceptDuringMaintenance(t *testing.T) {
+	conn := SetupTestDB(t)
+	outbox := &testOutbox{}
+	admin := SetupTestServerWithOutbox(t, conn, outbox)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+	q := queryFromConn(conn)
+
+	DoSSERequest(admin, http.MethodPost, "/api/sse/admin/users/create", &seed.AdminUser,
+		`{"newName":"Maint","newEmail":"maint@test.com","newRole":"viewer"}`)
+	token := inviteToken(t, outbox, "maint@test.com")
+
+	if err := maintenance.SetEnabled(t.Context(), q, true); err != nil {
+		t.Fatalf("SetEnabled: %v", err)
+	}
+	rec := postAccept(e, token)
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "メンテナンス中") {
+		t.Errorf("メンテナンス中の承認: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if n := countSessions(t, conn, "maint@test.com"); n != 0 {
+		t.Errorf("メンテナンス中にセッションが %d 件作られた", n)
+	}
+	if user, err := q.GetUserByEmail(t.Context(), "maint@test.com"); err != nil || user.IsActive {
+		t.Errorf("メンテナンス中に有効化された: %+v, %v", user, err)
+	}
+
+	if err := maintenance.SetEnabled(t.Context(), q, false); err != nil {
+		t.Fatalf("SetEnabled: %v", err)
+	}
+	if rec := postAccept(e, token); rec.Code != http.StatusSeeOther {
+		t.Errorf("メンテナンス後の承認: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// ゴミ箱に移した招待中のユーザーのリンクは、無効なリンクとして扱う（500 にしない）。
+func TestInvitation_TrashedUserLink(t *testing.T) {
+	conn := SetupTestDB(t)
+	outbox := &testOutbox{}
+	admin := SetupTestServerWithOutbox(t, conn, outbox)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+	q := queryFromConn(conn)
+
+	DoSSERequest(admin, http.MethodPost, "/api/sse/admin/users/create", &seed.AdminUser,
+		`{"newName":"Trashed","newEmail":"trashed@test.com","newRole":"viewer"}`)
+	token := inviteToken(t, outbox, "trashed@test.com")
+	user, err := q.GetUserByEmail(t.Context(), "trashed@test.com")
+	if err != nil {
+		t.Fatalf("ユーザーの取得に失敗: %v", err)
+	}
+	if rec := DoSSERequest(admin, http.MethodDelete, sprintf("/api/sse/admin/users/%d", user.ID), &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("削除: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := DoRequest(e, http.MethodGet, "/invite/accept?token="+token, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("承認画面: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := postAccept(e, token); rec.Code != http.StatusNotFound {
+		t.Errorf("承認: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if n := countSessions(t, conn, user.Email); n != 0 {
+		t.Errorf("ゴミ箱のユーザーのセッションが %d 件作られた", n)
+	}
+}
+
+// 一括インポートしたユーザーにも招待メールが送られる。送信に失敗した招待は「未送信」で残る。
+func TestInvitation_ImportSendsInvitations(t *testing.T) {
+	conn := SetupTestDB(t)
+	outbox := &testOutbox{}
+	e := SetupTestServerWithOutbox(t, conn, outbox)
+	seed := SeedTestData(t, conn)
+
+	data := createExcelBytes(t, []excelRow{
+		{Name: "A", Email: "a@test.com", Role: "viewer"},
+		{Name: "B", Email: "b@test.com", Role: "editor"},
+	})
+	if rec := doFileUpload(e, "/admin/users/import", &seed.AdminUser, "file", "users.xlsx", data); rec.Code != http.StatusOK {
+		t.Fatalf("インポート: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	inviteToken(t, outbox, "a@test.com")
+	inviteToken(t, outbox, "b@test.com")
+
+	outbox.Err = errors.New("smtp: connection refused")
+	data = createExcelBytes(t, []excelRow{{Name: "C", Email: "c@test.com", Role: "viewer"}})
+	if rec := doFileUpload(e, "/admin/users/import", &seed.AdminUser, "file", "users.xlsx", data); rec.Code != http.StatusOK {
+		t.Fatalf("インポート（送信失敗）: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	pending, err := queryFromConn(conn).ListPendingInvitations(t.Context())
+	if err != nil {
+		t.Fatalf("招待一覧の取得に失敗: %v", err)
+	}
+	sent := map[string]bool{}
+	for _, p := range pending {
+		sent[p.Email] = p.SentAt.Valid
+	}
+	want := map[string]bool{"a@test.com": true, "b@test.com": true, "c@test.com": false}
+	for email, wantSent := range want {
+		got, ok := sent[email]
+		if !ok {
+			t.Errorf("%s が招待中に無い", email)
+			continue
+		}
+		if got != wantSent {
+			t.Errorf("%s の送信済み = %v, want %v", email, got, wantSent)
+		}
+	}
+}
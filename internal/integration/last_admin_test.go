@@ -0,0 +1,121 @@
+package integration
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/loginpolicy"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+const lastAdminMessage = "有効な管理者が 1 人もいなくなるため変更できません"
+
+// 唯一の管理者は自分を無効化できない（理由をトーストで返し、何も変えない）。
+// 自分のロールはそもそも変えられない。別の管理者がいれば、その管理者が降格できる。
+func TestLastAdmin_SoleAdminCannotDemoteSelf(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+	path := fmt.Sprintf("/api/sse/admin/users/%d", seed.AdminUser.ID)
+
+	rec := DoSSERequest(e, http.MethodPut, path, &seed.AdminUser, `{"editName":"Admin","editRole":"admin","editStatus":"inactive"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), lastAdminMessage) {
+		t.Errorf("無効化: status = %d, 理由のトーストが無い: %s", rec.Code, rec.Body.String())
+	}
+	rec = DoSSERequest(e, http.MethodPut, path, &seed.AdminUser, `{"editName":"Admin","editRole":"editor","editStatus":"active"}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "自分自身のロールは変更できません") {
+		t.Errorf("降格: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if u, _ := q.GetUserByID(t.Context(), seed.AdminUser.ID); u.Role != seed.AdminUser.Role || !u.IsActive {
+		t.Errorf("断られたのに変更された: role = %s, active = %v", u.Role, u.IsActive)
+	}
+
+	// JSON API でも断る（400 とメッセージ）。
+	rec = DoAPIRequest(e, http.MethodPatch, fmt.Sprintf("/api/v1/users/%d", seed.AdminUser.ID), &seed.AdminUser, `{"is_active":false}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), lastAdminMessage) {
+		t.Errorf("API: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	// 別の管理者がいれば、その管理者が降格できる。
+	if _, err := conn.Exec(`UPDATE users SET role = ? WHERE id = ?`, roles.Admin, seed.EditorUser.ID); err != nil {
+		t.Fatal(err)
+	}
+	rec = DoSSERequest(e, http.MethodPut, path, &seed.EditorUser, `{"editName":"Admin","editRole":"editor","editStatus":"active"}`)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), lastAdminMessage) {
+		t.Fatalf("2 人目がいるときの降格: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if u, _ := q.GetUserByID(t.Context(), seed.AdminUser.ID); u.Role != roles.Editor {
+		t.Errorf("降格されない: role = %s", u.Role)
+	}
+}
+
+// 管理者がカスタムロールのユーザーだけなら、そのロールからユーザー管理の権限は外せない。
+func TestLastAdmin_CustomRoleKeepsUserManage(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	createCustomRole(t, e, seed, "superuser", roles.UserManage, roles.RoleManage)
+	if _, err := conn.Exec(`UPDATE users SET role = ? WHERE id = ?`, "superuser", seed.ViewerUser.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`UPDATE users SET is_active = 0 WHERE id = ?`, seed.AdminUser.ID); err != nil {
+		t.Fatal(err)
+	}
+	superuser, err := q.GetUserByID(t.Context(), seed.ViewerUser.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := fmt.Sprintf(`{"editRoleLabel":"スーパーユーザー","editRolePerms":{%q:true}}`, strings.ReplaceAll(string(roles.RoleManage), ".", "_"))
+	rec := DoSSERequest(e, http.MethodPut, "/api/sse/admin/roles/superuser", &superuser, body)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), lastAdminMessage) {
+		t.Fatalf("権限を外す: status = %d, 理由のトーストが無い: %s", rec.Code, rec.Body.String())
+	}
+	if !roles.Has("superuser", roles.UserManage) {
+		t.Error("断られたのにユーザー管理の権限が外れた")
+	}
+	if row, _ := q.GetCustomRole(t.Context(), "superuser"); !strings.Contains(row.Permissions, string(roles.UserManage)) {
+		t.Errorf("断られたのに保存された: %s", row.Permissions)
+	}
+}
+
+// 締め出されたときは promote-admin（loginpolicy.PromoteAdmin）で有効な管理者に戻せる。
+func TestLastAdmin_PromoteAdminRecovers(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	if _, err := conn.Exec(`UPDATE users SET is_active = 0 WHERE id = ?`, seed.AdminUser.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := roles.EnsureAdminRemains(t.Context(), q, nil); !errors.Is(err, roles.ErrNoAdminLeft) {
+		t.Fatalf("EnsureAdminRemains = %v, want ErrNoAdminLeft", err)
+	}
+
+	user, err := loginpolicy.PromoteAdmin(t.Context(), conn, seed.ViewerUser.Email)
+	if err != nil {
+		t.Fatalf("PromoteAdmin: %v", err)
+	}
+	if user.Role != roles.Admin || !user.IsActive {
+		t.Errorf("role = %s, active = %v", user.Role, user.IsActive)
+	}
+	if err := roles.EnsureAdminRemains(t.Context(), q, nil); err != nil {
+		t.Errorf("昇格後も管理者がいない: %v", err)
+	}
+	latest := listAllAuditLogs(t, q)[0]
+	if latest.Action != audit.ActionUserPromoteAdmin || latest.ActorEmail != "cli" || latest.TargetID != fmt.Sprint(seed.ViewerUser.ID) {
+		t.Errorf("監査ログ = %+v", latest)
+	}
+
+	if _, err := loginpolicy.PromoteAdmin(t.Context(), conn, "nobody@test.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("存在しないユーザー: err = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -17,7 +17,10 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/naozine/nz-magic-link/magiclink"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/appconfig"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
 	_ "modernc.org/sqlite"
 )
 
@@ -51,7 +54,8 @@ func setupHTTPBench(tb testing.TB, numUsers int) (http.Handler, *magiclink.Magic
 			role TEXT NOT NULL DEFAULT 'viewer',
 			is_active BOOLEAN NOT NULL DEFAULT 1,
 			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			deleted_at TIMESTAMP
 		);
 		CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(email);
 	`)
@@ -403,3 +407,58 @@ func benchHTTPBurstFull(b *testing.B, concurrency int) {
 			concurrency, int64(concurrency)-f, f, elapsed)
 	}
 }
+
+// ---------------------------------------------------------------------------
+// ベンチマーク: ログイン後のリクエスト（UserContextMiddleware）
+// ---------------------------------------------------------------------------
+
+// BenchmarkHTTPAuthenticatedRequest はログイン済みのリクエストが UserContextMiddleware を
+// 通るコストを測る。cached はユーザー情報のキャッシュが効く通常の状態、uncached は毎回
+// キャッシュを捨ててセッション・ユーザー・パスキーを DB から引く状態、static は
+// ユーザーを特定しない静的ファイルのパス。
+func BenchmarkHTTPAuthenticatedRequest(b *testing.B) {
+	conn := SetupTestDB(b)
+	seed := SeedTestData(b, conn)
+	ml := newTestMagicLink(b, conn)
+	cookie := LoginSession(b, ml, seed.ViewerUser)
+
+	var loggedIn bool
+	h := appMiddleware.UserContextMiddleware(ml, conn)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, loggedIn, _ = appcontext.GetUser(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	do := func(path string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.AddCookie(cookie)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	hash := authsession.CurrentHash(req, ml.Config.CookieName)
+
+	b.Run("cached", func(b *testing.B) {
+		for b.Loop() {
+			do("/projects")
+		}
+		if !loggedIn {
+			b.Fatal("ログイン済みとして扱われていない")
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for b.Loop() {
+			authsession.ForgetSession(hash)
+			do("/projects")
+		}
+		if !loggedIn {
+			b.Fatal("ログイン済みとして扱われていない")
+		}
+	})
+	b.Run("static", func(b *testing.B) {
+		for b.Loop() {
+			do("/static/css/output.css")
+		}
+		if loggedIn {
+			b.Fatal("静的ファイルでユーザーを特定している")
+		}
+	})
+}
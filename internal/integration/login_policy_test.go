@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/invitation"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/loginpolicy"
 )
 
@@ -25,6 +26,20 @@ func TestAllowLogin(t *testing.T) {
 		t.Fatalf("inactive seed: %v", err)
 	}
 
+	// 招待の承認待ちユーザー
+	pending, err := q.CreateUser(ctx, database.CreateUserParams{
+		Email:    "pending@test.com",
+		Name:     "Pending",
+		Role:     "viewer",
+		IsActive: false,
+	})
+	if err != nil {
+		t.Fatalf("pending seed: %v", err)
+	}
+	if _, err := invitation.Issue(ctx, q, pending, seed.AdminUser.Email); err != nil {
+		t.Fatalf("pending invitation: %v", err)
+	}
+
 	cases := []struct {
 		name       string
 		email      string
@@ -33,6 +48,7 @@ func TestAllowLogin(t *testing.T) {
 	}{
 		{"未登録メアド → 拒否", "nobody@test.com", "", "登録されていません"},
 		{"inactive ユーザー → 拒否", "inactive@test.com", "", "ご利用いただけません"},
+		{"招待の承認待ち → 拒否（招待メールへ誘導）", "pending@test.com", "", "招待の承認待ち"},
 		{"アクティブな admin → 許可", seed.AdminUser.Email, "", ""},
 		{"アクティブな viewer → 許可", seed.ViewerUser.Email, "", ""},
 		{"Honeypot に値あり → 拒否（メアドが正規でも）", seed.AdminUser.Email, "https://attack.example", "不正なリクエスト"},
@@ -41,7 +57,7 @@ func TestAllowLogin(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			err := loginpolicy.AllowLogin(ctx, q, c.email, c.honeypot)
+			err := loginpolicy.AllowLogin(ctx, conn, c.email, c.honeypot)
 			if c.wantErrSub == "" {
 				if err != nil {
 					t.Errorf("got %v, want nil", err)
@@ -3,10 +3,16 @@ package integration
 import (
 	"bytes"
 	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/loginpolicy"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
 )
 
@@ -149,3 +155,225 @@ func TestMaintenance_LoginPageWhenOff(t *testing.T) {
 		t.Error("OFF なのにメンテ文言が表示されている")
 	}
 }
+
+// メンテ ON の間、ログイン中の admin 以外は画面・SSE・JSON のいずれも 503 + Retry-After で止まる。
+// admin は通常どおり使え、全画面にバナーが出る。OFF にすると即座に元に戻る。
+func TestMaintenance_BlocksLoggedInNonAdmins(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	if rec := DoSSERequest(e, http.MethodPost, "/api/sse/admin/maintenance/toggle", &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("メンテ ON: got %d", rec.Code)
+	}
+
+	rec := DoRequest(e, http.MethodGet, "/projects", &seed.ViewerUser)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("viewer の画面: got %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Retry-After が無い")
+	}
+	if !strings.Contains(rec.Body.String(), "メンテナンス中です") {
+		t.Errorf("メンテナンス画面が出ていない: %s", rec.Body.String())
+	}
+
+	rec = DoSSERequest(e, http.MethodPut, sprintf("/api/sse/projects/%d", seed.Project.ID), &seed.EditorUser, `{"name":"メンテ中の更新"}`)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("editor の SSE 更新: got %d (Retry-After %q), want 503", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if p, err := queryFromConn(conn).GetProject(t.Context(), seed.Project.ID); err != nil || p.Name == "メンテ中の更新" {
+		t.Errorf("メンテ中に更新が通っている: %+v, %v", p, err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/projects", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Test-User-ID", sprintf("%d", seed.EditorUser.ID))
+	jrec := httptest.NewRecorder()
+	e.ServeHTTP(jrec, req)
+	if jrec.Code != http.StatusServiceUnavailable || !strings.Contains(jrec.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("JSON: got %d (%s), want 503 JSON", jrec.Code, jrec.Header().Get("Content-Type"))
+	}
+
+	rec = DoRequest(e, http.MethodGet, "/projects", &seed.AdminUser)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("admin: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !strings.Contains(rec.Body.String(), "メンテナンスモード中です") {
+		t.Errorf("admin の画面にバナーが無い")
+	}
+	if rec := DoRequest(e, http.MethodGet, "/projects", nil); rec.Code != http.StatusSeeOther {
+		t.Errorf("未ログイン: got %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+
+	if rec := DoSSERequest(e, http.MethodPost, "/api/sse/admin/maintenance/toggle", &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("メンテ OFF: got %d", rec.Code)
+	}
+	if rec := DoRequest(e, http.MethodGet, "/projects", &seed.ViewerUser); rec.Code != http.StatusOK {
+		t.Errorf("OFF 直後の viewer: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := DoRequest(e, http.MethodGet, "/projects", &seed.AdminUser); strings.Contains(rec.Body.String(), "メンテナンスモード中です") {
+		t.Errorf("OFF なのにバナーが出ている")
+	}
+}
+
+// Cache は Invalidate されるまで（または CacheTTL の間）DB を読み直さない。
+func TestMaintenance_CacheInvalidate(t *testing.T) {
+	conn := SetupTestDB(t)
+	q := database.New(conn)
+	cache := maintenance.NewCache(q)
+
+	if cache.Enabled(t.Context()) {
+		t.Fatalf("初期状態で ON")
+	}
+	if err := maintenance.SetEnabled(t.Context(), q, true); err != nil {
+		t.Fatalf("SetEnabled: %v", err)
+	}
+	if cache.Enabled(t.Context()) {
+		t.Errorf("Invalidate 前にキャッシュが更新されている")
+	}
+	cache.Invalidate()
+	if !cache.Enabled(t.Context()) {
+		t.Errorf("Invalidate 後も OFF のまま")
+	}
+}
+
+// 予定メンテナンス: 時間帯に入ると自動で ON になり、告知文・終了までの Retry-After を返す。
+// 許可アドレスのユーザーは使える。開始前はログイン中の全員に予告バナーを出す。
+func TestMaintenance_ScheduledWindow(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	now := time.Now()
+
+	// 開始前: 予告バナーだけ出て、利用はできる
+	body := sprintf(`{"mwStart":%q,"mwEnd":%q,"mwMessage":"DB 移行作業","mwAllow":"VIEWER@test.com"}`,
+		now.Add(time.Hour).Format("2006-01-02T15:04"), now.Add(3*time.Hour).Format("2006-01-02T15:04"))
+	if rec := DoSSERequest(e, http.MethodPut, "/api/sse/admin/maintenance/window", &seed.AdminUser, body); rec.Code != http.StatusOK {
+		t.Fatalf("予定の登録: got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := DoRequest(e, http.MethodGet, "/projects", &seed.EditorUser)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("開始前の editor: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !strings.Contains(rec.Body.String(), "メンテナンス予定") || !strings.Contains(rec.Body.String(), "DB 移行作業") {
+		t.Errorf("開始前に予告バナーが出ていない")
+	}
+	if maintenance.IsEnabled(t.Context(), queryFromConn(conn)) {
+		t.Errorf("開始前なのに ON になっている")
+	}
+
+	// 時間帯に入った予定に置き換える
+	body = sprintf(`{"mwStart":%q,"mwEnd":%q,"mwMessage":"DB 移行作業","mwAllow":"VIEWER@test.com"}`,
+		now.Add(-time.Minute).Format("2006-01-02T15:04"), now.Add(time.Hour).Format("2006-01-02T15:04"))
+	if rec := DoSSERequest(e, http.MethodPut, "/api/sse/admin/maintenance/window", &seed.AdminUser, body); rec.Code != http.StatusOK {
+		t.Fatalf("予定の更新: got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = DoRequest(e, http.MethodGet, "/projects", &seed.EditorUser)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("時間帯中の editor: got %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if !strings.Contains(rec.Body.String(), "DB 移行作業") {
+		t.Errorf("メンテナンス画面に告知文が無い")
+	}
+	if ra, _ := strconv.Atoi(rec.Header().Get("Retry-After")); ra <= maintenance.RetryAfter || ra > 3600 {
+		t.Errorf("Retry-After = %q, want 終了までの秒数", rec.Header().Get("Retry-After"))
+	}
+	rec = DoRequest(e, http.MethodGet, "/projects", &seed.ViewerUser)
+	if rec.Code != http.StatusOK {
+		t.Errorf("許可アドレスの viewer: got %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// ログイン: 許可アドレスと admin だけリンクを送る
+	if err := loginpolicy.AllowLogin(t.Context(), conn, seed.ViewerUser.Email, ""); err != nil {
+		t.Errorf("許可アドレス: %v", err)
+	}
+	if err := loginpolicy.AllowLogin(t.Context(), conn, seed.AdminUser.Email, ""); err != nil {
+		t.Errorf("admin: %v", err)
+	}
+	if err := loginpolicy.AllowLogin(t.Context(), conn, seed.EditorUser.Email, ""); err == nil || !strings.Contains(err.Error(), "メンテナンス中") {
+		t.Errorf("editor: got %v, want メンテナンス中で拒否", err)
+	}
+	rec = DoRequest(e, http.MethodGet, "/auth/login", nil)
+	if !strings.Contains(rec.Body.String(), "DB 移行作業") || !strings.Contains(rec.Body.String(), `id="login-form"`) {
+		t.Errorf("ログイン画面に告知文と（許可アドレス用の）フォームが出ていない")
+	}
+
+	// 取り消すとその時点で終わる
+	if rec := DoSSERequest(e, http.MethodDelete, "/api/sse/admin/maintenance/window", &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("予定の取り消し: got %d", rec.Code)
+	}
+	if rec := DoRequest(e, http.MethodGet, "/projects", &seed.EditorUser); rec.Code != http.StatusOK {
+		t.Errorf("取り消し後の editor: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := DoSSERequest(e, http.MethodDelete, "/api/sse/admin/maintenance/window", &seed.AdminUser, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("予定が無いときの取り消し: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+// 手動切替は予定より優先する。時間帯中に解除すると予定も取り消され、
+// 予定の登録・取り消しは監査ログに残る。不正な予定は 400。
+func TestMaintenance_WindowManualOverride(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+	now := time.Now()
+
+	for name, body := range map[string]string{
+		"終了が開始より前": sprintf(`{"mwStart":%q,"mwEnd":%q}`, now.Add(2*time.Hour).Format("2006-01-02T15:04"), now.Add(time.Hour).Format("2006-01-02T15:04")),
+		"終了が過去":    sprintf(`{"mwStart":%q,"mwEnd":%q}`, now.Add(-2*time.Hour).Format("2006-01-02T15:04"), now.Add(-time.Hour).Format("2006-01-02T15:04")),
+		"日時なし":     `{"mwStart":"","mwEnd":""}`,
+		"不正なアドレス":  sprintf(`{"mwStart":%q,"mwEnd":%q,"mwAllow":"not-an-email"}`, now.Format("2006-01-02T15:04"), now.Add(time.Hour).Format("2006-01-02T15:04")),
+	} {
+		if rec := DoSSERequest(e, http.MethodPut, "/api/sse/admin/maintenance/window", &seed.AdminUser, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", name, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if rec := DoSSERequest(e, http.MethodPut, "/api/sse/admin/maintenance/window", &seed.EditorUser, `{}`); rec.Code != http.StatusForbidden {
+		t.Errorf("editor の登録: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	if err := maintenance.SaveWindow(t.Context(), q, maintenance.Window{Start: now.Add(-time.Minute), End: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("SaveWindow: %v", err)
+	}
+	if !maintenance.IsEnabled(t.Context(), q) {
+		t.Fatalf("時間帯中なのに OFF")
+	}
+	// 解除 → 予定ごと終わる
+	if rec := DoSSERequest(e, http.MethodPost, "/api/sse/admin/maintenance/toggle", &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("解除: got %d", rec.Code)
+	}
+	if st := maintenance.Load(t.Context(), q); st.Active(time.Now()) || st.Window != nil {
+		t.Errorf("解除後: %+v, want OFF かつ予定なし", st)
+	}
+	if rec := DoRequest(e, http.MethodGet, "/projects", &seed.EditorUser); rec.Code != http.StatusOK {
+		t.Errorf("解除後の editor: got %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// 手動 ON は開始前の予定があっても即 ON
+	if err := maintenance.SaveWindow(t.Context(), q, maintenance.Window{Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)}); err != nil {
+		t.Fatalf("SaveWindow: %v", err)
+	}
+	if rec := DoSSERequest(e, http.MethodPost, "/api/sse/admin/maintenance/toggle", &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("ON: got %d", rec.Code)
+	}
+	rec := DoRequest(e, http.MethodGet, "/projects", &seed.EditorUser)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != strconv.Itoa(maintenance.RetryAfter) {
+		t.Errorf("手動 ON: got %d (Retry-After %q)", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	if rec := DoSSERequest(e, http.MethodDelete, "/api/sse/admin/maintenance/window", &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("取り消し: got %d", rec.Code)
+	}
+	if !maintenance.IsEnabled(t.Context(), q) {
+		t.Errorf("予定の取り消しで手動 ON まで解除された")
+	}
+	var actions []string
+	for _, l := range listAllAuditLogs(t, q) {
+		actions = append(actions, l.Action)
+	}
+	if !slices.Contains(actions, audit.ActionMaintenanceCancel) {
+		t.Errorf("予定の取り消しが監査ログに無い: %v", actions)
+	}
+}
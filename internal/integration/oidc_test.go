@@ -0,0 +1,429 @@
+package integration
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/naozine/nz-magic-link/magiclink"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appconfig"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/handlers"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
+	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/oidc"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+const fakeIdPClientID = "test-client"
+
+// fakeIdP はテスト用のインプロセス OpenID プロバイダ。認可エンドポイントはユーザー操作なしで
+// 次のログインのクレーム（next）でコードを発行し、トークンエンドポイントは PKCE を検証して
+// RS256 で署名した ID トークンを返す。
+type fakeIdP struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	next   map[string]any
+	grants map[string]fakeGrant
+	// tamper は署名前の ID トークンのクレームを書き換え、signKey は署名の鍵を差し替える
+	// （検証の失敗を再現する）。
+	tamper  func(claims map[string]any)
+	signKey *rsa.PrivateKey
+}
+
+type fakeGrant struct {
+	nonce, challenge, redirectURI string
+	claims                        map[string]any
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("鍵の生成に失敗: %v", err)
+	}
+	idp := &fakeIdP{key: key, grants: map[string]fakeGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":                 idp.srv.URL,
+			"authorization_endpoint": idp.srv.URL + "/authorize",
+			"token_endpoint":         idp.srv.URL + "/token",
+			"jwks_uri":               idp.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("GET /authorize", idp.authorize)
+	mux.HandleFunc("POST /token", idp.token)
+	idp.srv = httptest.NewServer(mux)
+	t.Cleanup(idp.srv.Close)
+	return idp
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (idp *fakeIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != fakeIdPClientID || q.Get("code_challenge_method") != "S256" || q.Get("response_type") != "code" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+	idp.mu.Lock()
+	code := rand.Text()
+	idp.grants[code] = fakeGrant{
+		nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), redirectURI: q.Get("redirect_uri"),
+		claims: idp.next,
+	}
+	idp.mu.Unlock()
+	back := url.Values{"code": {code}, "state": {q.Get("state")}}
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+back.Encode(), http.StatusFound)
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != fakeIdPClientID || secret != "test-secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		writeJSON(w, map[string]string{"error": "invalid_client"})
+		return
+	}
+	idp.mu.Lock()
+	g, found := idp.grants[r.PostFormValue("code")]
+	delete(idp.grants, r.PostFormValue("code"))
+	tamper, key := idp.tamper, idp.signKey
+	idp.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !found || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge || r.PostFormValue("redirect_uri") != g.redirectURI {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]any{
+		"iss": idp.srv.URL, "aud": fakeIdPClientID, "sub": "sub-" + rand.Text(),
+		"iat": time.Now().Unix(), "exp": time.Now().Add(5 * time.Minute).Unix(),
+		"nonce": g.nonce, "email_verified": true,
+	}
+	for k, v := range g.claims {
+		claims[k] = v
+	}
+	if tamper != nil {
+		tamper(claims)
+	}
+	if key == nil {
+		key = idp.key
+	}
+	writeJSON(w, map[string]string{"id_token": signIDToken(key, claims), "token_type": "Bearer"})
+}
+
+func signIDToken(key *rsa.PrivateKey, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// oidcTestServer は main.go と同じく OIDC のルートとログイン画面を登録したサーバー。
+type oidcTestServer struct {
+	h   http.Handler
+	ml  *magiclink.MagicLink
+	idp *fakeIdP
+}
+
+func setupOIDCTestServer(t *testing.T, conn *sql.DB, domains string) *oidcTestServer {
+	t.Helper()
+	idp := newFakeIdP(t)
+	ml := newTestMagicLink(t, conn)
+	roleMap, err := oidc.ParseRoleMap("idp-admins=admin, idp-editors=editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := oidc.New(oidc.Config{
+		Issuer:         idp.srv.URL,
+		ClientID:       fakeIdPClientID,
+		ClientSecret:   "test-secret",
+		RedirectURL:    "http://localhost:8080" + oidc.CallbackPath,
+		ProviderName:   "Example SSO",
+		AllowedDomains: oidc.ParseDomains(domains),
+		RoleClaim:      "groups",
+		RoleMap:        roleMap,
+		HTTPClient:     idp.srv.Client(),
+	})
+
+	r := chi.NewRouter()
+	r.Use(appMiddleware.UserContextMiddleware(ml, conn))
+	r.Get("/auth/login", handlers.NewAuthHandler(database.New(conn), "Example SSO").LoginPage)
+	oidcHandler := handlers.NewOIDCHandler(conn, ml, provider)
+	r.Get(oidc.LoginPath, oidcHandler.Login)
+	r.With(appMiddleware.RecordSessionDetails(ml.Config.CookieName, conn, authsession.MethodSSO)).Get(oidc.CallbackPath, oidcHandler.Callback)
+	return &oidcTestServer{h: r, ml: ml, idp: idp}
+}
+
+// login はログイン開始 → IdP（claims のユーザーとして認可）→ コールバックを通し、
+// コールバックのレスポンスを返す。
+func (s *oidcTestServer) login(t *testing.T, claims map[string]any) *httptest.ResponseRecorder {
+	t.Helper()
+	start := DoRequest(s.h, http.MethodGet, oidc.LoginPath, nil)
+	if start.Code != http.StatusFound {
+		t.Fatalf("ログイン開始: status = %d", start.Code)
+	}
+	s.idp.mu.Lock()
+	s.idp.next = claims
+	s.idp.mu.Unlock()
+	client := *s.idp.srv.Client() // Provider と共有しているのでコピーしてから変える
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	res, err := client.Get(start.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("IdP の認可: %v", err)
+	}
+	_ = res.Body.Close()
+	back, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || back.Path != oidc.CallbackPath {
+		t.Fatalf("IdP のリダイレクト先 = %q (status %d)", res.Header.Get("Location"), res.StatusCode)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, back.RequestURI(), nil)
+	for _, c := range start.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	s.h.ServeHTTP(rec, req)
+	return rec
+}
+
+// sessionCookie はコールバックで発行されたセッション Cookie を返す（無ければ nil）。
+func (s *oidcTestServer) sessionCookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == s.ml.Config.CookieName && c.Value != "" {
+			return c
+		}
+	}
+	return nil
+}
+
+// loginError はログイン画面に戻されたときのメッセージを返す（成功なら ""）。
+func loginError(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || loc.Path != "/auth/login" {
+		return ""
+	}
+	return loc.Query().Get("error_description")
+}
+
+// IdP でログインするとマジックリンクと同じセッションができ、業務画面に入れる。
+// ログイン画面には IdP のボタンが出る。
+func TestOIDC_Login(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	s := setupOIDCTestServer(t, conn, "")
+
+	if rec := DoRequest(s.h, http.MethodGet, "/auth/login", nil); !strings.Contains(rec.Body.String(), "Example SSO でログイン") ||
+		!strings.Contains(rec.Body.String(), `href="`+oidc.LoginPath+`"`) {
+		t.Error("ログイン画面に SSO のボタンが無い")
+	}
+
+	rec := s.login(t, map[string]any{"email": seed.EditorUser.Email})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != appconfig.LandingPath {
+		t.Fatalf("コールバック: status = %d, Location = %q (%s)", rec.Code, rec.Header().Get("Location"), loginError(t, rec))
+	}
+	cookie := s.sessionCookie(rec)
+	if cookie == nil {
+		t.Fatal("セッション Cookie が発行されない")
+	}
+	app := SetupSessionTestServer(t, conn, s.ml)
+	if got := DoCookieRequest(app, http.MethodGet, "/projects", cookie); got.Code != http.StatusOK {
+		t.Errorf("OIDC のセッションで /projects: status = %d", got.Code)
+	}
+	details, err := queryFromConn(conn).ListSessionDetailsByEmail(t.Context(), seed.EditorUser.Email)
+	if err != nil || len(details) != 1 {
+		t.Errorf("セッションの IP・User-Agent が記録されていない: %v %d", err, len(details))
+	}
+}
+
+// state・nonce・署名・発行者・宛先・有効期限・メールアドレスの確認のどれかが合わなければ
+// ログインさせない。
+func TestOIDC_RejectsInvalidResponses(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	s := setupOIDCTestServer(t, conn, "")
+	claims := map[string]any{"email": seed.ViewerUser.Email}
+
+	cases := map[string]func(c map[string]any){
+		"nonce":          func(c map[string]any) { c["nonce"] = "other" },
+		"issuer":         func(c map[string]any) { c["iss"] = "https://evil.example" },
+		"audience":       func(c map[string]any) { c["aud"] = "other-client" },
+		"expired":        func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"email_verified": func(c map[string]any) { c["email_verified"] = false },
+	}
+	for name, tamper := range cases {
+		s.idp.tamper = tamper
+		rec := s.login(t, claims)
+		if s.sessionCookie(rec) != nil || loginError(t, rec) == "" {
+			t.Errorf("%s: ログインできてしまった (status %d, Location %q)", name, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+	s.idp.tamper = nil
+
+	// IdP の JWKS に無い鍵で署名した ID トークン。
+	forger, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.idp.signKey = forger
+	if rec := s.login(t, claims); s.sessionCookie(rec) != nil {
+		t.Error("別の鍵の署名を受け付けた")
+	}
+	s.idp.signKey = nil
+
+	// state を持たない（Cookie の無い）コールバック。
+	rec := DoRequest(s.h, http.MethodGet, oidc.CallbackPath+"?code=x&state=y", nil)
+	if msg := loginError(t, rec); !strings.Contains(msg, "有効期限") {
+		t.Errorf("state 無し: %q", msg)
+	}
+	// IdP がエラーを返したとき。
+	rec = DoRequest(s.h, http.MethodGet, oidc.CallbackPath+"?error=access_denied", nil)
+	if loginError(t, rec) == "" {
+		t.Error("IdP のエラーでログイン画面に戻らない")
+	}
+}
+
+// OIDC_ALLOWED_DOMAINS のドメインの未登録ユーザーは初回ログインで登録され、
+// それ以外のドメインは（登録済みでも）拒否される。
+func TestOIDC_ProvisionAllowedDomains(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+	s := setupOIDCTestServer(t, conn, "@Corp.example")
+
+	rec := s.login(t, map[string]any{"email": "newhire@corp.example", "name": "New Hire"})
+	if s.sessionCookie(rec) == nil {
+		t.Fatalf("許可ドメインの初回ログインに失敗: %s", loginError(t, rec))
+	}
+	user, err := q.GetUserByEmail(t.Context(), "newhire@corp.example")
+	if err != nil {
+		t.Fatalf("自動登録されていない: %v", err)
+	}
+	if !user.IsActive || user.Role != roles.Viewer || user.Name != "New Hire" {
+		t.Errorf("自動登録ユーザー = %+v", user)
+	}
+	if latest := listAllAuditLogs(t, q)[0]; latest.Action != audit.ActionUserSSOProvision || latest.ActorID != user.ID {
+		t.Errorf("監査ログ = %s (actor %d)", latest.Action, latest.ActorID)
+	}
+
+	rec = s.login(t, map[string]any{"email": seed.EditorUser.Email})
+	if s.sessionCookie(rec) != nil || !strings.Contains(loginError(t, rec), "利用できません") {
+		t.Errorf("許可外ドメイン: %q", loginError(t, rec))
+	}
+}
+
+// ドメインを指定しなければ自動登録せず、未登録のユーザーはマジックリンクと同じく拒否する。
+func TestOIDC_UnregisteredWithoutProvisioning(t *testing.T) {
+	conn := SetupTestDB(t)
+	s := setupOIDCTestServer(t, conn, "")
+
+	rec := s.login(t, map[string]any{"email": "stranger@corp.example"})
+	if s.sessionCookie(rec) != nil || !strings.Contains(loginError(t, rec), "登録されていません") {
+		t.Errorf("未登録ユーザー: %q", loginError(t, rec))
+	}
+	if _, err := queryFromConn(conn).GetUserByEmail(t.Context(), "stranger@corp.example"); err == nil {
+		t.Error("ドメイン未指定なのに自動登録された")
+	}
+}
+
+// ロールのクレームを OIDC_ROLE_MAP で roles の定数に対応付け、ログインのたびに合わせる。
+func TestOIDC_RoleMapping(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+	s := setupOIDCTestServer(t, conn, "")
+
+	// 複数一致したら最も強いロール。
+	s.login(t, map[string]any{"email": seed.ViewerUser.Email, "groups": []string{"staff", "idp-editors", "idp-admins"}})
+	if u, _ := q.GetUserByEmail(t.Context(), seed.ViewerUser.Email); u.Role != roles.Admin {
+		t.Errorf("role = %q, want admin", u.Role)
+	}
+	if latest := listAllAuditLogs(t, q)[0]; latest.Action != audit.ActionUserSSORoleSync || latest.ActorID != seed.ViewerUser.ID {
+		t.Errorf("監査ログ = %s (actor %d)", latest.Action, latest.ActorID)
+	}
+	// 文字列 1 つのクレームでもよい。
+	s.login(t, map[string]any{"email": seed.ViewerUser.Email, "groups": "idp-editors"})
+	if u, _ := q.GetUserByEmail(t.Context(), seed.ViewerUser.Email); u.Role != roles.Editor {
+		t.Errorf("role = %q, want editor", u.Role)
+	}
+	// 一致しなければロールは変えない。
+	s.login(t, map[string]any{"email": seed.ViewerUser.Email, "groups": []string{"staff"}})
+	if u, _ := q.GetUserByEmail(t.Context(), seed.ViewerUser.Email); u.Role != roles.Editor {
+		t.Errorf("role = %q, want editor (unchanged)", u.Role)
+	}
+
+	if _, err := oidc.ParseRoleMap("idp-admins=superuser"); err == nil {
+		t.Error("roles に無いロールを受け付けた")
+	}
+}
+
+// メンテナンス中・無効ユーザーはマジックリンクと同じく loginpolicy で拒否される。
+func TestOIDC_HonoursLoginPolicy(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+	s := setupOIDCTestServer(t, conn, "corp.example, test.com")
+
+	if _, err := q.UpdateUser(t.Context(), database.UpdateUserParams{
+		ID: seed.DeletableUser.ID, Name: seed.DeletableUser.Name, Role: seed.DeletableUser.Role, IsActive: false,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	rec := s.login(t, map[string]any{"email": seed.DeletableUser.Email})
+	if s.sessionCookie(rec) != nil || !strings.Contains(loginError(t, rec), "ご利用いただけません") {
+		t.Errorf("無効ユーザー: %q", loginError(t, rec))
+	}
+
+	if err := maintenance.SetEnabled(t.Context(), q, true); err != nil {
+		t.Fatal(err)
+	}
+	rec = s.login(t, map[string]any{"email": seed.EditorUser.Email})
+	if s.sessionCookie(rec) != nil || !strings.Contains(loginError(t, rec), "メンテナンス中") {
+		t.Errorf("メンテナンス中の editor: %q", loginError(t, rec))
+	}
+	// メンテナンス中は自動登録もしない。
+	rec = s.login(t, map[string]any{"email": "newhire@corp.example"})
+	if s.sessionCookie(rec) != nil {
+		t.Error("メンテナンス中に未登録ユーザーがログインできた")
+	}
+	if _, err := q.GetUserByEmail(t.Context(), "newhire@corp.example"); err == nil {
+		t.Error("メンテナンス中に自動登録された")
+	}
+	// admin は作業のためにログインできる。
+	if rec := s.login(t, map[string]any{"email": seed.AdminUser.Email}); s.sessionCookie(rec) == nil {
+		t.Errorf("メンテナンス中の admin: %q", loginError(t, rec))
+	}
+}
@@ -0,0 +1,161 @@
+package integration
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/handlers"
+	"github.com/naozine/project_crud_with_auth_tmpl/web"
+)
+
+// 登録したルートに OpenAPI の説明（handlers の apiOperations）が無ければ失敗する。
+// ルートを追加したら apiOperations にも足すこと。
+func TestOpenAPI_EveryRouteDocumented(t *testing.T) {
+	conn := SetupTestDB(t)
+	servers := map[string]http.Handler{
+		"SetupTestServer":        SetupTestServer(t, conn),
+		"SetupSessionTestServer": SetupSessionTestServer(t, conn, newTestMagicLink(t, conn)),
+	}
+	for name, h := range servers {
+		_, missing, err := handlers.BuildOpenAPI(h.(chi.Routes), "session")
+		if err != nil {
+			t.Fatalf("%s: BuildOpenAPI: %v", name, err)
+		}
+		if len(missing) > 0 {
+			t.Errorf("%s: OpenAPI の説明が無いルート:\n  %s", name, strings.Join(missing, "\n  "))
+		}
+	}
+}
+
+// openAPIDoc はテストで見る部分だけの OpenAPI ドキュメント。
+type openAPIDoc struct {
+	OpenAPI string                                 `json:"openapi"`
+	Paths   map[string]map[string]openAPIOperation `json:"paths"`
+	Mounts  []struct {
+		Path string `json:"path"`
+	} `json:"x-mounts"`
+}
+
+type openAPIOperation struct {
+	Parameters []struct {
+		Name    string                     `json:"name"`
+		In      string                     `json:"in"`
+		Content map[string]json.RawMessage `json:"content"`
+	} `json:"parameters"`
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+	Responses     map[string]struct{ Content map[string]json.RawMessage } `json:"responses"`
+	Security      []map[string][]string                                   `json:"security"`
+	RequiredRoles []string                                                `json:"x-required-roles"`
+}
+
+// /api/openapi.json はログイン中なら誰でも取得でき、ルーターのメソッド・パス・必要ロール・
+// signals・レスポンスの種類が載る。
+func TestOpenAPI_Spec(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	if rec := DoAPIRequest(e, http.MethodGet, "/api/openapi.json", nil, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("未ログイン: status = %d, want 401", rec.Code)
+	}
+
+	rec := DoAPIRequest(e, http.MethodGet, "/api/openapi.json", &seed.ViewerUser, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var doc openAPIDoc
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("JSON の解釈に失敗: %v", err)
+	}
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("openapi = %q", doc.OpenAPI)
+	}
+
+	op := func(path, method string) openAPIOperation {
+		t.Helper()
+		o, ok := doc.Paths[path][method]
+		if !ok {
+			t.Fatalf("%s %s がドキュメントに無い", method, path)
+		}
+		return o
+	}
+
+	// 必要なロールは RequireRole から読み取る。
+	if got := op("/api/v1/projects/{id}", "patch").RequiredRoles; !slices.Equal(got, []string{"editor", "admin"}) {
+		t.Errorf("PATCH /api/v1/projects/{id} のロール = %v", got)
+	}
+	if got := op("/api/v1/users", "get").RequiredRoles; !slices.Equal(got, []string{"admin"}) {
+		t.Errorf("GET /api/v1/users のロール = %v", got)
+	}
+	if got := op("/api/v1/projects", "get").RequiredRoles; len(got) != 0 {
+		t.Errorf("GET /api/v1/projects はロール不要のはず: %v", got)
+	}
+
+	// レスポンスの種類。
+	if _, ok := op("/api/sse/projects/{id}", "put").Responses["200"].Content["text/event-stream"]; !ok {
+		t.Error("SSE のルートが text/event-stream になっていない")
+	}
+	if _, ok := op("/admin/users", "get").Responses["200"].Content["text/html"]; !ok {
+		t.Error("画面のルートが text/html になっていない")
+	}
+	if _, ok := op("/api/v1/projects", "post").Responses["201"].Content["application/json"]; !ok {
+		t.Error("POST /api/v1/projects が 201 の JSON になっていない")
+	}
+
+	// signals: POST/PUT は body、GET は ?datastar=。
+	body := op("/api/sse/admin/users/{id}", "put").RequestBody
+	if body == nil || body.Content["application/json"].Schema.Properties["editRole"] == nil {
+		t.Errorf("PUT /api/sse/admin/users/{id} の signals が body に載っていない: %+v", body)
+	}
+	audit := op("/admin/audit/table", "get")
+	if !slices.ContainsFunc(audit.Parameters, func(p struct {
+		Name    string                     `json:"name"`
+		In      string                     `json:"in"`
+		Content map[string]json.RawMessage `json:"content"`
+	}) bool {
+		return p.Name == "datastar" && p.In == "query" && strings.Contains(string(p.Content["application/json"]), "auditActor")
+	}) {
+		t.Errorf("GET /admin/audit/table の signals が ?datastar= に載っていない: %+v", audit.Parameters)
+	}
+
+	// 認証の要否。
+	if got := op("/setup", "post").Security; len(got) != 0 {
+		t.Errorf("/setup はログイン不要のはず: %v", got)
+	}
+	if got := op("/projects/{id}", "get").Security; len(got) == 0 {
+		t.Error("/projects/{id} はログインが必要なはず")
+	}
+}
+
+// API エクスプローラは admin 限定で、スクリプトはバイナリに同梱した静的ファイルを使う。
+func TestOpenAPI_ExplorerPage(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	if rec := DoRequest(e, http.MethodGet, "/admin/api-docs", &seed.EditorUser); rec.Code != http.StatusForbidden {
+		t.Errorf("editor: status = %d, want 403", rec.Code)
+	}
+	rec := DoRequest(e, http.MethodGet, "/admin/api-docs", &seed.AdminUser)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("admin: status = %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "/static/js/api-explorer.js") {
+		t.Error("エクスプローラのスクリプトが読み込まれていない")
+	}
+	if _, err := fs.Stat(web.StaticFS, "static/js/api-explorer.js"); err != nil {
+		t.Errorf("api-explorer.js が StaticFS に無い: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package integration
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+)
+
+// パスキーに名前を付けると一覧に反映され、他人のパスキーは名前を変更できない。
+func TestPasskeys_Rename(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+
+	savePasskey(t, conn, "cred-laptop", seed.ViewerUser.Email)
+	savePasskey(t, conn, "cred-editor", seed.EditorUser.Email)
+
+	rec := DoSSERequest(e, http.MethodGet, "/api/sse/profile/passkeys/cred-laptop/edit", &seed.ViewerUser, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "passkey-edit-dialog") {
+		t.Fatalf("名前変更ダイアログ: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = DoSSERequest(e, http.MethodPut, "/api/sse/profile/passkeys/cred-laptop", &seed.ViewerUser, `{"passkeyName":"  仕事用 MacBook "}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("名前変更: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "仕事用 MacBook") {
+		t.Errorf("カードに新しい名前が出ていない: %s", rec.Body.String())
+	}
+
+	passkeys, err := authsession.ListPasskeys(t.Context(), ml, conn, seed.ViewerUser.Email)
+	if err != nil {
+		t.Fatalf("パスキー一覧の取得に失敗: %v", err)
+	}
+	if len(passkeys) != 1 || passkeys[0].Nickname != "仕事用 MacBook" {
+		t.Errorf("一覧 = %+v, want 名前 %q の 1 件", passkeys, "仕事用 MacBook")
+	}
+
+	if rec := DoSSERequest(e, http.MethodPut, "/api/sse/profile/passkeys/cred-laptop", &seed.ViewerUser, `{"passkeyName":"  "}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("空の名前: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	long := `{"passkeyName":"` + strings.Repeat("あ", authsession.NicknameMaxLen+1) + `"}`
+	if rec := DoSSERequest(e, http.MethodPut, "/api/sse/profile/passkeys/cred-laptop", &seed.ViewerUser, long); rec.Code != http.StatusBadRequest {
+		t.Errorf("長すぎる名前: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	if rec := DoSSERequest(e, http.MethodPut, "/api/sse/profile/passkeys/cred-editor", &seed.ViewerUser, `{"passkeyName":"乗っ取り"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("他人のパスキーの名前変更: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := DoSSERequest(e, http.MethodGet, "/api/sse/profile/passkeys/cred-editor/edit", &seed.ViewerUser, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("他人のパスキーのダイアログ: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+// パスキーは 1 件ずつ削除でき、同じユーザーの他のパスキー・他人のパスキーは残る。
+func TestPasskeys_DeleteOne(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	savePasskey(t, conn, "cred-laptop", seed.ViewerUser.Email)
+	savePasskey(t, conn, "cred-phone", seed.ViewerUser.Email)
+	savePasskey(t, conn, "cred-editor", seed.EditorUser.Email)
+
+	if rec := DoSSERequest(e, http.MethodPut, "/api/sse/profile/passkeys/cred-laptop", &seed.ViewerUser, `{"passkeyName":"ノート PC"}`); rec.Code != http.StatusOK {
+		t.Fatalf("名前変更: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := DoSSERequest(e, http.MethodDelete, "/api/sse/profile/passkeys/cred-laptop", &seed.ViewerUser, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("削除: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "profile-security") {
+		t.Errorf("セキュリティカードが patch されていない: %s", rec.Body.String())
+	}
+	if n := countPasskeys(t, conn, seed.ViewerUser.Email); n != 1 {
+		t.Errorf("残ったパスキー = %d 件, want 1", n)
+	}
+	var details int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM passkey_details WHERE credential_id = 'cred-laptop'`).Scan(&details); err != nil {
+		t.Fatalf("passkey_details の集計に失敗: %v", err)
+	}
+	if details != 0 {
+		t.Errorf("削除したパスキーの名前が残っている")
+	}
+
+	if rec := DoSSERequest(e, http.MethodDelete, "/api/sse/profile/passkeys/cred-editor", &seed.ViewerUser, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("他人のパスキーの削除: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if n := countPasskeys(t, conn, seed.EditorUser.Email); n != 1 {
+		t.Errorf("他人のパスキー = %d 件, want 1", n)
+	}
+	if rec := DoSSERequest(e, http.MethodDelete, "/api/sse/profile/passkeys/cred-missing", &seed.ViewerUser, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("存在しないパスキー: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
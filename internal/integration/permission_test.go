@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
 )
 
 // bodyContentType はリクエストボディの形式を表す
@@ -17,16 +18,19 @@ const (
 	bodyJSON                        // application/json (Datastar SSE シグナル用)
 )
 
-// routeTestCase は権限マトリクスの1行を表す
+// routeTestCase は権限マトリクスの1行を表す。ロールごとの期待値は書かず、
+// roles の対応表（roles.Has）から組み立てる。
 type routeTestCase struct {
-	Name         string
-	Method       string
-	Path         string // %d は seed.Project.ID で置換される
-	Body         string // リクエストボディ
-	BodyType     bodyContentType
-	AdminStatus  int
-	EditorStatus int
-	ViewerStatus int
+	Name     string
+	Method   string
+	Path     string // %d は seed.Project.ID で置換される
+	Body     string // リクエストボディ
+	BodyType bodyContentType
+	// Permission はルートに必要な権限（空ならログイン中の全員が通る）。
+	Permission roles.Permission
+	// OKStatus は権限があるときのステータス（0 なら 200）。権限が無ければ 403。
+	OKStatus int
+	// UnauthStatus は未認証のステータス（0 なら 303 でログイン画面へ）。
 	UnauthStatus int
 }
 
@@ -41,42 +45,34 @@ func TestPermissionMatrix_ProjectRoutes(t *testing.T) {
 		{
 			Name:   "GET /projects（一覧）",
 			Method: http.MethodGet, Path: "/projects",
-			AdminStatus: http.StatusOK, EditorStatus: http.StatusOK,
-			ViewerStatus: http.StatusOK, UnauthStatus: http.StatusSeeOther,
 		},
 		{
 			Name:   "GET /projects/:id（詳細）",
 			Method: http.MethodGet, Path: fmt.Sprintf("/projects/%d", projectID),
-			AdminStatus: http.StatusOK, EditorStatus: http.StatusOK,
-			ViewerStatus: http.StatusOK, UnauthStatus: http.StatusSeeOther,
 		},
 		{
 			Name:   "POST /api/sse/projects/new（作成 SSE）",
 			Method: http.MethodPost, Path: "/api/sse/projects/new",
-			Body:        `{"name":"新規プロジェクト"}`,
-			BodyType:    bodyJSON,
-			AdminStatus: http.StatusOK, EditorStatus: http.StatusOK,
-			ViewerStatus: http.StatusForbidden, UnauthStatus: http.StatusSeeOther,
+			Body:       `{"name":"新規プロジェクト"}`,
+			BodyType:   bodyJSON,
+			Permission: roles.ProjectWrite,
 		},
 		{
 			Name:   "GET /api/sse/projects/:id/edit（編集ダイアログ SSE）",
 			Method: http.MethodGet, Path: fmt.Sprintf("/api/sse/projects/%d/edit", projectID),
-			AdminStatus: http.StatusOK, EditorStatus: http.StatusOK,
-			ViewerStatus: http.StatusForbidden, UnauthStatus: http.StatusSeeOther,
+			Permission: roles.ProjectWrite,
 		},
 		{
 			Name:   "PUT /api/sse/projects/:id（更新 SSE）",
 			Method: http.MethodPut, Path: fmt.Sprintf("/api/sse/projects/%d", projectID),
-			Body:        `{"name":"更新済み"}`,
-			BodyType:    bodyJSON,
-			AdminStatus: http.StatusOK, EditorStatus: http.StatusOK,
-			ViewerStatus: http.StatusForbidden, UnauthStatus: http.StatusSeeOther,
+			Body:       `{"name":"更新済み"}`,
+			BodyType:   bodyJSON,
+			Permission: roles.ProjectWrite,
 		},
 		{
 			Name:   "DELETE /api/sse/projects/:id（削除 SSE）",
 			Method: http.MethodDelete, Path: fmt.Sprintf("/api/sse/projects/%d", projectID),
-			AdminStatus: http.StatusOK, EditorStatus: http.StatusOK,
-			ViewerStatus: http.StatusForbidden, UnauthStatus: http.StatusSeeOther,
+			Permission: roles.ProjectWrite,
 		},
 	}
 
@@ -97,42 +93,91 @@ func TestPermissionMatrix_AdminRoutes(t *testing.T) {
 		{
 			Name:   "GET /admin/users（ユーザー一覧）",
 			Method: http.MethodGet, Path: "/admin/users",
-			AdminStatus: http.StatusOK, EditorStatus: http.StatusForbidden,
-			ViewerStatus: http.StatusForbidden, UnauthStatus: http.StatusSeeOther,
+			Permission: roles.UserManage,
 		},
 		{
 			Name:   "POST /api/sse/admin/users/create（ユーザー作成 SSE）",
 			Method: http.MethodPost, Path: "/api/sse/admin/users/create",
-			Body:        `{"newName":"NewUser","newEmail":"new@test.com","newRole":"viewer"}`,
-			BodyType:    bodyJSON,
-			AdminStatus: http.StatusOK, EditorStatus: http.StatusForbidden,
-			ViewerStatus: http.StatusForbidden, UnauthStatus: http.StatusSeeOther,
+			Body:       `{"newName":"NewUser","newEmail":"new@test.com","newRole":"viewer"}`,
+			BodyType:   bodyJSON,
+			Permission: roles.UserManage,
 		},
 		{
 			Name:   "GET /api/sse/admin/users/:id/edit（編集ダイアログ SSE）",
 			Method: http.MethodGet, Path: fmt.Sprintf("/api/sse/admin/users/%d/edit", targetID),
-			AdminStatus: http.StatusOK, EditorStatus: http.StatusForbidden,
-			ViewerStatus: http.StatusForbidden, UnauthStatus: http.StatusSeeOther,
+			Permission: roles.UserManage,
 		},
 		{
 			Name:   "PUT /api/sse/admin/users/:id（ユーザー更新 SSE）",
 			Method: http.MethodPut, Path: fmt.Sprintf("/api/sse/admin/users/%d", targetID),
-			Body:        `{"editName":"UpdatedViewer","editRole":"viewer","editStatus":"active"}`,
-			BodyType:    bodyJSON,
-			AdminStatus: http.StatusOK, EditorStatus: http.StatusForbidden,
-			ViewerStatus: http.StatusForbidden, UnauthStatus: http.StatusSeeOther,
+			Body:       `{"editName":"UpdatedViewer","editRole":"viewer","editStatus":"active"}`,
+			BodyType:   bodyJSON,
+			Permission: roles.UserManage,
 		},
 		{
 			Name:   "DELETE /api/sse/admin/users/:id（ユーザー削除 SSE）",
 			Method: http.MethodDelete, Path: fmt.Sprintf("/api/sse/admin/users/%d", seed.DeletableUser.ID),
-			AdminStatus: http.StatusOK, EditorStatus: http.StatusForbidden,
-			ViewerStatus: http.StatusForbidden, UnauthStatus: http.StatusSeeOther,
+			Permission: roles.UserManage,
+		},
+		{
+			Name:   "GET /admin/signup（サインアップ設定）",
+			Method: http.MethodGet, Path: "/admin/signup",
+			Permission: roles.UserManage,
+		},
+		{
+			Name:   "PUT /api/sse/admin/signup（サインアップ設定の保存 SSE）",
+			Method: http.MethodPut, Path: "/api/sse/admin/signup",
+			Body:       `{"signupEnabled":false,"signupDomains":"","signupRole":"viewer","signupApproval":true}`,
+			BodyType:   bodyJSON,
+			Permission: roles.UserManage,
 		},
 	}
 
 	runPermissionMatrix(t, e, seed, routes)
 }
 
+// 管理画面はページごとに必要な権限が違う（ロールを足したら対応表だけを直せばよい）。
+func TestPermissionMatrix_SettingsRoutes(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	runPermissionMatrix(t, e, seed, settingsRoutes())
+}
+
+// settingsRoutes はユーザー管理以外の管理画面（状態を変えない GET のみ）。
+func settingsRoutes() []routeTestCase {
+	return []routeTestCase{
+		{Name: "GET /admin/access-logs（アクセスログ）", Method: http.MethodGet, Path: "/admin/access-logs", Permission: roles.LogsRead},
+		{Name: "GET /admin/audit（監査ログ）", Method: http.MethodGet, Path: "/admin/audit", Permission: roles.LogsRead},
+		{Name: "GET /admin/maintenance（メンテナンス）", Method: http.MethodGet, Path: "/admin/maintenance", Permission: roles.MaintenanceToggle},
+		{Name: "GET /admin/api-tokens（全員の API トークン）", Method: http.MethodGet, Path: "/admin/api-tokens", Permission: roles.APITokenManage},
+		{Name: "GET /admin/api-docs（API エクスプローラ）", Method: http.MethodGet, Path: "/admin/api-docs", Permission: roles.APIDocsRead},
+		{Name: "GET /admin/roles（ロール）", Method: http.MethodGet, Path: "/admin/roles", Permission: roles.RoleManage},
+		{Name: "GET /admin/trash（ゴミ箱）", Method: http.MethodGet, Path: "/admin/trash", Permission: roles.UserManage},
+		{Name: "GET /api/v1/users（JSON API）", Method: http.MethodGet, Path: "/api/v1/users", Permission: roles.UserManage, UnauthStatus: http.StatusUnauthorized},
+		{Name: "POST /api/v1/projects（JSON API）", Method: http.MethodPost, Path: "/api/v1/projects", Body: `{"name":"api"}`, BodyType: bodyJSON,
+			Permission: roles.ProjectWrite, OKStatus: http.StatusCreated, UnauthStatus: http.StatusUnauthorized},
+	}
+}
+
+// roles.Permissions のどの権限も、マトリクスのどこかで検証されている。
+func TestPermissionMatrix_CoversEveryPermission(t *testing.T) {
+	covered := map[roles.Permission]bool{}
+	for _, rt := range settingsRoutes() {
+		covered[rt.Permission] = true
+	}
+	covered[roles.ProjectWrite] = true    // TestPermissionMatrix_ProjectRoutes
+	covered[roles.ProjectAll] = true      // TestProjectMembers_*（ルートではなく見えるプロジェクトの範囲を決める）
+	covered[roles.UserManage] = true      // TestPermissionMatrix_AdminRoutes
+	covered[roles.UserImpersonate] = true // TestImpersonation_*（セッション Cookie が要るのでマトリクスの外）
+	for _, p := range roles.Permissions {
+		if !covered[p] {
+			t.Errorf("権限 %s を要求するルートがマトリクスに無い", p)
+		}
+	}
+}
+
 // dispatchRequest は routeTestCase の BodyType に応じて Form/JSON のリクエストを使い分ける
 func dispatchRequest(h http.Handler, rt routeTestCase, user *database.User) *httptest.ResponseRecorder {
 	if rt.BodyType == bodyJSON {
@@ -141,35 +186,52 @@ func dispatchRequest(h http.Handler, rt routeTestCase, user *database.User) *htt
 	return DoRequest(h, rt.Method, rt.Path, user, rt.Body)
 }
 
-// runPermissionMatrix は各ルートに対して全ロール + 未認証のテストを実行する
-func runPermissionMatrix(t *testing.T, h http.Handler, seed SeedData, routes []routeTestCase) {
+// userWithRole は seed のうち role のユーザーを返す。
+func (s *SeedData) userWithRole(t *testing.T, role string) *database.User {
 	t.Helper()
-
-	type roleCase struct {
-		name string
-		user *database.User
+	switch role {
+	case roles.Admin:
+		return &s.AdminUser
+	case roles.Editor:
+		return &s.EditorUser
+	case roles.Viewer:
+		return &s.ViewerUser
 	}
+	t.Fatalf("ロール %q の seed ユーザーが無い", role)
+	return nil
+}
+
+// runPermissionMatrix は各ルートに対して全ロール + 未認証のテストを実行する。
+// 期待するステータスはルートの Permission と roles の対応表から決める。
+func runPermissionMatrix(t *testing.T, h http.Handler, seed SeedData, routes []routeTestCase) {
+	t.Helper()
 
 	for _, rt := range routes {
 		t.Run(rt.Name, func(t *testing.T) {
-			cases := []struct {
-				role       roleCase
-				wantStatus int
-			}{
-				{roleCase{"admin", &seed.AdminUser}, rt.AdminStatus},
-				{roleCase{"editor", &seed.EditorUser}, rt.EditorStatus},
-				{roleCase{"viewer", &seed.ViewerUser}, rt.ViewerStatus},
-				{roleCase{"未認証", nil}, rt.UnauthStatus},
+			ok := rt.OKStatus
+			if ok == 0 {
+				ok = http.StatusOK
 			}
-
-			for _, tc := range cases {
-				t.Run(tc.role.name, func(t *testing.T) {
-					var rec = dispatchRequest(h, rt, tc.role.user)
-					if rec.Code != tc.wantStatus {
-						t.Errorf("ステータスコード = %d, want %d", rec.Code, tc.wantStatus)
+			for _, role := range roles.All {
+				t.Run(role, func(t *testing.T) {
+					want := ok
+					if rt.Permission != "" && !roles.Has(role, rt.Permission) {
+						want = http.StatusForbidden
+					}
+					if rec := dispatchRequest(h, rt, seed.userWithRole(t, role)); rec.Code != want {
+						t.Errorf("ステータスコード = %d, want %d", rec.Code, want)
 					}
 				})
 			}
+			t.Run("未認証", func(t *testing.T) {
+				want := rt.UnauthStatus
+				if want == 0 {
+					want = http.StatusSeeOther
+				}
+				if rec := dispatchRequest(h, rt, nil); rec.Code != want {
+					t.Errorf("ステータスコード = %d, want %d", rec.Code, want)
+				}
+			})
 		})
 	}
 }
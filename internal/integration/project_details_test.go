@@ -0,0 +1,209 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/models"
+)
+
+// 作成ダイアログの項目（説明・状態・期限・タグ）が保存され、責任者は作成者になる。
+// 編集ダイアログで責任者・状態などを変えられ、詳細画面に出る（説明は Markdown を HTML にする）。
+func TestProjects_DetailsSSE(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	rec := DoSSERequest(e, http.MethodPost, "/api/sse/projects/new", &seed.EditorUser,
+		`{"name":"詳細つき","description":"  ## 目的\n- **移行**\n<script>alert(1)</script>  ","status":"planned","dueDate":"2026-12-01","tags":" 社内, 2026年度,社内,, ","ownerId":"999"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("作成: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "計画中") || !strings.Contains(body, "#社内") || !strings.Contains(body, "2026/12/01") {
+		t.Errorf("カードに状態・タグ・期限が出ていない: %s", body)
+	}
+	projects, err := q.ListProjects(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p database.Project
+	for _, project := range projects {
+		if project.ID != seed.Project.ID {
+			p = project
+		}
+	}
+	if p.Name != "詳細つき" || p.Description != "## 目的\n- **移行**\n<script>alert(1)</script>" || p.Status != models.ProjectStatusPlanned ||
+		p.Tags != "社内,2026年度" || p.DueDate.Time.Format("2006-01-02") != "2026-12-01" {
+		t.Errorf("保存された値 = %+v", p)
+	}
+	// 作成ダイアログは責任者を送らない（前の編集ダイアログの ownerId が残っていても使わない）。
+	if !p.OwnerID.Valid || p.OwnerID.Int64 != seed.EditorUser.ID {
+		t.Errorf("責任者 = %+v, want 作成者 (%d)", p.OwnerID, seed.EditorUser.ID)
+	}
+
+	page := DoRequest(e, http.MethodGet, sprintf("/projects/%d", p.ID), &seed.EditorUser).Body.String()
+	for _, want := range []string{"<h2>目的</h2>", "<li><strong>移行</strong></li>", "&lt;script&gt;alert(1)&lt;/script&gt;", "計画中", "Editor", "2026/12/01"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("詳細画面に %q が無い", want)
+		}
+	}
+	if strings.Contains(page, "<script>alert(1)") {
+		t.Error("説明の HTML がエスケープされていない")
+	}
+
+	// 編集ダイアログの責任者の選択肢はメンバー。メンバーでないユーザーは選べない。
+	dialog := DoSSERequest(e, http.MethodGet, sprintf("/api/sse/projects/%d/edit", p.ID), &seed.EditorUser, "").Body.String()
+	if !strings.Contains(dialog, "editor@test.com") || strings.Contains(dialog, "viewer@test.com") {
+		t.Errorf("責任者の選択肢がメンバーになっていない: %s", dialog)
+	}
+	rec = DoSSERequest(e, http.MethodPut, sprintf("/api/sse/projects/%d", p.ID), &seed.EditorUser,
+		sprintf(`{"name":"詳細つき","ownerId":"%d"}`, seed.ViewerUser.ID))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "責任者はプロジェクトのメンバーから選んでください") {
+		t.Errorf("メンバーでない責任者: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	rec = DoSSERequest(e, http.MethodPut, sprintf("/api/sse/projects/%d", p.ID), &seed.EditorUser,
+		`{"name":"詳細つき","description":"","status":"done","ownerId":"","dueDate":"","tags":""}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("更新: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	after, err := q.GetProject(t.Context(), p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Status != models.ProjectStatusDone || after.OwnerID.Valid || after.DueDate.Valid || after.Tags != "" || after.Description != "" {
+		t.Errorf("更新後 = %+v", after)
+	}
+}
+
+// 不正な項目は 400 で理由を返し、何も変えない。送らなかった項目は今の値のまま。
+func TestProjects_DetailsValidation(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+	path := sprintf("/api/sse/projects/%d", seed.Project.ID)
+
+	tags := make([]string, 11)
+	for i := range tags {
+		tags[i] = sprintf("t%d", i)
+	}
+	for _, tc := range []struct {
+		name, body, want string
+	}{
+		{"状態", `{"name":"x","status":"closed"}`, "状態の指定が不正です"},
+		{"期限の形式", `{"name":"x","dueDate":"2026/12/01"}`, "期限は YYYY-MM-DD の形式で入力してください"},
+		{"存在しない日付", `{"name":"x","dueDate":"2026-02-30"}`, "期限は YYYY-MM-DD の形式で入力してください"},
+		{"タグの数", `{"name":"x","tags":"` + strings.Join(tags, ",") + `"}`, "タグは 10 個までです"},
+		{"タグの長さ", `{"name":"x","tags":"` + strings.Repeat("長", 31) + `"}`, "タグは 1 つ 30 文字以内で入力してください"},
+		{"説明の長さ", `{"name":"x","description":"` + strings.Repeat("a", 10001) + `"}`, "説明は 10000 文字以内で入力してください"},
+		{"責任者の値", `{"name":"x","ownerId":"abc"}`, "責任者の指定が不正です"},
+		{"名前", `{"name":"  ","status":"done"}`, "プロジェクト名は必須です"},
+	} {
+		rec := DoSSERequest(e, http.MethodPut, path, &seed.EditorUser, tc.body)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tc.want) {
+			t.Errorf("%s: status = %d, body = %s", tc.name, rec.Code, rec.Body.String())
+		}
+	}
+	if p, _ := q.GetProject(t.Context(), seed.Project.ID); p != seed.Project {
+		t.Errorf("断られたのに変わった: %+v", p)
+	}
+
+	rec := DoSSERequest(e, http.MethodPut, path, &seed.EditorUser, `{"name":"名前だけ変更"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if p, _ := q.GetProject(t.Context(), seed.Project.ID); p.Name != "名前だけ変更" || p.Status != seed.Project.Status {
+		t.Errorf("送らなかった項目が変わった: %+v", p)
+	}
+}
+
+// JSON API も同じ項目を読み書きでき、PATCH は送った項目だけを変える。
+func TestAPIv1_ProjectDetails(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	type apiProject struct {
+		ID          int64    `json:"id"`
+		Description string   `json:"description"`
+		Status      string   `json:"status"`
+		OwnerID     *int64   `json:"owner_id"`
+		DueDate     *string  `json:"due_date"`
+		Tags        []string `json:"tags"`
+	}
+	decode := func(body []byte) apiProject {
+		t.Helper()
+		var out struct {
+			Data apiProject `json:"data"`
+		}
+		if err := json.Unmarshal(body, &out); err != nil {
+			t.Fatalf("JSON の解釈に失敗: %v: %s", err, body)
+		}
+		return out.Data
+	}
+
+	// 省略すると状態は active、責任者は作成者、タグは空の配列。
+	rec := DoAPIRequest(e, http.MethodPost, "/api/v1/projects", &seed.EditorUser, `{"name":"既定値"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("作成: got %d: %s", rec.Code, rec.Body.String())
+	}
+	p := decode(rec.Body.Bytes())
+	if p.Status != models.ProjectStatusActive || p.OwnerID == nil || *p.OwnerID != seed.EditorUser.ID || p.DueDate != nil || p.Tags == nil || len(p.Tags) != 0 {
+		t.Errorf("既定値 = %+v", p)
+	}
+
+	rec = DoAPIRequest(e, http.MethodPost, "/api/v1/projects", &seed.EditorUser,
+		`{"name":"全部","description":"説明","status":"on_hold","owner_id":0,"due_date":"2027-01-31","tags":["a","b"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("作成: got %d: %s", rec.Code, rec.Body.String())
+	}
+	p = decode(rec.Body.Bytes())
+	if p.Status != models.ProjectStatusOnHold || p.OwnerID != nil || p.DueDate == nil || *p.DueDate != "2027-01-31" || strings.Join(p.Tags, ",") != "a,b" {
+		t.Errorf("作成 = %+v", p)
+	}
+	// 作ったばかりのプロジェクトのメンバーは作成者だけ。
+	rec = DoAPIRequest(e, http.MethodPost, "/api/v1/projects", &seed.EditorUser, sprintf(`{"name":"x","owner_id":%d}`, seed.ViewerUser.ID))
+	if rec.Code != http.StatusBadRequest || decodeAPI(t, rec).Error != "invalid_request" {
+		t.Errorf("メンバーでない責任者: got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = DoAPIRequest(e, http.MethodPost, "/api/v1/projects", &seed.EditorUser, `{"name":"x","tags":["a,b"]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "タグにカンマは使えません") {
+		t.Errorf("カンマを含むタグ: got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	path := sprintf("/api/v1/projects/%d", p.ID)
+	rec = DoAPIRequest(e, http.MethodPatch, path, &seed.EditorUser, sprintf(`{"tags":["c"],"owner_id":%d}`, seed.EditorUser.ID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("更新: got %d: %s", rec.Code, rec.Body.String())
+	}
+	updated := decode(rec.Body.Bytes())
+	if strings.Join(updated.Tags, ",") != "c" || updated.OwnerID == nil || updated.Status != models.ProjectStatusOnHold || updated.Description != "説明" || *updated.DueDate != "2027-01-31" {
+		t.Errorf("送った項目だけ変わっていない: %+v", updated)
+	}
+}
+
+// 責任者をメンバーから外すと、責任者はなしになる。
+func TestProjects_RemovingOwnerMemberClearsOwner(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	rec := DoSSERequest(e, http.MethodPut, sprintf("/api/sse/projects/%d", seed.Project.ID), &seed.EditorUser,
+		sprintf(`{"name":"テストプロジェクト","ownerId":"%d"}`, seed.ViewerUser.ID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("責任者の変更: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	rec = DoSSERequest(e, http.MethodDelete, sprintf("/api/sse/projects/%d/members/%d", seed.Project.ID, seed.ViewerUser.ID), &seed.EditorUser, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("メンバーを外す: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if p, _ := q.GetProject(t.Context(), seed.Project.ID); p.OwnerID.Valid {
+		t.Errorf("外したメンバーが責任者のまま: %+v", p.OwnerID)
+	}
+}
@@ -0,0 +1,251 @@
+package integration
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// createProjectAs は user として SSE でプロジェクトを作り、作ったプロジェクトを返す（user がオーナーになる）。
+func createProjectAs(t *testing.T, h http.Handler, conn *sql.DB, user *database.User, name string) database.Project {
+	t.Helper()
+	if rec := DoSSERequest(h, http.MethodPost, "/api/sse/projects/new", user, sprintf(`{"name":%q}`, name)); rec.Code != http.StatusOK {
+		t.Fatalf("プロジェクト作成: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	projects, err := queryFromConn(conn).ListProjects(t.Context())
+	if err != nil {
+		t.Fatalf("ListProjects: %v", err)
+	}
+	for _, p := range projects {
+		if p.Name == name {
+			return p
+		}
+	}
+	t.Fatalf("作成したプロジェクト %q が無い", name)
+	return database.Project{}
+}
+
+// メンバーでないプロジェクトは、画面・SSE・JSON API のどこからも存在しないのと同じ 404 になる。
+func TestProjectMembers_CrossProjectAccessReturns404(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	// admin がオーナーのプロジェクト。editor / viewer はメンバーではない。
+	other := createProjectAs(t, e, conn, &seed.AdminUser, "他チームのプロジェクト")
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		api    bool
+	}{
+		{"詳細", http.MethodGet, sprintf("/projects/%d", other.ID), "", false},
+		{"編集ダイアログ", http.MethodGet, sprintf("/api/sse/projects/%d/edit", other.ID), "", false},
+		{"更新", http.MethodPut, sprintf("/api/sse/projects/%d", other.ID), `{"name":"乗っ取り"}`, false},
+		{"削除", http.MethodDelete, sprintf("/api/sse/projects/%d", other.ID), "", false},
+		{"メンバー追加", http.MethodPost, sprintf("/api/sse/projects/%d/members", other.ID), `{"memberEmail":"editor@test.com","memberRole":"owner"}`, false},
+		{"API 取得", http.MethodGet, sprintf("/api/v1/projects/%d", other.ID), "", true},
+		{"API 更新", http.MethodPatch, sprintf("/api/v1/projects/%d", other.ID), `{"name":"乗っ取り"}`, true},
+		{"API 削除", http.MethodDelete, sprintf("/api/v1/projects/%d", other.ID), "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got int
+			if tc.api {
+				got = DoAPIRequest(e, tc.method, tc.path, &seed.EditorUser, tc.body).Code
+			} else if tc.method == http.MethodGet && !strings.HasPrefix(tc.path, "/api/") {
+				got = DoRequest(e, tc.method, tc.path, &seed.EditorUser, "").Code
+			} else {
+				got = DoSSERequest(e, tc.method, tc.path, &seed.EditorUser, tc.body).Code
+			}
+			if got != http.StatusNotFound {
+				t.Errorf("status = %d, want 404", got)
+			}
+		})
+	}
+
+	if p, err := q.GetProject(t.Context(), other.ID); err != nil || p.Name != other.Name {
+		t.Fatalf("メンバーでないユーザーの操作でプロジェクトが変わった: %+v, %v", p, err)
+	}
+	if _, err := q.GetProjectMember(t.Context(), database.GetProjectMemberParams{ProjectID: other.ID, UserID: seed.EditorUser.ID}); err == nil {
+		t.Error("メンバーでないユーザーが自分をメンバーに追加できた")
+	}
+
+	// 一覧にも出ない（自分がメンバーのプロジェクトだけ）。
+	for _, path := range []string{"/projects", "/api/v1/projects"} {
+		var body string
+		if strings.HasPrefix(path, "/api/") {
+			body = DoAPIRequest(e, http.MethodGet, path, &seed.ViewerUser, "").Body.String()
+		} else {
+			body = DoRequest(e, http.MethodGet, path, &seed.ViewerUser, "").Body.String()
+		}
+		if strings.Contains(body, other.Name) || !strings.Contains(body, seed.Project.Name) {
+			t.Errorf("%s: メンバーのプロジェクトだけが出ていない", path)
+		}
+	}
+
+	// project.all を持つ admin はメンバーでなくても見える。
+	if rec := DoRequest(e, http.MethodGet, sprintf("/projects/%d", seed.Project.ID), &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Errorf("admin の詳細: status = %d, want 200", rec.Code)
+	}
+	if rec := DoRequest(e, http.MethodGet, "/projects", &seed.AdminUser, ""); !strings.Contains(rec.Body.String(), other.Name) || !strings.Contains(rec.Body.String(), seed.Project.Name) {
+		t.Error("admin の一覧に全プロジェクトが出ていない")
+	}
+}
+
+// メンバーでないユーザーの削除は、存在しない ID でも他人のプロジェクトでも同じ 404 になる
+// （応答の違いから、どの ID のプロジェクトがあるかを探れない）。
+func TestProjectMembers_DeleteDoesNotRevealExistence(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	other := createProjectAs(t, e, conn, &seed.AdminUser, "他チームのプロジェクト")
+	const missingID = 99999
+
+	for _, prefix := range []string{"/api/sse/projects/", "/api/v1/projects/"} {
+		do := func(id int64) *httptest.ResponseRecorder {
+			path := sprintf("%s%d", prefix, id)
+			if strings.HasPrefix(prefix, "/api/v1/") {
+				return DoAPIRequest(e, http.MethodDelete, path, &seed.EditorUser, "")
+			}
+			return DoSSERequest(e, http.MethodDelete, path, &seed.EditorUser, "")
+		}
+		missing, existing := do(missingID), do(other.ID)
+		if missing.Code != http.StatusNotFound || existing.Code != http.StatusNotFound {
+			t.Errorf("%s: 存在しない ID = %d, 他人のプロジェクト = %d, want どちらも 404", prefix, missing.Code, existing.Code)
+		}
+		if missing.Body.String() != existing.Body.String() {
+			t.Errorf("%s: 応答の本文が違う: %q / %q", prefix, missing.Body.String(), existing.Body.String())
+		}
+	}
+
+	if _, err := q.GetProject(t.Context(), other.ID); err != nil {
+		t.Errorf("メンバーでないユーザーの削除でプロジェクトが消えた: %v", err)
+	}
+}
+
+// 書き込めるかはプロジェクトロールで決まる（閲覧者は読むだけ、編集者は更新まで、削除はオーナーだけ）。
+func TestProjectMembers_ProjectRoleLimitsWrites(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	p := createProjectAs(t, e, conn, &seed.AdminUser, "共有プロジェクト")
+	membersPath := sprintf("/api/sse/projects/%d/members", p.ID)
+	if rec := DoSSERequest(e, http.MethodPost, membersPath, &seed.AdminUser, `{"memberEmail":"editor@test.com","memberRole":"viewer"}`); rec.Code != http.StatusOK {
+		t.Fatalf("メンバー追加: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	path := sprintf("/api/sse/projects/%d", p.ID)
+	if rec := DoRequest(e, http.MethodGet, sprintf("/projects/%d", p.ID), &seed.EditorUser, ""); rec.Code != http.StatusOK {
+		t.Errorf("閲覧者の詳細: status = %d, want 200", rec.Code)
+	}
+	if rec := DoSSERequest(e, http.MethodPut, path, &seed.EditorUser, `{"name":"閲覧者の更新"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("閲覧者の更新: status = %d, want 403", rec.Code)
+	}
+
+	memberPath := sprintf("%s/%d", membersPath, seed.EditorUser.ID)
+	body := sprintf(`{"memberRoles":{"u%d":"editor"}}`, seed.EditorUser.ID)
+	if rec := DoSSERequest(e, http.MethodPut, memberPath, &seed.AdminUser, body); rec.Code != http.StatusOK {
+		t.Fatalf("ロール変更: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := DoSSERequest(e, http.MethodPut, path, &seed.EditorUser, `{"name":"編集者の更新"}`); rec.Code != http.StatusOK {
+		t.Errorf("編集者の更新: status = %d, want 200", rec.Code)
+	}
+	if rec := DoSSERequest(e, http.MethodDelete, path, &seed.EditorUser, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("編集者の削除: status = %d, want 403", rec.Code)
+	}
+	if rec := DoSSERequest(e, http.MethodPost, membersPath, &seed.EditorUser, `{"memberEmail":"viewer@test.com","memberRole":"viewer"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("編集者のメンバー追加: status = %d, want 403", rec.Code)
+	}
+	if _, err := q.GetProject(t.Context(), p.ID); err != nil {
+		t.Fatalf("編集者がプロジェクトを削除できた: %v", err)
+	}
+}
+
+// オーナーはメンバーを追加・ロール変更・削除でき、操作は監査ログに残る。最後のオーナーは外せない。
+func TestProjectMembers_OwnerManagesMembers(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	// seed.Project のオーナーは editor。
+	owner := &seed.EditorUser
+	membersPath := sprintf("/api/sse/projects/%d/members", seed.Project.ID)
+
+	rec := DoSSERequest(e, http.MethodPost, membersPath, owner, `{"memberEmail":"deletable@test.com","memberRole":"editor"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "project-members") {
+		t.Fatalf("メンバー追加: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	m, err := q.GetProjectMember(t.Context(), database.GetProjectMemberParams{ProjectID: seed.Project.ID, UserID: seed.DeletableUser.ID})
+	if err != nil || m.Role != roles.ProjectEditor {
+		t.Fatalf("メンバーが追加されていない: %+v, %v", m, err)
+	}
+
+	for _, tc := range []struct{ name, body string }{
+		{"既にメンバー", `{"memberEmail":"deletable@test.com","memberRole":"viewer"}`},
+		{"未登録のユーザー", `{"memberEmail":"nobody@test.com","memberRole":"viewer"}`},
+		{"不正なロール", `{"memberEmail":"admin@test.com","memberRole":"admin"}`},
+	} {
+		if rec := DoSSERequest(e, http.MethodPost, membersPath, owner, tc.body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", tc.name, rec.Code)
+		}
+	}
+
+	// 最後のオーナーは降格も削除もできない。
+	ownerPath := sprintf("%s/%d", membersPath, owner.ID)
+	if rec := DoSSERequest(e, http.MethodPut, ownerPath, owner, sprintf(`{"memberRoles":{"u%d":"editor"}}`, owner.ID)); rec.Code != http.StatusBadRequest {
+		t.Errorf("最後のオーナーの降格: status = %d, want 400", rec.Code)
+	}
+	if rec := DoSSERequest(e, http.MethodDelete, ownerPath, owner, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("最後のオーナーの削除: status = %d, want 400", rec.Code)
+	}
+
+	// 別のオーナーを立てれば外せる。
+	deletablePath := sprintf("%s/%d", membersPath, seed.DeletableUser.ID)
+	if rec := DoSSERequest(e, http.MethodPut, deletablePath, owner, sprintf(`{"memberRoles":{"u%d":"owner"}}`, seed.DeletableUser.ID)); rec.Code != http.StatusOK {
+		t.Fatalf("オーナーへの変更: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := DoSSERequest(e, http.MethodDelete, deletablePath, owner, ""); rec.Code != http.StatusOK {
+		t.Fatalf("メンバー削除: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if _, err := q.GetProjectMember(t.Context(), database.GetProjectMemberParams{ProjectID: seed.Project.ID, UserID: seed.DeletableUser.ID}); err == nil {
+		t.Error("メンバーが外れていない")
+	}
+	if rec := DoSSERequest(e, http.MethodDelete, deletablePath, owner, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("メンバーでないユーザーの削除: status = %d, want 404", rec.Code)
+	}
+
+	var actions []string
+	for _, l := range listAllAuditLogs(t, q) {
+		if l.TargetType == audit.TargetProject && l.TargetID == sprintf("%d", seed.Project.ID) {
+			actions = append(actions, l.Action)
+		}
+	}
+	// listAllAuditLogs は新しい順
+	want := []string{audit.ActionProjectMemberRemove, audit.ActionProjectMemberUpdate, audit.ActionProjectMemberAdd}
+	if strings.Join(actions, ",") != strings.Join(want, ",") {
+		t.Errorf("監査ログ = %v, want %v", actions, want)
+	}
+
+	// 詳細画面にメンバー管理が出るのはオーナーだけ。
+	if body := DoRequest(e, http.MethodGet, sprintf("/projects/%d", seed.Project.ID), owner, "").Body.String(); !strings.Contains(body, membersPath) {
+		t.Error("オーナーの詳細画面に追加フォームが無い")
+	}
+	if body := DoRequest(e, http.MethodGet, sprintf("/projects/%d", seed.Project.ID), &seed.ViewerUser, "").Body.String(); strings.Contains(body, membersPath) {
+		t.Error("閲覧者の詳細画面に追加フォームが出ている")
+	}
+}
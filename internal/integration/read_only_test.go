@@ -0,0 +1,96 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
+)
+
+// 読み取り専用モード: 閲覧はできるが、admin を含む全員の書き込みが止まる。
+// Datastar にはトースト、それ以外には 503 + Retry-After を返し、画面の書き込みボタンは隠す。
+func TestReadOnly_BlocksWrites(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	if rec := DoSSERequest(e, http.MethodPost, "/api/sse/admin/maintenance/read-only/toggle", &seed.EditorUser, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("editor の切替: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := DoSSERequest(e, http.MethodPost, "/api/sse/admin/maintenance/read-only/toggle", &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("ON: got %d", rec.Code)
+	}
+	if !maintenance.Load(t.Context(), q).ReadOnly {
+		t.Fatalf("切替後も OFF のまま")
+	}
+
+	// 閲覧は通常どおり。書き込みボタンは出さず、バナーを出す
+	rec := DoRequest(e, http.MethodGet, "/projects", &seed.EditorUser)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("editor の一覧: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "読み取り専用モード中です") {
+		t.Errorf("バナーが無い")
+	}
+	if strings.Contains(body, "プロジェクトを追加") || strings.Contains(body, "/api/sse/projects/") {
+		t.Errorf("読み取り専用モード中に書き込みボタンが出ている")
+	}
+	if body := DoRequest(e, http.MethodGet, "/admin/users", &seed.AdminUser).Body.String(); strings.Contains(body, `aria-label="ユーザーを追加"`) || strings.Contains(body, `href="/admin/users/import"`) {
+		t.Errorf("ユーザー管理に FAB・一括インポートが出ている")
+	}
+
+	// Datastar の書き込みはトーストで断り、何も変えない
+	rec = DoSSERequest(e, http.MethodPut, sprintf("/api/sse/projects/%d", seed.Project.ID), &seed.EditorUser, `{"name":"読み取り専用中の更新"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "読み取り専用モードのため") {
+		t.Errorf("editor の更新: got %d: %s", rec.Code, rec.Body.String())
+	}
+	if p, err := q.GetProject(t.Context(), seed.Project.ID); err != nil || p.Name == "読み取り専用中の更新" {
+		t.Errorf("読み取り専用中に更新が通っている: %+v, %v", p, err)
+	}
+	rec = DoSSERequest(e, http.MethodDelete, sprintf("/api/sse/admin/users/%d", seed.DeletableUser.ID), &seed.AdminUser, "")
+	if !strings.Contains(rec.Body.String(), "読み取り専用モードのため") {
+		t.Errorf("admin の削除が断られていない: %d %s", rec.Code, rec.Body.String())
+	}
+	if _, err := q.GetUserByID(t.Context(), seed.DeletableUser.ID); err != nil {
+		t.Errorf("読み取り専用中にユーザーが削除された: %v", err)
+	}
+
+	// Datastar 以外（一括インポートのフォーム送信・JSON）は 503
+	rec = DoRequest(e, http.MethodPost, "/admin/users/import", &seed.AdminUser, "a=b")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("インポート: got %d (Retry-After %q), want 503", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	req := httptest.NewRequest(http.MethodPut, sprintf("/api/sse/projects/%d", seed.Project.ID), strings.NewReader(`{"name":"x"}`))
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Test-User-ID", sprintf("%d", seed.EditorUser.ID))
+	jrec := httptest.NewRecorder()
+	e.ServeHTTP(jrec, req)
+	if jrec.Code != http.StatusServiceUnavailable || !strings.Contains(jrec.Body.String(), `"read_only"`) {
+		t.Errorf("JSON: got %d: %s", jrec.Code, jrec.Body.String())
+	}
+
+	// メンテナンス画面の操作だけは受け付ける（解除できる）
+	if rec := DoSSERequest(e, http.MethodPost, "/api/sse/admin/maintenance/read-only/toggle", &seed.AdminUser, ""); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "読み取り専用モードのため") {
+		t.Fatalf("OFF: got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = DoSSERequest(e, http.MethodPut, sprintf("/api/sse/projects/%d", seed.Project.ID), &seed.EditorUser, `{"name":"解除後の更新"}`)
+	if p, _ := q.GetProject(t.Context(), seed.Project.ID); rec.Code != http.StatusOK || p.Name != "解除後の更新" {
+		t.Errorf("解除後の更新: got %d, name %q", rec.Code, p.Name)
+	}
+
+	logs := listAllAuditLogs(t, q)
+	var toggles int
+	for _, l := range logs {
+		if l.Action == audit.ActionReadOnlyToggle {
+			toggles++
+		}
+	}
+	if toggles != 2 {
+		t.Errorf("read_only.toggle の監査ログ = %d 件, want 2", toggles)
+	}
+}
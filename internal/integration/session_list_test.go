@@ -0,0 +1,156 @@
+package integration
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+)
+
+// sessionIDOf は cookie のセッションの sessions.id を返す。
+func sessionIDOf(t *testing.T, conn *sql.DB, cookie *http.Cookie) int64 {
+	t.Helper()
+	var id int64
+	if err := conn.QueryRow(`SELECT id FROM sessions WHERE session_hash = ?`, authsession.HashToken(cookie.Value)).Scan(&id); err != nil {
+		t.Fatalf("セッションの取得に失敗: %v", err)
+	}
+	return id
+}
+
+// マジックリンクでのログイン時に IP と User-Agent が記録され、一覧に出る。
+func TestSessions_LoginRecordsDetails(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	ml.DevBypassEmails = map[string]bool{seed.ViewerUser.Email: true}
+	e := SetupSessionTestServer(t, conn, ml)
+
+	token, err := doHTTPLogin(e, seed.ViewerUser.Email, "203.0.113.7:5555")
+	if err != nil {
+		t.Fatalf("ログイン要求に失敗: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/auth/verify?token="+token, nil)
+	req.RemoteAddr = "203.0.113.7:5555"
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/128.0.0.0 Safari/537.36")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("verify: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	sessions, err := authsession.List(t.Context(), conn, seed.ViewerUser.Email, "")
+	if err != nil {
+		t.Fatalf("セッション一覧の取得に失敗: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("セッション数 = %d, want 1", len(sessions))
+	}
+	s := sessions[0]
+	if s.IP != "203.0.113.7" || s.Device != "Chrome / macOS" || s.LastSeenAt.IsZero() {
+		t.Errorf("記録内容 = IP %q, Device %q, LastSeenAt %v", s.IP, s.Device, s.LastSeenAt)
+	}
+}
+
+// 他の端末のセッションを 1 件ずつ終了できる。現在の端末・他人のセッションは終了できない。
+func TestSessions_RevokeOne(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+
+	current := LoginSession(t, ml, seed.ViewerUser)
+	other := LoginSession(t, ml, seed.ViewerUser)
+	editors := LoginSession(t, ml, seed.EditorUser)
+
+	rec := DoCookieRequest(e, http.MethodDelete, sprintf("/api/sse/profile/sessions/%d", sessionIDOf(t, conn, other)), current)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("他端末の終了: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "profile-sessions") {
+		t.Errorf("一覧カードが patch されていない: %s", rec.Body.String())
+	}
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", other); rec.Code != http.StatusSeeOther {
+		t.Errorf("終了したセッション: got %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", current); rec.Code != http.StatusOK {
+		t.Errorf("現在のセッション: got %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = DoCookieRequest(e, http.MethodDelete, sprintf("/api/sse/profile/sessions/%d", sessionIDOf(t, conn, current)), current)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("現在の端末の終了: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = DoCookieRequest(e, http.MethodDelete, sprintf("/api/sse/profile/sessions/%d", sessionIDOf(t, conn, editors)), current)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("他人のセッションの終了: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if n := countSessions(t, conn, seed.EditorUser.Email); n != 1 {
+		t.Errorf("他人のセッション数 = %d, want 1", n)
+	}
+}
+
+func TestSessions_RevokeOthers(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+
+	current := LoginSession(t, ml, seed.ViewerUser)
+	LoginSession(t, ml, seed.ViewerUser)
+	LoginSession(t, ml, seed.ViewerUser)
+	LoginSession(t, ml, seed.EditorUser)
+
+	rec := DoCookieRequest(e, http.MethodDelete, "/api/sse/profile/sessions", current)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("他端末の一括終了: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if n := countSessions(t, conn, seed.ViewerUser.Email); n != 1 {
+		t.Errorf("残ったセッション数 = %d, want 1（この端末のみ）", n)
+	}
+	if n := countSessions(t, conn, seed.EditorUser.Email); n != 1 {
+		t.Errorf("他人のセッション数 = %d, want 1", n)
+	}
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", current); rec.Code != http.StatusOK {
+		t.Errorf("現在のセッション: got %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+// 管理者はユーザー編集ダイアログから対象ユーザーの全セッションを終了できる。
+func TestSessions_AdminRevokesAllForUser(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+
+	LoginSession(t, ml, seed.ViewerUser)
+	LoginSession(t, ml, seed.ViewerUser)
+	LoginSession(t, ml, seed.EditorUser)
+
+	path := sprintf("/api/sse/admin/users/%d/sessions", seed.ViewerUser.ID)
+	if rec := DoSSERequest(e, http.MethodDelete, path, &seed.EditorUser, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("editor からの操作: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec := DoSSERequest(e, http.MethodDelete, path, &seed.AdminUser, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("全セッション終了: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "2 件のセッションを終了しました") {
+		t.Errorf("トーストに件数が出ていない: %s", rec.Body.String())
+	}
+	if n := countSessions(t, conn, seed.ViewerUser.Email); n != 0 {
+		t.Errorf("対象ユーザーのセッション数 = %d, want 0", n)
+	}
+	if n := countSessions(t, conn, seed.EditorUser.Email); n != 1 {
+		t.Errorf("他ユーザーのセッション数 = %d, want 1", n)
+	}
+
+	self := sprintf("/api/sse/admin/users/%d/sessions", seed.AdminUser.ID)
+	if rec := DoSSERequest(e, http.MethodDelete, self, &seed.AdminUser, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("自分自身: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,177 @@
+package integration
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+// countSessions は email の magiclink セッション数を返す。
+func countSessions(t *testing.T, conn *sql.DB, email string) int {
+	t.Helper()
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM sessions WHERE user_id = ?`, email).Scan(&n); err != nil {
+		t.Fatalf("sessions の集計に失敗: %v", err)
+	}
+	return n
+}
+
+// countPasskeys は email のパスキー数を返す。
+func countPasskeys(t *testing.T, conn *sql.DB, email string) int {
+	t.Helper()
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM passkey_credentials WHERE user_id = ?`, email).Scan(&n); err != nil {
+		t.Fatalf("passkey_credentials の集計に失敗: %v", err)
+	}
+	return n
+}
+
+// 無効化されたユーザーのセッションは次のリクエストで未ログイン扱いになり、全端末分が失効する。
+func TestSession_InactiveUserIsLoggedOut(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+	q := queryFromConn(conn)
+
+	cookie := LoginSession(t, ml, seed.ViewerUser)
+	LoginSession(t, ml, seed.ViewerUser) // 別端末のセッション
+
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", cookie); rec.Code != http.StatusOK {
+		t.Fatalf("無効化前: got %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	if _, err := q.UpdateUser(t.Context(), database.UpdateUserParams{
+		Name: seed.ViewerUser.Name, Role: seed.ViewerUser.Role, IsActive: false, ID: seed.ViewerUser.ID,
+	}); err != nil {
+		t.Fatalf("ユーザーの無効化に失敗: %v", err)
+	}
+	// 管理画面を経由しない変更は IdentityTTL まで反映されないので、キャッシュを捨てて DB を読ませる。
+	authsession.ForgetUser(seed.ViewerUser.Email)
+
+	rec := DoCookieRequest(e, http.MethodGet, "/projects", cookie)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("無効化後: got %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+	if n := countSessions(t, conn, seed.ViewerUser.Email); n != 0 {
+		t.Errorf("無効化ユーザーのセッションが %d 件残っている", n)
+	}
+	if !cookieCleared(rec, ml.Config.CookieName) {
+		t.Errorf("セッション Cookie が削除されていない")
+	}
+
+	// 他のユーザーのセッションには影響しない
+	editorCookie := LoginSession(t, ml, seed.EditorUser)
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", editorCookie); rec.Code != http.StatusOK {
+		t.Errorf("他ユーザー: got %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+// 管理画面からの削除（ゴミ箱に移す）でセッションが消え、削除前の Cookie は使えなくなる。
+// パスキーはゴミ箱から戻したときのために残る。
+func TestSession_DeleteUserRevokesSessionsAndKeepsPasskeys(t *testing.T) {
+	conn := SetupTestDB(t)
+	admin := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+
+	target := seed.DeletableUser
+	cookie := LoginSession(t, ml, target)
+	savePasskey(t, conn, "cred-deletable", target.Email)
+	savePasskey(t, conn, "cred-viewer", seed.ViewerUser.Email)
+
+	rec := DoSSERequest(admin, http.MethodDelete, sprintf("/api/sse/admin/users/%d", target.ID), &seed.AdminUser, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ユーザー削除: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	if n := countSessions(t, conn, target.Email); n != 0 {
+		t.Errorf("削除ユーザーのセッションが %d 件残っている", n)
+	}
+	if n := countPasskeys(t, conn, target.Email); n != 1 {
+		t.Errorf("ゴミ箱のユーザーのパスキー = %d 件, want 1", n)
+	}
+	if n := countPasskeys(t, conn, seed.ViewerUser.Email); n != 1 {
+		t.Errorf("他ユーザーのパスキー = %d 件, want 1", n)
+	}
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", cookie); rec.Code != http.StatusSeeOther {
+		t.Errorf("削除後の Cookie: got %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+}
+
+// users から直接消された（管理画面を経由しない）場合もミドルウェアがセッションを失効させる。
+func TestSession_MissingUserIsLoggedOut(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+
+	cookie := LoginSession(t, ml, seed.DeletableUser)
+	if _, err := conn.Exec(`DELETE FROM users WHERE id = ?`, seed.DeletableUser.ID); err != nil {
+		t.Fatalf("ユーザー削除に失敗: %v", err)
+	}
+
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", cookie); rec.Code != http.StatusSeeOther {
+		t.Fatalf("削除後: got %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+	if n := countSessions(t, conn, seed.DeletableUser.Email); n != 0 {
+		t.Errorf("削除ユーザーのセッションが %d 件残っている", n)
+	}
+}
+
+// savePasskey はテスト用のダミーのパスキーを登録する（公開鍵の中身は検証しない）。
+// magiclink の PasskeyCredential 型は internal パッケージにあり使えないため SQL で入れる。
+func savePasskey(t *testing.T, conn *sql.DB, id, email string) {
+	t.Helper()
+	now := time.Now().Unix()
+	if _, err := conn.Exec(`INSERT INTO passkey_credentials (id, user_id, public_key, aaguid, attestation_type, transports, created_at, updated_at)
+		VALUES (?, ?, x'00', '', 'none', '[]', ?, ?)`, id, email, now, now); err != nil {
+		t.Fatalf("パスキー登録に失敗: %v", err)
+	}
+}
+
+// cookieCleared は name の Cookie を削除する Set-Cookie が返っているかを判定する。
+func cookieCleared(rec *httptest.ResponseRecorder, name string) bool {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name && c.MaxAge < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ユーザー情報のキャッシュが効いていても、管理画面での無効化とログアウトは次のリクエストから効く。
+func TestSession_IdentityCacheIsInvalidated(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+	admin := SetupTestServer(t, conn)
+
+	viewer := LoginSession(t, ml, seed.ViewerUser)
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", viewer); rec.Code != http.StatusOK {
+		t.Fatalf("無効化前: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := `{"editName":"Viewer","editRole":"viewer","editStatus":"inactive"}`
+	if rec := DoSSERequest(admin, http.MethodPut, sprintf("/api/sse/admin/users/%d", seed.ViewerUser.ID), &seed.AdminUser, body); rec.Code != http.StatusOK {
+		t.Fatalf("無効化: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", viewer); rec.Code != http.StatusSeeOther {
+		t.Errorf("無効化後: got %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+
+	editor := LoginSession(t, ml, seed.EditorUser)
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", editor); rec.Code != http.StatusOK {
+		t.Fatalf("ログアウト前: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	DoCookieRequest(e, http.MethodPost, "/auth/logout", editor)
+	if rec := DoCookieRequest(e, http.MethodGet, "/projects", editor); rec.Code != http.StatusSeeOther {
+		t.Errorf("ログアウト後: got %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+}
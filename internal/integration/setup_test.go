@@ -1,15 +1,31 @@
 package integration
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+
+	"github.com/pressly/goose/v3"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/db"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appconfig"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
 )
 
 // 初期セットアップフロー (/setup) のテスト。
 // 派生プロジェクトでも初回管理者作成の手順は共通なので、ここで挙動を保証する。
 
+// setupBody は fields（JSON のオブジェクトの中身）にトークンを足した POST /setup の body を返す。
+func setupBody(fields string) string {
+	return fmt.Sprintf(`{"token":%q,%s}`, testSetupToken, fields)
+}
+
 // ---------------------------------------------------------------------------
 // GET /setup
 // ---------------------------------------------------------------------------
@@ -18,7 +34,7 @@ func TestSetup_PageWithNoUsers(t *testing.T) {
 	conn := SetupTestDB(t)
 	e := SetupTestServer(t, conn)
 
-	rec := DoRequest(e, http.MethodGet, "/setup", nil)
+	rec := DoRequest(e, http.MethodGet, "/setup?token="+testSetupToken, nil)
 
 	if rec.Code != http.StatusOK {
 		t.Fatalf("ステータスコード = %d, want %d", rec.Code, http.StatusOK)
@@ -30,6 +46,19 @@ func TestSetup_PageWithNoUsers(t *testing.T) {
 	}
 }
 
+// トークン（起動時にログへ出す）が無い・違うと、ユーザーがいなくても開けない。
+func TestSetup_PageRequiresToken(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+
+	for _, path := range []string{"/setup", "/setup?token=wrong-token-0123456789"} {
+		rec := DoRequest(e, http.MethodGet, path, nil)
+		if rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "setup-form") {
+			t.Errorf("%s: ステータスコード = %d, want %d", path, rec.Code, http.StatusForbidden)
+		}
+	}
+}
+
 func TestSetup_PageWithExistingUsers(t *testing.T) {
 	conn := SetupTestDB(t)
 	e := SetupTestServer(t, conn)
@@ -54,7 +83,7 @@ func TestSetup_CreateAdminSuccess(t *testing.T) {
 	e := SetupTestServer(t, conn)
 
 	rec := DoSSERequest(e, http.MethodPost, "/setup", nil,
-		`{"email":"newadmin@test.com","name":"New Admin"}`)
+		setupBody(`"email":"newadmin@test.com","name":"New Admin"`))
 
 	if rec.Code != http.StatusOK {
 		t.Fatalf("ステータスコード = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
@@ -92,7 +121,7 @@ func TestSetup_CreateAdminDefaultName(t *testing.T) {
 
 	// name を省略
 	rec := DoSSERequest(e, http.MethodPost, "/setup", nil,
-		`{"email":"noname@test.com"}`)
+		setupBody(`"email":"noname@test.com"`))
 
 	if rec.Code != http.StatusOK {
 		t.Fatalf("ステータスコード = %d, want %d", rec.Code, http.StatusOK)
@@ -114,7 +143,7 @@ func TestSetup_CreateAdminAlreadySetup(t *testing.T) {
 	SeedTestData(t, conn) // 既にユーザーがいる状態
 
 	rec := DoSSERequest(e, http.MethodPost, "/setup", nil,
-		`{"email":"another@test.com","name":"Another"}`)
+		setupBody(`"email":"another@test.com","name":"Another"`))
 
 	if rec.Code != http.StatusForbidden {
 		t.Fatalf("ステータスコード = %d, want %d", rec.Code, http.StatusForbidden)
@@ -144,7 +173,7 @@ func TestSetup_CreateAdminMissingEmail(t *testing.T) {
 	e := SetupTestServer(t, conn)
 
 	rec := DoSSERequest(e, http.MethodPost, "/setup", nil,
-		`{"name":"NoEmail"}`)
+		setupBody(`"name":"NoEmail"`))
 
 	if rec.Code != http.StatusBadRequest {
 		t.Errorf("ステータスコード = %d, want %d", rec.Code, http.StatusBadRequest)
@@ -160,3 +189,157 @@ func TestSetup_CreateAdminMissingEmail(t *testing.T) {
 		t.Errorf("User 件数 = %d, want 0（email 必須エラー後にユーザーが作成された）", len(users))
 	}
 }
+
+func TestSetup_CreateAdminRequiresToken(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+
+	for name, body := range map[string]string{
+		"トークン無し": `{"email":"attacker@test.com"}`,
+		"トークン違い": `{"token":"wrong-token-0123456789","email":"attacker@test.com"}`,
+		"空のトークン": `{"token":"","email":"attacker@test.com"}`,
+	} {
+		rec := DoSSERequest(e, http.MethodPost, "/setup", nil, body)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: ステータスコード = %d, want %d", name, rec.Code, http.StatusForbidden)
+		}
+	}
+	if n, _ := queryFromConn(conn).CountUsers(t.Context()); n != 0 {
+		t.Errorf("トークン無しでユーザーが作成された: %d 件", n)
+	}
+}
+
+func TestSetup_CreateAdminInvalidEmail(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+
+	for _, email := range []string{"not-an-email", "admin@", "Admin <admin@test.com>", "a b@test.com"} {
+		rec := DoSSERequest(e, http.MethodPost, "/setup", nil, setupBody(fmt.Sprintf(`"email":%q`, email)))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "メールアドレスの形式が正しくありません") {
+			t.Errorf("%q: ステータスコード = %d, body = %s", email, rec.Code, rec.Body.String())
+		}
+	}
+	if n, _ := queryFromConn(conn).CountUsers(t.Context()); n != 0 {
+		t.Errorf("不正なメールアドレスでユーザーが作成された: %d 件", n)
+	}
+}
+
+// 同時に送られても、作られる管理者は 1 人だけ（数えてから作る間に割り込まれない）。
+// インメモリ DB は接続ごとに別物になるため、本番と同じファイルの DB で確かめる。
+func TestSetup_ConcurrentRequestsCreateOneAdmin(t *testing.T) {
+	conn, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "setup.db")+"?_pragma=busy_timeout(30000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(on)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	goose.SetBaseFS(db.MigrationsFS)
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := goose.Up(conn, "migrations"); err != nil {
+		t.Fatal(err)
+	}
+	e := SetupTestServer(t, conn)
+
+	const n = 10
+	codes := make([]int, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Go(func() {
+			rec := DoSSERequest(e, http.MethodPost, "/setup", nil, setupBody(fmt.Sprintf(`"email":"admin%d@test.com"`, i)))
+			codes[i] = rec.Code
+		})
+	}
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			created++
+		case http.StatusForbidden:
+		default:
+			t.Errorf("ステータスコード = %d", code)
+		}
+	}
+	users, err := queryFromConn(conn).ListUsers(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 1 || len(users) != 1 {
+		t.Errorf("成功 = %d, ユーザー = %d 件, want 1 件", created, len(users))
+	}
+}
+
+// アプリ名を設定でき、画面の見出しに反映され、再起動後も読み込まれる。作成は監査ログに残る。
+func TestSetup_AppName(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	q := queryFromConn(conn)
+	t.Cleanup(func() { appconfig.SetAppName("") })
+
+	rec := DoSSERequest(e, http.MethodPost, "/setup", nil, setupBody(`"email":"owner@test.com","app_name":"  受発注管理  "`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ステータスコード = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := appconfig.AppName(); got != "受発注管理" {
+		t.Errorf("AppName = %q", got)
+	}
+	if page := DoRequest(e, http.MethodGet, "/auth/login", nil); !strings.Contains(page.Body.String(), "受発注管理") {
+		t.Error("ログイン画面にアプリ名が出ていない")
+	}
+
+	appconfig.SetAppName("")
+	if err := appconfig.LoadAppName(t.Context(), q); err != nil || appconfig.AppName() != "受発注管理" {
+		t.Errorf("保存したアプリ名を読み込めない: %q, %v", appconfig.AppName(), err)
+	}
+
+	latest := listAllAuditLogs(t, q)[0]
+	if latest.Action != audit.ActionUserSetup || latest.ActorEmail != "owner@test.com" {
+		t.Errorf("監査ログ = %+v", latest)
+	}
+
+	long := strings.Repeat("名", 51)
+	conn2 := SetupTestDB(t)
+	rec = DoSSERequest(SetupTestServer(t, conn2), http.MethodPost, "/setup", nil, setupBody(fmt.Sprintf(`"email":"owner@test.com","app_name":%q`, long)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("長すぎるアプリ名: ステータスコード = %d", rec.Code)
+	}
+}
+
+// セットアップを終える前に、テストメールで SMTP の設定を確かめられる（トークンが要る）。
+func TestSetup_TestMail(t *testing.T) {
+	conn := SetupTestDB(t)
+	outbox := &testOutbox{}
+	e := SetupTestServerWithOutbox(t, conn, outbox)
+
+	rec := DoSSERequest(e, http.MethodPost, "/setup/test-mail", nil, setupBody(`"email":"owner@test.com"`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ステータスコード = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if msgs := outbox.Messages(); len(msgs) != 1 || msgs[0].To != "owner@test.com" {
+		t.Fatalf("送信したメール = %+v", msgs)
+	}
+
+	// 送信に失敗したら理由を返す。
+	outbox.Err = errors.New("535 authentication failed")
+	rec = DoSSERequest(e, http.MethodPost, "/setup/test-mail", nil, setupBody(`"email":"owner@test.com"`))
+	if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "535 authentication failed") {
+		t.Errorf("送信失敗: ステータスコード = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	outbox.Err = nil
+
+	// トークンが無い・セットアップ後は送らない。
+	rec = DoSSERequest(e, http.MethodPost, "/setup/test-mail", nil, `{"email":"owner@test.com"}`)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("トークン無し: ステータスコード = %d", rec.Code)
+	}
+	SeedTestData(t, conn)
+	rec = DoSSERequest(e, http.MethodPost, "/setup/test-mail", nil, setupBody(`"email":"owner@test.com"`))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("セットアップ後: ステータスコード = %d", rec.Code)
+	}
+	if n := len(outbox.Messages()); n != 1 {
+		t.Errorf("送信したメール = %d 通, want 1", n)
+	}
+}
@@ -0,0 +1,261 @@
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/loginpolicy"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/signup"
+)
+
+// 承認不要のポリシーでは、許可ドメインのアドレスで初めてログインするとユーザーが作られる。
+// 作るのはリンクを開いて本人と確かめた後（AllowVerifiedLogin）で、リンクを送る時点では何も書かない。
+func TestSignup_AutoProvision(t *testing.T) {
+	newcomer := signupAddress("newcomer", "EXAMPLE.com")
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	rec := DoSSERequest(e, http.MethodPut, "/api/sse/admin/signup", &seed.AdminUser,
+		`{"signupEnabled":true,"signupDomains":"@Example.com\nexample.org, example.com","signupRole":"editor","signupApproval":false}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("設定の保存: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	policy := signup.Load(t.Context(), q)
+	if !policy.Enabled || strings.Join(policy.Domains, ",") != "example.com,example.org" || policy.DefaultRole != roles.Editor {
+		t.Fatalf("保存されたポリシー = %+v", policy)
+	}
+
+	if err := loginpolicy.AllowLogin(t.Context(), conn, newcomer, ""); err != nil {
+		t.Fatalf("許可ドメインのログイン: %v", err)
+	}
+	if _, err := q.GetUserByEmail(t.Context(), newcomer); err == nil {
+		t.Fatal("リンクを開く前にユーザーが作られた")
+	}
+	if err := loginpolicy.AllowVerifiedLogin(t.Context(), conn, newcomer); err != nil {
+		t.Fatalf("リンクを開いた後のログイン: %v", err)
+	}
+	user, err := q.GetUserByEmail(t.Context(), newcomer)
+	if err != nil {
+		t.Fatalf("自動登録されていない: %v", err)
+	}
+	if local, _, _ := strings.Cut(newcomer, "@"); !user.IsActive || user.Role != roles.Editor || user.Name != local {
+		t.Errorf("自動登録ユーザー = %+v", user)
+	}
+	logs := listAllAuditLogs(t, q)
+	if latest := logs[0]; latest.Action != audit.ActionUserSignup || latest.ActorID != user.ID {
+		t.Errorf("監査ログ = %s (actor %d), want %s (actor %d)", latest.Action, latest.ActorID, audit.ActionUserSignup, user.ID)
+	}
+
+	for _, email := range []string{"someone@other.com", "someone@sub.example.com", "someone@example.com.evil.test"} {
+		if err := loginpolicy.AllowLogin(t.Context(), conn, email, ""); err == nil || !strings.Contains(err.Error(), "登録されていません") {
+			t.Errorf("%s: got %v, want 登録されていません", email, err)
+		}
+	}
+}
+
+// 不正な設定は保存されない。
+func TestSignup_PolicyValidation(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+
+	cases := map[string]string{
+		"ドメイン無しで有効化": `{"signupEnabled":true,"signupDomains":" ","signupRole":"viewer","signupApproval":false}`,
+		"admin ロール":  `{"signupEnabled":true,"signupDomains":"example.com","signupRole":"admin","signupApproval":false}`,
+		"不正なロール":     `{"signupEnabled":true,"signupDomains":"example.com","signupRole":"owner","signupApproval":false}`,
+		"不正なドメイン":    `{"signupEnabled":true,"signupDomains":"localhost","signupRole":"viewer","signupApproval":false}`,
+	}
+	for name, body := range cases {
+		if rec := DoSSERequest(e, http.MethodPut, "/api/sse/admin/signup", &seed.AdminUser, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", name, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if p := signup.Load(t.Context(), queryFromConn(conn)); p.Enabled {
+		t.Errorf("不正な設定が保存された: %+v", p)
+	}
+}
+
+// 承認制のポリシーでは申請が承認待ちに積まれ、管理者が承認するまでログインできない。
+func TestSignup_ApprovalQueue(t *testing.T) {
+	waiting := signupAddress("wait", "example.com")
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+
+	if rec := DoSSERequest(e, http.MethodPut, "/api/sse/admin/signup", &seed.AdminUser,
+		`{"signupEnabled":true,"signupDomains":"example.com","signupRole":"viewer","signupApproval":true}`); rec.Code != http.StatusOK {
+		t.Fatalf("設定の保存: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	if err := loginpolicy.AllowLogin(t.Context(), conn, waiting, ""); err != nil {
+		t.Fatalf("リンクの送信: %v", err)
+	}
+	if _, err := q.GetSignupRequestByEmail(t.Context(), waiting); err == nil {
+		t.Fatal("リンクを開く前に承認待ちに積まれた")
+	}
+	if err := loginpolicy.AllowVerifiedLogin(t.Context(), conn, waiting); err == nil || !strings.Contains(err.Error(), "申請を受け付けました") {
+		t.Fatalf("初回: got %v, want 申請を受け付けました", err)
+	}
+	if err := loginpolicy.AllowLogin(t.Context(), conn, waiting, ""); err == nil || !strings.Contains(err.Error(), "承認待ち") {
+		t.Errorf("2 回目: got %v, want 承認待ち", err)
+	}
+	if _, err := q.GetUserByEmail(t.Context(), waiting); err == nil {
+		t.Errorf("承認前にユーザーが作られている")
+	}
+
+	page := DoRequest(e, http.MethodGet, "/admin/users", &seed.AdminUser)
+	if !strings.Contains(page.Body.String(), waiting) {
+		t.Errorf("承認待ちが一覧に出ていない")
+	}
+
+	req, err := q.GetSignupRequestByEmail(t.Context(), waiting)
+	if err != nil {
+		t.Fatalf("申請の取得に失敗: %v", err)
+	}
+	approve := sprintf("/api/sse/admin/signup-requests/%d/approve", req.ID)
+	if rec := DoSSERequest(e, http.MethodPost, approve, &seed.EditorUser, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("editor からの承認: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec := DoSSERequest(e, http.MethodPost, approve, &seed.AdminUser, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("承認: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "signup-requests") || !strings.Contains(rec.Body.String(), "users-list") {
+		t.Errorf("承認待ちと一覧が patch されていない: %s", rec.Body.String())
+	}
+	user, err := q.GetUserByEmail(t.Context(), waiting)
+	if err != nil {
+		t.Fatalf("承認後にユーザーが無い: %v", err)
+	}
+	if !user.IsActive || user.Role != roles.Viewer {
+		t.Errorf("承認されたユーザー = %+v", user)
+	}
+	if err := loginpolicy.AllowLogin(t.Context(), conn, waiting, ""); err != nil {
+		t.Errorf("承認後のログイン: %v", err)
+	}
+	if rec := DoSSERequest(e, http.MethodPost, approve, &seed.AdminUser, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("承認済みの申請を再承認: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// 却下した申請は消え、同じアドレスから再び申請できる
+	_ = loginpolicy.AllowVerifiedLogin(t.Context(), conn, "nope@example.com")
+	req, err = q.GetSignupRequestByEmail(t.Context(), "nope@example.com")
+	if err != nil {
+		t.Fatalf("申請の取得に失敗: %v", err)
+	}
+	if rec := DoSSERequest(e, http.MethodDelete, sprintf("/api/sse/admin/signup-requests/%d", req.ID), &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("却下: got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := q.GetUserByEmail(t.Context(), "nope@example.com"); err == nil {
+		t.Errorf("却下した申請のユーザーが作られている")
+	}
+	if err := loginpolicy.AllowVerifiedLogin(t.Context(), conn, "nope@example.com"); err == nil || !strings.Contains(err.Error(), "申請を受け付けました") {
+		t.Errorf("却下後の再申請: got %v, want 申請を受け付けました", err)
+	}
+}
+
+// マジックリンクでのサインアップは /auth/verify でリンクを開いたときに行う。承認制なら
+// 承認待ちに積み、発行したセッションは消してログイン画面に理由を出す。
+func TestSignup_ProvisionsAfterVerify(t *testing.T) {
+	first, queued := signupAddress("first", "example.com"), signupAddress("queued", "example.org")
+	conn := SetupTestDB(t)
+	SeedTestData(t, conn)
+	q := queryFromConn(conn)
+	ml := newTestMagicLink(t, conn)
+	ml.DevBypassEmails = map[string]bool{first: true, queued: true}
+	ml.Config.AllowLogin = func(r *http.Request, email string) error {
+		return loginpolicy.AllowLogin(r.Context(), conn, email, "")
+	}
+	e := SetupSessionTestServer(t, conn, ml)
+
+	verify := func(email string) *httptest.ResponseRecorder {
+		t.Helper()
+		token, err := doHTTPLogin(e, email, "192.0.2.1:1234")
+		if err != nil {
+			t.Fatalf("%s: ログインリンクの発行: %v", email, err)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/verify?token="+url.QueryEscape(token), nil))
+		return rec
+	}
+	sessionCookie := func(rec *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == ml.Config.CookieName && c.Value != "" {
+				return c
+			}
+		}
+		return nil
+	}
+
+	policy := signup.Policy{Enabled: true, Domains: []string{"example.com", "example.org"}, DefaultRole: roles.Viewer}
+	if err := signup.Save(t.Context(), q, policy); err != nil {
+		t.Fatalf("ポリシーの保存: %v", err)
+	}
+	rec := verify(first)
+	if rec.Code != http.StatusFound || strings.HasPrefix(rec.Header().Get("Location"), "/auth/login") || sessionCookie(rec) == nil {
+		t.Fatalf("承認不要: status = %d, Location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if user, err := q.GetUserByEmail(t.Context(), first); err != nil || !user.IsActive {
+		t.Errorf("リンクを開いた後にユーザーが作られていない: %+v, %v", user, err)
+	}
+
+	policy.RequireApproval = true
+	if err := signup.Save(t.Context(), q, policy); err != nil {
+		t.Fatalf("ポリシーの保存: %v", err)
+	}
+	rec = verify(queued)
+	loc, _ := url.Parse(rec.Header().Get("Location"))
+	if rec.Code != http.StatusFound || loc.Path != "/auth/login" || !strings.Contains(loc.Query().Get("error_description"), "申請を受け付けました") {
+		t.Errorf("承認制: status = %d, Location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if c := sessionCookie(rec); c != nil {
+		t.Errorf("承認待ちにセッション Cookie が発行された")
+	}
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM sessions WHERE user_id = ?`, queued).Scan(&n); err != nil || n != 0 {
+		t.Errorf("承認待ちのセッションが残っている: %d, %v", n, err)
+	}
+	if _, err := q.GetSignupRequestByEmail(t.Context(), queued); err != nil {
+		t.Errorf("承認待ちに積まれていない: %v", err)
+	}
+}
+
+// 未登録のアドレスへのリンクは、同じアドレスには続けて送らない。
+func TestSignup_ThrottlesLinks(t *testing.T) {
+	burst := signupAddress("burst", "example.com")
+	conn := SetupTestDB(t)
+	SeedTestData(t, conn)
+	q := queryFromConn(conn)
+	if err := signup.Save(t.Context(), q, signup.Policy{Enabled: true, Domains: []string{"example.com"}, DefaultRole: roles.Viewer}); err != nil {
+		t.Fatalf("ポリシーの保存: %v", err)
+	}
+
+	if err := loginpolicy.AllowLogin(t.Context(), conn, burst, ""); err != nil {
+		t.Fatalf("1 通目: %v", err)
+	}
+	if err := loginpolicy.AllowLogin(t.Context(), conn, strings.ToUpper(burst), ""); err == nil || !strings.Contains(err.Error(), "しばらく待って") {
+		t.Errorf("2 通目: got %v, want しばらく待って", err)
+	}
+	if err := loginpolicy.AllowLogin(t.Context(), conn, signupAddress("other", "example.com"), ""); err != nil {
+		t.Errorf("別のアドレス: %v", err)
+	}
+}
+
+// signupAddresses は signupAddress の通し番号。
+var signupAddresses atomic.Int64
+
+// signupAddress は実行ごとに違う未登録のアドレスを返す。未登録のアドレスへのリンクは
+// プロセス内でアドレスごとに間引く（loginpolicy.AllowLogin）ため、-count を付けても同じアドレスにしない。
+func signupAddress(local, domain string) string {
+	return fmt.Sprintf("%s%d@%s", local, signupAddresses.Add(1), domain)
+}
@@ -0,0 +1,248 @@
+package integration
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/apitoken"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
+	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// doCookieSSERequest はセッション Cookie 付きで Datastar のリクエストを送る（jsonBody が空なら body 無し）。
+// layouts.Head の script と同じく CSRF トークンを付ける。
+func doCookieSSERequest(h http.Handler, method, path string, cookie *http.Cookie, jsonBody string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(jsonBody))
+	if jsonBody != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Datastar-Request", "true")
+	req.Header.Set(appMiddleware.CSRFHeader, appMiddleware.CSRFToken(cookie.Value))
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// ageSession は cookie のセッションを 1 時間前のログインにする（本人確認の猶予切れを再現する）。
+func ageSession(t *testing.T, conn *sql.DB, cookie *http.Cookie) {
+	t.Helper()
+	hash := authsession.HashToken(cookie.Value)
+	if _, err := conn.Exec(`UPDATE sessions SET created_at = created_at - 3600 WHERE session_hash = ?`, hash); err != nil {
+		t.Fatalf("セッションの作成時刻の変更に失敗: %v", err)
+	}
+	authsession.ForgetSession(hash)
+}
+
+// ログインから時間の経ったセッションでは、削除・管理者への昇格・メンテナンスの切替の前に
+// 本人確認のダイアログが開き、操作は行われない。
+func TestStepUp_StaleSessionIsAskedToReauthenticate(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+	q := queryFromConn(conn)
+
+	admin := LoginSession(t, ml, seed.AdminUser)
+	ageSession(t, conn, admin)
+
+	deletePath := fmt.Sprintf("/api/sse/admin/users/%d", seed.DeletableUser.ID)
+	rec := doCookieSSERequest(e, http.MethodDelete, deletePath, admin, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "step-up-dialog") || !strings.Contains(rec.Body.String(), deletePath) {
+		t.Fatalf("削除: status = %d, 本人確認のダイアログが開かない: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := q.GetUserByID(t.Context(), seed.DeletableUser.ID); err != nil {
+		t.Errorf("本人確認の前に削除された: %v", err)
+	}
+
+	updatePath := fmt.Sprintf("/api/sse/admin/users/%d", seed.ViewerUser.ID)
+	rec = doCookieSSERequest(e, http.MethodPut, updatePath, admin, `{"editName":"Viewer","editRole":"admin","editStatus":"active"}`)
+	if !strings.Contains(rec.Body.String(), "step-up-dialog") {
+		t.Errorf("管理者への昇格: 本人確認のダイアログが開かない: %s", rec.Body.String())
+	}
+	if u, _ := q.GetUserByID(t.Context(), seed.ViewerUser.ID); u.Role != seed.ViewerUser.Role {
+		t.Errorf("本人確認の前にロールが変わった: %s", u.Role)
+	}
+	// 管理者相当でないロールへの変更は本人確認なしで通る。
+	rec = doCookieSSERequest(e, http.MethodPut, updatePath, admin, `{"editName":"Viewer","editRole":"editor","editStatus":"active"}`)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "step-up-dialog") {
+		t.Errorf("editor への変更: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doCookieSSERequest(e, http.MethodPost, "/api/sse/admin/maintenance/toggle", admin, "")
+	if !strings.Contains(rec.Body.String(), "step-up-dialog") {
+		t.Errorf("メンテナンスの切替: 本人確認のダイアログが開かない: %s", rec.Body.String())
+	}
+	if s := maintenance.NewCache(q).Status(t.Context()); s.Manual {
+		t.Error("本人確認の前にメンテナンスモードになった")
+	}
+
+	// Datastar 以外には 403 を返す。
+	req := httptest.NewRequest(http.MethodDelete, deletePath, nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(appMiddleware.CSRFHeader, appMiddleware.CSRFToken(admin.Value))
+	req.AddCookie(admin)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "reauthentication_required") {
+		t.Errorf("JSON: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
+
+// JSON API も Cookie で認証したときは、ユーザーの削除と管理者への昇格に本人確認を求める。
+// API トークン（Bearer）での認証は対象外。
+func TestStepUp_JSONAPIWithCookie(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	e := SetupSessionTestServer(t, conn, ml)
+	q := queryFromConn(conn)
+
+	admin := LoginSession(t, ml, seed.AdminUser)
+	ageSession(t, conn, admin)
+	doJSON := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(appMiddleware.CSRFHeader, appMiddleware.CSRFToken(admin.Value))
+		req.AddCookie(admin)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	deletePath := fmt.Sprintf("/api/v1/users/%d", seed.DeletableUser.ID)
+	rec := doJSON(http.MethodDelete, deletePath, "")
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "reauthentication_required") {
+		t.Errorf("削除: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if _, err := q.GetUserByID(t.Context(), seed.DeletableUser.ID); err != nil {
+		t.Errorf("本人確認の前に削除された: %v", err)
+	}
+
+	updatePath := fmt.Sprintf("/api/v1/users/%d", seed.ViewerUser.ID)
+	rec = doJSON(http.MethodPatch, updatePath, `{"role":"admin"}`)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "reauthentication_required") {
+		t.Errorf("管理者への昇格: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if u, _ := q.GetUserByID(t.Context(), seed.ViewerUser.ID); u.Role != seed.ViewerUser.Role {
+		t.Errorf("本人確認の前にロールが変わった: %s", u.Role)
+	}
+	// 管理者相当でないロールへの変更は本人確認なしで通る。
+	if rec = doJSON(http.MethodPatch, updatePath, `{"role":"editor"}`); rec.Code != http.StatusOK {
+		t.Errorf("editor への変更: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	// API トークンでは本人確認ができないので、削除と管理者への昇格は断る。それ以外の変更は通る。
+	_, token, err := apitoken.Create(t.Context(), q, seed.AdminUser.ID, "夜間バッチ", apitoken.ScopeWrite, 30)
+	if err != nil {
+		t.Fatalf("API トークンの発行に失敗: %v", err)
+	}
+	if rec = doBearerRequest(e, http.MethodPatch, updatePath, token, `{"role":"admin"}`); rec.Code != http.StatusForbidden ||
+		!strings.Contains(rec.Body.String(), "reauthentication_required") {
+		t.Errorf("Bearer の昇格: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if u, _ := q.GetUserByID(t.Context(), seed.ViewerUser.ID); u.Role != roles.Editor {
+		t.Errorf("Bearer でロールが変わった: %s", u.Role)
+	}
+	if rec = doBearerRequest(e, http.MethodDelete, deletePath, token, ""); rec.Code != http.StatusForbidden ||
+		!strings.Contains(rec.Body.String(), "reauthentication_required") {
+		t.Errorf("Bearer の削除: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if _, err := q.GetUserByID(t.Context(), seed.DeletableUser.ID); err != nil {
+		t.Errorf("Bearer で削除された: %v", err)
+	}
+	if rec = doBearerRequest(e, http.MethodPatch, updatePath, token, `{"role":"viewer"}`); rec.Code != http.StatusOK {
+		t.Errorf("Bearer の viewer への変更: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
+
+// メールのリンクでログインし直すと、古いセッションは消え、新しいセッションで元の操作が通る。
+func TestStepUp_ReloginAllowsAction(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	ml.DevBypassEmails = map[string]bool{seed.AdminUser.Email: true}
+	e := SetupSessionTestServer(t, conn, ml)
+
+	old := LoginSession(t, ml, seed.AdminUser)
+	ageSession(t, conn, old)
+
+	fresh := reloginByLink(t, e, ml.Config.CookieName, seed.AdminUser.Email, old)
+	if n := countSessions(t, conn, seed.AdminUser.Email); n != 1 {
+		t.Errorf("ログインし直した後のセッション数 = %d, want 1（古いセッションが残っている）", n)
+	}
+
+	rec := doCookieSSERequest(e, http.MethodDelete, fmt.Sprintf("/api/sse/admin/users/%d", seed.DeletableUser.ID), fresh, "")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "step-up-dialog") {
+		t.Fatalf("削除: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if _, err := queryFromConn(conn).GetUserByID(t.Context(), seed.DeletableUser.ID); err == nil {
+		t.Error("ログインし直した後も削除されない")
+	}
+}
+
+// パスキーを登録しているユーザーは、メールのリンクでログインし直しても本人確認にならず、
+// パスキーでログインしたセッションでだけ操作が通る。
+func TestStepUp_PasskeyUserNeedsPasskeyLogin(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	ml := newTestMagicLink(t, conn)
+	ml.DevBypassEmails = map[string]bool{seed.AdminUser.Email: true}
+	e := SetupSessionTestServer(t, conn, ml)
+	savePasskey(t, conn, "step-up-key", seed.AdminUser.Email)
+
+	old := LoginSession(t, ml, seed.AdminUser)
+	ageSession(t, conn, old)
+	byLink := reloginByLink(t, e, ml.Config.CookieName, seed.AdminUser.Email, old)
+
+	deletePath := fmt.Sprintf("/api/sse/admin/users/%d", seed.DeletableUser.ID)
+	rec := doCookieSSERequest(e, http.MethodDelete, deletePath, byLink, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "step-up-dialog") {
+		t.Fatalf("メールのリンクでのログイン直後の削除: status = %d, 本人確認のダイアログが開かない: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := queryFromConn(conn).GetUserByID(t.Context(), seed.DeletableUser.ID); err != nil {
+		t.Fatalf("パスキーでの確認の前に削除された: %v", err)
+	}
+
+	// /webauthn/login/finish で発行されたセッションと同じく、手段をパスキーとして記録する。
+	byPasskey := LoginSession(t, ml, seed.AdminUser)
+	if err := authsession.RecordLogin(t.Context(), conn, authsession.HashToken(byPasskey.Value),
+		"192.0.2.1", "test", authsession.MethodPasskey); err != nil {
+		t.Fatalf("セッション情報の記録に失敗: %v", err)
+	}
+	rec = doCookieSSERequest(e, http.MethodDelete, deletePath, byPasskey, "")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "step-up-dialog") {
+		t.Fatalf("パスキーでのログイン直後の削除: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if _, err := queryFromConn(conn).GetUserByID(t.Context(), seed.DeletableUser.ID); err == nil {
+		t.Error("パスキーでログインし直した後も削除されない")
+	}
+}
+
+// reloginByLink は old の Cookie を持つブラウザでメールのリンクからログインし直し、
+// 新しいセッション Cookie を返す（email は ml.DevBypassEmails に入れておくこと）。
+func reloginByLink(t *testing.T, h http.Handler, cookieName, email string, old *http.Cookie) *http.Cookie {
+	t.Helper()
+	token, err := doHTTPLogin(h, email, "192.0.2.1:1234")
+	if err != nil {
+		t.Fatalf("ログインリンクの発行: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/auth/verify?token="+url.QueryEscape(token), nil)
+	req.AddCookie(old)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == cookieName && c.Value != "" {
+			return c
+		}
+	}
+	t.Fatalf("verify: 新しいセッション Cookie が発行されない (status = %d)", rec.Code)
+	return nil
+}
@@ -1,5 +1,5 @@
 // Package integration はエンドツーエンドの統合テスト基盤を提供する。
-// インメモリ SQLite + chi ルート登録により、magiclink に依存せず
+// インメモリ SQLite + chi ルート登録により、メール送信や実ブラウザに依存せず
 // ハンドラの動作と権限マトリクスを検証できる。
 package integration
 
@@ -10,14 +10,24 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/naozine/nz-magic-link/magiclink"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/handlers"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/invitation"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/limits"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/loginpolicy"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/mailer"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
 	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/models"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/routes"
 	"github.com/pressly/goose/v3"
 
@@ -34,8 +44,9 @@ type SeedData struct {
 	Project       database.Project
 }
 
-// SetupTestDB はインメモリ SQLite を作成し、マイグレーションを適用する
-func SetupTestDB(t *testing.T) *sql.DB {
+// SetupTestDB はインメモリ SQLite を作成し、マイグレーションを適用する。
+// ベンチマークからも使うので testing.TB で受ける（SeedTestData / newTestMagicLink / LoginSession も同じ）。
+func SetupTestDB(t testing.TB) *sql.DB {
 	t.Helper()
 	conn, err := sql.Open("sqlite", "file::memory:?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(on)")
 	if err != nil {
@@ -54,7 +65,7 @@ func SetupTestDB(t *testing.T) *sql.DB {
 }
 
 // SeedTestData はテスト用のユーザーとプロジェクトを作成する
-func SeedTestData(t *testing.T, conn *sql.DB) SeedData {
+func SeedTestData(t testing.TB, conn *sql.DB) SeedData {
 	t.Helper()
 	q := database.New(conn)
 	ctx := context.Background()
@@ -87,10 +98,19 @@ func SeedTestData(t *testing.T, conn *sql.DB) SeedData {
 		t.Fatalf("削除用ユーザー作成に失敗: %v", err)
 	}
 
-	project, err := q.CreateProject(ctx, "テストプロジェクト")
+	project, err := q.CreateProject(ctx, database.CreateProjectParams{Name: "テストプロジェクト", Status: models.ProjectStatusActive})
 	if err != nil {
 		t.Fatalf("プロジェクト作成に失敗: %v", err)
 	}
+	// editor をオーナー、viewer を閲覧者としてメンバーにする（admin は project.all で全件見える）。
+	for _, m := range []database.CreateProjectMemberParams{
+		{ProjectID: project.ID, UserID: editorUser.ID, Role: roles.ProjectOwner, CreatedAt: time.Now().UTC()},
+		{ProjectID: project.ID, UserID: viewerUser.ID, Role: roles.ProjectViewer, CreatedAt: time.Now().UTC()},
+	} {
+		if _, err := q.CreateProjectMember(ctx, m); err != nil {
+			t.Fatalf("プロジェクトメンバーの追加に失敗: %v", err)
+		}
+	}
 
 	return SeedData{
 		AdminUser:     adminUser,
@@ -127,6 +147,8 @@ func testUserContextMiddleware(queries *database.Queries) func(http.Handler) htt
 					u, err := queries.GetUserByID(r.Context(), userID)
 					if err == nil {
 						ctx := appcontext.WithUser(r.Context(), u.Email, true, false, u.Role, u.ID)
+						// ヘッダでの認証はリクエストごとのログインとみなす（本人確認は要らない）。
+						ctx = appcontext.WithAuthenticatedAt(ctx, time.Now())
 						r = r.WithContext(ctx)
 					}
 				}
@@ -136,72 +158,184 @@ func testUserContextMiddleware(queries *database.Queries) func(http.Handler) htt
 	}
 }
 
+// newTestMagicLink は conn を共有する magiclink を作る（sessions / passkey_credentials
+// テーブルもここで作成される）。メール送信は行わない。
+func newTestMagicLink(t testing.TB, conn *sql.DB) *magiclink.MagicLink {
+	t.Helper()
+	cfg := magiclink.DefaultConfig()
+	cfg.DatabaseType = "sqlite"
+	cfg.ServerAddr = "http://localhost:8080"
+	ml, err := magiclink.NewWithDB(cfg, conn)
+	if err != nil {
+		t.Fatalf("magiclink 初期化に失敗: %v", err)
+	}
+	return ml
+}
+
+// testOutbox は送信したメールを記録する mailer.Sender（招待メールの検証用）。
+// Err を設定すると送信失敗を再現できる。
+type testOutbox struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+	Err      error
+}
+
+func (o *testOutbox) Send(_ context.Context, msg mailer.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.Err != nil {
+		return o.Err
+	}
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+// Messages はこれまでに送信したメールを返す。
+func (o *testOutbox) Messages() []mailer.Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]mailer.Message(nil), o.messages...)
+}
+
+// newTestInviter は outbox に送る Inviter を作る。インメモリ DB は接続ごとに別物になるため、
+// 一括送信もバックグラウンドにせずリクエスト内で済ませる。
+func newTestInviter(outbox *testOutbox) *invitation.Inviter {
+	return invitation.NewInviter(outbox, "http://localhost:8080")
+}
+
+// withMaintenance は本番の main.go と同じく、認証の内側にメンテナンスと読み取り専用モードの
+// ミドルウェアを挟む。
+func withMaintenance(authMW func(http.Handler) http.Handler, mcache *maintenance.Cache) func(http.Handler) http.Handler {
+	guardMW := maintenanceGuards(mcache)
+	return func(next http.Handler) http.Handler { return authMW(guardMW(next)) }
+}
+
+// maintenanceGuards はメンテナンス・読み取り専用モード・「このユーザーとして表示」中の
+// 書き込み拒否のミドルウェア（main.go の guardMW）。
+// RequireAuth を含めないので、JSON API（routes.RegisterAPIRoutes）にはこれを渡す。
+func maintenanceGuards(mcache *maintenance.Cache) func(http.Handler) http.Handler {
+	maintenanceMW := appMiddleware.Maintenance(mcache, http.HandlerFunc(handlers.MaintenancePage))
+	readOnlyMW := appMiddleware.ReadOnly(mcache, http.HandlerFunc(handlers.ReadOnlyRejectedSSE), routes.ReadOnlyExemptPrefix, routes.ImpersonationStopPath)
+	impersonationMW := appMiddleware.BlockImpersonatedWrites(http.HandlerFunc(handlers.ImpersonationRejectedSSE), routes.ImpersonationStopPath)
+	return func(next http.Handler) http.Handler { return maintenanceMW(readOnlyMW(impersonationMW(next))) }
+}
+
+// LoginSession は user の magiclink セッションを作成し、その Cookie を返す。
+// SetupSessionTestServer に対するリクエストに付けるとログイン済みになる。
+func LoginSession(t testing.TB, ml *magiclink.MagicLink, user database.User) *http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := ml.SessionManager.Create(rec, req, user.Email); err != nil {
+		t.Fatalf("セッション作成に失敗: %v", err)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == ml.Config.CookieName {
+			return c
+		}
+	}
+	t.Fatalf("セッション Cookie が発行されない")
+	return nil
+}
+
+// SetupSessionTestServer は本番と同じ UserContextMiddleware / RequireAuth で
+// 業務ルート・SSE ルート・magiclink のハンドラを登録したサーバーを返す。
+// X-Test-User-ID ではなくセッション Cookie（LoginSession）で認証されるため、
+// セッションの失効や一覧の検証に使う。
+func SetupSessionTestServer(t *testing.T, conn *sql.DB, ml *magiclink.MagicLink) http.Handler {
+	t.Helper()
+	queries := database.New(conn)
+
+	r := chi.NewRouter()
+	r.Use(appMiddleware.CSP(appMiddleware.CSPEnforce))
+	r.Use(appMiddleware.UserContextMiddleware(ml, conn))
+	r.Use(appMiddleware.CSRF(ml.Config.CookieName, ml.Config.ServerAddr, appMiddleware.CSRFOptions{
+		Exempt:    []string{appMiddleware.CSPReportPath},
+		Multipart: routes.CSRFMultipartPaths,
+	}))
+	allowVerified := func(ctx context.Context, email string) error {
+		return loginpolicy.AllowVerifiedLogin(ctx, conn, email)
+	}
+	mlHandler := appMiddleware.RecordSessionDetails(ml.Config.CookieName, conn, authsession.MethodMagicLink)(
+		appMiddleware.ForgetSessionIdentity(ml.Config.CookieName)(
+			appMiddleware.AllowVerifiedLogin(ml.Config.CookieName, "/auth/login", conn, allowVerified)(ml.Handler())))
+	r.Handle("/auth/*", mlHandler)
+
+	invitationHandler := handlers.NewInvitationHandler(conn, queries, ml)
+	r.Get(invitation.AcceptPath, invitationHandler.AcceptPage)
+	r.With(appMiddleware.RecordSessionDetails(ml.Config.CookieName, conn, authsession.MethodMagicLink)).Post(invitation.AcceptPath, invitationHandler.Accept)
+
+	inviter := newTestInviter(&testOutbox{})
+	mcache := maintenance.NewCache(queries)
+	authMW := withMaintenance(appMiddleware.RequireAuth("/auth/login"), mcache)
+	routes.RegisterBusinessRoutes(r, conn, queries, inviter, authMW)
+	routes.RegisterSSERoutes(r, conn, queries, ml, inviter, mcache, authMW)
+	routes.RegisterAPIRoutes(r, conn, queries, ml, inviter, maintenanceGuards(mcache))
+	return r
+}
+
+// DoCookieRequest は Cookie 付きでリクエストを実行する（body 無し）。画面の fetch と同じく
+// セッションの CSRF トークンを付ける。
+func DoCookieRequest(h http.Handler, method, path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.AddCookie(cookie)
+	req.Header.Set(appMiddleware.CSRFHeader, appMiddleware.CSRFToken(cookie.Value))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// testSetupToken は SetupTestServer の初期セットアップ（/setup）のトークン。
+const testSetupToken = "test-setup-token-0123456789"
+
 // SetupTestServer は統合テスト用の chi ルーターを作成し、ルートを登録する。
 // 本番と同じ routes.Register* を使い、認証ミドルウェアのみテスト用に差し替える。
-// SSE 系のうち magiclink を必要としないルート（Project/Admin Users）は手動で登録する。
+// magiclink は同じインメモリ DB を共有する実体を渡す（メール送信・WebAuthn は使わない）。
 func SetupTestServer(t *testing.T, conn *sql.DB) http.Handler {
+	t.Helper()
+	return SetupTestServerWithOutbox(t, conn, &testOutbox{})
+}
+
+// SetupTestServerWithOutbox は招待メールを outbox に記録する SetupTestServer。
+func SetupTestServerWithOutbox(t *testing.T, conn *sql.DB, outbox *testOutbox) http.Handler {
 	t.Helper()
 	queries := database.New(conn)
+	ml := newTestMagicLink(t, conn)
+	inviter := newTestInviter(outbox)
 
 	r := chi.NewRouter()
+	r.Use(appMiddleware.CSP(appMiddleware.CSPEnforce))
 	r.Use(testUserContextMiddleware(queries))
-
-	authMW := testRequireAuth("/auth/login")
-	routes.RegisterBusinessRoutes(r, conn, queries, authMW)
-	routes.RegisterAdminRoutes(r, queries, authMW, appMiddleware.NewAccessLogStore(100))
-	registerTestSSERoutes(r, queries, authMW)
+	r.Use(appMiddleware.CSRF(ml.Config.CookieName, ml.Config.ServerAddr, appMiddleware.CSRFOptions{
+		Exempt:    []string{appMiddleware.CSPReportPath},
+		Multipart: routes.CSRFMultipartPaths,
+	}))
+
+	mcache := maintenance.NewCache(queries)
+	authMW := withMaintenance(testRequireAuth("/auth/login"), mcache)
+	routes.RegisterBusinessRoutes(r, conn, queries, inviter, authMW)
+	routes.RegisterAdminRoutes(r, conn, queries, mcache, authMW, appMiddleware.NewAccessLogStore(100))
+	routes.RegisterSSERoutes(r, conn, queries, ml, inviter, mcache, authMW)
+	routes.RegisterAPIRoutes(r, conn, queries, ml, inviter, maintenanceGuards(mcache))
 
 	// 初期セットアップ用エンドポイント（認証不要）
-	setupHandler := handlers.NewSetupHandler(queries)
+	setupHandler := handlers.NewSetupHandler(conn, queries, outbox, testSetupToken)
 	r.Get("/setup", setupHandler.SetupPage)
 	r.Post("/setup", setupHandler.CreateInitialAdmin)
+	r.Post("/setup/test-mail", setupHandler.SendTestMail)
+
+	// CSP の違反報告（認証不要）。
+	r.With(appMiddleware.MaxBodySize(limits.CSPReportBody)).Post(appMiddleware.CSPReportPath, handlers.CSPReport)
 
 	// ログイン画面（magiclink に依存しない GET のみ）。
 	// メンテナンスモード時の表示分岐を検証するために登録する。
-	authHandler := handlers.NewAuthHandler(queries)
+	authHandler := handlers.NewAuthHandler(queries, "")
 	r.Get("/auth/login", authHandler.LoginPage)
 
-	return r
-}
-
-// registerTestSSERoutes は magiclink に依存しない SSE ルートのみを登録する。
-// 本番の routes.RegisterSSERoutes は magiclink を要求するため、テストでは独自に組む。
-func registerTestSSERoutes(r chi.Router, queries *database.Queries, authMW func(http.Handler) http.Handler) {
-	projectSSE := handlers.NewProjectSSEHandler(queries)
-	adminSSE := handlers.NewAdminSSEHandler(queries)
-	maintenanceHandler := handlers.NewMaintenanceHandler(queries)
-	// Profile: UpdateProfileSSE は magiclink 非依存なので ml=nil で登録できる
-	// （DeletePasskeysSSE は ml 依存のためテスト対象外）。
-	profileSSE := handlers.NewProfileSSEHandler(queries, nil)
-
-	requireWrite := appMiddleware.RequireRole("admin", "editor")
-	requireAdmin := appMiddleware.RequireRole("admin")
-
-	r.Route("/api/sse", func(r chi.Router) {
-		r.Use(authMW)
-		r.Use(appMiddleware.MaxBodySize(limits.SSESignalBody))
-
-		r.Group(func(r chi.Router) {
-			r.Use(requireWrite)
-			r.Post("/projects/new", projectSSE.CreateProjectSSE)
-			r.Get("/projects/{id}/edit", projectSSE.EditProjectDialogSSE)
-			r.Put("/projects/{id}", projectSSE.UpdateProjectSSE)
-			r.Delete("/projects/{id}", projectSSE.DeleteProjectSSE)
-		})
-
-		r.Group(func(r chi.Router) {
-			r.Use(requireAdmin)
-			r.Post("/admin/users/create", adminSSE.CreateUserDialogSSE)
-			r.Get("/admin/users/{id}/edit", adminSSE.EditUserDialogSSE)
-			r.Put("/admin/users/{id}", adminSSE.UpdateUserSSE)
-			r.Delete("/admin/users/{id}", adminSSE.DeleteUserSSE)
-
-			r.Post("/admin/maintenance/toggle", maintenanceHandler.ToggleSSE)
-		})
+	// OpenAPI ドキュメントは上で登録したルートから組み立てるので最後に登録する（本番と同じ）。
+	routes.RegisterDocsRoutes(r, ml.Config.CookieName, maintenanceGuards(mcache), authMW)
 
-		// Profile（認証のみ。UpdateProfileSSE は ml 非依存）
-		r.Put("/profile", profileSSE.UpdateProfileSSE)
-	})
+	return r
 }
 
 // DoRequest は指定ロールで HTTP リクエストを実行し、レスポンスを返す。
@@ -225,6 +359,20 @@ func DoRequest(h http.Handler, method, path string, user *database.User, body ..
 	return rec
 }
 
+// DoAPIRequest は JSON API（/api/v1）へ JSON body を送る。jsonBody が空の場合は body 無しで送る。
+func DoAPIRequest(h http.Handler, method, path string, user *database.User, jsonBody string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(jsonBody))
+	if jsonBody != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if user != nil {
+		req.Header.Set("X-Test-User-ID", fmt.Sprintf("%d", user.ID))
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
 // DoSSERequest は Datastar SSE エンドポイントへ JSON シグナルを送る。
 // 成功時は 200 + SSE ストリーム、エラー時は 4xx/5xx + プレーンテキストが返る。
 // jsonBody が空の場合は body 無しで送る（DELETE 用）。
@@ -236,6 +384,8 @@ func DoSSERequest(h http.Handler, method, path string, user *database.User, json
 	} else {
 		req = httptest.NewRequest(method, path, nil)
 	}
+	// ブラウザの Datastar が付けるヘッダ。
+	req.Header.Set("Datastar-Request", "true")
 
 	if user != nil {
 		req.Header.Set("X-Test-User-ID", fmt.Sprintf("%d", user.ID))
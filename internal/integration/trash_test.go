@@ -0,0 +1,277 @@
+package integration
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/loginpolicy"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/models"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/trash"
+)
+
+// プロジェクトを削除するとゴミ箱に移って一覧・詳細から消え、管理画面から戻すとメンバーごと元どおりになる。
+func TestTrash_ProjectDeleteAndRestore(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+	projectPath := sprintf("/projects/%d", seed.Project.ID)
+
+	rec := DoSSERequest(e, http.MethodDelete, sprintf("/api/sse/projects/%d", seed.Project.ID), &seed.EditorUser, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "ゴミ箱に移しました") {
+		t.Fatalf("削除: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := DoRequest(e, http.MethodGet, projectPath, &seed.EditorUser); rec.Code != http.StatusNotFound {
+		t.Errorf("ゴミ箱のプロジェクトの詳細: status = %d, want 404", rec.Code)
+	}
+	if body := DoRequest(e, http.MethodGet, "/projects", &seed.AdminUser).Body.String(); strings.Contains(body, seed.Project.Name) {
+		t.Error("ゴミ箱のプロジェクトが一覧に出ている")
+	}
+	if body := DoRequest(e, http.MethodGet, "/admin/trash", &seed.AdminUser).Body.String(); !strings.Contains(body, seed.Project.Name) {
+		t.Error("ゴミ箱の画面にプロジェクトが出ていない")
+	}
+
+	rec = DoSSERequest(e, http.MethodPost, sprintf("/api/sse/admin/trash/projects/%d/restore", seed.Project.ID), &seed.AdminUser, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "プロジェクトを戻しました") {
+		t.Fatalf("戻す: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := DoRequest(e, http.MethodGet, projectPath, &seed.ViewerUser); rec.Code != http.StatusOK {
+		t.Errorf("戻したプロジェクトをメンバーが見られない: status = %d", rec.Code)
+	}
+	members, err := q.ListProjectMembers(t.Context(), seed.Project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Errorf("戻した後のメンバー = %d 人, want 2", len(members))
+	}
+	if got := listAllAuditLogs(t, q)[0]; got.Action != audit.ActionProjectRestore || got.ActorID != seed.AdminUser.ID {
+		t.Errorf("監査ログ = %s by %d", got.Action, got.ActorID)
+	}
+
+	// ゴミ箱に無いプロジェクトは戻せない。
+	rec = DoSSERequest(e, http.MethodPost, sprintf("/api/sse/admin/trash/projects/%d/restore", seed.Project.ID), &seed.AdminUser, "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("ゴミ箱に無いプロジェクトを戻す: status = %d, want 404", rec.Code)
+	}
+}
+
+// 完全に削除できるのはゴミ箱の中の行だけ。ユーザー管理の権限が無ければ操作できない。
+func TestTrash_PurgeProject(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+	purgePath := sprintf("/api/sse/admin/trash/projects/%d", seed.Project.ID)
+
+	if rec := DoSSERequest(e, http.MethodDelete, purgePath, &seed.AdminUser, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("ゴミ箱に無いプロジェクトを完全に削除: status = %d, want 404", rec.Code)
+	}
+	if rec := DoSSERequest(e, http.MethodDelete, sprintf("/api/sse/projects/%d", seed.Project.ID), &seed.EditorUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("削除: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := DoSSERequest(e, http.MethodDelete, purgePath, &seed.EditorUser, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("editor が完全に削除: status = %d, want 403", rec.Code)
+	}
+
+	rec := DoSSERequest(e, http.MethodDelete, purgePath, &seed.AdminUser, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "完全に削除しました") {
+		t.Fatalf("完全に削除: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM projects WHERE id = ?`, seed.Project.ID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Error("完全に削除したプロジェクトの行が残っている")
+	}
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM project_members WHERE project_id = ?`, seed.Project.ID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("完全に削除したプロジェクトのメンバーが %d 件残っている", n)
+	}
+	if got := listAllAuditLogs(t, q)[0]; got.Action != audit.ActionProjectPurge || !strings.Contains(got.BeforeJson, seed.Project.Name) {
+		t.Errorf("監査ログ = %s %s", got.Action, got.BeforeJson)
+	}
+}
+
+// ゴミ箱のユーザーはログインできず、同じメールアドレスでは追加できない。戻せばメンバーシップも元どおり。
+func TestTrash_UserDeleteAndRestore(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+	ctx := t.Context()
+	savePasskey(t, conn, "cred-viewer", seed.ViewerUser.Email)
+
+	rec := DoSSERequest(e, http.MethodDelete, sprintf("/api/sse/admin/users/%d", seed.ViewerUser.ID), &seed.AdminUser, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("削除: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if _, err := q.GetUserByEmail(ctx, seed.ViewerUser.Email); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ゴミ箱のユーザーが取れる: %v", err)
+	}
+	if err := loginpolicy.AllowLogin(ctx, conn, seed.ViewerUser.Email, ""); err == nil || !strings.Contains(err.Error(), "ご利用いただけません") {
+		t.Errorf("ゴミ箱のユーザーのログイン: %v", err)
+	}
+
+	rec = DoSSERequest(e, http.MethodPost, "/api/sse/admin/users/create", &seed.AdminUser,
+		sprintf(`{"newName":"同じ人","newEmail":"%s","newRole":"viewer"}`, seed.ViewerUser.Email))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "ゴミ箱にあります") {
+		t.Errorf("ゴミ箱のユーザーのメールアドレスで追加: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	rec = DoAPIRequest(e, http.MethodPost, "/api/v1/users", &seed.AdminUser,
+		sprintf(`{"name":"同じ人","email":"%s","role":"viewer"}`, seed.ViewerUser.Email))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("API で追加: status = %d, want 409, body = %s", rec.Code, rec.Body.String())
+	}
+
+	rec = DoSSERequest(e, http.MethodPost, sprintf("/api/sse/admin/trash/users/%d/restore", seed.ViewerUser.ID), &seed.AdminUser, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "ユーザーを戻しました") {
+		t.Fatalf("戻す: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if err := loginpolicy.AllowLogin(ctx, conn, seed.ViewerUser.Email, ""); err != nil {
+		t.Errorf("戻したユーザーのログイン: %v", err)
+	}
+	if n := countPasskeys(t, conn, seed.ViewerUser.Email); n != 1 {
+		t.Errorf("戻したユーザーのパスキー = %d 件, want 1", n)
+	}
+	if rec := DoRequest(e, http.MethodGet, sprintf("/projects/%d", seed.Project.ID), &seed.ViewerUser); rec.Code != http.StatusOK {
+		t.Errorf("戻したユーザーがプロジェクトを見られない: status = %d", rec.Code)
+	}
+	if got := listAllAuditLogs(t, q)[0]; got.Action != audit.ActionUserRestore {
+		t.Errorf("監査ログ = %s", got.Action)
+	}
+}
+
+// 完全に削除するとパスキーも消え、そのメールアドレスはまた追加できる。
+func TestTrash_PurgeUser(t *testing.T) {
+	conn := SetupTestDB(t)
+	e := SetupTestServer(t, conn)
+	seed := SeedTestData(t, conn)
+	savePasskey(t, conn, "cred-deletable", seed.DeletableUser.Email)
+	purgePath := sprintf("/api/sse/admin/trash/users/%d", seed.DeletableUser.ID)
+
+	if rec := DoSSERequest(e, http.MethodDelete, purgePath, &seed.AdminUser, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("ゴミ箱に無いユーザーを完全に削除: status = %d, want 404", rec.Code)
+	}
+	if rec := DoSSERequest(e, http.MethodDelete, sprintf("/api/sse/admin/users/%d", seed.DeletableUser.ID), &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("削除: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if body := DoRequest(e, http.MethodGet, "/admin/trash", &seed.AdminUser).Body.String(); !strings.Contains(body, seed.DeletableUser.Email) {
+		t.Error("ゴミ箱の画面にユーザーが出ていない")
+	}
+	if rec := DoSSERequest(e, http.MethodDelete, purgePath, &seed.AdminUser, ""); rec.Code != http.StatusOK {
+		t.Fatalf("完全に削除: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if n := countPasskeys(t, conn, seed.DeletableUser.Email); n != 0 {
+		t.Errorf("完全に削除したユーザーのパスキーが %d 件残っている", n)
+	}
+
+	rec := DoSSERequest(e, http.MethodPost, "/api/sse/admin/users/create", &seed.AdminUser,
+		sprintf(`{"newName":"また","newEmail":"%s","newRole":"viewer"}`, seed.DeletableUser.Email))
+	if rec.Code != http.StatusOK {
+		t.Errorf("完全に削除したユーザーのメールアドレスで追加: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
+
+// 保持日数を過ぎた行だけを自動で完全に削除し、操作者 system の監査ログを残す。0 日なら何もしない。
+func TestTrash_PurgeExpired(t *testing.T) {
+	conn := SetupTestDB(t)
+	seed := SeedTestData(t, conn)
+	q := queryFromConn(conn)
+	ctx := t.Context()
+	t.Cleanup(func() { trash.SetRetentionDays(trash.DefaultRetentionDays) })
+
+	now := time.Now().UTC()
+	recent, err := q.CreateProject(ctx, database.CreateProjectParams{Name: "最近削除", Status: models.ProjectStatusActive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range []struct {
+		table string
+		id    int64
+		at    time.Time
+	}{
+		{"projects", seed.Project.ID, now.AddDate(0, 0, -31)},
+		{"projects", recent.ID, now.AddDate(0, 0, -29)},
+		{"users", seed.DeletableUser.ID, now.AddDate(0, 0, -31)},
+	} {
+		if _, err := conn.Exec(`UPDATE `+tr.table+` SET deleted_at = ? WHERE id = ?`, tr.at, tr.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newTestMagicLink(t, conn) // passkey_credentials を作る
+	savePasskey(t, conn, "cred-deletable", seed.DeletableUser.Email)
+
+	trash.SetRetentionDays(0)
+	if res, err := trash.PurgeExpired(ctx, conn, now); err != nil || res != (trash.Result{}) {
+		t.Fatalf("保持日数 0: res = %+v, err = %v", res, err)
+	}
+
+	trash.SetRetentionDays(30)
+	res, err := trash.PurgeExpired(ctx, conn, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (trash.Result{Projects: 1, Users: 1}) {
+		t.Errorf("削除した件数 = %+v", res)
+	}
+	trashed, err := q.ListTrashedProjects(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trashed) != 1 || trashed[0].ID != recent.ID {
+		t.Errorf("ゴミ箱に残ったプロジェクト = %+v", trashed)
+	}
+	users, err := q.ListTrashedUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Errorf("ゴミ箱に残ったユーザー = %+v", users)
+	}
+	if n := countPasskeys(t, conn, seed.DeletableUser.Email); n != 0 {
+		t.Errorf("自動で削除したユーザーのパスキーが %d 件残っている", n)
+	}
+
+	entries := listAllAuditLogs(t, q)
+	if len(entries) != 2 {
+		t.Fatalf("監査ログ件数 = %d, want 2", len(entries))
+	}
+	for _, got := range entries {
+		if got.ActorID != 0 || got.ActorEmail != trash.SystemActor {
+			t.Errorf("actor = %d %s, want 0 %s", got.ActorID, got.ActorEmail, trash.SystemActor)
+		}
+		if got.Action != audit.ActionProjectPurge && got.Action != audit.ActionUserPurge {
+			t.Errorf("action = %s", got.Action)
+		}
+	}
+}
+
+func TestTrash_ParseRetentionDays(t *testing.T) {
+	for _, c := range []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"", trash.DefaultRetentionDays, false},
+		{" 7 ", 7, false},
+		{"0", 0, false},
+		{"-1", 0, true},
+		{"30d", 0, true},
+	} {
+		got, err := trash.ParseRetentionDays(c.in)
+		if (err != nil) != c.wantErr || got != c.want {
+			t.Errorf("ParseRetentionDays(%q) = %d, %v", c.in, got, err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Package invitation は管理者が作成・インポートしたユーザーへの招待を扱う。
+//
+// 招待されたユーザーは is_active=0 で作成され、invitations に未承認の行がある間は
+// 「招待中」として扱われる。招待メールのリンク（期限付き・1 回限り）を開くと
+// ユーザーが有効化され、そのままログインする。
+// トークンは SHA-256 ハッシュだけを保存し、再送のたびに作り直す（古いリンクは無効になる）。
+package invitation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appconfig"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/mailer"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/opaquetoken"
+)
+
+// TTL は招待リンクの有効期間。
+const TTL = 72 * time.Hour
+
+// AcceptPath は招待メールのリンク先（認証不要のルート）。
+const AcceptPath = "/invite/accept"
+
+// Lookup / Accept が返すエラー。画面にはそれぞれ別のメッセージを出す。
+var (
+	ErrInvalid  = errors.New("invitation: invalid token")
+	ErrExpired  = errors.New("invitation: expired")
+	ErrAccepted = errors.New("invitation: already accepted")
+)
+
+// Inviter は招待の発行とメール送信を行う。
+type Inviter struct {
+	Mailer  mailer.Sender
+	BaseURL string // 招待リンクの origin（SERVER_ADDR）
+	// Async が true なら SendAll はバックグラウンドで送る（本番）。テストでは false にして
+	// 送信完了を待ってから検証する。
+	Async bool
+}
+
+func NewInviter(m mailer.Sender, baseURL string) *Inviter {
+	return &Inviter{Mailer: m, BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// Pending は発行済みでまだメールを送っていない招待。Issue で作り、コミット後に Send に渡す。
+type Pending struct {
+	Invitation database.Invitation
+	User       database.User
+	Token      string
+}
+
+// Issue は user の招待トークンを発行する（既存の招待は置き換える）。
+// q はユーザー作成と同じトランザクションの Queries を渡す。メールは送らない。
+func Issue(ctx context.Context, q *database.Queries, user database.User, invitedBy string) (Pending, error) {
+	token, err := opaquetoken.New("")
+	if err != nil {
+		return Pending{}, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	inv, err := q.UpsertInvitation(ctx, database.UpsertInvitationParams{
+		UserID:    user.ID,
+		TokenHash: opaquetoken.Hash(token),
+		InvitedBy: invitedBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(TTL),
+	})
+	if err != nil {
+		return Pending{}, fmt.Errorf("invitation: save: %w", err)
+	}
+	return Pending{Invitation: inv, User: user, Token: token}, nil
+}
+
+// Send は招待メールを送り、送信日時を記録する。
+// SMTP の応答待ちで書き込みロックを持たないよう、トランザクションのコミット後に呼ぶ。
+func (iv *Inviter) Send(ctx context.Context, q *database.Queries, p Pending) error {
+	if err := iv.Mailer.Send(ctx, iv.message(p)); err != nil {
+		return fmt.Errorf("invitation: send to %s: %w", p.User.Email, err)
+	}
+	if err := q.MarkInvitationSent(ctx, database.MarkInvitationSentParams{
+		SentAt: sql.NullTime{Time: time.Now().UTC().Truncate(time.Second), Valid: true},
+		ID:     p.Invitation.ID,
+	}); err != nil {
+		return fmt.Errorf("invitation: mark sent: %w", err)
+	}
+	return nil
+}
+
+// SendAll は一括インポートで作った招待のメールを順に送る。件数が多いと SMTP の往復で
+// レスポンスが WriteTimeout を超えるため、Async のときはリクエストを待たせない。
+// 送れなかった招待は一覧に「未送信」として残り、管理者が再送できる。
+func (iv *Inviter) SendAll(ctx context.Context, q *database.Queries, ps []Pending) {
+	send := func(ctx context.Context) {
+		for _, p := range ps {
+			if err := iv.Send(ctx, q, p); err != nil {
+				logger.Error("招待メールの送信に失敗", "error", err, "email", p.User.Email)
+			}
+		}
+	}
+	if iv.Async {
+		go send(context.WithoutCancel(ctx))
+		return
+	}
+	send(ctx)
+}
+
+// AcceptURL は招待メールに載せるリンク。
+func (iv *Inviter) AcceptURL(token string) string {
+	return iv.BaseURL + AcceptPath + "?token=" + url.QueryEscape(token)
+}
+
+func (iv *Inviter) message(p Pending) mailer.Message {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s 様\n\n", p.User.Name)
+	fmt.Fprintf(&b, "%s に招待されました。\n", appconfig.AppName())
+	b.WriteString("以下のリンクを開くとアカウントが有効になり、そのままログインできます。\n\n")
+	fmt.Fprintf(&b, "%s\n\n", iv.AcceptURL(p.Token))
+	fmt.Fprintf(&b, "このリンクは %s まで、1 回だけ使用できます。\n", p.Invitation.ExpiresAt.Local().Format("2006/01/02 15:04"))
+	b.WriteString("期限が切れた場合は、管理者に招待の再送を依頼してください。\n\n")
+	b.WriteString("心当たりがない場合は、このメールを破棄してください。\n")
+	return mailer.Message{
+		To:      p.User.Email,
+		Subject: appconfig.AppName() + " への招待",
+		Body:    b.String(),
+	}
+}
+
+// Lookup は token の招待とその招待先ユーザーを返す（承認はしない）。
+// 承認画面の表示前に、リンクが使えるかを確かめるために使う。
+func Lookup(ctx context.Context, q *database.Queries, token string) (database.Invitation, database.User, error) {
+	if token == "" {
+		return database.Invitation{}, database.User{}, ErrInvalid
+	}
+	inv, err := q.GetInvitationByTokenHash(ctx, opaquetoken.Hash(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.Invitation{}, database.User{}, ErrInvalid
+	}
+	if err != nil {
+		return database.Invitation{}, database.User{}, fmt.Errorf("invitation: lookup: %w", err)
+	}
+	if inv.AcceptedAt.Valid {
+		return database.Invitation{}, database.User{}, ErrAccepted
+	}
+	if !time.Now().Before(inv.ExpiresAt) {
+		return database.Invitation{}, database.User{}, ErrExpired
+	}
+	user, err := q.GetUserByID(ctx, inv.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 招待先のユーザーがゴミ箱にある（GetUserByID はゴミ箱の行を返さない）。
+		return database.Invitation{}, database.User{}, ErrInvalid
+	}
+	if err != nil {
+		return database.Invitation{}, database.User{}, fmt.Errorf("invitation: get user: %w", err)
+	}
+	return inv, user, nil
+}
+
+// Accept は token の招待を承認してユーザーを有効化し、そのユーザーを返す。
+// q はトランザクション内の Queries を渡す（承認・有効化・監査ログをまとめてコミットする）。
+func Accept(ctx context.Context, q *database.Queries, token string) (database.User, error) {
+	inv, before, err := Lookup(ctx, q, token)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	// accepted_at IS NULL を条件に更新し、同じリンクの同時アクセスでも承認は 1 回だけにする。
+	n, err := q.AcceptInvitation(ctx, database.AcceptInvitationParams{
+		AcceptedAt: sql.NullTime{Time: time.Now().UTC().Truncate(time.Second), Valid: true},
+		ID:         inv.ID,
+	})
+	if err != nil {
+		return database.User{}, fmt.Errorf("invitation: accept: %w", err)
+	}
+	if n == 0 {
+		return database.User{}, ErrAccepted
+	}
+
+	after := before
+	if !before.IsActive {
+		after, err = q.UpdateUser(ctx, database.UpdateUserParams{
+			Name:     before.Name,
+			Role:     before.Role,
+			IsActive: true,
+			ID:       before.ID,
+		})
+		if err != nil {
+			return database.User{}, fmt.Errorf("invitation: activate user: %w", err)
+		}
+	}
+
+	// 未ログインのリクエストなので、操作者は招待されたユーザー本人として記録する。
+	entry := audit.Entry{
+		ActorID:    before.ID,
+		ActorEmail: before.Email,
+		Action:     audit.ActionInvitationAccept,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.FormatInt(before.ID, 10),
+		Before:     before,
+		After:      after,
+	}
+	if err := audit.Record(ctx, q, entry); err != nil {
+		return database.User{}, err
+	}
+	return after, nil
+}
+
+// Revoke は user の招待を取り消す（ユーザーは無効のまま残る）。招待が無ければ false。
+func Revoke(ctx context.Context, q *database.Queries, userID int64) (bool, error) {
+	n, err := q.DeletePendingInvitation(ctx, userID)
+	if err != nil {
+		return false, fmt.Errorf("invitation: revoke: %w", err)
+	}
+	return n > 0, nil
+}
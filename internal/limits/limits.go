@@ -9,7 +9,15 @@ const (
 	// プロジェクト名・ユーザー名等の小さな signals のみを想定。
 	SSESignalBody = 1 << 20 // 1 MB
 
+	// APIJSONBody は JSON API（/api/v1）の受信 body 上限。
+	// SSE の signals と同じく、プロジェクト名・ユーザー名等の小さな JSON のみを想定。
+	APIJSONBody = 1 << 20 // 1 MB
+
 	// UserImportBody は /admin/users/import の multipart 受信 body 上限。
 	// 5 MB の Excel ファイル + multipart オーバーヘッド分の余裕を見込む。
 	UserImportBody = 6 << 20 // 6 MB
+
+	// CSPReportBody はブラウザが送る CSP 違反報告（/csp-report）の受信 body 上限。
+	// 認証不要で誰でも送れるため、1 件分の報告に足りる程度に絞る。
+	CSPReportBody = 16 << 10 // 16 KB
 )
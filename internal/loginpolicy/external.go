@@ -0,0 +1,167 @@
+package loginpolicy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// ExternalIdentity は外部 IdP（OIDC）で本人確認が済んだユーザー。
+type ExternalIdentity struct {
+	Email string
+	// Name は IdP が返した表示名（空ならメールアドレスのローカル部を使う）。
+	Name string
+	// Role は IdP のクレームから決めたロール（空ならロールを変えない・自動登録では viewer）。
+	Role string
+	// Provision が true なら、未登録のユーザーをこの場で有効なユーザーとして登録する。
+	Provision bool
+}
+
+// AllowExternalLogin は外部 IdP で認証したユーザーのログインを判定する。
+// IdP 側で本人確認済みなので honeypot は見ず、次の順で扱う。
+//
+//  1. メンテナンス中で、admin でも予定の許可アドレスでもない → 拒否（何も書き込まない）
+//  2. 未登録（ゴミ箱の中のユーザーを除く）で Provision → 有効なユーザーとして登録（監査ログは本人を操作者として記録）
+//  3. AllowVerifiedLogin の判定（Provision でなければサインアップポリシーでの登録、
+//     招待の承認待ち・無効ユーザーの拒否など）
+//  4. ログインを許可した有効な登録済みユーザーで、Role が現在のロールと違う → ロールを IdP に合わせる
+//     （拒否したログインではロールも監査ログも変えない）
+func AllowExternalLogin(ctx context.Context, db *sql.DB, id ExternalIdentity) error {
+	q := database.New(db)
+	if err := duringMaintenance(ctx, q, id.Email); err != nil {
+		return err
+	}
+
+	user, err := q.GetUserByEmail(ctx, id.Email)
+	registered := err == nil
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if id.Provision {
+			// ゴミ箱の中のユーザーは登録し直さない（AllowVerifiedLogin が拒否する）。
+			trashed, err := inTrash(ctx, q, id.Email)
+			if err != nil {
+				logger.Error("Database error in AllowExternalLogin", "error", err, "email", id.Email)
+				return fmt.Errorf("システムエラーが発生しました。")
+			}
+			if !trashed {
+				if err := provision(ctx, db, q, id); err != nil {
+					return err
+				}
+			}
+		}
+	case err != nil:
+		logger.Error("Database error in AllowExternalLogin", "error", err, "email", id.Email)
+		return fmt.Errorf("システムエラーが発生しました。")
+	}
+
+	if err := AllowVerifiedLogin(ctx, db, id.Email); err != nil {
+		return err
+	}
+	if registered && user.IsActive && id.Role != "" && id.Role != user.Role {
+		err := syncRole(ctx, db, user, id.Role)
+		if errors.Is(err, roles.ErrNoAdminLeft) {
+			// 最後の管理者を IdP の設定ひとつで締め出さない。ロールは変えずにログインさせる。
+			logger.Warn("SSO role sync skipped: no active admin would remain", "email", id.Email, "role", id.Role)
+		} else if err != nil {
+			logger.Error("SSO role sync failed", "error", err, "email", id.Email)
+			return fmt.Errorf("システムエラーが発生しました。")
+		}
+	}
+	return nil
+}
+
+// provision は IdP で認証した未登録ユーザーを登録する。サインアップの承認待ちに積まれている
+// メールアドレスは、管理者の判断を飛ばさないよう登録しない（AllowVerifiedLogin が承認待ちとして拒否する）。
+func provision(ctx context.Context, db *sql.DB, q *database.Queries, id ExternalIdentity) error {
+	if _, err := q.GetSignupRequestByEmail(ctx, id.Email); err == nil {
+		return nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		logger.Error("Database error in AllowExternalLogin", "error", err, "email", id.Email)
+		return fmt.Errorf("システムエラーが発生しました。")
+	}
+
+	name := id.Name
+	if name == "" {
+		name = id.Email
+		if at := strings.LastIndex(id.Email, "@"); at > 0 {
+			name = id.Email[:at]
+		}
+	}
+	role := id.Role
+	if role == "" {
+		role = roles.Viewer
+	}
+
+	err := dbtx.Run(ctx, db, q, func(qtx *database.Queries) error {
+		user, err := qtx.CreateUser(ctx, database.CreateUserParams{
+			Email:    id.Email,
+			Name:     name,
+			Role:     role,
+			IsActive: true,
+		})
+		if err != nil {
+			return err
+		}
+		// 未ログインのリクエストなので、操作者は登録したユーザー本人として記録する。
+		return audit.Record(ctx, qtx, audit.Entry{
+			ActorID:    user.ID,
+			ActorEmail: user.Email,
+			Action:     audit.ActionUserSSOProvision,
+			TargetType: audit.TargetUser,
+			TargetID:   strconv.FormatInt(user.ID, 10),
+			After:      user,
+		})
+	})
+	if err != nil {
+		logger.Error("SSO provisioning failed", "error", err, "email", id.Email)
+		return fmt.Errorf("システムエラーが発生しました。")
+	}
+	logger.Info("User provisioned via SSO", "email", id.Email, "role", role)
+	return nil
+}
+
+// syncRole は user のロールを IdP のクレームに合わせる。有効な管理者がいなくなるなら
+// 変更せずに roles.ErrNoAdminLeft を返す。
+func syncRole(ctx context.Context, db *sql.DB, user database.User, role string) error {
+	err := dbtx.Run(ctx, db, database.New(db), func(qtx *database.Queries) error {
+		updated, err := qtx.UpdateUser(ctx, database.UpdateUserParams{
+			Name:     user.Name,
+			Role:     role,
+			IsActive: user.IsActive,
+			ID:       user.ID,
+		})
+		if err != nil {
+			return err
+		}
+		if roles.IsAdmin(user.Role) && !roles.IsAdmin(role) {
+			if err := roles.EnsureAdminRemains(ctx, qtx, nil); err != nil {
+				return err
+			}
+		}
+		return audit.Record(ctx, qtx, audit.Entry{
+			ActorID:    user.ID,
+			ActorEmail: user.Email,
+			Action:     audit.ActionUserSSORoleSync,
+			TargetType: audit.TargetUser,
+			TargetID:   strconv.FormatInt(user.ID, 10),
+			Before:     user,
+			After:      updated,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	authsession.ForgetUser(user.Email)
+	logger.Info("User role synced from SSO", "email", user.Email, "from", user.Role, "to", role)
+	return nil
+}
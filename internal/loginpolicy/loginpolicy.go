@@ -1,4 +1,5 @@
-// Package loginpolicy は magiclink の AllowLogin フックに渡すログイン許可ロジックを提供する。
+// Package loginpolicy は magiclink の AllowLogin フックに渡すログイン許可ロジックと、
+// リンクを開いた（本人と確かめた）後のサインアップを提供する。
 // main.go のクロージャから切り出すことで、テストから直接呼べるようにしている。
 package loginpolicy
 
@@ -8,38 +9,182 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/opaquetoken"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/signup"
 )
 
 // AllowLogin は magiclink の AllowLogin フックの本体。
 // 戻り値が nil のときマジックリンクの送信を許可し、error の場合は
 // その error 文字列がフォームに表示される（メール送信は行われない）。
+// リンクを開くまでは本人かどうか分からないので、ここでは何も書き込まない
+// （サインアップはリンクを開いた後の AllowVerifiedLogin で行う）。
 //
 // 判定順:
 //  1. honeypot に値が入っている → ボット扱いで拒否
-//  2. users にメアドが無い場合 → 「登録されていません」拒否
-//  3. is_active=false の場合 → 「ご利用いただけません」拒否
-//  4. アクティブな登録済みユーザー → 許可
-func AllowLogin(ctx context.Context, q *database.Queries, email, honeypot string) error {
+//  2. メンテナンス中で、admin でも予定の許可アドレスでもない → 「メンテナンス中」拒否
+//  3. users にメアドが無い場合
+//     a. ゴミ箱の中のユーザー → 「ご利用いただけません」拒否（サインアップとして扱わない）
+//     b. サインアップの承認待ち → 「承認待ち」案内で拒否
+//     c. サインアップポリシーで許可されたドメイン → 許可（同じアドレスへは signupLinks の間隔に 1 通まで）
+//     d. それ以外 → 「登録されていません」拒否
+//  4. 招待の承認待ち（is_active=false で未承認の招待がある）→ 「招待メールから有効化」案内で拒否
+//  5. is_active=false の場合 → 「ご利用いただけません」拒否
+//  6. アクティブな登録済みユーザー → 許可
+func AllowLogin(ctx context.Context, db *sql.DB, email, honeypot string) error {
 	if strings.TrimSpace(honeypot) != "" {
 		logger.Warn("Honeypot triggered", "email", email)
 		return fmt.Errorf("不正なリクエストです")
 	}
 
+	q := database.New(db)
+	if err := duringMaintenance(ctx, q, email); err != nil {
+		return err
+	}
+	err := allowRegistered(ctx, q, email)
+	if !errors.Is(err, errUnregistered) {
+		return err
+	}
+	if _, err := signupPolicy(ctx, q, email); err != nil {
+		return err
+	}
+	if !signupLinks.Allow(strings.ToLower(email), time.Now()) {
+		logger.Warn("Sign-up link throttled", "email", email)
+		return fmt.Errorf("確認メールを送信済みです。しばらく待ってからもう一度お試しください")
+	}
+	logger.Info("Sign-up link requested", "email", email)
+	return nil
+}
+
+// signupLinks は未登録のアドレスへのリンク送信を、アドレスごとに 1 分に 1 通までに絞る
+// （誰でも許可ドメインの他人のアドレスへ送らせることができるため）。
+var signupLinks = opaquetoken.NewThrottle(time.Minute)
+
+// AllowVerifiedLogin はリンクを開く・IdP で認証するなどで本人と確かめた email のログインを判定する。
+// 未登録ならここでサインアップポリシーに従って登録する（承認制なら承認待ちに積んで拒否）。
+// 登録済みなら AllowLogin の 4〜6 と同じ判定。error の文字列はそのまま利用者に表示できる。
+func AllowVerifiedLogin(ctx context.Context, db *sql.DB, email string) error {
+	q := database.New(db)
+	err := allowRegistered(ctx, q, email)
+	if !errors.Is(err, errUnregistered) {
+		return err
+	}
+	// リンクを送ってから開くまでにポリシーが変わっていることもあるので、もう一度確かめる。
+	policy, err := signupPolicy(ctx, q, email)
+	if err != nil {
+		return err
+	}
+
+	user, err := signup.Register(ctx, db, policy, email)
+	if errors.Is(err, signup.ErrPendingApproval) {
+		logger.Info("Sign-up request queued for approval", "email", email)
+		return fmt.Errorf("利用申請を受け付けました。管理者の承認後にログインできるようになります")
+	}
+	if err != nil {
+		logger.Error("Sign-up failed", "error", err, "email", email)
+		return fmt.Errorf("システムエラーが発生しました。")
+	}
+	logger.Info("User signed up", "email", user.Email, "role", user.Role)
+	return nil
+}
+
+// AllowInvitationLogin は招待メールのリンクから承認したユーザーを、そのままログインさせてよいかを判定する。
+// 招待の承認（ユーザーの有効化）より前に呼ぶ。メンテナンス中は AllowLogin と同じく
+// admin と予定の許可アドレスだけを通す（拒否した招待はリンクを使わずに残る）。
+func AllowInvitationLogin(ctx context.Context, db *sql.DB, email string) error {
+	return duringMaintenance(ctx, database.New(db), email)
+}
+
+// errUnregistered は email が users に無いこと（allowRegistered）を表す。
+var errUnregistered = errors.New("loginpolicy: unregistered")
+
+// allowRegistered は登録済みユーザーとしてログインできるかを判定する。
+// 未登録なら errUnregistered を返す。
+func allowRegistered(ctx context.Context, q *database.Queries, email string) error {
 	user, err := q.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errUnregistered
+	}
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			logger.Warn("Login attempt with unregistered email", "email", email)
-			return fmt.Errorf("このメールアドレスは登録されていません")
-		}
 		logger.Error("Database error in AllowLogin", "error", err, "email", email)
 		return fmt.Errorf("システムエラーが発生しました。")
 	}
 	if !user.IsActive {
+		inv, err := q.GetInvitationByUserID(ctx, user.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			logger.Error("Database error in AllowLogin", "error", err, "email", email)
+			return fmt.Errorf("システムエラーが発生しました。")
+		}
+		if err == nil && !inv.AcceptedAt.Valid {
+			logger.Warn("Login attempt with pending invitation", "email", email)
+			return fmt.Errorf("このアカウントは招待の承認待ちです。招待メールのリンクからアカウントを有効にしてください")
+		}
 		logger.Warn("Login attempt with inactive account", "email", email)
 		return fmt.Errorf("このアカウントは現在ご利用いただけません")
 	}
 	return nil
 }
+
+// duringMaintenance はメンテナンス中に email へのリンク送信を止めるかを判定する。
+// メンテナンスを切り替えられるロール（admin）は作業のためにログインできるようにしておく。
+func duringMaintenance(ctx context.Context, q *database.Queries, email string) error {
+	now := time.Now()
+	st := maintenance.Load(ctx, q)
+	if !st.Active(now) || st.Allows(email, now) {
+		return nil
+	}
+	user, err := q.GetUserByEmail(ctx, email)
+	if err == nil && user.IsActive && roles.Has(user.Role, roles.MaintenanceToggle) {
+		return nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error("Database error in AllowLogin", "error", err, "email", email)
+		return fmt.Errorf("システムエラーが発生しました。")
+	}
+	logger.Warn("Login attempt during maintenance", "email", email)
+	return fmt.Errorf("メンテナンス中のため、現在ログインを受け付けていません")
+}
+
+// signupPolicy は未登録の email がサインアップできるかを判定し、できるならポリシーを返す。
+// ゴミ箱の中のユーザー・承認待ち・許可されていないドメインは、表示用の error を返す。
+func signupPolicy(ctx context.Context, q *database.Queries, email string) (signup.Policy, error) {
+	if trashed, err := inTrash(ctx, q, email); err != nil {
+		logger.Error("Database error in AllowLogin", "error", err, "email", email)
+		return signup.Policy{}, fmt.Errorf("システムエラーが発生しました。")
+	} else if trashed {
+		logger.Warn("Login attempt with trashed account", "email", email)
+		return signup.Policy{}, fmt.Errorf("このアカウントは現在ご利用いただけません")
+	}
+
+	_, err := q.GetSignupRequestByEmail(ctx, email)
+	if err == nil {
+		logger.Warn("Login attempt with pending sign-up", "email", email)
+		return signup.Policy{}, fmt.Errorf("このメールアドレスは管理者の承認待ちです。承認後にログインできるようになります")
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		logger.Error("Database error in AllowLogin", "error", err, "email", email)
+		return signup.Policy{}, fmt.Errorf("システムエラーが発生しました。")
+	}
+
+	policy := signup.Load(ctx, q)
+	if !policy.Allows(email) {
+		logger.Warn("Login attempt with unregistered email", "email", email)
+		return signup.Policy{}, fmt.Errorf("このメールアドレスは登録されていません")
+	}
+	return policy, nil
+}
+
+// inTrash は email のユーザーがゴミ箱にあるかを返す。ゴミ箱の中のユーザーもメールアドレスを
+// 使ったままなので、未登録として登録し直すことはできない。
+func inTrash(ctx context.Context, q *database.Queries, email string) (bool, error) {
+	_, err := q.GetTrashedUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,51 @@
+package loginpolicy
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// PromoteAdmin は email のユーザーを有効な管理者にし、監査ログ（操作者は "cli"）を残す。
+// 有効な管理者が 1 人もいなくなったときの復旧用（`server promote-admin <email>`）で、
+// 画面を通らないため本人確認はしない。ユーザーが無ければ sql.ErrNoRows を返す。
+//
+// 別プロセスで動いているサーバーのキャッシュは消せないため、ログイン中のセッションには
+// 最大 authsession.IdentityTTL 遅れて反映される。
+func PromoteAdmin(ctx context.Context, db *sql.DB, email string) (database.User, error) {
+	var user database.User
+	err := dbtx.Run(ctx, db, database.New(db), func(qtx *database.Queries) error {
+		before, err := qtx.GetUserByEmail(ctx, email)
+		if err != nil {
+			return err
+		}
+		user, err = qtx.UpdateUser(ctx, database.UpdateUserParams{
+			Name:     before.Name,
+			Role:     roles.Admin,
+			IsActive: true,
+			ID:       before.ID,
+		})
+		if err != nil {
+			return err
+		}
+		return audit.Record(ctx, qtx, audit.Entry{
+			ActorEmail: "cli",
+			Action:     audit.ActionUserPromoteAdmin,
+			TargetType: audit.TargetUser,
+			TargetID:   strconv.FormatInt(user.ID, 10),
+			Before:     before,
+			After:      user,
+		})
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	authsession.ForgetUser(user.Email)
+	return user, nil
+}
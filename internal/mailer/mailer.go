@@ -0,0 +1,149 @@
+// Package mailer はアプリ自身が送るメール（招待メールなど）の送信を提供する。
+// ログイン用マジックリンクのメールは magiclink が送るため、ここでは扱わない。
+// SMTP の接続設定は magiclink と同じ SMTP_* 環境変数を使う。
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+)
+
+// Message は送信する 1 通分のメール（本文はプレーンテキスト）。
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender はメールの送信手段。テストでは送信内容を記録する実装に差し替える。
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPConfig は SMTP サーバーへの接続設定。
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	FromName string
+}
+
+// New は cfg に合った Sender を返す。Host が未設定（ローカル開発）の場合は送信せずログに出す。
+func New(cfg SMTPConfig) Sender {
+	if cfg.Host == "" {
+		return LogSender{}
+	}
+	return &SMTP{Config: cfg}
+}
+
+// LogSender は送信の代わりに宛先・件名・本文をアプリログに出す（SMTP 未設定の開発環境用）。
+type LogSender struct{}
+
+func (LogSender) Send(_ context.Context, msg Message) error {
+	logger.Info("SMTP 未設定のためメールを送信せずログに出力します", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// SMTP は SMTP サーバー経由でメールを送る。ポート 465 は接続時から TLS、
+// それ以外は STARTTLS を使う（magiclink の既定と同じ）。
+type SMTP struct {
+	Config SMTPConfig
+}
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	body, err := s.build(msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(s.Config.Host, strconv.Itoa(s.Config.Port))
+	tlsConfig := &tls.Config{ServerName: s.Config.Host}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	var conn net.Conn
+	if s.Config.Port == 465 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("mailer: connect: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.Config.Host)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("mailer: smtp client: %w", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	if s.Config.Port != 465 {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("mailer: starttls: %w", err)
+		}
+	}
+	if s.Config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Config.Username, s.Config.Password, s.Config.Host)); err != nil {
+			return fmt.Errorf("mailer: auth: %w", err)
+		}
+	}
+	if err := client.Mail(s.Config.From); err != nil {
+		return fmt.Errorf("mailer: mail from: %w", err)
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return fmt.Errorf("mailer: rcpt to: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("mailer: data: %w", err)
+	}
+	if _, err := w.Write(body); err != nil {
+		return fmt.Errorf("mailer: write body: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("mailer: close body: %w", err)
+	}
+	return client.Quit()
+}
+
+// build はヘッダ付きのメール本体を組み立てる。件名・差出人名は MIME エンコードし、
+// 本文は日本語を含むため base64 で送る。
+func (s *SMTP) build(msg Message, now time.Time) ([]byte, error) {
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return nil, fmt.Errorf("mailer: invalid recipient %q: %w", msg.To, err)
+	}
+	from := (&mail.Address{Name: s.Config.FromName, Address: s.Config.From}).String()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(msg.Body))
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+	return b.Bytes(), nil
+}
@@ -1,10 +1,24 @@
-// Package maintenance はメンテナンスモード（一般ユーザーのログイン受付停止）の
-// オン／オフを管理するヘルパーを提供する。app_settings テーブルの 1 行
-// （key="maintenance_mode"）に "true" / "false" を格納する。
+// Package maintenance はメンテナンスモード（一般ユーザーのログイン受付停止と、
+// ログイン中の一般ユーザーの操作停止）の状態を管理するヘルパーを提供する。
+//
+// 状態は app_settings の 3 行で持つ:
+//   - key="maintenance_mode": 管理画面の手動切替。"true" / "false"
+//   - key="maintenance_window": 予定メンテナンスの時間帯・告知文・許可アドレス（JSON）
+//   - key="read_only_mode": 読み取り専用モード。"true" / "false"
+//
+// 手動で ON にしている間、または現在時刻が予定の時間帯に入っている間がメンテナンス中。
+// 予定は時刻で評価するだけなので、開始・終了の切替にバックグラウンド処理は要らない。
+// 読み取り専用モードはメンテナンスとは独立で、閲覧は通常どおり、書き込みだけを止める。
 package maintenance
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
 )
@@ -12,17 +26,136 @@ import (
 // Key は app_settings テーブルでメンテモード状態を保存する key 名。
 const Key = "maintenance_mode"
 
-// IsEnabled は現在メンテモードが ON かを返す。
-// 行が無い／DB エラーなどの場合は false（安全側＝サービス継続）を返す。
-func IsEnabled(ctx context.Context, q *database.Queries) bool {
-	s, err := q.GetAppSetting(ctx, Key)
-	if err != nil {
+// ReadOnlyKey は app_settings テーブルで読み取り専用モードを保存する key 名。
+const ReadOnlyKey = "read_only_mode"
+
+// WindowKey は app_settings テーブルで予定メンテナンスを保存する key 名。
+const WindowKey = "maintenance_window"
+
+// MaxMessageLen は告知文の最大文字数。
+const MaxMessageLen = 500
+
+// Window は予定メンテナンスの時間帯。[Start, End) の間メンテナンス中になる。
+type Window struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Message string    `json:"message"`
+	// AllowEmails はメンテナンス中もログイン・操作できるアドレス（小文字）。admin は常に許可。
+	AllowEmails []string `json:"allow_emails"`
+}
+
+// Contains は t が時間帯に入っているかを返す。
+func (w Window) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+// FormatPeriod は "2026/10/16 22:00 〜 2026/10/17 02:00" の形の時間帯の表示（サーバーの
+// タイムゾーン）。同じ日に終わるなら終了側の日付を省く。
+func FormatPeriod(start, end time.Time) string {
+	start, end = start.Local(), end.Local()
+	const layout = "2006/01/02 15:04"
+	if start.Format("20060102") == end.Format("20060102") {
+		return start.Format(layout) + " 〜 " + end.Format("15:04")
+	}
+	return start.Format(layout) + " 〜 " + end.Format(layout)
+}
+
+// Status はメンテナンスの設定（手動切替と予定）。メンテナンス中かどうかは時刻で
+// 変わるため、Active などのメソッドに現在時刻を渡して判定する。
+type Status struct {
+	Manual   bool
+	Window   *Window // 予定が無い、または終了済みなら nil
+	ReadOnly bool
+}
+
+// Active は now の時点でメンテナンス中かを返す。
+func (s Status) Active(now time.Time) bool {
+	return s.Manual || s.InWindow(now)
+}
+
+// InWindow は now が予定の時間帯に入っているかを返す。
+func (s Status) InWindow(now time.Time) bool {
+	return s.Window != nil && s.Window.Contains(now)
+}
+
+// Upcoming は now の時点でまだ始まっていない予定を返す（無ければ nil）。
+func (s Status) Upcoming(now time.Time) *Window {
+	if s.Window != nil && now.Before(s.Window.Start) {
+		return s.Window
+	}
+	return nil
+}
+
+// Allows は予定の時間帯中に email がログイン・操作を許可されているかを返す。
+// 許可アドレスは予定に付くものなので、手動で ON にしているだけのときは誰も許可しない。
+func (s Status) Allows(email string, now time.Time) bool {
+	if !s.InWindow(now) || email == "" {
 		return false
 	}
-	return s.Value == "true"
+	email = strings.ToLower(email)
+	for _, e := range s.Window.AllowEmails {
+		if e == email {
+			return true
+		}
+	}
+	return false
+}
+
+// Message は now の時点で利用者に出す告知文を返す。予定の時間帯外や、告知文が
+// 空のときは ""（呼び出し側の既定の文言を使う）。
+func (s Status) Message(now time.Time) string {
+	if !s.InWindow(now) {
+		return ""
+	}
+	return s.Window.Message
+}
+
+// RetryAfterSeconds は 503 に付ける Retry-After（秒）。予定の時間帯中で手動 ON でなければ
+// 終了までの秒数、それ以外は RetryAfter。
+func (s Status) RetryAfterSeconds(now time.Time) int {
+	if s.Manual || !s.InWindow(now) {
+		return RetryAfter
+	}
+	sec := int(s.Window.End.Sub(now).Seconds())
+	if sec < 1 {
+		sec = 1
+	}
+	return sec
+}
+
+// Load は現在の設定を返す。行が無い／DB エラーなどの場合は OFF（安全側＝サービス継続）。
+func Load(ctx context.Context, q *database.Queries) Status {
+	var st Status
+	if s, err := q.GetAppSetting(ctx, Key); err == nil {
+		st.Manual = s.Value == "true"
+	}
+	if s, err := q.GetAppSetting(ctx, ReadOnlyKey); err == nil {
+		st.ReadOnly = s.Value == "true"
+	}
+	st.Window = loadWindow(ctx, q, time.Now())
+	return st
+}
+
+// loadWindow は now の時点で終了していない予定を返す。
+func loadWindow(ctx context.Context, q *database.Queries, now time.Time) *Window {
+	s, err := q.GetAppSetting(ctx, WindowKey)
+	if err != nil {
+		return nil
+	}
+	var w Window
+	if err := json.Unmarshal([]byte(s.Value), &w); err != nil || !now.Before(w.End) {
+		return nil
+	}
+	return &w
+}
+
+// IsEnabled は現在メンテナンス中（手動 ON または予定の時間帯中）かを返す。
+// 行が無い／DB エラーなどの場合は false（安全側＝サービス継続）を返す。
+func IsEnabled(ctx context.Context, q *database.Queries) bool {
+	return Load(ctx, q).Active(time.Now())
 }
 
-// SetEnabled はメンテモードを ON / OFF に切り替える。
+// SetEnabled は手動切替を ON / OFF にする。
 func SetEnabled(ctx context.Context, q *database.Queries, on bool) error {
 	val := "false"
 	if on {
@@ -33,3 +166,124 @@ func SetEnabled(ctx context.Context, q *database.Queries, on bool) error {
 		Value: val,
 	})
 }
+
+// SetReadOnly は読み取り専用モードを ON / OFF にする。
+func SetReadOnly(ctx context.Context, q *database.Queries, on bool) error {
+	val := "false"
+	if on {
+		val = "true"
+	}
+	return q.UpsertAppSetting(ctx, database.UpsertAppSettingParams{
+		Key:   ReadOnlyKey,
+		Value: val,
+	})
+}
+
+// SaveWindow は予定を保存する（既存の予定は置き換える）。保存前に NormalizeWindow で検証すること。
+func SaveWindow(ctx context.Context, q *database.Queries, w Window) error {
+	b, err := json.Marshal(w)
+	if err != nil {
+		return fmt.Errorf("maintenance: encode window: %w", err)
+	}
+	return q.UpsertAppSetting(ctx, database.UpsertAppSettingParams{
+		Key:   WindowKey,
+		Value: string(b),
+	})
+}
+
+// ClearWindow は予定を取り消す（時間帯中なら、その時点でメンテナンスが終わる）。
+func ClearWindow(ctx context.Context, q *database.Queries) error {
+	return q.DeleteAppSetting(ctx, WindowKey)
+}
+
+// ParseEmails は改行・カンマ・空白区切りのアドレス一覧を分割する（検証は NormalizeWindow で行う）。
+func ParseEmails(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' ' || r == '\t' || r == '、'
+	})
+}
+
+// NormalizeWindow は許可アドレスを小文字に揃えて重複を除き、予定を検証する。
+// エラーは画面にそのまま出せるメッセージ。
+func NormalizeWindow(w Window, now time.Time) (Window, error) {
+	if w.Start.IsZero() || w.End.IsZero() {
+		return w, fmt.Errorf("開始日時と終了日時を指定してください")
+	}
+	if !w.End.After(w.Start) {
+		return w, fmt.Errorf("終了日時は開始日時より後にしてください")
+	}
+	if !w.End.After(now) {
+		return w, fmt.Errorf("終了日時が過去です")
+	}
+	w.Message = strings.TrimSpace(w.Message)
+	if len([]rune(w.Message)) > MaxMessageLen {
+		return w, fmt.Errorf("告知文は %d 文字以内で入力してください", MaxMessageLen)
+	}
+
+	seen := map[string]bool{}
+	emails := make([]string, 0, len(w.AllowEmails))
+	for _, e := range w.AllowEmails {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" || seen[e] {
+			continue
+		}
+		if a, err := mail.ParseAddress(e); err != nil || a.Address != e {
+			return w, fmt.Errorf("メールアドレスの形式が不正です: %s", e)
+		}
+		seen[e] = true
+		emails = append(emails, e)
+	}
+	w.AllowEmails = emails
+	w.Start, w.End = w.Start.UTC(), w.End.UTC()
+	return w, nil
+}
+
+// RetryAfter は手動切替によるメンテナンス中の 503 に付ける Retry-After（秒）。
+// 予定の時間帯中は終了までの秒数を使う（Status.RetryAfterSeconds）。
+const RetryAfter = 300
+
+// CacheTTL は Cache が DB を読み直すまでの最長時間。同じプロセスでの切替は
+// Invalidate で即時に反映されるので、これは別プロセス（CLI・複数台構成）で
+// 切り替えた場合に反映されるまでの上限になる。
+const CacheTTL = 30 * time.Second
+
+// Cache は Load の結果をプロセス内に保持する。メンテナンスのミドルウェアは
+// 認証付きの全リクエストで状態を見るため、毎回 app_settings を読まないようにする。
+// 予定の開始・終了は呼び出しごとに時刻で判定するので、キャッシュ中でも遅れない。
+type Cache struct {
+	q *database.Queries
+
+	mu      sync.Mutex
+	status  Status
+	expires time.Time
+}
+
+func NewCache(q *database.Queries) *Cache {
+	return &Cache{q: q}
+}
+
+// Status は現在の設定を返す。CacheTTL 以内の結果はキャッシュから返す。
+func (c *Cache) Status(ctx context.Context) Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().Before(c.expires) {
+		return c.status
+	}
+	c.status = Load(ctx, c.q)
+	c.expires = time.Now().Add(CacheTTL)
+	return c.status
+}
+
+// Enabled は現在メンテナンス中かを返す。
+func (c *Cache) Enabled(ctx context.Context) bool {
+	return c.Status(ctx).Active(time.Now())
+}
+
+// Invalidate はキャッシュを捨て、次の Status で DB を読み直させる。
+// 設定を書き換えたトランザクションをコミットした後に呼ぶ（コミット前に呼ぶと、
+// 古い値を読み直してキャッシュしてしまう）。
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expires = time.Time{}
+}
@@ -0,0 +1,192 @@
+// Package markdown はプロジェクトの説明などに書く Markdown の一部を HTML にする。
+//
+// 入力の HTML はすべてエスケープする（生の HTML は書けない）。リンクは http(s)・mailto・
+// サイト内のパスだけを許し、それ以外（javascript: など）は文字だけを出す。
+// 対応する記法: 見出し（#）、段落と改行、箇条書き（- * + / 1.）、引用（>）、
+// コードブロック（```）、水平線（---）、強調（** / *）、インラインコード（`）、リンク（[text](url)）。
+package markdown
+
+import (
+	"html"
+	"strings"
+	"unicode/utf8"
+)
+
+// ToHTML は src を HTML にする。結果はそのまま埋め込んでよい（templ.Raw など）。
+func ToHTML(src string) string {
+	var b strings.Builder
+	writeBlocks(&b, strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n"))
+	return b.String()
+}
+
+func writeBlocks(b *strings.Builder, lines []string) {
+	for i := 0; i < len(lines); {
+		line := strings.TrimSpace(lines[i])
+		switch {
+		case line == "":
+			i++
+		case strings.HasPrefix(line, "```"):
+			i++
+			start := i
+			for i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```") {
+				i++
+			}
+			b.WriteString("<pre><code>")
+			b.WriteString(html.EscapeString(strings.Join(lines[start:i], "\n")))
+			b.WriteString("</code></pre>\n")
+			i++ // 閉じの ```（無ければ末尾まで）
+		case headingLevel(line) > 0:
+			level := headingLevel(line)
+			tag := "h" + string(rune('0'+level))
+			b.WriteString("<" + tag + ">" + inline(strings.TrimSpace(line[level:])) + "</" + tag + ">\n")
+			i++
+		case isRule(line):
+			b.WriteString("<hr>\n")
+			i++
+		case strings.HasPrefix(line, ">"):
+			var quoted []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				q := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quoted = append(quoted, strings.TrimPrefix(q, " "))
+			}
+			b.WriteString("<blockquote>\n")
+			writeBlocks(b, quoted)
+			b.WriteString("</blockquote>\n")
+		default:
+			if ordered, _, ok := listItem(line); ok {
+				tag := "ul"
+				if ordered {
+					tag = "ol"
+				}
+				b.WriteString("<" + tag + ">\n")
+				for ; i < len(lines); i++ {
+					o, text, ok := listItem(strings.TrimSpace(lines[i]))
+					if !ok || o != ordered {
+						break
+					}
+					b.WriteString("<li>" + inline(text) + "</li>\n")
+				}
+				b.WriteString("</" + tag + ">\n")
+				continue
+			}
+			var para []string
+			for ; i < len(lines) && !startsBlock(strings.TrimSpace(lines[i])); i++ {
+				para = append(para, inline(strings.TrimSpace(lines[i])))
+			}
+			b.WriteString("<p>" + strings.Join(para, "<br>\n") + "</p>\n")
+		}
+	}
+}
+
+// startsBlock は line で段落が終わる（空行か、別のブロックが始まる）かを返す。
+func startsBlock(line string) bool {
+	_, _, item := listItem(line)
+	return line == "" || strings.HasPrefix(line, "```") || strings.HasPrefix(line, ">") ||
+		headingLevel(line) > 0 || isRule(line) || item
+}
+
+// headingLevel は "# " から "###### " で始まる行の見出しのレベルを返す（見出しでなければ 0）。
+func headingLevel(line string) int {
+	n := 0
+	for n < len(line) && line[n] == '#' {
+		n++
+	}
+	if n == 0 || n > 6 || (n < len(line) && line[n] != ' ') {
+		return 0
+	}
+	return n
+}
+
+// isRule は line が水平線（同じ記号 - * _ の 3 つ以上）かを返す。
+func isRule(line string) bool {
+	if len(line) < 3 || !strings.ContainsRune("-*_", rune(line[0])) {
+		return false
+	}
+	return strings.Count(line, line[:1]) == len(line)
+}
+
+// listItem は line が箇条書きの項目なら、番号付きか・項目の文字を返す。
+func listItem(line string) (ordered bool, text string, ok bool) {
+	if len(line) >= 2 && strings.ContainsRune("-*+", rune(line[0])) && line[1] == ' ' {
+		return false, strings.TrimSpace(line[2:]), true
+	}
+	n := 0
+	for n < len(line) && line[n] >= '0' && line[n] <= '9' {
+		n++
+	}
+	if n > 0 && n+1 < len(line) && line[n] == '.' && line[n+1] == ' ' {
+		return true, strings.TrimSpace(line[n+2:]), true
+	}
+	return false, "", false
+}
+
+// inline は 1 行の中の記法（コード・強調・リンク）を HTML にし、残りの文字をエスケープする。
+func inline(s string) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		switch {
+		case s[0] == '`':
+			if end := strings.IndexByte(s[1:], '`'); end >= 0 {
+				b.WriteString("<code>" + html.EscapeString(s[1:1+end]) + "</code>")
+				s = s[end+2:]
+				continue
+			}
+		case strings.HasPrefix(s, "**"):
+			if end := strings.Index(s[2:], "**"); end > 0 {
+				b.WriteString("<strong>" + inline(s[2:2+end]) + "</strong>")
+				s = s[end+4:]
+				continue
+			}
+		case s[0] == '*':
+			if end := strings.IndexByte(s[1:], '*'); end > 0 {
+				b.WriteString("<em>" + inline(s[1:1+end]) + "</em>")
+				s = s[end+2:]
+				continue
+			}
+		case s[0] == '[':
+			if text, href, n, ok := link(s); ok {
+				if safeURL(href) {
+					b.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener noreferrer"`)
+					if !strings.HasPrefix(href, "/") {
+						b.WriteString(` target="_blank"`)
+					}
+					b.WriteString(">" + inline(text) + "</a>")
+				} else {
+					b.WriteString(inline(text))
+				}
+				s = s[n:]
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(s)
+		b.WriteString(html.EscapeString(s[:size]))
+		s = s[size:]
+	}
+	return b.String()
+}
+
+// link は s の先頭の [text](url) を読み、文字・URL・読んだバイト数を返す。
+func link(s string) (text, href string, n int, ok bool) {
+	mid := strings.Index(s, "](")
+	if mid < 0 || strings.IndexByte(s[1:mid], '[') >= 0 {
+		return "", "", 0, false
+	}
+	end := strings.IndexByte(s[mid+2:], ')')
+	if end < 0 {
+		return "", "", 0, false
+	}
+	return s[1:mid], strings.TrimSpace(s[mid+2 : mid+2+end]), mid + 3 + end, true
+}
+
+// safeURL はリンク先に使ってよい URL（http(s)・mailto・サイト内のパス）かを返す。
+// "//host" はサイト外に出るので断る。
+func safeURL(href string) bool {
+	lower := strings.ToLower(href)
+	switch {
+	case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"), strings.HasPrefix(lower, "mailto:"):
+		return true
+	case strings.HasPrefix(href, "/"):
+		return !strings.HasPrefix(href, "//") && !strings.HasPrefix(href, "/\\")
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package markdown
+
+import "testing"
+
+func TestToHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"段落と改行", "1 行目\n2 行目\n\n次の段落", "<p>1 行目<br>\n2 行目</p>\n<p>次の段落</p>\n"},
+		{"見出し", "## 目的", "<h2>目的</h2>\n"},
+		{"# の後に空白が無いものは見出しにしない", "#タグ", "<p>#タグ</p>\n"},
+		{"箇条書き", "- a\n- **b**\n\n1. c\n2. d", "<ul>\n<li>a</li>\n<li><strong>b</strong></li>\n</ul>\n<ol>\n<li>c</li>\n<li>d</li>\n</ol>\n"},
+		{"引用", "> 引用\n> 続き", "<blockquote>\n<p>引用<br>\n続き</p>\n</blockquote>\n"},
+		{"コードブロックはそのまま", "```\n<b>**x**</b>\n```", "<pre><code>&lt;b&gt;**x**&lt;/b&gt;</code></pre>\n"},
+		{"水平線", "a\n\n---", "<p>a</p>\n<hr>\n"},
+		{"強調とインラインコード", "*em* と `a<b`", "<p><em>em</em> と <code>a&lt;b</code></p>\n"},
+		{"リンク", "[仕様](https://example.com/a?b=1&c=2)", `<p><a href="https://example.com/a?b=1&amp;c=2" rel="nofollow noopener noreferrer" target="_blank">仕様</a></p>` + "\n"},
+		{"サイト内のリンク", "[一覧](/projects)", `<p><a href="/projects" rel="nofollow noopener noreferrer">一覧</a></p>` + "\n"},
+		{"javascript: のリンクは文字だけ", "[x](javascript:alert(1))", "<p>x)</p>\n"},
+		{"サイト外へのパスは文字だけ", "[x](//evil.example)", "<p>x</p>\n"},
+		{"HTML はエスケープ", `<script>alert("x")</script>`, "<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;</p>\n"},
+		{"属性も閉じられない", `[x](https://e.example/" onclick="alert(1))`, `<p><a href="https://e.example/&#34; onclick=&#34;alert(1" rel="nofollow noopener noreferrer" target="_blank">x</a>)</p>` + "\n"},
+		{"閉じていない記号はそのまま", "**a と `b", "<p>**a と `b</p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToHTML(tt.src); got != tt.want {
+				t.Errorf("ToHTML(%q)\n got: %q\nwant: %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
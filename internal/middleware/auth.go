@@ -2,59 +2,268 @@ package middleware
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
 
 	"github.com/naozine/nz-magic-link/magiclink"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/apitoken"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
 )
 
+// UserContextMiddleware はセッション Cookie からログイン中のユーザーを特定し、appcontext に載せる。
+//
+// セッションが有効でも、users に該当行が無い（削除済み）か is_active=false のユーザーは
+// 未ログインとして扱い、そのユーザーの magiclink セッションをすべて失効させる。
+// 無効化・削除の時点でログイン中だった端末も、次のリクエストでログアウトされる。
+//
+// 管理者のセッションが「このユーザーとして表示」中なら、表示しているユーザーとして載せ、
+// 実際の管理者は appcontext.WithImpersonator で残す（impersonationTarget を参照）。
+//
+// セッションでログインした時刻と手段も appcontext.WithAuthenticatedAt / WithAuthMethod で載せる
+// （RequireRecentAuth が使う）。
+//
+// 解決したユーザーはセッションごとに authsession.IdentityTTL の間キャッシュし、その間は
+// セッション・ユーザー・パスキーを DB から読まない。変更した側が authsession.Forget* で捨てる。
+// 静的ファイルとヘルスチェック（skipsIdentity）はユーザーを特定せずに通す。
+//
+// Authorization: Bearer ヘッダがあるリクエストは Cookie を見ずに API トークンで認証する
+// （bearerAuth を参照）。
 func UserContextMiddleware(ml *magiclink.MagicLink, dbConn *sql.DB) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			userEmail, isLoggedIn := ml.ValidateSession(r)
-
-			var hasPasskey bool
-			var role string
-			var userID int64
-
-			if isLoggedIn {
-				creds, err := ml.DB.GetPasskeyCredentialsByUserID(userEmail)
-				if err == nil && len(creds) > 0 {
-					hasPasskey = true
-				}
-
-				q := database.New(dbConn)
-				user, err := q.GetUserByEmail(r.Context(), userEmail)
-				if err == nil {
-					role = user.Role
-					userID = user.ID
-				}
+			if skipsIdentity(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if raw, ok := apitoken.FromRequest(r); ok {
+				bearerAuth(w, r, next, dbConn, raw)
+				return
+			}
+
+			hash := authsession.CurrentHash(r, ml.Config.CookieName)
+			id, ok := authsession.CachedIdentity(hash)
+			if !ok {
+				id, ok = resolveIdentity(w, r, ml, dbConn, hash)
+			}
+			if !ok {
+				ctx := appcontext.WithUser(r.Context(), "", false, false, "", 0)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
 			}
 
-			ctx := appcontext.WithUser(r.Context(), userEmail, isLoggedIn, hasPasskey, role, userID)
+			if err := authsession.Touch(r.Context(), dbConn, hash, authsession.ClientIP(r)); err != nil {
+				logger.Error("セッションの最終アクセス時刻の更新に失敗", "error", err, "email", id.Email)
+			}
+			ctx := appcontext.WithUser(r.Context(), id.Email, true, id.HasPasskey, id.Role, id.UserID)
+			ctx = appcontext.WithAuthenticatedAt(ctx, id.AuthenticatedAt)
+			ctx = appcontext.WithAuthMethod(ctx, id.AuthMethod)
+			if id.Impersonator != nil {
+				ctx = appcontext.WithImpersonator(ctx, *id.Impersonator)
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-// RequireRole は指定されたロールのいずれかを持つユーザーのみアクセスを許可するミドルウェア。
-func RequireRole(roles ...string) func(http.Handler) http.Handler {
+// skipsIdentity はユーザーを特定しないパス（静的ファイルとヘルスチェック）かを返す。
+// これらのリクエストではセッションの検証も DB の読み込みもしない。
+func skipsIdentity(path string) bool {
+	return path == "/health" || strings.HasPrefix(path, "/static/")
+}
+
+// resolveIdentity はキャッシュに無いセッションを検証し、DB からユーザーとパスキーの有無、
+// 「このユーザーとして表示」の状態を引く。有効なユーザーならキャッシュして ok=true を返す。
+func resolveIdentity(w http.ResponseWriter, r *http.Request, ml *magiclink.MagicLink, dbConn *sql.DB, hash string) (authsession.Identity, bool) {
+	email, ok := ml.ValidateSession(r)
+	if !ok {
+		return authsession.Identity{}, false
+	}
+
+	q := database.New(dbConn)
+	user, err := q.GetUserByEmail(r.Context(), email)
+	switch {
+	case err == nil && user.IsActive:
+	case err == nil || errors.Is(err, sql.ErrNoRows):
+		revokeSessions(w, r, ml, dbConn, email)
+		return authsession.Identity{}, false
+	default:
+		// DB の一時的な失敗ではセッションは消さず、このリクエストだけ未ログインとして扱う。
+		logger.Error("ユーザーの取得に失敗", "error", err, "email", email)
+		return authsession.Identity{}, false
+	}
+
+	id := authsession.Identity{Email: email, Role: user.Role, UserID: user.ID}
+	if creds, err := ml.DB.GetPasskeyCredentialsByUserID(email); err == nil && len(creds) > 0 {
+		id.HasPasskey = true
+	}
+	if id.AuthenticatedAt, err = authsession.AuthenticatedAt(r.Context(), dbConn, hash); err != nil {
+		// ゼロ値のまま（本人確認が必要な操作では確認を求める）。
+		logger.Error("セッションのログイン時刻の取得に失敗", "error", err, "email", email)
+	}
+	if id.AuthMethod, err = authsession.AuthMethod(r.Context(), dbConn, hash); err != nil {
+		// 空文字のまま（パスキーを登録しているユーザーには本人確認を求める）。
+		logger.Error("セッションのログイン手段の取得に失敗", "error", err, "email", email)
+	}
+	if target, ok := impersonationTarget(r.Context(), q, user, hash); ok {
+		id.Impersonator = &appcontext.Impersonator{ID: user.ID, Email: user.Email, Role: user.Role}
+		id.Email, id.Role, id.UserID = target.Email, target.Role, target.ID
+	}
+	authsession.CacheIdentity(hash, id)
+	return id, true
+}
+
+// ForgetSessionIdentity は magiclink のハンドラ（/auth/*, /webauthn/*）を包み、書き込みの
+// リクエスト（ログアウト・パスキーの登録など）の後で、そのセッションのキャッシュを捨てる。
+// magiclink にはこれらの完了を知らせるフックが無いため、GET 以外のたびに捨てる。
+func ForgetSessionIdentity(cookieName string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			role := appcontext.GetUserRole(r.Context())
-			for _, allowed := range roles {
-				if role == allowed {
-					next.ServeHTTP(w, r)
-					return
-				}
+			next.ServeHTTP(w, r)
+			if !isSafeMethod(r.Method) {
+				authsession.ForgetSession(authsession.CurrentHash(r, cookieName))
 			}
-			http.Error(w, "アクセス権限がありません", http.StatusForbidden)
 		})
 	}
 }
 
+// bearerAuth は API トークンの所有者をログイン中のユーザーとして appcontext に載せる。
+// 無効・期限切れのトークンは Cookie 認証に落とさず 401 にし、read スコープのトークンでの
+// 書き込みは 403 にする（どちらもスクリプト向けに JSON で返す）。
+func bearerAuth(w http.ResponseWriter, r *http.Request, next http.Handler, dbConn *sql.DB, raw string) {
+	q := database.New(dbConn)
+	tok, user, err := apitoken.Authenticate(r.Context(), q, raw)
+	switch {
+	case errors.Is(err, apitoken.ErrInvalid), errors.Is(err, apitoken.ErrExpired):
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeJSONError(w, http.StatusUnauthorized, "invalid_token", "API トークンが無効か、有効期限が切れています")
+		return
+	case err != nil:
+		logger.Error("API トークンの検証に失敗", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal", "API トークンを検証できませんでした")
+		return
+	}
+	if !apitoken.Allows(tok.Scope, r.Method) {
+		writeJSONError(w, http.StatusForbidden, "insufficient_scope", "この API トークンは読み取り専用です")
+		return
+	}
+	if err := apitoken.Touch(r.Context(), q, tok); err != nil {
+		logger.Error("API トークンの最終使用日時の更新に失敗", "error", err, "token_id", tok.ID)
+	}
+
+	ctx := appcontext.WithUser(r.Context(), user.Email, true, false, user.Role, user.ID)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// writeJSONError は JSON API と同じ形式（{"error": コード, "message": 説明}）でエラーを返す。
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code, "message": message})
+}
+
+// revokeSessions は削除済み・無効化済みユーザーの全セッションを失効させ、
+// このリクエストの Cookie も消す。
+func revokeSessions(w http.ResponseWriter, r *http.Request, ml *magiclink.MagicLink, dbConn *sql.DB, email string) {
+	if err := ml.Logout(w, r); err != nil {
+		logger.Error("セッション Cookie の削除に失敗", "error", err, "email", email)
+	}
+	n, err := authsession.RevokeAll(r.Context(), dbConn, email)
+	if err != nil {
+		logger.Error("セッションの失効に失敗", "error", err, "email", email)
+		return
+	}
+	logger.Info("無効なユーザーのセッションを失効", "email", email, "sessions", n)
+}
+
+// RequireRole は指定されたロールのいずれかを持つユーザーのみアクセスを許可するミドルウェア。
+// JSON API（wantsJSON）には 403 を JSON で返す。
+// ルートの権限は RequirePermission で書く（ロールを足したときにルートを直さずに済むように）。
+func RequireRole(names ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return roleGate{
+			next:    next,
+			allows:  func(role string) bool { return slices.Contains(names, role) },
+			allowed: func() []string { return names },
+		}
+	}
+}
+
+// RequirePermission は p の権限を持つロールのユーザーのみアクセスを許可するミドルウェア
+// （ロールと権限の対応は roles.rolePermissions）。拒否したときの応答は RequireRole と同じ。
+func RequirePermission(p roles.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return roleGate{
+			next:    next,
+			allows:  func(role string) bool { return roles.Has(role, p) },
+			allowed: func() []string { return roles.With(p) },
+		}
+	}
+}
+
+// roleGate は RequireRole / RequirePermission が next を包んだハンドラ。通すロールを
+// RequiredRoles が読めるように持つ。カスタムロールは後から増えるので、allowed は呼ぶたびに引く。
+type roleGate struct {
+	next    http.Handler
+	allows  func(role string) bool
+	allowed func() []string
+}
+
+func (g roleGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !g.allows(appcontext.GetUserRole(r.Context())) {
+		forbidden(w, r)
+		return
+	}
+	g.next.ServeHTTP(w, r)
+}
+
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		writeJSONError(w, http.StatusForbidden, "forbidden", "アクセス権限がありません")
+		return
+	}
+	http.Error(w, "アクセス権限がありません", http.StatusForbidden)
+}
+
+// RequiredRoles は mw が RequireRole か RequirePermission で作ったミドルウェアなら、通すロールを返す。
+// OpenAPI のドキュメントにルートごとの必要ロールを載せるためのもので、mw が包んだハンドラが
+// roleGate かどうかで判断する（リクエストは通さない）。
+func RequiredRoles(mw func(http.Handler) http.Handler) ([]string, bool) {
+	g, ok := mw(http.NotFoundHandler()).(roleGate)
+	if !ok {
+		return nil, false
+	}
+	return slices.Clone(g.allowed()), true
+}
+
+// RequireAPIAuth は JSON API 用の RequireAuth。未認証ならログイン画面へ送らず、401 を JSON で返す。
+func RequireAPIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, isLoggedIn, _ := appcontext.GetUser(r.Context()); !isLoggedIn {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized", "ログインするか、API トークンを指定してください")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// APIPathPrefix は JSON API（routes.RegisterAPIRoutes）のパス。配下のエラーは常に JSON で返す。
+const APIPathPrefix = "/api/v1/"
+
+// wantsJSON はエラーを JSON で返すべきリクエスト（JSON API か Accept: application/json）かを返す。
+func wantsJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, APIPathPrefix) || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
 // RequireAuth は認証を必須とするミドルウェア。
 // 未認証の場合はログインページへリダイレクトし、元の URL を redirect パラメータで引き継ぐ。
 func RequireAuth(loginURL string) func(http.Handler) http.Handler {
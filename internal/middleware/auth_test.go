@@ -3,9 +3,11 @@ package middleware
 import (
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"testing"
 
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
 )
 
 func TestRequireRole(t *testing.T) {
@@ -45,6 +47,43 @@ func TestRequireRole(t *testing.T) {
 	}
 }
 
+func TestRequirePermission(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, p := range roles.Permissions {
+		for _, role := range append(slices.Clone(roles.All), "") {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req = req.WithContext(appcontext.WithUser(req.Context(), "test@example.com", true, false, role, 1))
+			rec := httptest.NewRecorder()
+			RequirePermission(p)(next).ServeHTTP(rec, req)
+
+			want := http.StatusForbidden
+			if roles.Has(role, p) {
+				want = http.StatusOK
+			}
+			if rec.Code != want {
+				t.Errorf("%s / ロール %q: ステータスコード = %d, want %d", p, role, rec.Code, want)
+			}
+		}
+	}
+
+	// OpenAPI に載せる必要ロールは対応表と一致する。
+	for _, p := range roles.Permissions {
+		got, ok := RequiredRoles(RequirePermission(p))
+		if !ok || !slices.Equal(got, roles.With(p)) {
+			t.Errorf("RequiredRoles(%s) = %v, %v; want %v", p, got, ok, roles.With(p))
+		}
+	}
+	if got, ok := RequiredRoles(RequireRole("admin")); !ok || !slices.Equal(got, []string{"admin"}) {
+		t.Errorf("RequiredRoles(RequireRole) = %v, %v", got, ok)
+	}
+	// ロールを要求しないミドルウェアは対象外。
+	if got, ok := RequiredRoles(RequireAPIAuth); ok {
+		t.Errorf("RequiredRoles(RequireAPIAuth) = %v, %v; want false", got, ok)
+	}
+}
+
 func TestRequireAuth(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -108,3 +147,20 @@ func TestRequireAuth(t *testing.T) {
 		})
 	}
 }
+
+// 静的ファイルとヘルスチェックはセッションを見ずに通す（magiclink も DB も使わない）。
+func TestUserContextMiddleware_SkipsStaticAndHealth(t *testing.T) {
+	for _, path := range []string{"/static/css/output.css", "/health"} {
+		var called bool
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			if _, loggedIn, _ := appcontext.GetUser(r.Context()); loggedIn {
+				t.Errorf("%s: ログイン済みとして扱われた", path)
+			}
+		})
+		UserContextMiddleware(nil, nil)(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		if !called {
+			t.Errorf("%s: 次のハンドラが呼ばれない", path)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/a-h/templ"
+)
+
+// CSPReportPath はブラウザが CSP 違反を報告する先（handlers.CSPReport）。
+const CSPReportPath = "/csp-report"
+
+// CSPMode は CSP ヘッダの出し方（環境変数 CSP_MODE）。
+type CSPMode string
+
+const (
+	// CSPEnforce は違反するスクリプトを止め、報告もする（既定）。
+	CSPEnforce CSPMode = "enforce"
+	// CSPReportOnly は止めずに報告だけする（Content-Security-Policy-Report-Only）。
+	// 派生プロジェクトで導入するときに、既存の画面が違反しないかを本番で確かめる段階に使う。
+	CSPReportOnly CSPMode = "report-only"
+	// CSPOff は CSP ヘッダを付けない。
+	CSPOff CSPMode = "off"
+)
+
+// ParseCSPMode は CSP_MODE の値を CSPMode にする。空は CSPEnforce。
+func ParseCSPMode(s string) (CSPMode, error) {
+	switch m := CSPMode(s); m {
+	case "":
+		return CSPEnforce, nil
+	case CSPEnforce, CSPReportOnly, CSPOff:
+		return m, nil
+	}
+	return "", fmt.Errorf("CSP_MODE must be %s, %s or %s: %q", CSPEnforce, CSPReportOnly, CSPOff, s)
+}
+
+// CSP はリクエストごとに nonce を作って context に載せ（templ.WithNonce）、それを許す
+// Content-Security-Policy を付けるミドルウェア。layouts の inline <script> は
+// nonce={ templ.GetNonce(ctx) } を付けること。nonce の無い inline script と onclick などの
+// inline のイベントハンドラは動かない（Datastar の data-on:* を使う）。
+//
+// Datastar は data-* 属性の式を Function で評価するため 'unsafe-eval' を許す。同じ理由で
+// sse.ExecuteScript（Redirect なども内部で使う）が patch する <script> は nonce が無く動かない。
+// handlers.runScript を使うこと。style は画面に style 属性があるため 'unsafe-inline' のまま。
+//
+// CSPOff のときは何もしない（nonce も載せないので、script の nonce 属性は空になる）。
+func CSP(mode CSPMode) func(http.Handler) http.Handler {
+	header := "Content-Security-Policy"
+	if mode == CSPReportOnly {
+		header = "Content-Security-Policy-Report-Only"
+	}
+	return func(next http.Handler) http.Handler {
+		if mode == CSPOff {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce := newNonce()
+			w.Header().Set(header, cspPolicy(nonce))
+			next.ServeHTTP(w, r.WithContext(templ.WithNonce(r.Context(), nonce)))
+		})
+	}
+}
+
+// cspPolicy は nonce を許すポリシーを返す。外部のリソースは読み込まないので、すべて 'self' に絞る。
+func cspPolicy(nonce string) string {
+	return "default-src 'self'; " +
+		"script-src 'self' 'nonce-" + nonce + "' 'unsafe-eval'; " +
+		"style-src 'self' 'unsafe-inline'; " +
+		"img-src 'self' data:; " +
+		"connect-src 'self'; " +
+		"object-src 'none'; " +
+		"base-uri 'none'; " +
+		"form-action 'self'; " +
+		"frame-ancestors 'none'; " +
+		"report-uri " + CSPReportPath
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b) // crypto/rand.Read はエラーを返さない（Go 1.24 以降）
+	return base64.StdEncoding.EncodeToString(b)
+}
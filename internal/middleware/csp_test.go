@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-h/templ"
+)
+
+// CSP はリクエストごとに別の nonce を作り、context（templ.GetNonce）とヘッダで同じ値を使う。
+func TestCSP_NonceMatchesHeader(t *testing.T) {
+	var nonce string
+	h := CSP(CSPEnforce)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		nonce = templ.GetNonce(r.Context())
+	}))
+
+	seen := map[string]bool{}
+	for range 3 {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		policy := rec.Header().Get("Content-Security-Policy")
+		if nonce == "" || !strings.Contains(policy, "'nonce-"+nonce+"'") {
+			t.Fatalf("nonce = %q, policy = %q", nonce, policy)
+		}
+		if seen[nonce] {
+			t.Fatalf("nonce が使い回されている: %q", nonce)
+		}
+		seen[nonce] = true
+	}
+}
+
+func TestCSP_Modes(t *testing.T) {
+	tests := []struct {
+		mode       string
+		wantHeader string
+	}{
+		{"", "Content-Security-Policy"},
+		{"enforce", "Content-Security-Policy"},
+		{"report-only", "Content-Security-Policy-Report-Only"},
+		{"off", ""},
+	}
+	for _, tt := range tests {
+		mode, err := ParseCSPMode(tt.mode)
+		if err != nil {
+			t.Fatalf("ParseCSPMode(%q): %v", tt.mode, err)
+		}
+		rec := httptest.NewRecorder()
+		CSP(mode)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		for _, name := range []string{"Content-Security-Policy", "Content-Security-Policy-Report-Only"} {
+			if got := rec.Header().Get(name); (name == tt.wantHeader) != (got != "") {
+				t.Errorf("%q: %s = %q", tt.mode, name, got)
+			}
+		}
+		if tt.wantHeader != "" && !strings.Contains(rec.Header().Get(tt.wantHeader), "report-uri "+CSPReportPath) {
+			t.Errorf("%q: 違反の報告先が無い: %s", tt.mode, rec.Header().Get(tt.wantHeader))
+		}
+	}
+	if _, err := ParseCSPMode("strict"); err == nil {
+		t.Error("ParseCSPMode(strict) はエラーにすること")
+	}
+}
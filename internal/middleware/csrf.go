@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+)
+
+// CSRFHeader は fetch（Datastar を含む）が CSRF トークンを送るヘッダ。layouts.Head の script が
+// 同一オリジンへの書き込みに自動で付ける。トークンが古いときは 403 の応答にも今のトークンを載せる。
+const CSRFHeader = "X-CSRF-Token"
+
+// CSRFFormField はネイティブのフォームが CSRF トークンを送るフィールド名（layouts.CSRFField）。
+const CSRFFormField = "csrf_token"
+
+// CSRF で断るときのメッセージ。
+const (
+	CSRFOriginMessage = "別のサイトからのリクエストは受け付けません"
+	CSRFTokenMessage  = "リクエストを確認できませんでした。ページを再読み込みしてからやり直してください"
+)
+
+// CSRFToken はセッション Cookie の値から、そのセッションの CSRF トークンを導く。Cookie の値は
+// HttpOnly でスクリプトから読めず、DB にはハッシュしか無いので、トークンから逆算もできない。
+func CSRFToken(sessionToken string) string {
+	mac := hmac.New(sha256.New, []byte(sessionToken))
+	mac.Write([]byte("csrf"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CSRFOptions は CSRF の確かめ方をパスごとに変える。
+type CSRFOptions struct {
+	// Exempt は確かめないパス（ブラウザが自分で送る CSP の違反報告など、画面から送るのではないもの）。
+	Exempt []string
+	// Multipart は multipart のフォームを受け付けるパス。body の上限がルートごとに違うため
+	// ミドルウェアでは読まず、ハンドラが ParseMultipartForm の後に ValidCSRFForm で確かめる。
+	// ここに無いパスへの multipart は、CSRFHeader が無ければ断る。
+	Multipart []string
+}
+
+// CSRF は書き込み（GET / HEAD / OPTIONS 以外）のリクエストを、別のサイトから送らせたもの
+// でないか確かめるミドルウェア。UserContextMiddleware の内側で使う。
+//
+//   - Sec-Fetch-Site があれば same-origin（か none）、無ければ Origin が serverAddr（SERVER_ADDR）の
+//     オリジンと一致すること。どちらも無い（ブラウザ以外の）リクエストはここでは断らない。
+//   - セッションでログインしているなら、さらに CSRFHeader（urlencoded のフォームは CSRFFormField）の
+//     トークンが CSRFToken と一致すること。multipart のフォームは opts.Multipart のパスだけ
+//     ハンドラに任せ、それ以外は断る。
+//
+// API トークン（Authorization: Bearer）での認証はブラウザが自動で送らないため対象外。
+// opts.Exempt のパスも確かめない。
+// 断るときは 403（JSON API と Accept: application/json は JSON、それ以外はテキスト）。
+// 読み取りのリクエストにも、画面に埋め込むトークンを context に載せる（appcontext.GetCSRFToken）。
+func CSRF(cookieName, serverAddr string, opts CSRFOptions) func(http.Handler) http.Handler {
+	origin := originOf(serverAddr)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := sessionCSRFToken(r, cookieName)
+			if token != "" {
+				r = r.WithContext(appcontext.WithCSRFToken(r.Context(), token))
+			}
+			if isSafeMethod(r.Method) || strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") || slices.Contains(opts.Exempt, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			sameOrigin, fromBrowser := checkOrigin(r, origin)
+			if !sameOrigin {
+				logger.Warn("CSRF: cross-origin request rejected", "path", r.URL.Path,
+					"origin", r.Header.Get("Origin"), "sec_fetch_site", r.Header.Get("Sec-Fetch-Site"))
+				rejectCSRF(w, r, "cross_origin", CSRFOriginMessage)
+				return
+			}
+			if token != "" && !validCSRFToken(r, token, slices.Contains(opts.Multipart, r.URL.Path)) {
+				// 同じオリジンからでもトークンが古いことはある（別のタブや本人確認でログインし直した）。
+				// ブラウザが同一オリジンと示したときだけ今のトークンを返し、layouts.Head の script が
+				// 1 回だけ送り直す。
+				if fromBrowser {
+					w.Header().Set(CSRFHeader, token)
+				}
+				logger.Warn("CSRF: token mismatch", "path", r.URL.Path)
+				rejectCSRF(w, r, "csrf_token", CSRFTokenMessage)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ValidCSRFForm は multipart のフォームの CSRFFormField を確かめる（CSRF を参照）。
+// ParseMultipartForm の後に呼ぶ。セッションでログインしていなければ true。
+func ValidCSRFForm(r *http.Request) bool {
+	token := appcontext.GetCSRFToken(r.Context())
+	return token == "" || equalToken(r.FormValue(CSRFFormField), token)
+}
+
+// sessionCSRFToken はセッションでログインしているリクエストなら、そのセッションのトークンを返す。
+func sessionCSRFToken(r *http.Request, cookieName string) string {
+	if _, loggedIn, _ := appcontext.GetUser(r.Context()); !loggedIn {
+		return ""
+	}
+	c, err := r.Cookie(cookieName)
+	if err != nil || c.Value == "" {
+		return ""
+	}
+	return CSRFToken(c.Value)
+}
+
+// checkOrigin はリクエストが origin から送られたものかを返す。fromBrowser はブラウザが
+// Sec-Fetch-Site か Origin で送信元を示したか（どちらも無ければ sameOrigin は true）。
+func checkOrigin(r *http.Request, origin string) (sameOrigin, fromBrowser bool) {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		// none はアドレスバーやブックマークなど利用者自身の操作。
+		return site == "same-origin" || site == "none", true
+	}
+	if o := r.Header.Get("Origin"); o != "" {
+		return strings.EqualFold(o, origin), true
+	}
+	return true, false
+}
+
+// validCSRFToken は CSRFHeader（無ければフォームの CSRFFormField）が token と一致するかを返す。
+// multipart のフォームは、ハンドラが ValidCSRFForm で確かめるパス（multipartOK）なら true、
+// それ以外は false（確かめる人がいないまま通さない）。
+func validCSRFToken(r *http.Request, token string, multipartOK bool) bool {
+	got := r.Header.Get(CSRFHeader)
+	if got == "" {
+		contentType := r.Header.Get("Content-Type")
+		switch {
+		case strings.HasPrefix(contentType, "multipart/form-data"):
+			return multipartOK
+		case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+			got = r.PostFormValue(CSRFFormField)
+		}
+	}
+	return equalToken(got, token)
+}
+
+func equalToken(got, want string) bool {
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// originOf は serverAddr（https://example.com/ など）のオリジン（scheme://host）を返す。
+func originOf(serverAddr string) string {
+	u, err := url.Parse(serverAddr)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return strings.TrimRight(serverAddr, "/")
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func rejectCSRF(w http.ResponseWriter, r *http.Request, code, message string) {
+	w.Header().Set("Cache-Control", "no-store")
+	if wantsJSON(r) {
+		writeJSONError(w, http.StatusForbidden, code, message)
+		return
+	}
+	http.Error(w, message, http.StatusForbidden)
+}
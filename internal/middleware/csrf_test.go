@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+)
+
+func TestCSRF(t *testing.T) {
+	const cookieName = "app_session"
+	session := &http.Cookie{Name: cookieName, Value: "session-token"}
+	token := CSRFToken(session.Value)
+
+	tests := []struct {
+		name       string
+		method     string
+		headers    map[string]string
+		body       string
+		loggedIn   bool
+		wantStatus int
+	}{
+		{"GET は確かめない", http.MethodGet, map[string]string{"Origin": "https://evil.example"}, "", true, http.StatusOK},
+		{"同一オリジン + トークン", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-origin", CSRFHeader: token}, "", true, http.StatusOK},
+		{"Origin 一致 + トークン", http.MethodPost, map[string]string{"Origin": "https://app.example", CSRFHeader: token}, "", true, http.StatusOK},
+		{"別サイトの Sec-Fetch-Site", http.MethodPost, map[string]string{"Sec-Fetch-Site": "cross-site", CSRFHeader: token}, "", true, http.StatusForbidden},
+		{"同じサイトの別オリジン", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-site", CSRFHeader: token}, "", true, http.StatusForbidden},
+		{"別オリジンの Origin", http.MethodDelete, map[string]string{"Origin": "https://evil.example", CSRFHeader: token}, "", true, http.StatusForbidden},
+		{"Origin: null", http.MethodPost, map[string]string{"Origin": "null"}, "", false, http.StatusForbidden},
+		{"トークン無し", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-origin"}, "", true, http.StatusForbidden},
+		{"トークン違い", http.MethodPut, map[string]string{"Sec-Fetch-Site": "same-origin", CSRFHeader: CSRFToken("other")}, "", true, http.StatusForbidden},
+		{"フォームのトークン", http.MethodPost, map[string]string{"Origin": "https://app.example", "Content-Type": "application/x-www-form-urlencoded"}, CSRFFormField + "=" + token, true, http.StatusOK},
+		{"未ログインはオリジンだけ", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-origin"}, "", false, http.StatusOK},
+		{"Bearer は確かめない", http.MethodPost, map[string]string{"Origin": "https://evil.example", "Authorization": "Bearer x"}, "", true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := CSRF(cookieName, "https://app.example/", CSRFOptions{Multipart: []string{"/admin/users/import"}})(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			req := httptest.NewRequest(tt.method, "/api/sse/projects/new", strings.NewReader(tt.body))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			req.AddCookie(session)
+			req = req.WithContext(appcontext.WithUser(req.Context(), "u@example.com", tt.loggedIn, false, "", 1))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body: %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
+
+// multipart はトークンをハンドラが確かめるルート（CSRFOptions.Multipart）だけ通し、
+// それ以外は断る。
+func TestCSRF_MultipartNeedsOptIn(t *testing.T) {
+	const cookieName = "app_session"
+	h := CSRF(cookieName, "https://app.example", CSRFOptions{Multipart: []string{"/admin/users/import"}})(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	do := func(path string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("--x--\r\n"))
+		req.Header.Set("Sec-Fetch-Site", "same-origin")
+		req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: "session-token"})
+		req = req.WithContext(appcontext.WithUser(req.Context(), "u@example.com", true, false, "", 1))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if got := do("/admin/users/import"); got != http.StatusOK {
+		t.Errorf("opt-in route: status = %d, want %d", got, http.StatusOK)
+	}
+	if got := do("/api/sse/projects/new"); got != http.StatusForbidden {
+		t.Errorf("other route: status = %d, want %d", got, http.StatusForbidden)
+	}
+}
+
+// トークンが古いときは、ブラウザが同一オリジンと示した場合だけ今のトークンを返す。
+func TestCSRF_RefreshesStaleToken(t *testing.T) {
+	const cookieName = "app_session"
+	h := CSRF(cookieName, "https://app.example", CSRFOptions{})(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	do := func(site string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		if site != "" {
+			req.Header.Set("Sec-Fetch-Site", site)
+		}
+		req.Header.Set(CSRFHeader, CSRFToken("old-session"))
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: "new-session"})
+		req = req.WithContext(appcontext.WithUser(req.Context(), "u@example.com", true, false, "", 1))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("same-origin"); rec.Code != http.StatusForbidden || rec.Header().Get(CSRFHeader) != CSRFToken("new-session") {
+		t.Errorf("same-origin: status = %d, %s = %q", rec.Code, CSRFHeader, rec.Header().Get(CSRFHeader))
+	}
+	if rec := do(""); rec.Code != http.StatusForbidden || rec.Header().Get(CSRFHeader) != "" {
+		t.Errorf("送信元不明: status = %d, %s = %q（トークンを返してはいけない）", rec.Code, CSRFHeader, rec.Header().Get(CSRFHeader))
+	}
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// ImpersonationMessage は「このユーザーとして表示」中に書き込みを断るときのメッセージ。
+const ImpersonationMessage = "ユーザーとして表示中は変更できません。表示を終了してから操作してください"
+
+// impersonationTarget は admin のセッション（sessionHash）が「このユーザーとして表示」中なら、
+// 表示しているユーザーを返す。admin が権限を失った、または相手が無効化・削除された・
+// 権限を得た場合は表示を終わらせる（impersonations の行を消す）。
+func impersonationTarget(ctx context.Context, q *database.Queries, admin database.User, sessionHash string) (database.User, bool) {
+	if sessionHash == "" {
+		return database.User{}, false
+	}
+	imp, err := q.GetImpersonation(ctx, sessionHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, false
+	}
+	if err != nil {
+		logger.Error("ユーザーとして表示の状態の取得に失敗", "error", err, "email", admin.Email)
+		return database.User{}, false
+	}
+
+	target, err := q.GetUserByID(ctx, imp.TargetUserID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error("表示中のユーザーの取得に失敗", "error", err, "user_id", imp.TargetUserID)
+		return database.User{}, false
+	}
+	if err == nil && imp.AdminUserID == admin.ID && CanImpersonate(admin, target) {
+		return target, true
+	}
+	if _, err := q.DeleteImpersonation(ctx, sessionHash); err != nil {
+		logger.Error("ユーザーとして表示の終了に失敗", "error", err, "email", admin.Email)
+	}
+	logger.Info("ユーザーとして表示を終了（条件を満たさなくなった）", "email", admin.Email, "target_user_id", imp.TargetUserID)
+	return database.User{}, false
+}
+
+// CanImpersonate は admin が target として表示できるかを返す。自分自身・無効なユーザー・
+// 同じく表示の権限を持つユーザー（他の管理者）としては表示できない。
+func CanImpersonate(admin, target database.User) bool {
+	return roles.Has(admin.Role, roles.UserImpersonate) &&
+		admin.IsActive && target.IsActive && admin.ID != target.ID &&
+		!roles.Has(target.Role, roles.UserImpersonate)
+}
+
+// BlockImpersonatedWrites は「このユーザーとして表示」中、書き込み（GET / HEAD / OPTIONS 以外）の
+// リクエストを止めるミドルウェア。exempt で始まるパス（表示の終了）は止めない。
+// 応答の振り分けは ReadOnly と同じで、Datastar のリクエストには rejected、
+// JSON API と Accept: application/json には 403 の JSON、それ以外は 403 のテキストを返す。
+func BlockImpersonatedWrites(rejected http.Handler, exempt ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := appcontext.GetImpersonator(r.Context()); !ok || isSafeMethod(r.Method) || hasAnyPrefix(r.URL.Path, exempt) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.Header.Get("Datastar-Request") == "true" {
+				rejected.ServeHTTP(w, r)
+				return
+			}
+			if wantsJSON(r) {
+				writeJSONError(w, http.StatusForbidden, "impersonating", ImpersonationMessage)
+				return
+			}
+			http.Error(w, ImpersonationMessage, http.StatusForbidden)
+		})
+	}
+}
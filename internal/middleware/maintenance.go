@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// maintenanceMessage は告知文が未設定のとき HTML 以外のリクエストに返すメッセージ。
+const maintenanceMessage = "メンテナンス中のため、現在ご利用いただけません"
+
+// Maintenance はメンテナンス中、maintenance.toggle の権限を持つロール（admin）と予定で許可された
+// アドレス以外のログイン中ユーザーのリクエストを 503 で止めるミドルウェア。RequireAuth の内側に置く（未ログインはこれまで
+// どおりログイン画面へ送る）。
+//
+// 画面のリクエストには page を返す（page は 503 を書くこと）。Datastar の SSE
+// （Datastar-Request ヘッダ・/api/ 配下）はテキスト、JSON API と Accept: application/json は JSON で返す。
+// いずれも Retry-After を付ける。通したリクエストと、予定メンテナンスの開始前のリクエストには
+// Shell がバナーを出せるよう context にメンテナンスの情報を載せる。
+func Maintenance(cache *maintenance.Cache, page http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			now := time.Now()
+			st := cache.Status(ctx)
+
+			if !st.Active(now) {
+				if win := st.Upcoming(now); win != nil {
+					ctx = appcontext.WithMaintenance(ctx, appcontext.Maintenance{Start: win.Start, End: win.End, Message: win.Message})
+					r = r.WithContext(ctx)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			info := appcontext.Maintenance{Active: true, Message: st.Message(now)}
+			if st.InWindow(now) && !st.Manual {
+				info.Start, info.End = st.Window.Start, st.Window.End
+			}
+			ctx = appcontext.WithMaintenance(ctx, info)
+			email, _, _ := appcontext.GetUser(ctx)
+			// 「このユーザーとして表示」中は実際の管理者で判定する（表示の終了まで止めないため）。
+			admin, impersonating := appcontext.GetImpersonator(ctx)
+			if appcontext.Can(ctx, roles.MaintenanceToggle) || st.Allows(email, now) ||
+				(impersonating && roles.Has(admin.Role, roles.MaintenanceToggle)) {
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			msg := info.Message
+			if msg == "" {
+				msg = maintenanceMessage
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(st.RetryAfterSeconds(now)))
+			w.Header().Set("Cache-Control", "no-store")
+			switch {
+			case wantsJSON(r):
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_ = json.NewEncoder(w).Encode(map[string]string{
+					"error":   "maintenance",
+					"message": msg,
+				})
+			case r.Header.Get("Datastar-Request") == "true", strings.HasPrefix(r.URL.Path, "/api/"):
+				http.Error(w, msg, http.StatusServiceUnavailable)
+			default:
+				page.ServeHTTP(w, r.WithContext(ctx))
+			}
+		})
+	}
+}
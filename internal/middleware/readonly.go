@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
+)
+
+// ReadOnlyMessage は読み取り専用モード中に書き込みを断るときのメッセージ。
+const ReadOnlyMessage = "読み取り専用モードのため、現在は変更できません"
+
+// ReadOnly は読み取り専用モード中、書き込み（GET / HEAD / OPTIONS 以外）のリクエストを止める
+// ミドルウェア。admin も対象。exempt で始まるパス（モードを解除する管理画面の操作）は止めない。
+// 読み取りのリクエストは通し、画面が書き込みボタンを隠せるよう context にモードを載せる。
+//
+// Datastar のリクエスト（Datastar-Request ヘッダ）には rejected を返す（rejected は SSE の
+// トーストで知らせること。Datastar は 200 以外の応答本文を処理しない）。
+// JSON API と Accept: application/json は 503 の JSON、それ以外は 503 のテキストで、Retry-After を付ける。
+func ReadOnly(cache *maintenance.Cache, rejected http.Handler, exempt ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !cache.Status(r.Context()).ReadOnly {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r = r.WithContext(appcontext.WithReadOnly(r.Context()))
+			if isSafeMethod(r.Method) || hasAnyPrefix(r.URL.Path, exempt) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.Header.Get("Datastar-Request") == "true" {
+				rejected.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(maintenance.RetryAfter))
+			w.Header().Set("Cache-Control", "no-store")
+			if wantsJSON(r) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_ = json.NewEncoder(w).Encode(map[string]string{
+					"error":   "read_only",
+					"message": ReadOnlyMessage,
+				})
+				return
+			}
+			http.Error(w, ReadOnlyMessage, http.StatusServiceUnavailable)
+		})
+	}
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -11,8 +11,7 @@ import "net/http"
 //     URL のパス・クエリを Referer に載せない（トークン入り URL の漏洩対策）
 //
 // HSTS は TLS を終端する前段のリバースプロキシ（nz-vps-ops の Caddy）で付与する
-// 方針のため、ここでは付けない。CSP は templ / Datastar の inline script と
-// 衝突するため未導入（導入する場合は nonce 化が必要）。
+// 方針のため、ここでは付けない。CSP はリクエストごとの nonce が要るため CSP で付ける。
 func SecurityHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("X-Frame-Options", "DENY")
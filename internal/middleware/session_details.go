@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+)
+
+// RecordSessionDetails は magiclink のハンドラ（/auth/verify, /webauthn/login/finish など）を包み、
+// レスポンスで新しいセッション Cookie が発行されたらログイン元の IP・User-Agent と
+// ログインの手段 method（authsession.Method*）を記録する。magiclink のハンドラのうち
+// /webauthn/ 配下で発行されたセッションは method によらず authsession.MethodPasskey にする。
+// magiclink にはログイン完了のフックが無いため、Set-Cookie ヘッダから新しいセッションを検出する。
+// ログイン中のブラウザがログインし直した（本人確認など）場合は、それまでのセッションを消す。
+func RecordSessionDetails(cookieName string, dbConn *sql.DB, method string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+
+			method := method
+			if strings.HasPrefix(r.URL.Path, "/webauthn/") {
+				method = authsession.MethodPasskey
+			}
+
+			for _, hash := range issuedSessions(w.Header(), cookieName) {
+				if err := authsession.ReplaceSession(r.Context(), dbConn, authsession.CurrentHash(r, cookieName), hash); err != nil {
+					logger.Error("再ログイン前のセッションの削除に失敗", "error", err)
+				}
+				if err := authsession.RecordLogin(r.Context(), dbConn, hash,
+					authsession.ClientIP(r), r.UserAgent(), method); err != nil {
+					logger.Error("セッション情報の記録に失敗", "error", err)
+				}
+			}
+		})
+	}
+}
+
+// issuedSessions は応答の Set-Cookie で発行された（消すのではない）セッションのハッシュを返す。
+func issuedSessions(h http.Header, cookieName string) []string {
+	var hashes []string
+	for _, line := range h.Values("Set-Cookie") {
+		c, err := http.ParseSetCookie(line)
+		if err != nil || c.Name != cookieName || c.Value == "" || c.MaxAge < 0 {
+			continue
+		}
+		hashes = append(hashes, authsession.HashToken(c.Value))
+	}
+	return hashes
+}
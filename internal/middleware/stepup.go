@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/apitoken"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+)
+
+// StepUpMaxAge は破壊的な管理操作（ユーザーの削除・管理者相当のロールへの変更・メンテナンスの
+// 切替）に求める「最近のログイン」の猶予。セッションのログインがこれより前なら、パスキー
+// （登録が無ければマジックリンク）でログインし直して本人確認してもらう。
+// パスキーを登録しているユーザーは、マジックリンクや SSO でログインし直しても確認にならない。
+const StepUpMaxAge = 10 * time.Minute
+
+// StepUpMessage は本人確認を求めるときのメッセージ。
+const StepUpMessage = "この操作には本人確認が必要です。パスキーまたはメールのリンクでもう一度ログインしてください"
+
+// StepUpTokenMessage は API トークンで本人確認が必要な操作をしようとしたときのメッセージ。
+// トークンにはログインし直すという確認の手段が無いため、これらの操作はセッションでだけ行える。
+const StepUpTokenMessage = "この操作は API トークンでは実行できません。ログインした画面（または Cookie のセッション）から本人確認して実行してください"
+
+// RecentlyAuthenticated はこのリクエストのセッションが StepUpMaxAge 以内にログインしたものかを返す。
+// パスキーを登録しているユーザーは、そのログインがパスキーでなければ false。
+// API トークンでの認証など、セッションが無ければ false。
+func RecentlyAuthenticated(ctx context.Context) bool {
+	at := appcontext.GetAuthenticatedAt(ctx)
+	if at.IsZero() || time.Since(at) > StepUpMaxAge {
+		return false
+	}
+	_, _, hasPasskey := appcontext.GetUser(ctx)
+	return !hasPasskey || appcontext.GetAuthMethod(ctx) == authsession.MethodPasskey
+}
+
+// RequireRecentAuth はセッションのログインが StepUpMaxAge より前なら本人確認を求めるミドルウェア
+// （RejectStepUp を参照）。UserContextMiddleware の内側で使う。API トークンのリクエストは
+// セッションが無いので常に断る。
+func RequireRecentAuth(rejected http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if RecentlyAuthenticated(r.Context()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			RejectStepUp(w, r, rejected)
+		})
+	}
+}
+
+// AuthenticatedByToken はリクエストが API トークン（Authorization: Bearer）で認証されるかを返す
+// （UserContextMiddleware は Bearer ヘッダがあれば Cookie を見ない）。
+func AuthenticatedByToken(r *http.Request) bool {
+	_, ok := apitoken.FromRequest(r)
+	return ok
+}
+
+// RejectStepUp は本人確認が必要なことを返す。リクエストの中身を見て判断するハンドラ
+// （管理者相当のロールへの変更など）は RequireRecentAuth の代わりにこれを呼ぶ。
+//
+// Datastar のリクエストには rejected を返す（rejected は本人確認のダイアログを開き、確認できたら
+// 同じ操作をやり直すこと）。JSON API と Accept: application/json は 403 の JSON、それ以外は 403 のテキスト。
+// API トークン（Bearer）のリクエストは常に 403 の JSON（StepUpTokenMessage）で断る。
+func RejectStepUp(w http.ResponseWriter, r *http.Request, rejected http.Handler) {
+	if AuthenticatedByToken(r) {
+		writeJSONError(w, http.StatusForbidden, "reauthentication_required", StepUpTokenMessage)
+		return
+	}
+	if r.Header.Get("Datastar-Request") == "true" {
+		rejected.ServeHTTP(w, r)
+		return
+	}
+	if wantsJSON(r) {
+		writeJSONError(w, http.StatusForbidden, "reauthentication_required", StepUpMessage)
+		return
+	}
+	http.Error(w, StepUpMessage, http.StatusForbidden)
+}
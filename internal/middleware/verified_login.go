@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+)
+
+// AllowVerifiedLogin は magiclink のハンドラ（/auth/verify, /webauthn/login/finish など）を包み、
+// 新しいセッションを発行したら、そのメールアドレスで allow（loginpolicy.AllowVerifiedLogin）を呼ぶ。
+// リンクを開いて本人と確かめるまでサインアップ（ユーザーの作成・承認待ちへの追加）をしないためのもの。
+// magiclink にはログイン完了のフックが無いため、応答をいったん溜めて Set-Cookie から検出する。
+//
+// allow が error を返したら、発行したセッションを消し、Cookie を返さずに loginURL へ
+// error_description 付きでリダイレクトする（ログイン画面がそのまま表示する）。
+func AllowVerifiedLogin(cookieName, loginURL string, dbConn *sql.DB, allow func(ctx context.Context, email string) error) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			buf := &bufferedResponse{header: http.Header{}}
+			next.ServeHTTP(buf, r)
+
+			for _, hash := range issuedSessions(buf.header, cookieName) {
+				email, err := authsession.SessionEmail(r.Context(), dbConn, hash)
+				if err != nil {
+					logger.Error("発行したセッションの取得に失敗", "error", err)
+					continue
+				}
+				if err := allow(r.Context(), email); err != nil {
+					if err := authsession.Discard(r.Context(), dbConn, hash); err != nil {
+						logger.Error("認めなかったセッションの削除に失敗", "error", err, "email", email)
+					}
+					q := url.Values{"error": {"login_denied"}, "error_description": {err.Error()}}
+					http.Redirect(w, r, loginURL+"?"+q.Encode(), http.StatusFound)
+					return
+				}
+			}
+			buf.flush(w)
+		})
+	}
+}
+
+// bufferedResponse は応答を溜めておく ResponseWriter（AllowVerifiedLogin が差し替えられるように）。
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// flush は溜めた応答を w に書き出す。
+func (b *bufferedResponse) flush(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	if b.status != 0 {
+		w.WriteHeader(b.status)
+	}
+	_, _ = w.Write(b.body.Bytes())
+}
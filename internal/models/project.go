@@ -0,0 +1,39 @@
+package models
+
+import (
+	"slices"
+	"strings"
+)
+
+// プロジェクトの状態。projects.status カラムに保存される値と一致する。
+const (
+	ProjectStatusPlanned = "planned"
+	ProjectStatusActive  = "active"
+	ProjectStatusOnHold  = "on_hold"
+	ProjectStatusDone    = "done"
+)
+
+// ProjectStatuses はプロジェクトの状態の一覧（表示順）。
+var ProjectStatuses = []string{ProjectStatusPlanned, ProjectStatusActive, ProjectStatusOnHold, ProjectStatusDone}
+
+// IsValidProjectStatus は s が有効なプロジェクトの状態かを返す。
+func IsValidProjectStatus(s string) bool {
+	return slices.Contains(ProjectStatuses, s)
+}
+
+// ParseProjectTags は projects.tags（カンマ区切り）をタグの一覧にする。
+func ParseProjectTags(s string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// EncodeProjectTags は projects.tags に保存する形（カンマ区切り）にする。タグにカンマを
+// 含めないこと（handlers の検証で断る）。
+func EncodeProjectTags(tags []string) string {
+	return strings.Join(tags, ",")
+}
@@ -0,0 +1,288 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// clockSkew は exp / iat の検証で許す IdP との時計のずれ。
+const clockSkew = time.Minute
+
+// jwksRefreshInterval は未知の kid を見たときに JWKS を取り直す最短の間隔
+// （不正なトークンを送り付けられて IdP へ問い合わせが殺到しないように）。
+const jwksRefreshInterval = time.Minute
+
+// Claims は ID トークンのクレーム。
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Expiry          float64  `json:"exp"`
+	IssuedAt        float64  `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Email           string   `json:"email"`
+	EmailVerified   any      `json:"email_verified"`
+	Name            string   `json:"name"`
+
+	raw map[string]any
+}
+
+// Verified は email_verified が真かを返す（文字列の "true" を返す IdP もある）。
+func (c *Claims) Verified() bool {
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// Strings は name のクレームを文字列の一覧として返す（文字列 1 つでも配列でもよい）。
+func (c *Claims) Strings(name string) []string {
+	switch v := c.raw[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// audience は aud クレーム（文字列 1 つか配列）。
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// verifyIDToken は ID トークンの署名と iss / aud / azp / exp / iat / nonce を検証する
+// （OIDC Core 3.1.3.7）。
+func (p *Provider) verifyIDToken(ctx context.Context, m *metadata, raw, nonce string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed id_token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("oidc: id_token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("oidc: id_token signature: %w", err)
+	}
+	key, err := p.key(ctx, m, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("oidc: id_token claims: %w", err)
+	}
+	if err := decodeSegment(parts[1], &c.raw); err != nil {
+		return nil, fmt.Errorf("oidc: id_token claims: %w", err)
+	}
+
+	now := time.Now()
+	switch {
+	case c.Issuer != m.Issuer:
+		return nil, fmt.Errorf("oidc: id_token issuer %q is not %q", c.Issuer, m.Issuer)
+	case !slices.Contains(c.Audience, p.cfg.ClientID):
+		return nil, errors.New("oidc: id_token audience does not include client_id")
+	case len(c.Audience) > 1 && c.AuthorizedParty != p.cfg.ClientID:
+		return nil, errors.New("oidc: id_token azp is not client_id")
+	case c.Expiry == 0 || now.After(unixTime(c.Expiry).Add(clockSkew)):
+		return nil, errors.New("oidc: id_token has expired")
+	case unixTime(c.IssuedAt).After(now.Add(clockSkew)):
+		return nil, errors.New("oidc: id_token was issued in the future")
+	case c.Nonce == "" || c.Nonce != nonce:
+		return nil, errors.New("oidc: id_token nonce does not match")
+	case c.Subject == "":
+		return nil, errors.New("oidc: id_token has no subject")
+	}
+	return &c, nil
+}
+
+func unixTime(sec float64) time.Time {
+	return time.Unix(int64(sec), 0)
+}
+
+func decodeSegment(seg string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("oidc: RS256 token signed with a non-RSA key")
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("oidc: id_token signature: %w", err)
+		}
+		return nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return errors.New("oidc: invalid ES256 signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return errors.New("oidc: id_token signature: verification failed")
+		}
+		return nil
+	}
+	// "none" や HS256（client_secret を鍵にする）は受け付けない。
+	return fmt.Errorf("oidc: unsupported id_token alg %q", alg)
+}
+
+// keySet は取得済みの JWKS。
+type keySet struct {
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// key は kid の公開鍵を返す。知らない kid なら（IdP の鍵のローテーションに備えて）JWKS を取り直す。
+func (p *Provider) key(ctx context.Context, m *metadata, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keys != nil {
+		if k, ok := p.keys.lookup(kid); ok {
+			return k, nil
+		}
+		if time.Since(p.keys.fetchedAt) < jwksRefreshInterval {
+			return nil, fmt.Errorf("oidc: unknown key id %q", kid)
+		}
+	}
+	ks, err := p.fetchKeys(ctx, m.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = ks
+	if k, ok := ks.lookup(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown key id %q", kid)
+}
+
+// lookup は kid の鍵を返す。kid が無いトークンは、鍵が 1 つだけのときに限りそれを使う。
+func (ks *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, k := range ks.keys {
+			return k, true
+		}
+	}
+	k, ok := ks.keys[kid]
+	return k, ok
+}
+
+// jwk は JWKS の 1 件（RSA と P-256 の EC 鍵だけを読む）。
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *Provider) fetchKeys(ctx context.Context, uri string) (*keySet, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, uri, &doc); err != nil {
+		return nil, fmt.Errorf("oidc: jwks: %w", err)
+	}
+	ks := &keySet{keys: map[string]crypto.PublicKey{}, fetchedAt: time.Now()}
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue // 対応していない種類の鍵は読み飛ばす
+		}
+		ks.keys[k.Kid] = pub
+	}
+	return ks, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 point")
+		}
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
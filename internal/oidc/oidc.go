@@ -0,0 +1,290 @@
+// Package oidc は OpenID Connect の認可コードフロー（PKCE 付き）で社内 IdP にログインさせる。
+//
+// 外部ライブラリは使わず、必要な部分（ディスカバリ、認可 URL、トークン交換、ID トークンの
+// 署名と各クレームの検証、JWKS の取得）だけを実装している。署名は RS256 と ES256 に対応する。
+// セッションの発行やログイン可否の判定はこのパッケージでは行わない（handlers.OIDCHandler）。
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// CallbackPath は IdP から戻ってくるパス。IdP にはリダイレクト URI として
+// SERVER_ADDR + CallbackPath を登録する。
+const CallbackPath = "/auth/oidc/callback"
+
+// LoginPath は IdP へのログインを始めるパス。
+const LoginPath = "/auth/oidc/login"
+
+// Config は OIDC の設定。Issuer が空なら OIDC ログインは無効。
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL は CallbackPath の絶対 URL。
+	RedirectURL string
+	// ProviderName はログイン画面のボタンに出す IdP の名前。
+	ProviderName string
+	// AllowedDomains は OIDC でログインできるメールアドレスのドメイン（小文字・"@" 無し）。
+	// 空ならドメインでは絞らず、登録済みのユーザーだけがログインできる。
+	// 指定したドメインの未登録ユーザーは初回ログイン時に自動登録する。
+	AllowedDomains []string
+	// RoleClaim は ID トークンでロールを表すクレーム名（例: groups）。空ならロールを同期しない。
+	RoleClaim string
+	// RoleMap は RoleClaim の値からロール（組み込みロールかカスタムロール）への対応。
+	RoleMap map[string]string
+	// HTTPClient は IdP への通信に使う（nil なら 10 秒タイムアウトの既定クライアント）。
+	HTTPClient *http.Client
+}
+
+// Enabled は OIDC ログインが設定されているかを返す。
+func (c Config) Enabled() bool { return c.Issuer != "" }
+
+// ParseDomains はカンマ区切りのドメイン一覧を小文字・"@" 無しにそろえる。
+func ParseDomains(s string) []string {
+	var domains []string
+	for _, d := range strings.Split(s, ",") {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@"))
+		if d != "" && !slices.Contains(domains, d) {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// ParseRoleMap は "idp-admins=admin,staff=editor" の形のロール対応を読む。
+// 右辺は有効なロール（roles.IsValid）でなければならない。
+func ParseRoleMap(s string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		value, role, ok := strings.Cut(pair, "=")
+		value, role = strings.TrimSpace(value), strings.TrimSpace(role)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("oidc: role map entry %q must be claim-value=role", pair)
+		}
+		if !roles.IsValid(role) {
+			return nil, fmt.Errorf("oidc: unknown role %q in role map", role)
+		}
+		m[value] = role
+	}
+	return m, nil
+}
+
+// Provider は 1 つの IdP。ディスカバリと JWKS は初回に取得してキャッシュする
+// （起動時に IdP が落ちていてもサーバーは起動できるように）。
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu   sync.Mutex
+	meta *metadata
+	keys *keySet
+}
+
+// metadata は /.well-known/openid-configuration のうち使う項目。
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// New は cfg の IdP を返す。IdP への通信は最初のログイン時まで行わない。
+func New(cfg Config) *Provider {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{cfg: cfg, client: client}
+}
+
+// Config は設定を返す。
+func (p *Provider) Config() Config { return p.cfg }
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+	var m metadata
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	// 別の IdP の設定を掴まされないよう、issuer は設定と完全一致を要求する（OIDC Discovery 4.3）。
+	if m.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", m.Issuer, p.cfg.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+	p.meta = &m
+	return p.meta, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(dst)
+}
+
+// AuthRequest は認可リクエスト 1 回分の使い捨ての値。ブラウザに預け、コールバックで照合する。
+type AuthRequest struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+}
+
+// NewAuthRequest は state・nonce・PKCE の code_verifier を作る。
+func NewAuthRequest() (AuthRequest, error) {
+	var vals [3]string
+	for i := range vals {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return AuthRequest{}, err
+		}
+		vals[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return AuthRequest{State: vals[0], Nonce: vals[1], Verifier: vals[2]}, nil
+}
+
+// AuthCodeURL は IdP の認可エンドポイントへの URL を返す。
+func (p *Provider) AuthCodeURL(ctx context.Context, ar AuthRequest) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(ar.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {ar.State},
+		"nonce":                 {ar.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// tokenResponse はトークンエンドポイントの応答のうち使う項目。
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange は認可コードをトークンに交換し、ID トークンを検証してクレームを返す。
+func (p *Provider) Exchange(ctx context.Context, code string, ar AuthRequest) (*Claims, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {ar.Verifier},
+		"client_id":     {p.cfg.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		// client_secret_basic（RFC 6749 2.3.1 のとおり URL エンコードしてから Basic にする）。
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request: %w", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	var tr tokenResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&tr); err != nil {
+		return nil, fmt.Errorf("oidc: token response (%s): %w", res.Status, err)
+	}
+	if res.StatusCode != http.StatusOK || tr.Error != "" {
+		return nil, fmt.Errorf("oidc: token request: %s %s %s", res.Status, tr.Error, tr.ErrorDescription)
+	}
+	if tr.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+	return p.verifyIDToken(ctx, m, tr.IDToken, ar.Nonce)
+}
+
+// AllowsEmail は email が AllowedDomains のいずれかのドメインかを返す（未指定なら常に true）。
+func (p *Provider) AllowsEmail(email string) bool {
+	if len(p.cfg.AllowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return false
+	}
+	return slices.Contains(p.cfg.AllowedDomains, strings.ToLower(email[at+1:]))
+}
+
+// Provisions は未登録のユーザーを初回ログインで自動登録するか（AllowedDomains 指定時のみ）。
+func (p *Provider) Provisions() bool { return len(p.cfg.AllowedDomains) > 0 }
+
+// RoleFor は RoleClaim の値を RoleMap で引き、対応するロールを返す。値が複数あれば
+// 最も権限の多いもの（同数なら roles.Names の後ろのもの）。対応が無いか、対応先の
+// カスタムロールが削除されていれば ""（ロールを変えない）。
+func (p *Provider) RoleFor(c *Claims) string {
+	if p.cfg.RoleClaim == "" {
+		return ""
+	}
+	// 権限の数で比べ、同数なら Names の順で比べる。
+	names := roles.Names()
+	strength := func(role string) int {
+		return len(roles.PermissionsOf(role))*len(names) + slices.Index(names, role)
+	}
+	best := ""
+	for _, v := range c.Strings(p.cfg.RoleClaim) {
+		role, ok := p.cfg.RoleMap[v]
+		if !ok || !roles.IsValid(role) {
+			continue
+		}
+		if best == "" || strength(role) > strength(best) {
+			best = role
+		}
+	}
+	return best
+}
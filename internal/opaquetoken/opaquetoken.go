@@ -0,0 +1,65 @@
+// Package opaquetoken は、平文を利用者に渡して DB にはハッシュだけを保存するトークン
+// （招待リンク・API トークン）の生成とハッシュ化、最終使用日時の書き込み間引きをまとめる。
+package opaquetoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// New は prefix 付きのランダムなトークン（32 バイトを base64url）を作る。
+func New(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("opaquetoken: generate token: %w", err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Hash はトークンを DB に保存するハッシュ（SHA-256 hex）にする。magiclink のセッションと同じ形式。
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Throttle は最終使用日時・最終アクセス時刻の書き込みを、キーごとに Interval に 1 回までに間引く。
+// 連続アクセスのたびに UPDATE して SQLite の書き込みロックを取り合わないようにする（プロセス内）。
+// Interval を過ぎたキーは覚えていても意味が無いので、Interval ごとにまとめて捨てる
+// （覚えているのは直近 2 Interval に使われたキーだけになる）。
+type Throttle struct {
+	Interval time.Duration
+
+	mu        sync.Mutex
+	last      map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewThrottle は interval ごとに 1 回だけ書き込ませる Throttle を作る。
+func NewThrottle(interval time.Duration) *Throttle {
+	return &Throttle{Interval: interval, last: map[string]time.Time{}}
+}
+
+// Allow は key を now に書き込んでよいか（前回から Interval 以上たったか）を返し、
+// よければ now を前回として覚える。
+func (t *Throttle) Allow(key string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last, ok := t.last[key]; ok && now.Sub(last) < t.Interval {
+		return false
+	}
+	t.last[key] = now
+	if now.Sub(t.lastSweep) >= t.Interval {
+		for k, last := range t.last {
+			if now.Sub(last) >= t.Interval {
+				delete(t.last, k)
+			}
+		}
+		t.lastSweep = now
+	}
+	return true
+}
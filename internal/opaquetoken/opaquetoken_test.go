@@ -0,0 +1,43 @@
+package opaquetoken
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	th := NewThrottle(time.Minute)
+	now := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+
+	if !th.Allow("a", now) {
+		t.Fatal("初回は書き込ませる")
+	}
+	if th.Allow("a", now.Add(59*time.Second)) {
+		t.Error("間隔内の 2 回目は書き込ませない")
+	}
+	if !th.Allow("b", now.Add(59*time.Second)) {
+		t.Error("別のキーは間隔内でも書き込ませる")
+	}
+	if !th.Allow("a", now.Add(time.Minute)) {
+		t.Error("間隔を過ぎたら書き込ませる")
+	}
+}
+
+// 使われなくなったキーは捨て、覚えておくのは直近のキーだけ（セッション・トークンの数だけ増え続けない）。
+func TestThrottle_EvictsStaleKeys(t *testing.T) {
+	th := NewThrottle(time.Minute)
+	now := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+
+	for i := range 1000 {
+		th.Allow(fmt.Sprintf("old-%d", i), now)
+	}
+	later := now.Add(2 * time.Minute)
+	th.Allow("new", later)
+	if len(th.last) != 1 {
+		t.Errorf("覚えているキー = %d, want 1", len(th.last))
+	}
+	if !th.Allow("old-0", later) {
+		t.Error("捨てたキーは次のアクセスで書き込ませる")
+	}
+}
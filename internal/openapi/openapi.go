@@ -0,0 +1,336 @@
+// Package openapi は chi のルート登録から OpenAPI 3.1 のドキュメントを組み立てる。
+//
+// メソッドとパス、RequireRole で要求されるロールはルーター自体（chi.Walk）から読み取り、
+// 要約・リクエストの signals 構造体・レスポンスの種類など、ルーターから分からない情報は
+// 呼び出し側が Operation の表で渡す。表に無いルートは Build が missing として返すので、
+// ルートを足したのに表を更新し忘れるとテストで気付ける。
+package openapi
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// レスポンスのメディアタイプ。
+const (
+	MediaHTML = "text/html"
+	MediaSSE  = "text/event-stream"
+	MediaJSON = "application/json"
+	MediaText = "text/plain"
+	MediaXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// AnyMethod は Handle で全メソッドに登録したルート（/auth/* などのマウント）のキーに使う。
+const AnyMethod = "*"
+
+// Operation は 1 ルート（メソッド + パス）の説明。
+type Operation struct {
+	Summary string
+	Tag     string
+	// Public はログイン不要のルート。
+	Public bool
+	// Signals は Datastar の signals 構造体。GET/DELETE は ?datastar= のクエリ、
+	// それ以外はリクエスト body の JSON で送る（datastar.ReadSignals と同じ）。
+	Signals any
+	// Body は JSON API のリクエスト body の構造体。
+	Body any
+	// Form はフォーム送信の項目（Multipart なら multipart/form-data、でなければ urlencoded）。
+	Form      []Param
+	Multipart bool
+	// Query はクエリパラメータ。
+	Query []Param
+	// Status は成功時のステータス（0 なら 200）。
+	Status int
+	// Media は成功時のレスポンスのメディアタイプ（204・リダイレクトでは空）。
+	Media string
+	// Result は Media が JSON のときのレスポンスの構造体。
+	Result any
+}
+
+// Param はクエリ・フォームの 1 項目。
+type Param struct {
+	Name        string
+	Description string
+	// File はファイルのアップロード項目。
+	File bool
+}
+
+// Key は Operation の表のキー（"GET /projects/{id}" の形）。
+func Key(method, pattern string) string {
+	return method + " " + pattern
+}
+
+// Config はドキュメントの見出しと、ルーターから読み取れない情報の取り出し方。
+type Config struct {
+	Title   string
+	Version string
+	// CookieName はセッション Cookie の名前（securitySchemes に載せる）。
+	CookieName string
+	// RequiredRoles はミドルウェアがロールを要求するものなら、通すロールを返す。
+	RequiredRoles func(func(http.Handler) http.Handler) ([]string, bool)
+}
+
+// Document は OpenAPI 3.1 のドキュメント（このアプリで使う部分だけ）。
+type Document struct {
+	OpenAPI    string                       `json:"openapi"`
+	Info       docInfo                      `json:"info"`
+	Paths      map[string]map[string]*docOp `json:"paths"`
+	Components docComponents                `json:"components"`
+	Mounts     []docMount                   `json:"x-mounts,omitempty"`
+}
+
+type docInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type docComponents struct {
+	SecuritySchemes map[string]map[string]string `json:"securitySchemes"`
+}
+
+type docOp struct {
+	Summary       string                  `json:"summary,omitempty"`
+	Tags          []string                `json:"tags,omitempty"`
+	OperationID   string                  `json:"operationId"`
+	Parameters    []docParam              `json:"parameters,omitempty"`
+	RequestBody   *docBody                `json:"requestBody,omitempty"`
+	Responses     map[string]*docResponse `json:"responses"`
+	Security      []map[string][]string   `json:"security"`
+	RequiredRoles []string                `json:"x-required-roles,omitempty"`
+}
+
+type docParam struct {
+	Name        string              `json:"name"`
+	In          string              `json:"in"`
+	Description string              `json:"description,omitempty"`
+	Required    bool                `json:"required,omitempty"`
+	Schema      Schema              `json:"schema,omitempty"`
+	Content     map[string]docMedia `json:"content,omitempty"`
+}
+
+type docBody struct {
+	Content map[string]docMedia `json:"content"`
+}
+
+type docMedia struct {
+	Schema Schema `json:"schema,omitempty"`
+}
+
+type docResponse struct {
+	Description string              `json:"description"`
+	Content     map[string]docMedia `json:"content,omitempty"`
+}
+
+// docMount は Handle で丸ごと委譲しているパス（中のルートは委譲先が決める）。
+type docMount struct {
+	Path    string `json:"path"`
+	Summary string `json:"summary,omitempty"`
+	Public  bool   `json:"public"`
+}
+
+// allMethods は chi が Handle で登録するメソッド。全部そろっていればマウントとみなす。
+var allMethods = []string{
+	http.MethodConnect, http.MethodDelete, http.MethodGet, http.MethodHead, http.MethodOptions,
+	http.MethodPatch, http.MethodPost, http.MethodPut, http.MethodTrace,
+}
+
+// walkedRoute は chi.Walk で見つけた 1 パターン分のルート。
+type walkedRoute struct {
+	methods []string
+	// roles は各メソッドで要求されるロール（要求が無ければ nil）。
+	roles map[string][]string
+}
+
+// Build は routes に登録されたルートと ops の表からドキュメントを組み立てる。
+// missing は表に無いルートのキー（ソート済み）。表に無いルートもドキュメントには載せる。
+func Build(routes chi.Routes, ops map[string]Operation, cfg Config) (*Document, []string, error) {
+	walked := map[string]*walkedRoute{}
+	err := chi.Walk(routes, func(method, route string, _ http.Handler, mws ...func(http.Handler) http.Handler) error {
+		wr := walked[route]
+		if wr == nil {
+			wr = &walkedRoute{roles: map[string][]string{}}
+			walked[route] = wr
+		}
+		wr.methods = append(wr.methods, method)
+		wr.roles[method] = requiredRoles(mws, cfg.RequiredRoles)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc := &Document{
+		OpenAPI: "3.1.0",
+		Info:    docInfo{Title: cfg.Title, Version: cfg.Version},
+		Paths:   map[string]map[string]*docOp{},
+		Components: docComponents{SecuritySchemes: map[string]map[string]string{
+			"cookieAuth": {"type": "apiKey", "in": "cookie", "name": cfg.CookieName},
+			"bearerAuth": {"type": "http", "scheme": "bearer"},
+		}},
+	}
+	var missing []string
+
+	patterns := make([]string, 0, len(walked))
+	for p := range walked {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		wr := walked[pattern]
+		if isMount(wr.methods) {
+			op, ok := ops[Key(AnyMethod, pattern)]
+			if !ok {
+				missing = append(missing, Key(AnyMethod, pattern))
+			}
+			doc.Mounts = append(doc.Mounts, docMount{Path: pattern, Summary: op.Summary, Public: op.Public})
+			continue
+		}
+		path := openAPIPath(pattern)
+		for _, method := range wr.methods {
+			op, ok := ops[Key(method, pattern)]
+			if !ok {
+				missing = append(missing, Key(method, pattern))
+			}
+			if doc.Paths[path] == nil {
+				doc.Paths[path] = map[string]*docOp{}
+			}
+			doc.Paths[path][strings.ToLower(method)] = buildOp(method, pattern, op, wr.roles[method])
+		}
+	}
+	sort.Strings(missing)
+	return doc, missing, nil
+}
+
+func isMount(methods []string) bool {
+	for _, m := range allMethods {
+		if !slices.Contains(methods, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// requiredRoles はミドルウェアの連なりで要求されるロールを返す。RequireRole が
+// 重なっていれば、すべてを通るロール（共通部分）になる。
+func requiredRoles(mws []func(http.Handler) http.Handler, rolesOf func(func(http.Handler) http.Handler) ([]string, bool)) []string {
+	if rolesOf == nil {
+		return nil
+	}
+	var result []string
+	found := false
+	for _, mw := range mws {
+		allowed, ok := rolesOf(mw)
+		if !ok {
+			continue
+		}
+		if !found {
+			result, found = slices.Clone(allowed), true
+			continue
+		}
+		result = slices.DeleteFunc(result, func(r string) bool { return !slices.Contains(allowed, r) })
+	}
+	return result
+}
+
+// chiParam は chi のパスパラメータ（{id} や {id:[0-9]+}）。
+var chiParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// openAPIPath は chi のパターンを OpenAPI のパスにする（正規表現の制約を落とす）。
+func openAPIPath(pattern string) string {
+	return chiParam.ReplaceAllString(pattern, "{$1}")
+}
+
+// operationID は "GET /api/v1/projects/{id}" を "get_api_v1_projects_id" にする。
+func operationID(method, pattern string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(openAPIPath(pattern), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		b.WriteString("_" + strings.ToLower(part))
+	}
+	return b.String()
+}
+
+func buildOp(method, pattern string, op Operation, roles []string) *docOp {
+	d := &docOp{
+		Summary:       op.Summary,
+		OperationID:   operationID(method, pattern),
+		Responses:     map[string]*docResponse{},
+		Security:      []map[string][]string{},
+		RequiredRoles: roles,
+	}
+	if op.Tag != "" {
+		d.Tags = []string{op.Tag}
+	}
+	for _, m := range chiParam.FindAllStringSubmatch(pattern, -1) {
+		d.Parameters = append(d.Parameters, docParam{Name: m[1], In: "path", Required: true, Schema: Schema{"type": "string"}})
+	}
+	for _, q := range op.Query {
+		d.Parameters = append(d.Parameters, docParam{Name: q.Name, In: "query", Description: q.Description, Schema: Schema{"type": "string"}})
+	}
+
+	switch {
+	case op.Signals != nil && (method == http.MethodGet || method == http.MethodDelete):
+		d.Parameters = append(d.Parameters, docParam{
+			Name:        "datastar",
+			In:          "query",
+			Description: "Datastar の signals（JSON）",
+			Content:     map[string]docMedia{MediaJSON: {Schema: SchemaOf(op.Signals)}},
+		})
+	case op.Signals != nil:
+		d.RequestBody = &docBody{Content: map[string]docMedia{MediaJSON: {Schema: SchemaOf(op.Signals)}}}
+	case op.Body != nil:
+		d.RequestBody = &docBody{Content: map[string]docMedia{MediaJSON: {Schema: SchemaOf(op.Body)}}}
+	case len(op.Form) > 0:
+		media := "application/x-www-form-urlencoded"
+		if op.Multipart {
+			media = "multipart/form-data"
+		}
+		d.RequestBody = &docBody{Content: map[string]docMedia{media: {Schema: formSchema(op.Form)}}}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	res := &docResponse{Description: http.StatusText(status)}
+	if op.Media != "" {
+		media := docMedia{}
+		if op.Media == MediaJSON && op.Result != nil {
+			media.Schema = SchemaOf(op.Result)
+		}
+		res.Content = map[string]docMedia{op.Media: media}
+	}
+	d.Responses[strconv.Itoa(status)] = res
+
+	if !op.Public {
+		d.Security = []map[string][]string{{"cookieAuth": {}}, {"bearerAuth": {}}}
+		d.Responses["401"] = &docResponse{Description: "未ログイン（画面はログインページへリダイレクト、/api/v1 は JSON の 401）"}
+	}
+	if len(roles) > 0 {
+		d.Responses["403"] = &docResponse{Description: "ロールが足りない（必要なロール: " + strings.Join(roles, ", ") + "）"}
+	}
+	return d
+}
+
+func formSchema(fields []Param) Schema {
+	props := map[string]any{}
+	for _, f := range fields {
+		s := Schema{"type": "string"}
+		if f.File {
+			s["contentMediaType"] = "application/octet-stream"
+		}
+		if f.Description != "" {
+			s["description"] = f.Description
+		}
+		props[f.Name] = s
+	}
+	return Schema{"type": "object", "properties": props}
+}
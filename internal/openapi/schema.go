@@ -0,0 +1,91 @@
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema は JSON Schema（OpenAPI 3.1 は JSON Schema 2020-12 をそのまま使う）。
+type Schema map[string]any
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// SchemaOf は v の型の JSON Schema を、encoding/json と同じ json タグの解釈で組み立てる。
+// ポインタは null を許す型にする。
+func SchemaOf(v any) Schema {
+	if v == nil {
+		return nil
+	}
+	return schemaOf(reflect.TypeOf(v))
+}
+
+func schemaOf(t reflect.Type) Schema {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+
+	var s Schema
+	switch {
+	case t == timeType:
+		s = Schema{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Bool:
+		s = Schema{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		s = Schema{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		s = Schema{"type": "number"}
+	case t.Kind() == reflect.String:
+		s = Schema{"type": "string"}
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() == reflect.Uint8:
+		s = Schema{"type": "string", "contentEncoding": "base64"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		s = Schema{"type": "array", "items": schemaOf(t.Elem())}
+	case t.Kind() == reflect.Map:
+		s = Schema{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case t.Kind() == reflect.Struct:
+		props := map[string]any{}
+		addFields(t, props)
+		s = Schema{"type": "object", "properties": props}
+	default:
+		// interface など、型から形が決まらないもの。
+		s = Schema{}
+	}
+	if nullable {
+		if typ, ok := s["type"].(string); ok {
+			s["type"] = []string{typ, "null"}
+		}
+	}
+	return s
+}
+
+// addFields は構造体のフィールドを props に足す。タグの無い埋め込み構造体は展開する。
+func addFields(t reflect.Type, props map[string]any) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addFields(ft, props)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaOf(f.Type)
+	}
+}
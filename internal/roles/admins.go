@@ -0,0 +1,38 @@
+package roles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+// ErrNoAdminLeft は、有効な管理者が 1 人もいなくなる変更を表す。誰もユーザーを管理できなく
+// なると画面からは戻せない（戻すには `server promote-admin <email>` を使う）。
+var ErrNoAdminLeft = errors.New("roles: no active administrator would remain")
+
+// IsAdmin はロールが管理者相当（ユーザー管理の権限を持つ）かを返す。
+func IsAdmin(role string) bool {
+	return Has(role, UserManage)
+}
+
+// EnsureAdminRemains は q（変更を書いたトランザクション）で有効な管理者が残っているかを確かめ、
+// 残っていなければ ErrNoAdminLeft を返す（呼び出し元はロールバックすること）。
+// isAdmin はロールの判定で、nil なら IsAdmin。カスタムロールの権限を変えるときは、変更後の
+// 定義で判定する関数を渡す（読み込み済みの定義はコミット後に差し替わるため）。
+func EnsureAdminRemains(ctx context.Context, q *database.Queries, isAdmin func(role string) bool) error {
+	if isAdmin == nil {
+		isAdmin = IsAdmin
+	}
+	counts, err := q.CountActiveUsersGroupByRole(ctx)
+	if err != nil {
+		return fmt.Errorf("roles: count admins: %w", err)
+	}
+	for _, c := range counts {
+		if c.Count > 0 && isAdmin(c.Role) {
+			return nil
+		}
+	}
+	return ErrNoAdminLeft
+}
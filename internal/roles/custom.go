@@ -0,0 +1,197 @@
+package roles
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+// Role はロールの定義。組み込みロール（Viewer / Editor / Admin）はコードで決まっていて
+// 変更・削除できない。カスタムロールは管理画面（/admin/roles）で作り、custom_roles
+// テーブルに保存する。
+type Role struct {
+	Name        string       `json:"name"`
+	Label       string       `json:"label"`
+	Permissions []Permission `json:"permissions"`
+	BuiltIn     bool         `json:"built_in"`
+}
+
+// NameMaxLen / LabelMaxLen はカスタムロールの名前・表示名の最大文字数。
+const (
+	NameMaxLen  = 32
+	LabelMaxLen = 50
+)
+
+// builtInLabels は組み込みロールの表示名。
+var builtInLabels = map[string]string{
+	Viewer: "閲覧者",
+	Editor: "編集者",
+	Admin:  "管理者",
+}
+
+// custom はプロセス内に読み込んだカスタムロール（名前 → 定義）。
+// 起動時と管理画面での変更後に Load で DB から読み直す。
+var (
+	customMu sync.RWMutex
+	custom   = map[string]Role{}
+)
+
+// SetCustom はカスタムロールの一覧を rs に差し替える。組み込みロールと同じ名前は無視する。
+func SetCustom(rs []Role) {
+	m := make(map[string]Role, len(rs))
+	for _, r := range rs {
+		if isBuiltIn(r.Name) {
+			continue
+		}
+		r.BuiltIn = false
+		r.Permissions = slices.Clone(r.Permissions)
+		m[r.Name] = r
+	}
+	customMu.Lock()
+	custom = m
+	customMu.Unlock()
+}
+
+// Load は custom_roles テーブルを読み、カスタムロールを差し替える。
+// 複数プロセスで動かす場合、別プロセスでの変更は再起動するまで反映されない。
+func Load(ctx context.Context, q *database.Queries) error {
+	rows, err := q.ListCustomRoles(ctx)
+	if err != nil {
+		return fmt.Errorf("roles: load custom roles: %w", err)
+	}
+	rs := make([]Role, 0, len(rows))
+	for _, row := range rows {
+		rs = append(rs, FromRow(row))
+	}
+	SetCustom(rs)
+	return nil
+}
+
+// FromRow は custom_roles の行をロールの定義にする。未知の権限（コードから消えたもの）は捨てる。
+func FromRow(row database.CustomRole) Role {
+	r := Role{Name: row.Name, Label: row.Label}
+	for _, p := range strings.Split(row.Permissions, ",") {
+		if p := Permission(strings.TrimSpace(p)); slices.Contains(Permissions, p) {
+			r.Permissions = append(r.Permissions, p)
+		}
+	}
+	return r
+}
+
+// EncodePermissions は custom_roles.permissions に保存する形（カンマ区切り）にする。
+func EncodePermissions(ps []Permission) string {
+	s := make([]string, len(ps))
+	for i, p := range ps {
+		s[i] = string(p)
+	}
+	return strings.Join(s, ",")
+}
+
+// lookupCustom は name のカスタムロールを返す。
+func lookupCustom(name string) (Role, bool) {
+	customMu.RLock()
+	defer customMu.RUnlock()
+	r, ok := custom[name]
+	return r, ok
+}
+
+// List は組み込みロール（All の順）に続けて、カスタムロールを名前順に返す。
+// ロールの選択肢や一覧画面に使う。
+func List() []Role {
+	rs := make([]Role, 0, len(All))
+	for _, name := range All {
+		rs = append(rs, Role{Name: name, Label: builtInLabels[name], Permissions: PermissionsOf(name), BuiltIn: true})
+	}
+	customMu.RLock()
+	cs := make([]Role, 0, len(custom))
+	for _, r := range custom {
+		r.Permissions = slices.Clone(r.Permissions)
+		cs = append(cs, r)
+	}
+	customMu.RUnlock()
+	slices.SortFunc(cs, func(a, b Role) int { return strings.Compare(a.Name, b.Name) })
+	return append(rs, cs...)
+}
+
+// Names は List のロール名だけを返す。
+func Names() []string {
+	rs := List()
+	names := make([]string, len(rs))
+	for i, r := range rs {
+		names[i] = r.Name
+	}
+	return names
+}
+
+// Get は name のロールの定義を返す。
+func Get(name string) (Role, bool) {
+	if isBuiltIn(name) {
+		return Role{Name: name, Label: builtInLabels[name], Permissions: PermissionsOf(name), BuiltIn: true}, true
+	}
+	r, ok := lookupCustom(name)
+	if ok {
+		r.Permissions = slices.Clone(r.Permissions)
+	}
+	return r, ok
+}
+
+// Label は name の表示名を返す（未知のロールは name をそのまま返す）。
+func Label(name string) string {
+	if r, ok := Get(name); ok {
+		return r.Label
+	}
+	return name
+}
+
+// Normalize はカスタムロールの入力を検証し、名前を小文字に、権限を Permissions の順に揃える。
+// 名前は英小文字で始まる英小文字・数字・"-"・"_" で、組み込みロールと同じ名前は使えない。
+// エラーは画面にそのまま出せるメッセージ。
+func Normalize(r Role) (Role, error) {
+	r.Name = strings.ToLower(strings.TrimSpace(r.Name))
+	r.Label = strings.TrimSpace(r.Label)
+	r.BuiltIn = false
+	if r.Name == "" {
+		return r, fmt.Errorf("ロール名は必須です")
+	}
+	if len(r.Name) > NameMaxLen || !validName(r.Name) {
+		return r, fmt.Errorf("ロール名は英小文字で始まる %d 文字以内の英小文字・数字・「-」「_」で入力してください", NameMaxLen)
+	}
+	if isBuiltIn(r.Name) {
+		return r, fmt.Errorf("%s は組み込みロールの名前のため使えません", r.Name)
+	}
+	if r.Label == "" {
+		return r, fmt.Errorf("表示名は必須です")
+	}
+	if len([]rune(r.Label)) > LabelMaxLen {
+		return r, fmt.Errorf("表示名は %d 文字以内で入力してください", LabelMaxLen)
+	}
+	for _, p := range r.Permissions {
+		if !slices.Contains(Permissions, p) {
+			return r, fmt.Errorf("権限の指定が不正です: %s", p)
+		}
+	}
+	var ps []Permission
+	for _, p := range Permissions {
+		if slices.Contains(r.Permissions, p) {
+			ps = append(ps, p)
+		}
+	}
+	r.Permissions = ps
+	return r, nil
+}
+
+func validName(name string) bool {
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '-' || c == '_'):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package roles
+
+import "slices"
+
+// Permission はロールに与える操作の権限。ルートは RequirePermission で、画面のボタンは
+// appcontext.Can で権限を確かめる（ロールの一覧をルートや画面に直接書かない）。
+// 組み込みロールの権限は rolePermissions、カスタムロールの権限は管理画面で決める。
+type Permission string
+
+// 権限定数。"<対象>.<操作>" の形で揃える。
+const (
+	// ProjectWrite はプロジェクトの作成・更新・削除。更新・削除できるのはメンバーとして
+	// 編集者・オーナーのプロジェクトだけ（ProjectAll があれば全プロジェクト）。
+	ProjectWrite Permission = "project.write"
+	// ProjectAll はメンバーでないプロジェクトも含めた全プロジェクトへのアクセス。
+	// 持っていればどのプロジェクトでもオーナーと同じ扱いになる。
+	ProjectAll Permission = "project.all"
+	// UserManage はユーザーの追加・編集・削除・一括インポート、招待、サインアップの承認と設定、ゴミ箱。
+	UserManage Permission = "user.manage"
+	// UserImpersonate は他のユーザーとして画面を表示する（表示中の書き込みはすべて断られる）。
+	UserImpersonate Permission = "user.impersonate"
+	// LogsRead はアクセスログと監査ログの閲覧。
+	LogsRead Permission = "logs.read"
+	// MaintenanceToggle はメンテナンスモード・予定・読み取り専用モードの切替。
+	// メンテナンス中も解除のために利用・ログインできる。
+	MaintenanceToggle Permission = "maintenance.toggle"
+	// APITokenManage は全ユーザーの API トークンの一覧と失効（自分のトークンは誰でも管理できる）。
+	APITokenManage Permission = "api_token.manage"
+	// APIDocsRead は API エクスプローラの閲覧。
+	APIDocsRead Permission = "api_docs.read"
+	// RoleManage はカスタムロールの作成・変更・削除。
+	RoleManage Permission = "role.manage"
+)
+
+// Permissions は権限の一覧（表示順）。
+var Permissions = []Permission{ProjectWrite, ProjectAll, UserManage, UserImpersonate, LogsRead, MaintenanceToggle, APITokenManage, APIDocsRead, RoleManage}
+
+// rolePermissions は組み込みロールごとの権限。
+var rolePermissions = map[string][]Permission{
+	Viewer: {},
+	Editor: {ProjectWrite},
+	Admin:  Permissions,
+}
+
+// Has は role が p の権限を持つかを返す。未知のロールは何の権限も持たない。
+func Has(role string, p Permission) bool {
+	return slices.Contains(permissionsOf(role), p)
+}
+
+// PermissionsOf は role の権限の一覧を返す。
+func PermissionsOf(role string) []Permission {
+	return slices.Clone(permissionsOf(role))
+}
+
+func permissionsOf(role string) []Permission {
+	if ps, ok := rolePermissions[role]; ok {
+		return ps
+	}
+	r, _ := lookupCustom(role)
+	return r.Permissions
+}
+
+// With は p の権限を持つロールの一覧を返す（Names の順）。
+func With(p Permission) []string {
+	var rs []string
+	for _, r := range Names() {
+		if Has(r, p) {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
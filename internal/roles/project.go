@@ -0,0 +1,30 @@
+package roles
+
+import "slices"
+
+// プロジェクトロール定数。project_members.role カラムに保存される値と一致する。
+// ユーザーのロール（Admin / Editor / Viewer）とは別で、プロジェクトごとにメンバーへ与える。
+const (
+	ProjectOwner  = "owner"
+	ProjectEditor = "editor"
+	ProjectViewer = "viewer"
+)
+
+// ProjectRoles はプロジェクトロールの一覧。権限の弱い順（表示順）に並べる。
+var ProjectRoles = []string{ProjectViewer, ProjectEditor, ProjectOwner}
+
+// IsValidProjectRole は r が有効なプロジェクトロールかを返す。
+func IsValidProjectRole(r string) bool {
+	return slices.Contains(ProjectRoles, r)
+}
+
+// ProjectCanWrite はプロジェクトロール r でプロジェクトを更新できるかを返す。
+// 更新にはユーザーのロールの ProjectWrite も要る（ルート側で判定する）。
+func ProjectCanWrite(r string) bool {
+	return r == ProjectEditor || r == ProjectOwner
+}
+
+// ProjectCanManage はプロジェクトロール r でプロジェクトの削除とメンバー管理ができるかを返す。
+func ProjectCanManage(r string) bool {
+	return r == ProjectOwner
+}
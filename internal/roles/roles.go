@@ -1,5 +1,8 @@
 // Package roles はユーザーのロール定数と検証を一元管理する。
 //
+// 組み込みロール（admin / editor / viewer）に加えて、管理者が画面から作る
+// カスタムロール（custom.go）がある。どちらも IsValid・Has で同じように扱える。
+//
 // ロール文字列 ("admin" / "editor" / "viewer") をコード中に直接書かず、
 // 必ずこのパッケージの定数を使うこと。文字列リテラルの混入は
 // `make check-roles` で検出される（CLAUDE.md のコード規約も参照）。
@@ -12,12 +15,25 @@ const (
 	Viewer = "viewer"
 )
 
-// All は有効なロールの一覧。UI のセレクトボックスや一括検証で使う。
-// 権限の弱い順（表示順）に並べる。
+// All は組み込みロールの一覧。権限の弱い順（表示順）に並べる。
+// カスタムロールを含めた一覧は List / Names を使う。
 var All = []string{Viewer, Editor, Admin}
 
-// IsValid は r が有効なロール文字列かを返す。
+// IsValid は r が有効なロール文字列（組み込みロールか、読み込み済みのカスタムロール）かを返す。
 func IsValid(r string) bool {
+	if isBuiltIn(r) {
+		return true
+	}
+	_, ok := lookupCustom(r)
+	return ok
+}
+
+// IsBuiltIn は r が組み込みロールかを返す（組み込みロールは変更・削除できない）。
+func IsBuiltIn(r string) bool {
+	return isBuiltIn(r)
+}
+
+func isBuiltIn(r string) bool {
 	switch r {
 	case Admin, Editor, Viewer:
 		return true
@@ -1,27 +1,45 @@
 package routes
 
 import (
+	"database/sql"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/handlers"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
 	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
 )
 
 // RegisterAdminRoutes は管理者用ルートを登録する。
-func RegisterAdminRoutes(r chi.Router, queries *database.Queries, authMW func(http.Handler) http.Handler, accessLogStore *appMiddleware.AccessLogStore) {
+// mcache はメンテナンスのミドルウェアと共有する状態キャッシュ（切替時に捨てる）。
+func RegisterAdminRoutes(r chi.Router, db *sql.DB, queries *database.Queries, mcache *maintenance.Cache, authMW func(http.Handler) http.Handler, accessLogStore *appMiddleware.AccessLogStore) {
 	adminHandler := handlers.NewAdminHandler(queries)
-	maintenanceHandler := handlers.NewMaintenanceHandler(queries)
+	maintenanceHandler := handlers.NewMaintenanceHandler(db, queries, mcache)
+	signupHandler := handlers.NewSignupHandler(db, queries)
 	accessLogHandler := handlers.NewAccessLogHandler(accessLogStore)
+	auditHandler := handlers.NewAuditHandler(queries)
+	apiTokenHandler := handlers.NewAPITokenHandler(db, queries)
+	roleHandler := handlers.NewRoleHandler(db, queries)
+	trashHandler := handlers.NewTrashHandler(db, queries)
+
+	requirePerm := appMiddleware.RequirePermission
 
 	r.Route("/admin", func(r chi.Router) {
 		r.Use(authMW)
-		r.Use(appMiddleware.RequireRole(roles.Admin))
-		r.Get("/users", adminHandler.ListUsers)
-		r.Get("/access-logs", accessLogHandler.Page)
-		r.Get("/access-logs/table", accessLogHandler.TableSSE)
-		r.Get("/maintenance", maintenanceHandler.Page)
+		r.With(requirePerm(roles.UserManage)).Get("/users", adminHandler.ListUsers)
+		r.With(requirePerm(roles.UserManage)).Get("/signup", signupHandler.Page)
+		r.Group(func(r chi.Router) {
+			r.Use(requirePerm(roles.LogsRead))
+			r.Get("/access-logs", accessLogHandler.Page)
+			r.Get("/access-logs/table", accessLogHandler.TableSSE)
+			r.Get("/audit", auditHandler.Page)
+			r.Get("/audit/table", auditHandler.TableSSE)
+		})
+		r.With(requirePerm(roles.MaintenanceToggle)).Get("/maintenance", maintenanceHandler.Page)
+		r.With(requirePerm(roles.APITokenManage)).Get("/api-tokens", apiTokenHandler.AdminPage)
+		r.With(requirePerm(roles.RoleManage)).Get("/roles", roleHandler.Page)
+		r.With(requirePerm(roles.UserManage)).Get("/trash", trashHandler.Page)
 	})
 }
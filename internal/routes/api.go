@@ -0,0 +1,59 @@
+package routes
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/naozine/nz-magic-link/magiclink"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/handlers"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/invitation"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/limits"
+	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// RegisterAPIRoutes はスクリプト・外部連携向けの JSON API（/api/v1）を登録する。
+// 認証は Cookie か API トークン（Bearer）で、未認証は 401 の JSON を返す。権限は画面・SSE と
+// 同じ RequirePermission で判定する（閲覧はログイン中の全員、プロジェクトの変更は project.write、
+// ユーザーは user.manage）。プロジェクトはさらにハンドラがメンバーかどうかとプロジェクトロールで絞る。
+// ユーザーの削除と管理者相当への変更は、画面と同じく本人確認（step-up）を求める。API トークンでは
+// 確認できないので、これらは Cookie で認証したリクエストでしか行えない。
+// guardMW はメンテナンスと読み取り専用モードのミドルウェア（RequireAuth は含めない）。
+func RegisterAPIRoutes(r chi.Router, db *sql.DB, queries *database.Queries, ml *magiclink.MagicLink, inviter *invitation.Inviter, guardMW func(http.Handler) http.Handler) {
+	projectAPI := handlers.NewProjectAPIHandler(db, queries)
+	userAPI := handlers.NewUserAPIHandler(db, queries, ml, inviter)
+
+	requireWrite := appMiddleware.RequirePermission(roles.ProjectWrite)
+	requireUserManage := appMiddleware.RequirePermission(roles.UserManage)
+	requireRecentAuth := appMiddleware.RequireRecentAuth(http.HandlerFunc(handlers.StepUpRequiredSSE))
+
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(appMiddleware.RequireAPIAuth)
+		r.Use(guardMW)
+		r.Use(appMiddleware.MaxBodySize(limits.APIJSONBody))
+		r.NotFound(handlers.APINotFound)
+		r.MethodNotAllowed(handlers.APIMethodNotAllowed)
+
+		// Projects
+		r.Get("/projects", projectAPI.List)
+		r.Get("/projects/{id}", projectAPI.Get)
+		r.Group(func(r chi.Router) {
+			r.Use(requireWrite)
+			r.Post("/projects", projectAPI.Create)
+			r.Patch("/projects/{id}", projectAPI.Update)
+			r.Delete("/projects/{id}", projectAPI.Delete)
+		})
+
+		// Users
+		r.Group(func(r chi.Router) {
+			r.Use(requireUserManage)
+			r.Get("/users", userAPI.List)
+			r.Get("/users/{id}", userAPI.Get)
+			r.Post("/users", userAPI.Create)
+			r.Patch("/users/{id}", userAPI.Update)
+			r.With(requireRecentAuth).Delete("/users/{id}", userAPI.Delete)
+		})
+	})
+}
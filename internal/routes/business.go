@@ -7,18 +7,27 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/handlers"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/invitation"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/limits"
 	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
 )
 
+// UserImportPath はユーザー一括インポート。Excel を multipart で受け取る。
+const UserImportPath = "/admin/users/import"
+
+// CSRFMultipartPaths は multipart のフォームを受け付けるパス（middleware.CSRFOptions.Multipart）。
+// ハンドラが ParseMultipartForm の後に middleware.ValidCSRFForm で CSRF トークンを確かめること。
+var CSRFMultipartPaths = []string{UserImportPath}
+
 // RegisterBusinessRoutes はビジネスロジックのルートを登録する。
 // db はトランザクションを使うハンドラ（一括インポート等）に渡す。
-func RegisterBusinessRoutes(r chi.Router, db *sql.DB, queries *database.Queries, authMW func(http.Handler) http.Handler) {
+// inviter は一括インポートしたユーザーへの招待メール送信に使う。
+func RegisterBusinessRoutes(r chi.Router, db *sql.DB, queries *database.Queries, inviter *invitation.Inviter, authMW func(http.Handler) http.Handler) {
 	projectHandler := handlers.NewProjectHandler(queries)
-	importHandler := handlers.NewUserImportHandler(db, queries)
+	importHandler := handlers.NewUserImportHandler(db, queries, inviter)
 
-	requireAdmin := appMiddleware.RequireRole(roles.Admin)
+	requireUserManage := appMiddleware.RequirePermission(roles.UserManage)
 
 	// プロジェクトの作成・編集・削除は Datastar SSE（/api/sse/projects/*）で行う。
 	// 通常ルートは一覧・詳細の表示のみ。
@@ -28,12 +37,12 @@ func RegisterBusinessRoutes(r chi.Router, db *sql.DB, queries *database.Queries,
 		r.Get("/{id}", projectHandler.ShowProject)
 	})
 
-	// ユーザー一括インポート（admin のみ）
+	// ユーザー一括インポート（user.manage）
 	r.Group(func(r chi.Router) {
 		r.Use(authMW)
-		r.Use(requireAdmin)
-		r.Get("/admin/users/import", importHandler.ImportPage)
-		r.With(appMiddleware.MaxBodySize(limits.UserImportBody)).Post("/admin/users/import", importHandler.ExecuteImport)
+		r.Use(requireUserManage)
+		r.Get(UserImportPath, importHandler.ImportPage)
+		r.With(appMiddleware.MaxBodySize(limits.UserImportBody)).Post(UserImportPath, importHandler.ExecuteImport)
 		r.Get("/admin/users/import/template", importHandler.TemplateDownload)
 	})
 }
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/handlers"
+	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// RegisterDocsRoutes は OpenAPI ドキュメント（/api/openapi.json）と API エクスプローラ
+// （/admin/api-docs）を登録する。ドキュメントは r に登録されたルートから初回アクセス時に
+// 組み立てるので、r はルートのルーター（子ルーターではなく）を渡す。
+// ドキュメントはログイン中なら誰でも（API トークンでも）取得でき、エクスプローラは api_docs.read の権限が必要。
+func RegisterDocsRoutes(r chi.Router, cookieName string, guardMW, authMW func(http.Handler) http.Handler) {
+	docs := handlers.NewOpenAPIHandler(r, cookieName)
+
+	r.With(appMiddleware.RequireAPIAuth, guardMW).Get("/api/openapi.json", docs.Spec)
+	r.With(authMW, appMiddleware.RequirePermission(roles.APIDocsRead)).Get("/admin/api-docs", docs.ExplorerPage)
+}
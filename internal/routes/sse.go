@@ -1,54 +1,124 @@
 package routes
 
 import (
+	"database/sql"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/naozine/nz-magic-link/magiclink"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/handlers"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/invitation"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/limits"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
 	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
 )
 
+// ReadOnlyExemptPrefix は読み取り専用モード中も書き込みを受け付けるパス。メンテナンス画面の
+// 操作（読み取り専用モード自体の解除を含む）はここにまとめておく。
+const ReadOnlyExemptPrefix = "/api/sse/admin/maintenance/"
+
+// ImpersonationStopPath は「このユーザーとして表示」の終了。表示中（書き込みを断る）も
+// 読み取り専用モード中も受け付ける。
+const ImpersonationStopPath = "/api/sse/impersonation/stop"
+
 // RegisterSSERoutes は Datastar SSE 用のルートを登録する。
-func RegisterSSERoutes(r chi.Router, queries *database.Queries, ml *magiclink.MagicLink, authMW func(http.Handler) http.Handler) {
-	projectSSE := handlers.NewProjectSSEHandler(queries)
-	adminSSE := handlers.NewAdminSSEHandler(queries)
-	maintenanceHandler := handlers.NewMaintenanceHandler(queries)
-	profileSSE := handlers.NewProfileSSEHandler(queries, ml)
+// db は変更と監査ログを同一トランザクションで書くハンドラに渡す。
+// inviter は管理画面からのユーザー追加・招待の再送に使う。
+// mcache はメンテナンスモードの切替時に捨てる状態キャッシュ。
+func RegisterSSERoutes(r chi.Router, db *sql.DB, queries *database.Queries, ml *magiclink.MagicLink, inviter *invitation.Inviter, mcache *maintenance.Cache, authMW func(http.Handler) http.Handler) {
+	projectSSE := handlers.NewProjectSSEHandler(db, queries)
+	projectMemberSSE := handlers.NewProjectMemberSSEHandler(db, queries)
+	adminSSE := handlers.NewAdminSSEHandler(db, queries, ml, inviter)
+	maintenanceHandler := handlers.NewMaintenanceHandler(db, queries, mcache)
+	signupHandler := handlers.NewSignupHandler(db, queries)
+	profileSSE := handlers.NewProfileSSEHandler(db, queries, ml)
+	apiTokenHandler := handlers.NewAPITokenHandler(db, queries)
+	roleHandler := handlers.NewRoleHandler(db, queries)
+	trashHandler := handlers.NewTrashHandler(db, queries)
+	impersonationSSE := handlers.NewImpersonationSSEHandler(db, queries, ml.Config.CookieName)
 
-	requireWrite := appMiddleware.RequireRole(roles.Admin, roles.Editor)
-	requireAdmin := appMiddleware.RequireRole(roles.Admin)
+	requirePerm := appMiddleware.RequirePermission
+	// 取り返しのつかない管理操作は、最近ログインしたセッションに限る（古ければ本人確認を求める）。
+	requireRecentAuth := appMiddleware.RequireRecentAuth(http.HandlerFunc(handlers.StepUpRequiredSSE))
 
 	r.Route("/api/sse", func(r chi.Router) {
 		r.Use(authMW)
 		// SSE 書き込み（@post/@put）の signals JSON body 上限（DoS 対策）。
 		r.Use(appMiddleware.MaxBodySize(limits.SSESignalBody))
 
-		// Projects
+		// Projects（プロジェクトごとの可否はハンドラがプロジェクトロールで判定する）
 		r.Group(func(r chi.Router) {
-			r.Use(requireWrite)
+			r.Use(requirePerm(roles.ProjectWrite))
 			r.Post("/projects/new", projectSSE.CreateProjectSSE)
 			r.Get("/projects/{id}/edit", projectSSE.EditProjectDialogSSE)
 			r.Put("/projects/{id}", projectSSE.UpdateProjectSSE)
 			r.Delete("/projects/{id}", projectSSE.DeleteProjectSSE)
+			r.Post("/projects/{id}/members", projectMemberSSE.AddSSE)
+			r.Put("/projects/{id}/members/{userID}", projectMemberSSE.UpdateSSE)
+			r.Delete("/projects/{id}/members/{userID}", projectMemberSSE.RemoveSSE)
 		})
 
-		// Admin Users
+		// Admin Users（招待・サインアップを含む）
 		r.Group(func(r chi.Router) {
-			r.Use(requireAdmin)
+			r.Use(requirePerm(roles.UserManage))
 			r.Post("/admin/users/create", adminSSE.CreateUserDialogSSE)
 			r.Get("/admin/users/{id}/edit", adminSSE.EditUserDialogSSE)
 			r.Put("/admin/users/{id}", adminSSE.UpdateUserSSE)
-			r.Delete("/admin/users/{id}", adminSSE.DeleteUserSSE)
+			r.With(requireRecentAuth).Delete("/admin/users/{id}", adminSSE.DeleteUserSSE)
+			r.Delete("/admin/users/{id}/sessions", adminSSE.RevokeUserSessionsSSE)
+			r.Post("/admin/users/{id}/invitation", adminSSE.ResendInvitationSSE)
+			r.Delete("/admin/users/{id}/invitation", adminSSE.RevokeInvitationSSE)
+			r.Post("/admin/signup-requests/{id}/approve", adminSSE.ApproveSignupSSE)
+			r.Delete("/admin/signup-requests/{id}", adminSSE.RejectSignupSSE)
+			r.Put("/admin/signup", signupHandler.UpdatePolicySSE)
+		})
 
+		// このユーザーとして表示。終了は表示中のユーザーの権限で呼ばれるので権限で絞らない。
+		r.With(requirePerm(roles.UserImpersonate)).Post("/admin/users/{id}/impersonate", impersonationSSE.StartSSE)
+		r.Post("/impersonation/stop", impersonationSSE.StopSSE)
+
+		// Maintenance
+		r.Group(func(r chi.Router) {
+			r.Use(requirePerm(roles.MaintenanceToggle), requireRecentAuth)
 			r.Post("/admin/maintenance/toggle", maintenanceHandler.ToggleSSE)
+			r.Put("/admin/maintenance/window", maintenanceHandler.SaveWindowSSE)
+			r.Delete("/admin/maintenance/window", maintenanceHandler.CancelWindowSSE)
+			r.Post("/admin/maintenance/read-only/toggle", maintenanceHandler.ToggleReadOnlySSE)
+		})
+
+		r.With(requirePerm(roles.APITokenManage)).Delete("/admin/api-tokens/{id}", apiTokenHandler.AdminRevokeSSE)
+
+		// Trash（完全に削除は取り返しがつかないので最近のログインに限る）
+		r.Group(func(r chi.Router) {
+			r.Use(requirePerm(roles.UserManage))
+			r.Post("/admin/trash/users/{id}/restore", trashHandler.RestoreUserSSE)
+			r.With(requireRecentAuth).Delete("/admin/trash/users/{id}", trashHandler.PurgeUserSSE)
+			r.Group(func(r chi.Router) {
+				r.Use(requirePerm(roles.ProjectAll))
+				r.Post("/admin/trash/projects/{id}/restore", trashHandler.RestoreProjectSSE)
+				r.With(requireRecentAuth).Delete("/admin/trash/projects/{id}", trashHandler.PurgeProjectSSE)
+			})
+		})
+
+		// Roles
+		r.Group(func(r chi.Router) {
+			r.Use(requirePerm(roles.RoleManage))
+			r.Post("/admin/roles", roleHandler.CreateSSE)
+			r.Get("/admin/roles/{name}/edit", roleHandler.EditDialogSSE)
+			r.Put("/admin/roles/{name}", roleHandler.UpdateSSE)
+			r.Delete("/admin/roles/{name}", roleHandler.DeleteSSE)
 		})
 
 		// Profile
 		r.Put("/profile", profileSSE.UpdateProfileSSE)
-		r.Delete("/profile/passkeys", profileSSE.DeletePasskeysSSE)
+		r.Get("/profile/passkeys/{id}/edit", profileSSE.EditPasskeyDialogSSE)
+		r.Put("/profile/passkeys/{id}", profileSSE.RenamePasskeySSE)
+		r.Delete("/profile/passkeys/{id}", profileSSE.DeletePasskeySSE)
+		r.Delete("/profile/sessions", profileSSE.RevokeOtherSessionsSSE)
+		r.Delete("/profile/sessions/{id}", profileSSE.RevokeSessionSSE)
+		r.Post("/profile/api-tokens", apiTokenHandler.CreateSSE)
+		r.Delete("/profile/api-tokens/{id}", apiTokenHandler.RevokeSSE)
 	})
 }
@@ -0,0 +1,225 @@
+// Package signup は許可ドメインのメールアドレスによるセルフサインアップを扱う。
+//
+// ポリシー（有効／無効・許可ドメイン・自動登録時のロール・管理者承認の要否）は
+// app_settings の 1 行（key="signup_policy"）に JSON で保存する。
+// 未登録のメールアドレスでログインしようとしたとき、ポリシーが有効でドメインが
+// 許可されていれば、承認不要ならその場でユーザーを作り、承認が必要なら
+// signup_requests に承認待ちとして積む（ユーザーは承認時に作る）。
+package signup
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// Key は app_settings テーブルでサインアップポリシーを保存する key 名。
+const Key = "signup_policy"
+
+// Policy はセルフサインアップの設定。
+type Policy struct {
+	Enabled         bool     `json:"enabled"`
+	Domains         []string `json:"domains"` // 小文字・"@" 無し（例: "example.com"）
+	DefaultRole     string   `json:"default_role"`
+	RequireApproval bool     `json:"require_approval"`
+}
+
+// DefaultPolicy は未設定時のポリシー（サインアップ無効）。
+func DefaultPolicy() Policy {
+	return Policy{DefaultRole: roles.Viewer, RequireApproval: true}
+}
+
+// ErrPendingApproval は管理者の承認待ちでログインできないことを表す。
+var ErrPendingApproval = errors.New("signup: pending approval")
+
+// Load は現在のポリシーを返す。行が無い／読めない場合は DefaultPolicy（無効＝安全側）。
+func Load(ctx context.Context, q *database.Queries) Policy {
+	p := DefaultPolicy()
+	s, err := q.GetAppSetting(ctx, Key)
+	if err != nil {
+		return p
+	}
+	if err := json.Unmarshal([]byte(s.Value), &p); err != nil {
+		return DefaultPolicy()
+	}
+	return p
+}
+
+// Save はポリシーを保存する。保存前に Normalize で検証・正規化すること。
+func Save(ctx context.Context, q *database.Queries, p Policy) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("signup: encode policy: %w", err)
+	}
+	return q.UpsertAppSetting(ctx, database.UpsertAppSettingParams{
+		Key:   Key,
+		Value: string(b),
+	})
+}
+
+// ParseDomains は改行・カンマ・空白区切りのドメイン一覧を分割する（検証は Normalize で行う）。
+func ParseDomains(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' ' || r == '\t' || r == '、'
+	})
+}
+
+// Normalize はドメインを小文字・"@" 無しに揃えて重複を除き、ポリシーを検証する。
+// エラーは画面にそのまま出せるメッセージ。
+func Normalize(p Policy) (Policy, error) {
+	if !roles.IsValid(p.DefaultRole) {
+		return p, fmt.Errorf("ロールが不正です")
+	}
+	if p.DefaultRole == roles.Admin {
+		return p, fmt.Errorf("自動登録ユーザーのロールに admin は指定できません")
+	}
+
+	seen := map[string]bool{}
+	domains := make([]string, 0, len(p.Domains))
+	for _, d := range p.Domains {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@"))
+		if d == "" || seen[d] {
+			continue
+		}
+		if !validDomain(d) {
+			return p, fmt.Errorf("ドメインの形式が不正です: %s", d)
+		}
+		seen[d] = true
+		domains = append(domains, d)
+	}
+	p.Domains = domains
+
+	if p.Enabled && len(p.Domains) == 0 {
+		return p, fmt.Errorf("サインアップを有効にするには許可ドメインを 1 つ以上指定してください")
+	}
+	return p, nil
+}
+
+func validDomain(d string) bool {
+	if !strings.Contains(d, ".") || strings.HasPrefix(d, ".") || strings.HasSuffix(d, ".") {
+		return false
+	}
+	for _, r := range d {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Allows は email がポリシーで自動登録できるアドレスかを返す。
+// ドメインは完全一致（サブドメインは個別に許可する）。
+func (p Policy) Allows(email string) bool {
+	if !p.Enabled {
+		return false
+	}
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, d := range p.Domains {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// Register は未登録の email をポリシーに従って登録する。承認不要ならユーザーを作って返し、
+// 承認が必要なら承認待ちに積んで ErrPendingApproval を返す。
+// email が p.Allows を満たすことは呼び出し元で確認しておく。
+func Register(ctx context.Context, db *sql.DB, p Policy, email string) (database.User, error) {
+	var user database.User
+	err := dbtx.Run(ctx, db, database.New(db), func(qtx *database.Queries) error {
+		if p.RequireApproval {
+			if err := qtx.CreateSignupRequest(ctx, database.CreateSignupRequestParams{
+				Email:       email,
+				RequestedAt: time.Now().UTC().Truncate(time.Second),
+			}); err != nil {
+				return fmt.Errorf("signup: queue request: %w", err)
+			}
+			return nil
+		}
+
+		var err error
+		user, err = createUser(ctx, qtx, email, p.DefaultRole)
+		if err != nil {
+			return err
+		}
+		// 未ログインのリクエストなので、操作者は登録したユーザー本人として記録する。
+		return audit.Record(ctx, qtx, audit.Entry{
+			ActorID:    user.ID,
+			ActorEmail: user.Email,
+			Action:     audit.ActionUserSignup,
+			TargetType: audit.TargetUser,
+			TargetID:   strconv.FormatInt(user.ID, 10),
+			After:      user,
+		})
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	if p.RequireApproval {
+		return database.User{}, ErrPendingApproval
+	}
+	return user, nil
+}
+
+// Approve は承認待ち id のユーザーを role で作り、承認待ちから外す。
+// q はトランザクション内の Queries を渡す（監査ログは呼び出し元が同じトランザクションで書く）。
+func Approve(ctx context.Context, q *database.Queries, id int64, role string) (database.User, error) {
+	req, err := q.GetSignupRequest(ctx, id)
+	if err != nil {
+		return database.User{}, err
+	}
+	user, err := createUser(ctx, q, req.Email, role)
+	if err != nil {
+		return database.User{}, err
+	}
+	if _, err := q.DeleteSignupRequest(ctx, id); err != nil {
+		return database.User{}, fmt.Errorf("signup: delete request: %w", err)
+	}
+	return user, nil
+}
+
+// Reject は承認待ち id を削除して返す。無ければ sql.ErrNoRows。
+func Reject(ctx context.Context, q *database.Queries, id int64) (database.SignupRequest, error) {
+	req, err := q.GetSignupRequest(ctx, id)
+	if err != nil {
+		return database.SignupRequest{}, err
+	}
+	if _, err := q.DeleteSignupRequest(ctx, id); err != nil {
+		return database.SignupRequest{}, fmt.Errorf("signup: delete request: %w", err)
+	}
+	return req, nil
+}
+
+// createUser は有効なユーザーを作る。名前はメールアドレスのローカル部にしておき、
+// 本人がマイページで変更する。
+func createUser(ctx context.Context, q *database.Queries, email, role string) (database.User, error) {
+	name := email
+	if at := strings.LastIndex(email, "@"); at > 0 {
+		name = email[:at]
+	}
+	user, err := q.CreateUser(ctx, database.CreateUserParams{
+		Email:    email,
+		Name:     name,
+		Role:     role,
+		IsActive: true,
+	})
+	if err != nil {
+		return database.User{}, fmt.Errorf("signup: create user: %w", err)
+	}
+	return user, nil
+}
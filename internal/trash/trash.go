@@ -0,0 +1,159 @@
+// Package trash はゴミ箱（deleted_at を入れた projects / users の行）の保持期間と、
+// 期間を過ぎた行の自動削除を提供する。
+//
+// 画面や API の削除はゴミ箱に移すだけで、通常の一覧・取得のクエリはゴミ箱の行を返さない。
+// ゴミ箱から戻す・すぐに完全に削除するのは管理画面（/admin/trash）で行う。
+package trash
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dbtx"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
+)
+
+// DefaultRetentionDays は TRASH_RETENTION_DAYS 未設定時の保持日数。
+const DefaultRetentionDays = 30
+
+// PurgeInterval は自動削除を実行する間隔。
+const PurgeInterval = time.Hour
+
+// SystemActor は自動削除の監査ログに記録する操作者（actor_id は 0）。
+const SystemActor = "system"
+
+var retentionDays atomic.Int64
+
+func init() {
+	retentionDays.Store(DefaultRetentionDays)
+}
+
+// ParseRetentionDays は TRASH_RETENTION_DAYS の値を保持日数にする。空なら DefaultRetentionDays、
+// 0 は自動削除しない（管理画面から完全に削除するまで残す）。
+func ParseRetentionDays(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return DefaultRetentionDays, nil
+	}
+	days, err := strconv.Atoi(s)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("TRASH_RETENTION_DAYS は 0 以上の日数で指定してください: %q", s)
+	}
+	return days, nil
+}
+
+// RetentionDays はゴミ箱の保持日数（0 は自動削除しない）。管理画面の案内に使う。
+func RetentionDays() int {
+	return int(retentionDays.Load())
+}
+
+// SetRetentionDays は保持日数を設定する。起動時に Run より前に呼ぶ。
+func SetRetentionDays(days int) {
+	retentionDays.Store(int64(days))
+}
+
+// Result は PurgeExpired で完全に削除した件数。
+type Result struct {
+	Projects int
+	Users    int
+}
+
+// PurgeExpired は now から保持日数より前にゴミ箱に移した行を完全に削除し、1 件ずつ監査ログ
+// （操作者は SystemActor）を残す。保持日数が 0 なら何もしない。
+// ユーザーを消すと、メンバーシップや API トークンは外部キーで一緒に消え、責任者はなしになる。
+// パスキーはコミット後に消す（authsession.Purge。失敗はログに残す）。
+func PurgeExpired(ctx context.Context, db *sql.DB, now time.Time) (Result, error) {
+	days := RetentionDays()
+	if days == 0 {
+		return Result{}, nil
+	}
+	cutoff := sql.NullTime{Time: now.UTC().AddDate(0, 0, -days), Valid: true}
+
+	var res Result
+	var emails []string
+	err := dbtx.Run(ctx, db, database.New(db), func(qtx *database.Queries) error {
+		projects, err := qtx.PurgeProjectsTrashedBefore(ctx, cutoff)
+		if err != nil {
+			return fmt.Errorf("trash: purge projects: %w", err)
+		}
+		for _, p := range projects {
+			if err := recordPurge(ctx, qtx, audit.ActionProjectPurge, audit.TargetProject, p.ID, p); err != nil {
+				return err
+			}
+		}
+		users, err := qtx.PurgeUsersTrashedBefore(ctx, cutoff)
+		if err != nil {
+			return fmt.Errorf("trash: purge users: %w", err)
+		}
+		for _, u := range users {
+			if err := recordPurge(ctx, qtx, audit.ActionUserPurge, audit.TargetUser, u.ID, u); err != nil {
+				return err
+			}
+		}
+		res = Result{Projects: len(projects), Users: len(users)}
+		for _, u := range users {
+			emails = append(emails, u.Email)
+		}
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	for _, email := range emails {
+		if err := authsession.Purge(ctx, db, email); err != nil {
+			logger.Error("完全に削除したユーザーのパスキーの削除に失敗", "error", err, "email", email)
+		}
+	}
+	return res, nil
+}
+
+func recordPurge(ctx context.Context, qtx *database.Queries, action, targetType string, id int64, before any) error {
+	return audit.Record(ctx, qtx, audit.Entry{
+		ActorEmail: SystemActor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   strconv.FormatInt(id, 10),
+		Before:     before,
+	})
+}
+
+// Run は ctx が終わるまで、起動時と PurgeInterval ごとに PurgeExpired を実行する。
+// 読み取り専用モードの間は書き込まないよう見送る。失敗はログに残して次の回に任せる。
+func Run(ctx context.Context, db *sql.DB) {
+	if RetentionDays() == 0 {
+		return
+	}
+	ticker := time.NewTicker(PurgeInterval)
+	defer ticker.Stop()
+	for {
+		purgeOnce(ctx, db)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func purgeOnce(ctx context.Context, db *sql.DB) {
+	if maintenance.Load(ctx, database.New(db)).ReadOnly {
+		return
+	}
+	res, err := PurgeExpired(ctx, db, time.Now())
+	if err != nil {
+		logger.Error("ゴミ箱の自動削除に失敗", "error", err)
+		return
+	}
+	if res.Projects > 0 || res.Users > 0 {
+		logger.Info("ゴミ箱の保持期間を過ぎた行を削除", "projects", res.Projects, "users", res.Users, "retention_days", RetentionDays())
+	}
+}
@@ -0,0 +1,147 @@
+package components
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/audit"
+)
+
+var auditActionLabels = map[string]string{
+	audit.ActionUserCreate:          "ユーザー作成",
+	audit.ActionUserUpdate:          "ユーザー更新",
+	audit.ActionUserDelete:          "ユーザーをゴミ箱へ",
+	audit.ActionUserRestore:         "ユーザーをゴミ箱から戻す",
+	audit.ActionUserPurge:           "ユーザーを完全に削除",
+	audit.ActionUserImport:          "ユーザー一括インポート",
+	audit.ActionUserSignup:          "セルフサインアップ",
+	audit.ActionUserSSOProvision:    "SSO で自動登録",
+	audit.ActionUserSSORoleSync:     "SSO のロール同期",
+	audit.ActionUserPromoteAdmin:    "管理者へ昇格（コマンド）",
+	audit.ActionUserSetup:           "初期セットアップで管理者を作成",
+	audit.ActionImpersonateStart:    "ユーザーとして表示を開始",
+	audit.ActionImpersonateStop:     "ユーザーとして表示を終了",
+	audit.ActionSignupApprove:       "サインアップ承認",
+	audit.ActionSignupReject:        "サインアップ却下",
+	audit.ActionSignupPolicy:        "サインアップ設定変更",
+	audit.ActionInvitationResend:    "招待メール再送",
+	audit.ActionInvitationRevoke:    "招待取り消し",
+	audit.ActionInvitationAccept:    "招待承認",
+	audit.ActionProjectCreate:       "プロジェクト作成",
+	audit.ActionProjectUpdate:       "プロジェクト更新",
+	audit.ActionProjectDelete:       "プロジェクトをゴミ箱へ",
+	audit.ActionProjectRestore:      "プロジェクトをゴミ箱から戻す",
+	audit.ActionProjectPurge:        "プロジェクトを完全に削除",
+	audit.ActionProjectMemberAdd:    "プロジェクトメンバー追加",
+	audit.ActionProjectMemberUpdate: "プロジェクトメンバーのロール変更",
+	audit.ActionProjectMemberRemove: "プロジェクトメンバー削除",
+	audit.ActionMaintenanceToggle:   "メンテナンスモード切替",
+	audit.ActionMaintenanceSchedule: "メンテナンス予定登録",
+	audit.ActionMaintenanceCancel:   "メンテナンス予定取り消し",
+	audit.ActionReadOnlyToggle:      "読み取り専用モード切替",
+	audit.ActionAPITokenCreate:      "API トークン発行",
+	audit.ActionAPITokenRevoke:      "API トークン失効",
+	audit.ActionRoleCreate:          "ロール作成",
+	audit.ActionRoleUpdate:          "ロール更新",
+	audit.ActionRoleDelete:          "ロール削除",
+}
+
+var auditTargetLabels = map[string]string{
+	audit.TargetUser:          "ユーザー",
+	audit.TargetSignupRequest: "サインアップ申請",
+	audit.TargetProject:       "プロジェクト",
+	audit.TargetSetting:       "設定",
+	audit.TargetAPIToken:      "API トークン",
+	audit.TargetRole:          "ロール",
+}
+
+// auditActionLabel は操作種別の表示名を返す（未登録の種別はそのまま表示）。
+func auditActionLabel(action string) string {
+	if l, ok := auditActionLabels[action]; ok {
+		return l
+	}
+	return action
+}
+
+// auditTargetLabel は対象種別の表示名を返す。
+func auditTargetLabel(targetType string) string {
+	if l, ok := auditTargetLabels[targetType]; ok {
+		return l
+	}
+	return targetType
+}
+
+// auditActor は操作者の表示。actor_id=0 はシステム（CLI 等）による操作。
+func auditActor(email string, id int64) string {
+	if id == 0 && email == "" {
+		return "システム"
+	}
+	return email
+}
+
+// auditIgnoredKeys は差分表示から除く項目（更新のたびに変わり、読む価値が無い）。
+var auditIgnoredKeys = map[string]bool{"created_at": true, "updated_at": true}
+
+// auditChanges は before / after の JSON を項目ごとに比べ、変わった項目を
+// "項目: 旧 → 新" の形で返す。作成・削除のように片側が空なら、もう片側の値を並べる。
+// JSON オブジェクトとして読めない場合は nil（画面では生の JSON だけを出す）。
+func auditChanges(before, after string) []string {
+	b, okB := decodeAuditState(before)
+	a, okA := decodeAuditState(after)
+	if !okB || !okA {
+		return nil
+	}
+
+	keys := make([]string, 0, len(a)+len(b))
+	seen := map[string]bool{}
+	for _, m := range []map[string]any{b, a} {
+		for k := range m {
+			if !seen[k] && !auditIgnoredKeys[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	for _, k := range keys {
+		bv, inB := b[k]
+		av, inA := a[k]
+		switch {
+		case before == "":
+			lines = append(lines, fmt.Sprintf("%s: %s", k, auditValue(av)))
+		case after == "":
+			lines = append(lines, fmt.Sprintf("%s: %s", k, auditValue(bv)))
+		case !inB || !inA || auditValue(bv) != auditValue(av):
+			lines = append(lines, fmt.Sprintf("%s: %s → %s", k, auditValue(bv), auditValue(av)))
+		}
+	}
+	return lines
+}
+
+func decodeAuditState(s string) (map[string]any, bool) {
+	m := map[string]any{}
+	if s == "" {
+		return m, true
+	}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil, false
+	}
+	return m, true
+}
+
+func auditValue(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return "—"
+	case string:
+		return t
+	case bool, float64:
+		return fmt.Sprint(t)
+	default:
+		b, _ := json.Marshal(t)
+		return string(b)
+	}
+}
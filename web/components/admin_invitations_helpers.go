@@ -0,0 +1,21 @@
+package components
+
+import (
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+// invitationStatus は招待の送信日時と期限を 1 行にまとめる。送信できていない招待は「未送信」。
+func invitationStatus(inv database.ListPendingInvitationsRow) string {
+	sent := "未送信"
+	if inv.SentAt.Valid {
+		sent = "送信 " + inv.SentAt.Time.Local().Format("2006/01/02 15:04")
+	}
+	return sent + " • 期限 " + inv.ExpiresAt.Local().Format("2006/01/02 15:04")
+}
+
+// invitationExpired は招待リンクの期限が切れているか。
+func invitationExpired(inv database.ListPendingInvitationsRow) bool {
+	return !time.Now().Before(inv.ExpiresAt)
+}
@@ -0,0 +1,38 @@
+package components
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/maintenance"
+)
+
+// maintenanceWindowSignals は予定メンテナンスのフォームの初期 signals。予定があれば
+// その値を入れておき、時間を延ばすなどの修正をしやすくする。
+func maintenanceWindowSignals(win *maintenance.Window) string {
+	m := map[string]any{"mwStart": "", "mwEnd": "", "mwMessage": "", "mwAllow": ""}
+	if win != nil {
+		m["mwStart"] = win.Start.Local().Format("2006-01-02T15:04")
+		m["mwEnd"] = win.End.Local().Format("2006-01-02T15:04")
+		m["mwMessage"] = win.Message
+		m["mwAllow"] = strings.Join(win.AllowEmails, "\n")
+	}
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+func maintenanceWindowSubmitLabel(win *maintenance.Window) string {
+	if win != nil {
+		return "予定を更新"
+	}
+	return "予定を登録"
+}
+
+// maintenanceWindowState は予定が開始前か実施中かの表示。
+func maintenanceWindowState(win maintenance.Window) string {
+	if win.Contains(time.Now()) {
+		return "実施中"
+	}
+	return "開始前"
+}
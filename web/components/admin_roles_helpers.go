@@ -0,0 +1,112 @@
+package components
+
+import (
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// rolePermissionLabels は権限の表示名と説明（ロールの編集ダイアログのチェックボックス）。
+var rolePermissionLabels = map[roles.Permission][2]string{
+	roles.ProjectWrite:      {"プロジェクトの編集", "プロジェクトの作成と、編集者・オーナーとして参加しているプロジェクトの更新・削除。"},
+	roles.ProjectAll:        {"全プロジェクトへのアクセス", "メンバーでないプロジェクトも含めて、すべてのプロジェクトをオーナーと同じように扱えます。"},
+	roles.UserManage:        {"ユーザー管理", "ユーザーの追加・編集・削除・一括インポート、招待、サインアップの承認と設定、ゴミ箱。"},
+	roles.UserImpersonate:   {"ユーザーとして表示", "他のユーザーとして画面を表示します（表示中は変更できません）。管理者として表示することはできません。"},
+	roles.LogsRead:          {"ログの閲覧", "アクセスログと監査ログの閲覧。"},
+	roles.MaintenanceToggle: {"メンテナンスの切替", "メンテナンスモード・予定・読み取り専用モードの切替。メンテナンス中も利用できます。"},
+	roles.APITokenManage:    {"API トークンの管理", "全ユーザーの API トークンの一覧と失効。"},
+	roles.APIDocsRead:       {"API ドキュメントの閲覧", "API エクスプローラの閲覧。"},
+	roles.RoleManage:        {"ロールの管理", "カスタムロールの作成・変更・削除。"},
+}
+
+// rolePermissionLabel は権限の表示名を返す（未登録の権限はそのまま表示）。
+func rolePermissionLabel(p roles.Permission) string {
+	if l, ok := rolePermissionLabels[p]; ok {
+		return l[0]
+	}
+	return string(p)
+}
+
+// rolePermissionHelp は権限の説明を返す。
+func rolePermissionHelp(p roles.Permission) string {
+	return rolePermissionLabels[p][1]
+}
+
+// rolePermissionsSummary は一覧に出す権限の表示名（読点区切り）。
+func rolePermissionsSummary(ps []roles.Permission) string {
+	if len(ps) == 0 {
+		return "閲覧のみ"
+	}
+	labels := make([]string, len(ps))
+	for i, p := range ps {
+		labels[i] = rolePermissionLabel(p)
+	}
+	return strings.Join(labels, "、")
+}
+
+// builtInRoleOptionLabels / builtInRoleDisplayNames は組み込みロールの選択肢とプロフィールでの表示。
+var (
+	builtInRoleOptionLabels = map[string]string{
+		roles.Viewer: "Viewer（閲覧のみ）",
+		roles.Editor: "Editor（編集可能）",
+		roles.Admin:  "Admin（管理者）",
+	}
+	builtInRoleDisplayNames = map[string]string{
+		roles.Viewer: "閲覧者 (Viewer)",
+		roles.Editor: "編集者 (Editor)",
+		roles.Admin:  "管理者 (Admin)",
+	}
+)
+
+// roleOptionLabel はロールのセレクトボックスの選択肢の表示（カスタムロールは「表示名（名前）」）。
+func roleOptionLabel(role string) string {
+	if l, ok := builtInRoleOptionLabels[role]; ok {
+		return l
+	}
+	return roles.Label(role) + "（" + role + "）"
+}
+
+// roleDisplayName はロールの表示名（カスタムロールは「表示名 (名前)」）。
+func roleDisplayName(role string) string {
+	if l, ok := builtInRoleDisplayNames[role]; ok {
+		return l
+	}
+	return roles.Label(role) + " (" + role + ")"
+}
+
+// RolePermissionSignal は権限のチェックボックスの signal 名（"." は signal のパス区切りと
+// 衝突するので "_" にする）。handlers はこの名前で signals を読む。
+func RolePermissionSignal(p roles.Permission) string {
+	return strings.ReplaceAll(string(p), ".", "_")
+}
+
+// roleDialogSignals はロールの追加・編集ダイアログの初期 signals。prefix は "newRole" か
+// "editRole"（<prefix>Name・<prefix>Label・<prefix>Perms.<権限> になる）。
+func roleDialogSignals(prefix string, r roles.Role) string {
+	perms := map[string]bool{}
+	for _, p := range roles.Permissions {
+		perms[RolePermissionSignal(p)] = slices.Contains(r.Permissions, p)
+	}
+	m := map[string]any{
+		prefix + "Label": r.Label,
+		prefix + "Perms": perms,
+	}
+	if prefix == "newRole" {
+		m[prefix+"Name"] = r.Name
+	}
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+// roleURL は SSE でカスタムロールを操作する URL。
+func roleURL(name string) string {
+	return "/api/sse/admin/roles/" + url.PathEscape(name)
+}
+
+// deleteRoleConfirm はロールを削除する確認ダイアログを開く式。
+func deleteRoleConfirm(name string) string {
+	return "$confirmMsg = 'ロール「" + name + "」を削除しますか？'; $confirmUrl = '" + roleURL(name) + "'; $confirmMethod = 'delete'; document.getElementById('confirm-dialog').showModal()"
+}
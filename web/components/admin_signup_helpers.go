@@ -0,0 +1,20 @@
+package components
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/signup"
+)
+
+// signupPolicySignals はサインアップ設定フォームの初期 signals。
+// ドメインはテキストエリアで 1 行 1 件として編集する。
+func signupPolicySignals(p signup.Policy) string {
+	b, _ := json.Marshal(map[string]any{
+		"signupEnabled":  p.Enabled,
+		"signupDomains":  strings.Join(p.Domains, "\n"),
+		"signupRole":     p.DefaultRole,
+		"signupApproval": p.RequireApproval,
+	})
+	return string(b)
+}
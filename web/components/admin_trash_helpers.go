@@ -0,0 +1,44 @@
+package components
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+// trashDescription はゴミ箱の画面の説明（保持日数の案内）。
+func trashDescription(retentionDays int) string {
+	const base = "削除したプロジェクトとユーザーはここに移ります。戻すか、完全に削除できます。"
+	if retentionDays == 0 {
+		return base + "自動では削除されません。"
+	}
+	return base + fmt.Sprintf("ゴミ箱に移してから %d 日たつと自動で完全に削除されます。", retentionDays)
+}
+
+// trashDeletedAt はゴミ箱に移した日時の表示。
+func trashDeletedAt(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Local().Format("2006/01/02 15:04") + " に削除"
+}
+
+func trashProjectURL(id int64) string {
+	return "/api/sse/admin/trash/projects/" + strconv.FormatInt(id, 10)
+}
+
+func trashUserURL(id int64) string {
+	return "/api/sse/admin/trash/users/" + strconv.FormatInt(id, 10)
+}
+
+// purgeProjectConfirm はプロジェクトを完全に削除する確認ダイアログを開く式。
+func purgeProjectConfirm(p database.Project) string {
+	return "$confirmMsg = 'プロジェクトを完全に削除しますか？メンバーも消え、元に戻せません。'; $confirmUrl = '" + trashProjectURL(p.ID) + "'; $confirmMethod = 'delete'; document.getElementById('confirm-dialog').showModal()"
+}
+
+// purgeUserConfirm はユーザーを完全に削除する確認ダイアログを開く式。
+func purgeUserConfirm(u database.User) string {
+	return "$confirmMsg = 'ユーザーを完全に削除しますか？プロジェクトのメンバーや API トークンも消え、元に戻せません。'; $confirmUrl = '" + trashUserURL(u.ID) + "'; $confirmMethod = 'delete'; document.getElementById('confirm-dialog').showModal()"
+}
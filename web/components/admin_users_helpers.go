@@ -0,0 +1,18 @@
+package components
+
+import (
+	"context"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// canImpersonateUser は一覧に user の「として表示」ボタンを出すかを返す。
+// 条件は middleware.CanImpersonate と揃える（自分自身・無効なユーザー・管理者は対象外）。
+// 読み取り専用モード中は開始できないので出さない。
+func canImpersonateUser(ctx context.Context, user database.User) bool {
+	return appcontext.Can(ctx, roles.UserImpersonate) && !appcontext.IsReadOnly(ctx) &&
+		user.ID != appcontext.GetUserID(ctx) && user.IsActive &&
+		!roles.Has(user.Role, roles.UserImpersonate)
+}
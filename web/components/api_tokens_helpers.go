@@ -0,0 +1,65 @@
+package components
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/apitoken"
+)
+
+// apiTokenScopeLabel は権限（スコープ）の表示名。
+func apiTokenScopeLabel(scope string) string {
+	if scope == apitoken.ScopeWrite {
+		return "読み書き"
+	}
+	return "読み取りのみ"
+}
+
+// apiTokenExpiryLabel は発行ダイアログの有効期間の選択肢の表示名。
+func apiTokenExpiryLabel(days int) string {
+	if days == 0 {
+		return "無期限"
+	}
+	return fmt.Sprintf("%d 日", days)
+}
+
+// apiTokenExpired は期限切れか（一覧でバッジを出す）。
+func apiTokenExpired(expiresAt sql.NullTime) bool {
+	return expiresAt.Valid && !time.Now().Before(expiresAt.Time)
+}
+
+// apiTokenDetail はトークンの先頭・発行日・期限・最終使用を 1 行にまとめる。
+func apiTokenDetail(prefix string, createdAt time.Time, expiresAt, lastUsedAt sql.NullTime) string {
+	line := prefix + "… • 発行 " + createdAt.Local().Format("2006/01/02")
+	if expiresAt.Valid {
+		line += " • 期限 " + expiresAt.Time.Local().Format("2006/01/02")
+	} else {
+		line += " • 無期限"
+	}
+	if lastUsedAt.Valid {
+		line += " • 最終使用 " + lastUsedAt.Time.Local().Format("2006/01/02 15:04")
+	} else {
+		line += " • 未使用"
+	}
+	return line
+}
+
+// apiTokenDialogSignals は発行ダイアログの初期値（読み取りのみ・90 日）。
+func apiTokenDialogSignals() string {
+	return fmt.Sprintf("{tokenName: '', tokenScope: '%s', tokenExpiry: '90'}", apitoken.ScopeRead)
+}
+
+// revokeAPITokenConfirm はトークンを失効させる確認ダイアログを開く式。url は DELETE 先。
+func revokeAPITokenConfirm(url string) string {
+	return fmt.Sprintf("$confirmMsg = 'この API トークンを失効させますか？使っているスクリプトは認証できなくなります。'; $confirmUrl = '%s'; $confirmMethod = 'delete'; document.getElementById('confirm-dialog').showModal()", url)
+}
+
+func profileAPITokenURL(id int64) string {
+	return "/api/sse/profile/api-tokens/" + strconv.FormatInt(id, 10)
+}
+
+func adminAPITokenURL(id int64) string {
+	return "/api/sse/admin/api-tokens/" + strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,47 @@
+package components
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+)
+
+// passkeyName はパスキー一覧の見出し。名前が無ければ認証器の種類を出す。
+func passkeyName(p authsession.Passkey) string {
+	if p.Nickname == "" {
+		return p.Authenticator
+	}
+	return p.Nickname
+}
+
+// passkeyDetail は種類・登録日・最終使用日時を 1 行にまとめる。
+func passkeyDetail(p authsession.Passkey) string {
+	line := "登録 " + p.CreatedAt.Local().Format("2006/01/02")
+	if p.LastUsedAt.IsZero() {
+		line += " • 未使用"
+	} else {
+		line += " • 最終使用 " + p.LastUsedAt.Local().Format("2006/01/02 15:04")
+	}
+	if p.Nickname != "" {
+		line = p.Authenticator + " • " + line
+	}
+	return line
+}
+
+// passkeyURL はパスキー 1 件の SSE エンドポイント。credential ID は base64url だが念のためエスケープする。
+func passkeyURL(id string) string {
+	return "/api/sse/profile/passkeys/" + url.PathEscape(id)
+}
+
+// deletePasskeyConfirm は 1 件のパスキーを削除する確認ダイアログを開く式。
+func deletePasskeyConfirm(id string) string {
+	return fmt.Sprintf("$confirmMsg = 'このパスキーを削除しますか？この端末・サービスからはパスキーでログインできなくなります。'; $confirmUrl = '%s'; $confirmMethod = 'delete'; document.getElementById('confirm-dialog').showModal()", passkeyURL(id))
+}
+
+// passkeySignals は名前変更ダイアログの初期 signals。名前はユーザー入力なので JSON でエスケープする。
+func passkeySignals(p authsession.Passkey) string {
+	b, _ := json.Marshal(map[string]string{"passkeyName": p.Nickname})
+	return string(b)
+}
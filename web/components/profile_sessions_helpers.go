@@ -0,0 +1,50 @@
+package components
+
+import (
+	"fmt"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/authsession"
+)
+
+// sessionDevice はセッション一覧の見出し（端末の要約）。記録が無いセッションは「不明な端末」。
+func sessionDevice(s authsession.Session) string {
+	if s.Device == "" {
+		return "不明な端末"
+	}
+	return s.Device
+}
+
+// sessionDetail は IP・ログイン日時・最終アクセス日時を 1 行にまとめる。
+func sessionDetail(s authsession.Session) string {
+	line := "ログイン " + s.CreatedAt.Local().Format("2006/01/02 15:04")
+	if !s.LastSeenAt.IsZero() {
+		line += " • 最終アクセス " + s.LastSeenAt.Local().Format("2006/01/02 15:04")
+	}
+	if s.IP != "" {
+		line = "IP " + s.IP + " • " + line
+	}
+	return line
+}
+
+// hasOtherSessions は現在の端末以外のセッションがあるか（「他の端末からログアウト」の表示判定）。
+func hasOtherSessions(sessions []authsession.Session) bool {
+	for _, s := range sessions {
+		if !s.Current {
+			return true
+		}
+	}
+	return false
+}
+
+// revokeSessionConfirm は 1 件のセッションを終了する確認ダイアログを開く式。
+func revokeSessionConfirm(id int64) string {
+	return fmt.Sprintf("$confirmMsg = 'この端末をログアウトさせますか？'; $confirmUrl = '/api/sse/profile/sessions/%d'; $confirmMethod = 'delete'; document.getElementById('confirm-dialog').showModal()", id)
+}
+
+// sessionCountLabel は管理画面のユーザー編集ダイアログに出すセッション数の表記。
+func sessionCountLabel(n int) string {
+	if n == 0 {
+		return "ログイン中のセッションはありません"
+	}
+	return fmt.Sprintf("ログイン中のセッション: %d 件", n)
+}
@@ -0,0 +1,91 @@
+package components
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/models"
+)
+
+// projectStatusLabels はプロジェクトの状態の表示名。
+var projectStatusLabels = map[string]string{
+	models.ProjectStatusPlanned: "計画中",
+	models.ProjectStatusActive:  "稼働中",
+	models.ProjectStatusOnHold:  "保留中",
+	models.ProjectStatusDone:    "完了",
+}
+
+// projectStatusLabel はプロジェクトの状態の表示名を返す（未登録の値はそのまま表示）。
+func projectStatusLabel(s string) string {
+	if l, ok := projectStatusLabels[s]; ok {
+		return l
+	}
+	return s
+}
+
+// projectDueDate は期限の表示（期限なしは空）。
+func projectDueDate(p database.Project) string {
+	if !p.DueDate.Valid {
+		return ""
+	}
+	return p.DueDate.Time.Format("2006/01/02")
+}
+
+// projectOverdue は完了していないのに期限を過ぎているかを返す（期限の日のうちは過ぎていない）。
+func projectOverdue(p database.Project) bool {
+	if !p.DueDate.Valid || p.Status == models.ProjectStatusDone {
+		return false
+	}
+	return p.DueDate.Time.Format(time.DateOnly) < time.Now().Format(time.DateOnly)
+}
+
+// projectOwnerName は責任者の名前を返す。責任者はメンバーから選ぶので members から探す。
+func projectOwnerName(p database.Project, members []database.ListProjectMembersRow) string {
+	for _, m := range members {
+		if p.OwnerID.Valid && m.UserID == p.OwnerID.Int64 {
+			return m.Name
+		}
+	}
+	return ""
+}
+
+// projectAddSignals は作成ダイアログの初期 signals。
+func projectAddSignals() string {
+	b, _ := json.Marshal(map[string]any{
+		"name":        "",
+		"description": "",
+		"status":      models.ProjectStatusActive,
+		"dueDate":     "",
+		"tags":        "",
+	})
+	return string(b)
+}
+
+// openProjectAddDialog は作成ダイアログを空にして開く式。signals は編集ダイアログと共有なので、
+// 前に開いた編集ダイアログの値を消してから開く。
+const openProjectAddDialog = "$name = ''; $description = ''; $status = '" + models.ProjectStatusActive + "'; $dueDate = ''; $tags = ''; " +
+	"document.getElementById('project-add-dialog').showModal(); document.activeElement?.blur()"
+
+// projectEditSignals は編集ダイアログの初期 signals（今の値）。ownerId は選択肢の値なので文字列。
+func projectEditSignals(p database.Project) string {
+	ownerID := ""
+	if p.OwnerID.Valid {
+		ownerID = strconv.FormatInt(p.OwnerID.Int64, 10)
+	}
+	dueDate := ""
+	if p.DueDate.Valid {
+		dueDate = p.DueDate.Time.Format(time.DateOnly)
+	}
+	b, _ := json.Marshal(map[string]any{
+		"name":        p.Name,
+		"description": p.Description,
+		"status":      p.Status,
+		"ownerId":     ownerID,
+		"dueDate":     dueDate,
+		"tags":        strings.Join(models.ParseProjectTags(p.Tags), ", "),
+	})
+	return string(b)
+}
@@ -0,0 +1,67 @@
+package components
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/roles"
+)
+
+// projectRoleLabels はプロジェクトロールの表示名。
+var projectRoleLabels = map[string]string{
+	roles.ProjectViewer: "閲覧者",
+	roles.ProjectEditor: "編集者",
+	roles.ProjectOwner:  "オーナー",
+}
+
+// projectRoleLabel はプロジェクトロールの表示名を返す（未登録のロールはそのまま表示）。
+func projectRoleLabel(r string) string {
+	if l, ok := projectRoleLabels[r]; ok {
+		return l
+	}
+	return r
+}
+
+// projectCanEdit はログイン中のユーザーがプロジェクトロール r のプロジェクトを編集できるかを返す
+// （ボタンの出し分け用。読み取り専用モード中は書き込みを断られるだけなので出さない）。
+func projectCanEdit(ctx context.Context, r string) bool {
+	return appcontext.Can(ctx, roles.ProjectWrite) && !appcontext.IsReadOnly(ctx) && roles.ProjectCanWrite(r)
+}
+
+// projectCanManage はプロジェクトの削除とメンバー管理ができるかを返す。
+func projectCanManage(ctx context.Context, r string) bool {
+	return appcontext.Can(ctx, roles.ProjectWrite) && !appcontext.IsReadOnly(ctx) && roles.ProjectCanManage(r)
+}
+
+// ProjectMemberSignal はメンバー一覧のロール選択の signal 名（memberRoles.<名前>）。
+// 数字だけのキーは signal のパスに使えないので "u" を付ける。handlers はこの名前で signals を読む。
+func ProjectMemberSignal(userID int64) string {
+	return fmt.Sprintf("u%d", userID)
+}
+
+// projectMembersSignals はメンバー管理の初期 signals（追加フォームと、各メンバーの現在のロール）。
+func projectMembersSignals(members []database.ListProjectMembersRow) string {
+	current := map[string]string{}
+	for _, m := range members {
+		current[ProjectMemberSignal(m.UserID)] = m.Role
+	}
+	b, _ := json.Marshal(map[string]any{
+		"memberEmail": "",
+		"memberRole":  roles.ProjectViewer,
+		"memberRoles": current,
+	})
+	return string(b)
+}
+
+// projectMemberURL は SSE でメンバーを操作する URL。
+func projectMemberURL(projectID, userID int64) string {
+	return fmt.Sprintf("/api/sse/projects/%d/members/%d", projectID, userID)
+}
+
+// removeProjectMemberConfirm はメンバーを外す確認ダイアログを開く式。
+func removeProjectMemberConfirm(projectID, userID int64) string {
+	return "$confirmMsg = 'このメンバーをプロジェクトから外しますか？'; $confirmUrl = '" + projectMemberURL(projectID, userID) + "'; $confirmMethod = 'delete'; document.getElementById('confirm-dialog').showModal()"
+}
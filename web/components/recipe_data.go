@@ -105,7 +105,8 @@ const RecipeDialogBack = `func RecipeDialog(w http.ResponseWriter, r *http.Reque
     sse := datastar.NewSSE(w, r)
     sse.PatchElementTempl(RecipeDialog(),
         datastar.WithSelectorID("recipe-dialog-container"), datastar.WithModeInner())
-    sse.ExecuteScript("document.getElementById('recipe-dialog')?.showModal()")
+    // ExecuteScript の <script> は CSP で止まるため、data-init の要素で実行する（handlers.runScript）
+    runScript(sse, "document.getElementById('recipe-dialog')?.showModal()")
 }`
 
 // 8. ドロップダウン（クリックでトグル、外側クリックで閉じる。フロントのみ）
@@ -183,7 +184,7 @@ func RecipeItemEdit(w http.ResponseWriter, r *http.Request) {
     sse := datastar.NewSSE(w, r)
     sse.PatchElementTempl(RecipeItemEditDialog(item),
         datastar.WithSelectorID("recipe-item-dialog-container"), datastar.WithModeInner())
-    sse.ExecuteScript("document.getElementById('recipe-item-dialog').showModal()")
+    runScript(sse, "document.getElementById('recipe-item-dialog').showModal()")
 }
 // 保存（@put）— 該当行だけ patch、reload も遷移もしない
 func RecipeItemUpdate(w http.ResponseWriter, r *http.Request) {
@@ -193,7 +194,7 @@ func RecipeItemUpdate(w http.ResponseWriter, r *http.Request) {
     sse := datastar.NewSSE(w, r)
     sse.PatchElementTempl(RecipeItemRow(store.item(id)),
         datastar.WithSelectorID(fmt.Sprintf("item-%d", id)), datastar.WithModeOuter())
-    sse.ExecuteScript("document.getElementById('recipe-item-dialog').close()")
+    runScript(sse, "document.getElementById('recipe-item-dialog').close()")
 }`
 
 // 11. View Transition でフェード（フロントのみ。v1.0.2 の viewTransitionSelector と
@@ -0,0 +1,20 @@
+package components
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
+)
+
+// stepUpMaxAgeLabel は本人確認の猶予（middleware.StepUpMaxAge）を「10 分」のように返す。
+func stepUpMaxAgeLabel() string {
+	return fmt.Sprintf("%d 分", int(appMiddleware.StepUpMaxAge.Minutes()))
+}
+
+// stepUpRetryAction は本人確認の後に元の操作をやり直す Datastar の式（例: @delete('/api/sse/...')）。
+// signals は元の操作と同じものがそのまま送られる。
+func stepUpRetryAction(method, path string) string {
+	return fmt.Sprintf("@%s(%s)", strings.ToLower(method), strconv.Quote(path))
+}
@@ -0,0 +1,239 @@
+// API エクスプローラ（/admin/api-docs）
+// /api/openapi.json を読み込み、タグごとにルートを並べて「試す」フォームを付ける。
+// 外部ライブラリ・CDN は使わない。サーバー由来の文字列は textContent でのみ描画する。
+
+(function() {
+    'use strict';
+
+    var METHOD_CLASS = {
+        get: 'bg-success/10 text-success',
+        post: 'bg-accent/10 text-accent',
+        put: 'bg-warning/10 text-warning',
+        patch: 'bg-warning/10 text-warning',
+        delete: 'bg-danger/10 text-danger'
+    };
+
+    function el(tag, className, text) {
+        var e = document.createElement(tag);
+        if (className) e.className = className;
+        if (text !== undefined) e.textContent = text;
+        return e;
+    }
+
+    // example は JSON Schema から入力欄の初期値にする値を作る。
+    function example(schema) {
+        if (!schema) return null;
+        var type = Array.isArray(schema.type) ? schema.type[0] : schema.type;
+        switch (type) {
+            case 'object':
+                var obj = {};
+                Object.keys(schema.properties || {}).forEach(function(k) {
+                    obj[k] = example(schema.properties[k]);
+                });
+                return obj;
+            case 'array': return [];
+            case 'integer':
+            case 'number': return 0;
+            case 'boolean': return false;
+            case 'string': return '';
+            default: return null;
+        }
+    }
+
+    function jsonSchemaOf(content) {
+        var media = content && content['application/json'];
+        return media ? media.schema : null;
+    }
+
+    // successMedia は成功時のレスポンスのメディアタイプ（Accept に使う）。
+    function successMedia(op) {
+        var codes = Object.keys(op.responses || {}).filter(function(c) { return c.charAt(0) === '2'; });
+        var res = codes.length ? op.responses[codes[0]] : null;
+        var types = res && res.content ? Object.keys(res.content) : [];
+        return types.length ? types[0] : '';
+    }
+
+    function renderTryIt(path, method, op) {
+        var form = el('form', 'mt-3 space-y-3');
+        var fields = [];
+
+        (op.parameters || []).forEach(function(p) {
+            var label = el('label', 'block text-xs text-muted');
+            label.textContent = p.name + '（' + (p.in === 'path' ? 'パス' : 'クエリ') + '）' + (p.description ? ' ' + p.description : '');
+            var input;
+            var schema = p.content ? jsonSchemaOf(p.content) : null;
+            if (schema) {
+                input = el('textarea', 'mt-1 block w-full rounded-ui bg-code-bg text-code-fg font-mono text-xs p-2');
+                input.rows = 3;
+                input.value = JSON.stringify(example(schema));
+            } else {
+                input = el('input', 'mt-1 block w-full rounded-ui ring-1 ring-border bg-surface text-ink text-sm px-2 py-1');
+                input.type = 'text';
+            }
+            label.appendChild(input);
+            form.appendChild(label);
+            fields.push({ param: p, input: input });
+        });
+
+        var body = null;
+        var bodySchema = op.requestBody ? jsonSchemaOf(op.requestBody.content) : null;
+        if (bodySchema) {
+            var bodyLabel = el('label', 'block text-xs text-muted', 'body（JSON）');
+            body = el('textarea', 'mt-1 block w-full rounded-ui bg-code-bg text-code-fg font-mono text-xs p-2');
+            body.rows = 5;
+            body.value = JSON.stringify(example(bodySchema), null, 2);
+            bodyLabel.appendChild(body);
+            form.appendChild(bodyLabel);
+        } else if (op.requestBody) {
+            form.appendChild(el('p', 'text-xs text-muted', 'フォーム送信のルートはここからは試せません。'));
+            return form;
+        }
+
+        var submit = el('button', 'rounded-ui bg-accent px-3 py-1.5 text-sm font-semibold text-accent-fg hover:bg-accent-hover', '送信');
+        submit.type = 'submit';
+        form.appendChild(submit);
+        var output = el('pre', 'hidden max-h-80 overflow-auto rounded-ui bg-code-bg text-code-fg font-mono text-xs p-3 whitespace-pre-wrap break-all');
+        form.appendChild(output);
+
+        form.addEventListener('submit', async function(e) {
+            e.preventDefault();
+            var url = path;
+            var query = new URLSearchParams();
+            fields.forEach(function(f) {
+                var v = f.input.value;
+                if (f.param.in === 'path') {
+                    url = url.replace('{' + f.param.name + '}', encodeURIComponent(v));
+                } else if (v !== '') {
+                    query.set(f.param.name, v);
+                }
+            });
+            var qs = query.toString();
+            if (qs) url += '?' + qs;
+
+            var media = successMedia(op);
+            var headers = {};
+            if (media) headers['Accept'] = media;
+            if (media === 'text/event-stream') headers['Datastar-Request'] = 'true';
+            var init = { method: method.toUpperCase(), headers: headers, credentials: 'same-origin' };
+            if (body) {
+                headers['Content-Type'] = 'application/json';
+                init.body = body.value;
+            }
+
+            submit.disabled = true;
+            output.classList.remove('hidden');
+            output.textContent = method.toUpperCase() + ' ' + url + '\n...';
+            try {
+                var res = await fetch(url, init);
+                var text = await res.text();
+                if ((res.headers.get('Content-Type') || '').indexOf('application/json') === 0) {
+                    try { text = JSON.stringify(JSON.parse(text), null, 2); } catch (_) { /* そのまま表示 */ }
+                }
+                output.textContent = method.toUpperCase() + ' ' + url + '\n' + res.status + ' ' + res.statusText + '\n\n' + text.slice(0, 20000);
+            } catch (err) {
+                output.textContent = method.toUpperCase() + ' ' + url + '\n' + err;
+            } finally {
+                submit.disabled = false;
+            }
+        });
+        return form;
+    }
+
+    function renderOperation(path, method, op) {
+        var item = el('details', 'api-op border-b border-border last:border-b-0');
+        item.dataset.search = (method + ' ' + path + ' ' + (op.summary || '')).toLowerCase();
+
+        var summary = el('summary', 'flex flex-wrap items-center gap-2 px-4 py-3 cursor-pointer hover:bg-canvas');
+        summary.appendChild(el('span', 'w-16 shrink-0 rounded-ui px-2 py-0.5 text-center font-mono text-xs font-semibold uppercase ' + (METHOD_CLASS[method] || 'bg-canvas text-muted'), method));
+        summary.appendChild(el('span', 'font-mono text-sm text-ink break-all', path));
+        summary.appendChild(el('span', 'text-sm text-muted', op.summary || ''));
+        if (!op.security || op.security.length === 0) {
+            summary.appendChild(el('span', 'rounded-full bg-canvas px-2 py-0.5 text-xs text-muted', 'ログイン不要'));
+        }
+        (op['x-required-roles'] || []).forEach(function(role) {
+            summary.appendChild(el('span', 'rounded-full bg-accent/10 px-2 py-0.5 text-xs text-accent', role));
+        });
+        item.appendChild(summary);
+
+        var detail = el('div', 'px-4 pb-4 text-sm');
+        var responses = el('ul', 'text-xs text-muted space-y-0.5');
+        Object.keys(op.responses || {}).sort().forEach(function(code) {
+            var res = op.responses[code];
+            var media = res.content ? ' — ' + Object.keys(res.content).join(', ') : '';
+            responses.appendChild(el('li', '', code + ' ' + res.description + media));
+        });
+        detail.appendChild(responses);
+        detail.appendChild(renderTryIt(path, method, op));
+        item.appendChild(detail);
+        return item;
+    }
+
+    function render(root, spec) {
+        root.textContent = '';
+        var groups = {};
+        var order = [];
+        Object.keys(spec.paths).sort().forEach(function(path) {
+            Object.keys(spec.paths[path]).forEach(function(method) {
+                var op = spec.paths[path][method];
+                var tag = (op.tags && op.tags[0]) || 'その他';
+                if (!groups[tag]) {
+                    groups[tag] = [];
+                    order.push(tag);
+                }
+                groups[tag].push(renderOperation(path, method, op));
+            });
+        });
+
+        order.forEach(function(tag) {
+            var section = el('section', 'api-group');
+            section.appendChild(el('h3', 'text-sm font-semibold text-ink mb-2', tag));
+            var list = el('div', 'bg-surface rounded-card border border-border shadow-sm');
+            groups[tag].forEach(function(item) { list.appendChild(item); });
+            section.appendChild(list);
+            root.appendChild(section);
+        });
+
+        if (spec['x-mounts'] && spec['x-mounts'].length) {
+            var section = el('section', 'api-group');
+            section.appendChild(el('h3', 'text-sm font-semibold text-ink mb-2', '委譲しているパス'));
+            var list = el('ul', 'bg-surface rounded-card border border-border shadow-sm divide-y divide-border');
+            spec['x-mounts'].forEach(function(m) {
+                var li = el('li', 'api-op px-4 py-3 text-sm');
+                li.dataset.search = (m.path + ' ' + (m.summary || '')).toLowerCase();
+                li.appendChild(el('span', 'font-mono text-ink', m.path));
+                li.appendChild(el('span', 'ml-2 text-muted', m.summary || ''));
+                list.appendChild(li);
+            });
+            section.appendChild(list);
+            root.appendChild(section);
+        }
+    }
+
+    function applyFilter(root, q) {
+        q = q.trim().toLowerCase();
+        root.querySelectorAll('.api-op').forEach(function(op) {
+            op.classList.toggle('hidden', q !== '' && op.dataset.search.indexOf(q) === -1);
+        });
+        root.querySelectorAll('.api-group').forEach(function(group) {
+            group.classList.toggle('hidden', group.querySelectorAll('.api-op:not(.hidden)').length === 0);
+        });
+    }
+
+    document.addEventListener('DOMContentLoaded', async function() {
+        var root = document.getElementById('api-explorer');
+        if (!root) return;
+        try {
+            var res = await fetch(root.dataset.specUrl, { credentials: 'same-origin', headers: { 'Accept': 'application/json' } });
+            if (!res.ok) throw new Error(res.status + ' ' + res.statusText);
+            render(root, await res.json());
+        } catch (err) {
+            root.textContent = '';
+            root.appendChild(el('p', 'text-sm text-danger', 'API ドキュメントを読み込めませんでした: ' + err.message));
+            return;
+        }
+        var filter = document.getElementById('api-explorer-filter');
+        if (filter) {
+            filter.addEventListener('input', function() { applyFilter(root, filter.value); });
+        }
+    });
+})();
@@ -0,0 +1,76 @@
+// 破壊的な操作の前の本人確認（step-up）
+// サーバが #step-up-dialog を patch して開く。パスキー（またはメールのリンク）でログインし直すと
+// 新しいセッション Cookie が発行され、#step-up-retry のボタンで元の操作をやり直す。
+
+function stepUpMessage(text) {
+    var el = document.getElementById('step-up-message');
+    if (el) el.textContent = text;
+}
+
+function stepUpEmail(button) {
+    return button.closest('dialog').dataset.stepUpEmail;
+}
+
+// パスキーで確認する。magiclink の webauthn.js は成功後に画面を移動するため、同じ API を直接呼ぶ。
+async function stepUpWithPasskey(button) {
+    button.disabled = true;
+    stepUpMessage('パスキーで確認中...');
+    try {
+        var start = await fetch('/webauthn/login/start', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ email: stepUpEmail(button) })
+        }).then(function (r) { return r.json(); });
+        if (start.error) throw new Error(start.error);
+
+        var assertion = await navigator.credentials.get({
+            publicKey: PublicKeyCredential.parseRequestOptionsFromJSON(start.options.publicKey)
+        });
+        var finish = await fetch('/webauthn/login/finish', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ challenge_id: start.challenge_id, response: assertion.toJSON() })
+        }).then(function (r) { return r.json(); });
+        if (finish.error) throw new Error(finish.error);
+
+        stepUpMessage('確認できました');
+        document.querySelector('#step-up-retry button').click();
+    } catch (e) {
+        stepUpMessage(e.name === 'NotAllowedError' ? 'パスキーでの確認が取り消されました' : '確認に失敗しました: ' + e.message);
+    } finally {
+        button.disabled = false;
+    }
+}
+
+// メールのリンクで確認する。リンクを開くと同じブラウザのセッションが新しくなるので、
+// この画面に戻って「続ける」を押してもらう。
+async function stepUpWithMagicLink(button) {
+    button.disabled = true;
+    stepUpMessage('送信中...');
+    try {
+        var res = await fetch('/auth/login?redirect=' + encodeURIComponent(window.location.pathname), {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ email: stepUpEmail(button) })
+        });
+        var resp = await res.json();
+        if (!res.ok) throw new Error(resp.error || 'エラーが発生しました');
+
+        var el = document.getElementById('step-up-message');
+        el.textContent = 'メールのリンクを開いてから「続ける」を押してください。';
+        if (resp.magic_link) {
+            // 開発用（.bypass_emails）はメールを送らずリンクが返る。
+            var a = document.createElement('a');
+            a.href = resp.magic_link;
+            a.target = '_blank';
+            a.className = 'ml-1 underline';
+            a.textContent = 'リンクを開く';
+            el.appendChild(a);
+        }
+        document.getElementById('step-up-retry').classList.remove('hidden');
+    } catch (e) {
+        stepUpMessage('送信に失敗しました: ' + e.message);
+    } finally {
+        button.disabled = false;
+    }
+}